@@ -15,11 +15,20 @@ import (
 	"github.com/4planet/backend/internal/middleware"
 	"github.com/4planet/backend/internal/models"
 	"github.com/4planet/backend/pkg/achievements"
+	"github.com/4planet/backend/pkg/allocations"
 	"github.com/4planet/backend/pkg/auth"
+	"github.com/4planet/backend/pkg/campaigns"
+	"github.com/4planet/backend/pkg/codes"
+	"github.com/4planet/backend/pkg/dedications"
 	"github.com/4planet/backend/pkg/donations"
+	"github.com/4planet/backend/pkg/dunning"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/4planet/backend/pkg/idempotency"
 	"github.com/4planet/backend/pkg/mailer"
+	"github.com/4planet/backend/pkg/matching"
 	"github.com/4planet/backend/pkg/news"
 	"github.com/4planet/backend/pkg/payments"
+	"github.com/4planet/backend/pkg/planting"
 	"github.com/4planet/backend/pkg/prices"
 	"github.com/4planet/backend/pkg/projects"
 	"github.com/4planet/backend/pkg/shares"
@@ -73,16 +82,10 @@ func main() {
 		mailerService = mailer.NewNoOpMailer()
 	}
 
-	_ = payments.NewCloudPaymentsService(
-		cfg.CloudPayments.PublicID,
-		cfg.CloudPayments.Secret,
-		cfg.App.BaseURL,
-	)
-
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService, mailerService, cfg)
 	userHandler := handlers.NewUserHandler(userService, donationService, subscriptionService, achievementsService)
-	projectsHandler := handlers.NewProjectsHandler(projectsService, cfg)
+	projectsHandler := handlers.NewProjectsHandler(projectsService, pricesService, cfg)
 	newsHandler := handlers.NewNewsHandler(newsService, cfg)
 	pricesHandler := handlers.NewPricesHandler(pricesService, cfg)
 	achievementsHandler := handlers.NewAchievementsHandler(achievementsService, cfg)
@@ -90,16 +93,95 @@ func main() {
 	// Initialize share services and handlers
 	sharesHandler := handlers.NewSharesHandler(sharesService, cfg.App.BaseURL)
 
-	// Initialize payment services and handlers
-	paymentService := payments.NewCloudPaymentsService(
-		cfg.CloudPayments.PublicID,
-		cfg.CloudPayments.Secret,
-		cfg.App.BaseURL,
+	// Initialize payment providers, services and handlers
+	if cfg.CloudPayments.Secret == "" {
+		logrus.Warn("CLOUDPAYMENTS_SECRET is not set; CloudPayments notifications will be rejected")
+	}
+	paymentProviders := payments.NewRegistry(
+		payments.NewCloudPaymentsProvider(
+			cfg.CloudPayments.PublicID,
+			cfg.CloudPayments.Secret,
+			cfg.App.BaseURL,
+			cfg.CloudPayments.APIURL,
+		),
 	)
-	paymentsHandler := handlers.NewPaymentsHandler(paymentService)
+	if cfg.Kaspi.MerchantID != "" {
+		kaspiProvider, err := payments.NewKaspiProvider(
+			cfg.Kaspi.MerchantID,
+			cfg.Kaspi.APIKey,
+			cfg.Kaspi.APIURL,
+			cfg.Kaspi.CallbackSecret,
+			cfg.Kaspi.AllowedIPs,
+		)
+		if err != nil {
+			log.Fatalf("Failed to configure Kaspi: %v", err)
+		}
+		if len(cfg.Kaspi.AllowedIPs) == 0 || cfg.Kaspi.CallbackSecret == "" {
+			logrus.Warn("KASPI_ALLOWED_IPS or KASPI_CALLBACK_SECRET is not set; Kaspi callbacks will be rejected")
+		}
+		paymentProviders.Register(kaspiProvider)
+	}
+	if cfg.PayPal.ClientID != "" {
+		if cfg.PayPal.WebhookID == "" {
+			logrus.Warn("PAYPAL_WEBHOOK_ID is not set; PayPal webhooks will be rejected")
+		}
+		paymentProviders.Register(payments.NewPayPalProvider(payments.PayPalConfig{
+			ClientID:  cfg.PayPal.ClientID,
+			Secret:    cfg.PayPal.Secret,
+			APIURL:    cfg.PayPal.APIURL,
+			WebhookID: cfg.PayPal.WebhookID,
+			ProductID: cfg.PayPal.ProductID,
+			PlanStore: payments.NewPlanStore(models.PaymentProviderPayPal),
+		}))
+	}
+	var fakeProvider *payments.FakeProvider
+	if cfg.FakePayments.Enabled {
+		logrus.Warn("Fake payment provider is enabled; never enable it in production")
+		fakeProvider = payments.NewFakeProvider(cfg.App.BaseURL, cfg.FakePayments.Secret)
+		paymentProviders.Register(fakeProvider)
+	}
+	paymentLimits := payments.AmountLimits{MaxAmountMinor: make(map[models.Currency]int64)}
+	for currency, maxAmountMinor := range cfg.Payments.MaxAmountMinor {
+		paymentLimits.MaxAmountMinor[models.Currency(currency)] = maxAmountMinor
+	}
+	paymentService := payments.NewService(paymentProviders, paymentLimits)
+	idempotencyService := idempotency.NewService()
+	paymentsHandler := handlers.NewPaymentsHandler(paymentService, idempotencyService, authService)
 
 	// Initialize subscription handlers
-	subscriptionsHandler := handlers.NewSubscriptionsHandler(paymentService)
+	subscriptionsHandler := handlers.NewSubscriptionsHandler(paymentService, idempotencyService)
+
+	// Initialize webhook handlers
+	webhooksHandler := handlers.NewWebhooksHandler(paymentService)
+
+	// Chase past due subscriptions in the background
+	dunningService := dunning.NewService(paymentService, mailerService, dunning.Config{
+		RetrySchedule: cfg.Dunning.RetrySchedule,
+		MaxFailures:   cfg.Dunning.MaxFailures,
+		GracePeriod:   cfg.Dunning.GracePeriod,
+		CheckInterval: cfg.Dunning.CheckInterval,
+	})
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go dunningService.Run(workerCtx)
+
+	// Email tree certificates of gift donations in the background
+	dedicationService := dedications.NewService(mailerService, achievementsService, dedications.Config{
+		BaseURL:       cfg.App.BaseURL,
+		CheckInterval: cfg.Dedications.CheckInterval,
+	})
+	go dedicationService.Run(workerCtx)
+	dedicationsHandler := handlers.NewDedicationsHandler(dedicationService)
+
+	// Initialize admin refund handlers
+	refundsHandler := handlers.NewRefundsHandler(paymentService)
+	fxService := fx.NewService()
+	fxHandler := handlers.NewFXHandler(fxService, cfg)
+	campaignsHandler := handlers.NewCampaignsHandler(campaigns.NewService(fxService))
+	matchingHandler := handlers.NewMatchingHandler(matching.NewService())
+	codesHandler := handlers.NewCodesHandler(codes.NewService(achievementsService))
+	allocationsHandler := handlers.NewAllocationsHandler(allocations.NewService())
+	plantingHandler := handlers.NewPlantingHandler(planting.NewService())
 
 	// Set Gin mode
 	if cfg.Log.Level == "debug" {
@@ -111,6 +193,12 @@ func main() {
 	// Create router
 	router := gin.New()
 
+	// Client addresses are taken from X-Forwarded-For only when the request comes through
+	// a trusted proxy; Kaspi callbacks are authenticated by address
+	if err := router.SetTrustedProxies(cfg.App.TrustedProxies); err != nil {
+		log.Fatalf("Invalid APP_TRUSTED_PROXIES: %v", err)
+	}
+
 	// Add middleware
 	router.Use(middleware.RequestIDMiddleware())
 	router.Use(middleware.LoggingMiddleware())
@@ -138,8 +226,17 @@ func main() {
 		{
 			me.GET("", userHandler.Me)
 			me.GET("/donations", userHandler.GetMyDonations)
+			me.GET("/donations/:id/planting", plantingHandler.GetDonationPlanting)
 			me.GET("/subscriptions", userHandler.GetMySubscriptions)
+			me.PATCH("/subscriptions/:id", subscriptionsHandler.UpdateSubscription)
+			me.GET("/subscriptions/:id/changes", subscriptionsHandler.GetSubscriptionChanges)
+			me.POST("/subscriptions/:id/cancel", subscriptionsHandler.CancelSubscription)
+			me.POST("/subscriptions/:id/pause", subscriptionsHandler.PauseSubscription)
+			me.POST("/subscriptions/:id/resume", subscriptionsHandler.ResumeSubscription)
 			me.GET("/achievements", userHandler.GetMyAchievements)
+			me.POST("/certificates/:token/claim", dedicationsHandler.ClaimCertificate)
+			me.GET("/matching-pools", matchingHandler.GetMyMatchingPools)
+			me.POST("/redeem", codesHandler.Redeem)
 		}
 
 		// Projects
@@ -147,6 +244,7 @@ func main() {
 		{
 			projects.GET("", projectsHandler.GetProjects)
 			projects.GET("/:id", projectsHandler.GetProject)
+			projects.GET("/:id/funding", allocationsHandler.GetProjectFunding)
 		}
 
 		news := v1.Group("/news")
@@ -155,11 +253,19 @@ func main() {
 			news.GET("/:id", newsHandler.GetNewsItem)
 		}
 
+		// Fundraising campaigns
+		campaigns := v1.Group("/campaigns")
+		{
+			campaigns.GET("", campaignsHandler.GetCampaigns)
+			campaigns.GET("/:slug", campaignsHandler.GetCampaign)
+		}
+
 		// Prices
 		prices := v1.Group("/prices")
 		{
 			prices.GET("", pricesHandler.GetPrices)
 			prices.GET("/:currency", pricesHandler.GetPriceByCurrency)
+			prices.GET("/history/:currency", pricesHandler.GetPriceHistory)
 		}
 
 		// Achievements
@@ -169,6 +275,9 @@ func main() {
 			achievements.GET("", achievementsHandler.GetAchievements)
 		}
 
+		// Tree certificates of gift donations (no auth required)
+		v1.GET("/certificates/:token", dedicationsHandler.GetCertificate)
+
 		// Badges (public catalog of all achievements)
 		v1.GET("/badges", achievementsHandler.GetAchievements)
 
@@ -183,6 +292,15 @@ func main() {
 		payments.Use(middleware.RequireAuth(authService, cfg))
 		{
 			payments.POST("/intents", paymentsHandler.CreatePaymentIntent)
+			payments.GET("/:id", paymentsHandler.GetPayment)
+			payments.GET("/:id/events", paymentsHandler.StreamPayment)
+		}
+
+		// Guest checkout (no auth required)
+		guest := v1.Group("/guest")
+		{
+			guest.POST("/payments/intents", paymentsHandler.CreateGuestPaymentIntent)
+			guest.GET("/payments/:id", paymentsHandler.GetGuestPayment)
 		}
 
 		// Subscriptions
@@ -212,15 +330,18 @@ func main() {
 	}
 
 	// Webhooks
-	router.POST("/webhooks/:provider", func(c *gin.Context) {
-		provider := c.Param("provider")
-		if provider == "cloudpayments" {
-			// TODO: Implement CloudPayments webhook handler
-			c.JSON(http.StatusOK, gin.H{"message": "Webhook received"})
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported provider"})
-		}
-	})
+	router.GET("/webhooks/:provider", webhooksHandler.HandleWebhook)
+	router.POST("/webhooks/:provider", webhooksHandler.HandleWebhook)
+	router.POST("/webhooks/:provider/:kind", webhooksHandler.HandleWebhook)
+
+	// Hosted test checkout of the fake payment provider
+	if fakeProvider != nil {
+		checkoutHandler := handlers.NewCheckoutHandler(paymentService, fakeProvider)
+		router.GET("/pay/:id", checkoutHandler.PaymentPage)
+		router.POST("/pay/:id", checkoutHandler.CompletePayment)
+		router.GET("/subscribe/:id", checkoutHandler.SubscriptionPage)
+		router.POST("/subscribe/:id", checkoutHandler.CompleteSubscription)
+	}
 
 	// Admin interface
 	adminRouter := router.Group("/admin")
@@ -258,6 +379,33 @@ func main() {
 			database.GetDB().Find(&donations)
 			c.JSON(http.StatusOK, donations)
 		})
+
+		adminRouter.GET("/payments/:id/refunds", refundsHandler.GetRefunds)
+		adminRouter.POST("/payments/:id/refunds", refundsHandler.CreateRefund)
+
+		adminRouter.PUT("/projects/:id/prices/:currency", pricesHandler.SetProjectPrice)
+		adminRouter.DELETE("/projects/:id/prices/:currency", pricesHandler.DeleteProjectPrice)
+		adminRouter.PUT("/projects/:id/target", allocationsHandler.SetProjectTarget)
+		adminRouter.POST("/allocations/run", allocationsHandler.AllocatePending)
+		adminRouter.GET("/projects/:id/batches", plantingHandler.ListBatches)
+		adminRouter.POST("/projects/:id/batches", plantingHandler.CreateBatch)
+		adminRouter.POST("/batches/:id/media", plantingHandler.AddEvidence)
+
+		adminRouter.GET("/fx-rates", fxHandler.GetRates)
+		adminRouter.POST("/fx-rates", fxHandler.ImportRates)
+		adminRouter.GET("/reports/gross-monthly", fxHandler.GetMonthlyGross)
+
+		adminRouter.GET("/campaigns", campaignsHandler.ListCampaigns)
+		adminRouter.POST("/campaigns", campaignsHandler.CreateCampaign)
+		adminRouter.PUT("/campaigns/:id", campaignsHandler.UpdateCampaign)
+
+		adminRouter.GET("/matching-pools", matchingHandler.ListPools)
+		adminRouter.POST("/matching-pools", matchingHandler.CreatePool)
+		adminRouter.GET("/matching-pools/:id/report", matchingHandler.GetPoolReport)
+
+		adminRouter.GET("/codes", codesHandler.ListCodes)
+		adminRouter.POST("/codes", codesHandler.GenerateCodes)
+		adminRouter.GET("/codes/export", codesHandler.ExportCodes)
 	}
 
 	// Load HTML templates
@@ -299,6 +447,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logrus.Info("Shutting down server...")
+	stopWorkers()
 
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
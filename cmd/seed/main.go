@@ -9,6 +9,7 @@ import (
 
 	"github.com/4planet/backend/internal/database"
 	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/allocations"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	"gorm.io/gorm"
@@ -134,7 +135,7 @@ func seedTreePrices(ctx context.Context, db *gorm.DB) error {
 
 	for _, price := range prices {
 		price.UpdatedAt = time.Now()
-		if err := db.WithContext(ctx).Where("currency = ?", price.Currency).
+		if err := db.WithContext(ctx).Where("currency = ? AND effective_to IS NULL", price.Currency).
 			Assign(price).FirstOrCreate(&price).Error; err != nil {
 			return err
 		}
@@ -507,25 +508,25 @@ func seedDonations(ctx context.Context, db *gorm.DB) error {
 	donations := []models.Donation{
 		{
 			AuthUserID: "test-user-1",
-			PaymentID:  payments[0].ID,
+			PaymentID:  &payments[0].ID,
 			ProjectID:  &moscowProject.ID,
 			TreesCount: 5,
 		},
 		{
 			AuthUserID: "test-user-1",
-			PaymentID:  payments[1].ID,
+			PaymentID:  &payments[1].ID,
 			ProjectID:  &almatyProject.ID,
 			TreesCount: 10,
 		},
 		{
 			AuthUserID: "test-user-2",
-			PaymentID:  payments[2].ID,
+			PaymentID:  &payments[2].ID,
 			ProjectID:  &steppeProject.ID,
 			TreesCount: 20,
 		},
 		{
 			AuthUserID: "test-user-2",
-			PaymentID:  payments[3].ID,
+			PaymentID:  &payments[3].ID,
 			ProjectID:  nil, // General donation
 			TreesCount: 22,
 		},
@@ -533,7 +534,12 @@ func seedDonations(ctx context.Context, db *gorm.DB) error {
 
 	for _, donation := range donations {
 		donation.ID = uuid.New()
-		if err := db.WithContext(ctx).Create(&donation).Error; err != nil {
+		if err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&donation).Error; err != nil {
+				return err
+			}
+			return allocations.Allocate(tx, &donation)
+		}); err != nil {
 			return err
 		}
 	}
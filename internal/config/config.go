@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -17,6 +18,8 @@ type Config struct {
 		CookieSecure   bool
 		CookieHTTPOnly bool
 		SessionTTL     time.Duration
+		// TrustedProxies are the addresses whose X-Forwarded-For header is believed
+		TrustedProxies []string
 	}
 
 	Database struct {
@@ -34,6 +37,49 @@ type Config struct {
 	CloudPayments struct {
 		PublicID string
 		Secret   string
+		APIURL   string
+	}
+
+	Kaspi struct {
+		MerchantID string
+		APIKey     string
+		APIURL     string
+		// CallbackSecret signs check and pay callbacks
+		CallbackSecret string
+		AllowedIPs     []string
+	}
+
+	PayPal struct {
+		ClientID  string
+		Secret    string
+		APIURL    string
+		WebhookID string
+		ProductID string
+	}
+
+	FakePayments struct {
+		Enabled bool
+		Secret  string
+	}
+
+	Payments struct {
+		// MaxAmountMinor caps single payments and recurring charges per currency
+		MaxAmountMinor map[string]int64
+	}
+
+	Dunning struct {
+		RetrySchedule []time.Duration
+		MaxFailures   int
+		GracePeriod   time.Duration
+		CheckInterval time.Duration
+	}
+
+	Dedications struct {
+		CheckInterval time.Duration
+	}
+
+	FX struct {
+		ReportingCurrency string
 	}
 
 	Log struct {
@@ -56,6 +102,7 @@ func Load() (*Config, error) {
 
 	// App config
 	config.App.BaseURL = getEnv("APP_BASE_URL", "http://localhost:8080")
+	config.App.TrustedProxies = getEnvList("APP_TRUSTED_PROXIES", nil)
 	config.App.CookieName = getEnv("APP_COOKIE_NAME", "session_id")
 	config.App.CookieDomain = getEnv("APP_COOKIE_DOMAIN", "")
 	config.App.CookieSecure = getEnvBool("APP_COOKIE_SECURE", false)
@@ -75,6 +122,45 @@ func Load() (*Config, error) {
 	// CloudPayments config
 	config.CloudPayments.PublicID = getEnv("CLOUDPAYMENTS_PUBLIC_ID", "")
 	config.CloudPayments.Secret = getEnv("CLOUDPAYMENTS_SECRET", "")
+	config.CloudPayments.APIURL = getEnv("CLOUDPAYMENTS_API_URL", "https://api.cloudpayments.ru")
+
+	// Kaspi config
+	config.Kaspi.MerchantID = getEnv("KASPI_MERCHANT_ID", "")
+	config.Kaspi.APIKey = getEnv("KASPI_API_KEY", "")
+	config.Kaspi.APIURL = getEnv("KASPI_API_URL", "https://api.kaspi.kz/merchant")
+	config.Kaspi.CallbackSecret = getEnv("KASPI_CALLBACK_SECRET", "")
+	config.Kaspi.AllowedIPs = getEnvList("KASPI_ALLOWED_IPS", nil)
+
+	// PayPal config
+	config.PayPal.ClientID = getEnv("PAYPAL_CLIENT_ID", "")
+	config.PayPal.Secret = getEnv("PAYPAL_SECRET", "")
+	config.PayPal.APIURL = getEnv("PAYPAL_API_URL", "https://api-m.paypal.com")
+	config.PayPal.WebhookID = getEnv("PAYPAL_WEBHOOK_ID", "")
+	config.PayPal.ProductID = getEnv("PAYPAL_PRODUCT_ID", "")
+
+	// Fake payment provider config (development and e2e tests only)
+	config.FakePayments.Enabled = getEnvBool("FAKE_PAYMENTS_ENABLED", false)
+	config.FakePayments.Secret = getEnv("FAKE_PAYMENTS_SECRET", "fake-payments-secret")
+
+	// Payment limits, in minor units per currency
+	config.Payments.MaxAmountMinor = getEnvInt64Map("PAYMENT_MAX_AMOUNT_MINOR", map[string]int64{
+		"RUB": 100000000, // 1 000 000 RUB
+		"KZT": 500000000, // 5 000 000 KZT
+		"USD": 1000000,   // 10 000 USD
+		"EUR": 1000000,   // 10 000 EUR
+	})
+
+	// Dunning config; the retry schedule is measured from the first failed charge
+	config.Dunning.RetrySchedule = getEnvDurationList("DUNNING_RETRY_SCHEDULE", []time.Duration{24 * time.Hour, 72 * time.Hour, 7 * 24 * time.Hour})
+	config.Dunning.MaxFailures = getEnvInt("DUNNING_MAX_FAILURES", 4)
+	config.Dunning.GracePeriod = getEnvDuration("DUNNING_GRACE_PERIOD", 72*time.Hour)
+	config.Dunning.CheckInterval = getEnvDuration("DUNNING_CHECK_INTERVAL", 15*time.Minute)
+
+	// Dedications config; gift certificates are emailed in the background
+	config.Dedications.CheckInterval = getEnvDuration("DEDICATION_CHECK_INTERVAL", time.Minute)
+
+	// FX config; reports convert payments to the reporting currency
+	config.FX.ReportingCurrency = getEnv("FX_REPORTING_CURRENCY", "EUR")
 
 	// Log config
 	config.Log.Level = getEnv("LOG_LEVEL", "info")
@@ -93,6 +179,19 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvList(key string, defaultValue []string) []string {
+	if value := os.Getenv(key); value != "" {
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	return defaultValue
+}
+
 func getEnvInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -119,3 +218,37 @@ func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvDurationList(key string, defaultValue []time.Duration) []time.Duration {
+	var durations []time.Duration
+	for _, item := range getEnvList(key, nil) {
+		duration, err := time.ParseDuration(item)
+		if err != nil {
+			return defaultValue
+		}
+		durations = append(durations, duration)
+	}
+	if len(durations) == 0 {
+		return defaultValue
+	}
+	return durations
+}
+
+func getEnvInt64Map(key string, defaultValue map[string]int64) map[string]int64 {
+	values := make(map[string]int64)
+	for _, item := range getEnvList(key, nil) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return defaultValue
+		}
+		intValue, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return defaultValue
+		}
+		values[strings.TrimSpace(name)] = intValue
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
@@ -96,6 +96,10 @@ func autoMigrate() error {
 		&models.PasswordResetToken{},
 		&models.TreePrice{},
 		&models.Project{},
+		&models.Campaign{},
+		&models.MatchingPool{},
+		&models.RedemptionCode{},
+		&models.PlantingBatch{},
 		&models.MediaFile{},
 		&models.News{},
 		&models.Achievement{},
@@ -104,7 +108,17 @@ func autoMigrate() error {
 		&models.Payment{},
 		&models.Donation{},
 		&models.ShareToken{},
+		&models.Dedication{},
 		&models.WebhookEvent{},
+		&models.IdempotencyKey{},
+		&models.Refund{},
+		&models.SubscriptionChange{},
+		&models.CreditBalance{},
+		&models.CreditEntry{},
+		&models.TreeAllocation{},
+		&models.TreePlanting{},
+		&models.FXRate{},
+		&models.ProviderPlan{},
 	}
 
 	for _, model := range models {
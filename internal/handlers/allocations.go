@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AllocationsHandler handles project funding and tree allocation requests
+type AllocationsHandler struct {
+	allocationService *allocations.Service
+}
+
+// NewAllocationsHandler creates a new allocations handler
+func NewAllocationsHandler(allocationService *allocations.Service) *AllocationsHandler {
+	return &AllocationsHandler{
+		allocationService: allocationService,
+	}
+}
+
+// projectTargetBody is the body of tree target changes; a null target removes it
+type projectTargetBody struct {
+	TreesTarget *int `json:"trees_target"`
+}
+
+// GetProjectFunding returns a project's progress towards its tree target
+func (h *AllocationsHandler) GetProjectFunding(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	funding, err := h.allocationService.GetFunding(id)
+	if errors.Is(err, allocations.ErrProjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to fetch project funding: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project funding"})
+		return
+	}
+
+	c.JSON(http.StatusOK, funding)
+}
+
+// SetProjectTarget changes how many trees a project aims for
+func (h *AllocationsHandler) SetProjectTarget(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req projectTargetBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	funding, err := h.allocationService.SetTarget(id, req.TreesTarget)
+	switch {
+	case errors.Is(err, allocations.ErrInvalidTarget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, allocations.ErrProjectNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to set project target: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set project target"})
+		return
+	}
+
+	c.JSON(http.StatusOK, funding)
+}
+
+// AllocatePending allocates the trees of general donations no project needed when they were
+// made
+func (h *AllocationsHandler) AllocatePending(c *gin.Context) {
+	allocated, unallocated, err := h.allocationService.AllocatePending()
+	if err != nil {
+		logrus.Errorf("Failed to allocate pending trees: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to allocate trees"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allocated": allocated, "unallocated": unallocated})
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocationsHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAllocationsHandler(allocations.NewService())
+	router := gin.New()
+	router.GET("/v1/projects/:id/funding", handler.GetProjectFunding)
+	router.PUT("/admin/projects/:id/target", handler.SetProjectTarget)
+
+	projectID := "1b4e28ba-2fa1-41d2-883f-0016d3cca427"
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid funding project ID", http.MethodGet, "/v1/projects/nope/funding", ``},
+		{"invalid target project ID", http.MethodPut, "/admin/projects/nope/target", `{"trees_target":1000}`},
+		{"invalid JSON", http.MethodPut, "/admin/projects/" + projectID + "/target", `{`},
+		{"negative target", http.MethodPut, "/admin/projects/" + projectID + "/target", `{"trees_target":-1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
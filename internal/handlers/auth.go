@@ -66,13 +66,19 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
-	if _, err := h.authService.GetUserByEmail(req.Email); err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User with this email already exists"})
-		return
+	// Check if user already exists; a guest account of the email is claimed instead
+	guest, err := h.authService.GetUserByEmail(req.Email)
+	if err == nil {
+		userAuth, err := h.authService.GetUserAuthByAuthUserID(guest.AuthUserID)
+		if err != nil || !userAuth.Guest {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User with this email already exists"})
+			return
+		}
+	} else {
+		guest = nil
 	}
 
-	if _, err := h.authService.GetUserByUsername(req.Username); err == nil {
+	if existing, err := h.authService.GetUserByUsername(req.Username); err == nil && (guest == nil || existing.AuthUserID != guest.AuthUserID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Username already taken"})
 		return
 	}
@@ -84,8 +90,13 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	// Create user
-	user, err := h.authService.CreateUser(req.Email, req.Username, passwordHash, req.DisplayName)
+	// Create user, or claim the guest account with its donations
+	var user *models.User
+	if guest != nil {
+		user, err = h.authService.ClaimGuestUser(guest.AuthUserID, req.Username, passwordHash, req.DisplayName)
+	} else {
+		user, err = h.authService.CreateUser(req.Email, req.Username, passwordHash, req.DisplayName)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
@@ -273,6 +284,12 @@ func (h *AuthHandler) ForgotPassword(c *gin.Context) {
 		return
 	}
 
+	// Guest accounts have no password; they are claimed by registering
+	if userAuth, err := h.authService.GetUserAuthByAuthUserID(user.AuthUserID); err != nil || userAuth.Guest {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
 	// Create password reset token
 	expiresAt := time.Now().Add(1 * time.Hour)
 	token, err := h.authService.CreatePasswordResetToken(user.AuthUserID, expiresAt)
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/campaigns"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// CampaignsHandler handles fundraising campaign requests
+type CampaignsHandler struct {
+	campaignService *campaigns.Service
+}
+
+// NewCampaignsHandler creates a new campaigns handler
+func NewCampaignsHandler(campaignService *campaigns.Service) *CampaignsHandler {
+	return &CampaignsHandler{
+		campaignService: campaignService,
+	}
+}
+
+// campaignBody is the body of admin campaign requests
+type campaignBody struct {
+	Slug            string      `json:"slug" binding:"required"`
+	Title           string      `json:"title" binding:"required"`
+	Description     *string     `json:"description"`
+	GoalTrees       *int        `json:"goal_trees"`
+	GoalAmountMinor *int64      `json:"goal_amount_minor"`
+	GoalCurrency    *string     `json:"goal_currency"`
+	StartsAt        time.Time   `json:"starts_at" binding:"required"`
+	EndsAt          time.Time   `json:"ends_at" binding:"required"`
+	ProjectIDs      []uuid.UUID `json:"project_ids"`
+}
+
+// input converts the body to a campaign input
+func (b *campaignBody) input() *campaigns.CampaignInput {
+	input := &campaigns.CampaignInput{
+		Slug:            b.Slug,
+		Title:           b.Title,
+		Description:     b.Description,
+		GoalTrees:       b.GoalTrees,
+		GoalAmountMinor: b.GoalAmountMinor,
+		StartsAt:        b.StartsAt,
+		EndsAt:          b.EndsAt,
+		ProjectIDs:      b.ProjectIDs,
+	}
+	if b.GoalCurrency != nil {
+		currency := models.Currency(*b.GoalCurrency)
+		input.GoalCurrency = &currency
+	}
+	return input
+}
+
+// GetCampaigns returns the progress of the campaigns that are running or coming up
+func (h *CampaignsHandler) GetCampaigns(c *gin.Context) {
+	progress, err := h.campaignService.GetCampaigns(time.Now())
+	if err != nil {
+		logrus.Errorf("Failed to fetch campaigns: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch campaigns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// GetCampaign returns the progress of a campaign towards its goals
+func (h *CampaignsHandler) GetCampaign(c *gin.Context) {
+	progress, err := h.campaignService.GetProgress(c.Param("slug"), time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to fetch campaign progress: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch campaign"})
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+// ListCampaigns returns every campaign, including ended ones
+func (h *CampaignsHandler) ListCampaigns(c *gin.Context) {
+	list, err := h.campaignService.ListCampaigns()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch campaigns"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateCampaign creates a campaign
+func (h *CampaignsHandler) CreateCampaign(c *gin.Context) {
+	var req campaignBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaign, err := h.campaignService.CreateCampaign(req.input())
+	if campaignFailed(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusCreated, campaign)
+}
+
+// UpdateCampaign replaces the fields and projects of a campaign
+func (h *CampaignsHandler) UpdateCampaign(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid campaign ID"})
+		return
+	}
+
+	var req campaignBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaign, err := h.campaignService.UpdateCampaign(id, req.input())
+	if campaignFailed(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, campaign)
+}
+
+// campaignFailed responds to an error of creating or updating a campaign. It reports
+// whether there was an error.
+func campaignFailed(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, campaigns.ErrInvalidCampaign):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, campaigns.ErrUnknownProject):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+	case errors.Is(err, campaigns.ErrSlugTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Another campaign uses this slug"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Campaign not found"})
+	default:
+		logrus.Errorf("Failed to save campaign: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save campaign"})
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/pkg/campaigns"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaignsHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewCampaignsHandler(campaigns.NewService(fx.NewService()))
+	router := gin.New()
+	router.POST("/admin/campaigns", handler.CreateCampaign)
+	router.PUT("/admin/campaigns/:id", handler.UpdateCampaign)
+
+	dates := `"starts_at":"2027-03-01T00:00:00Z","ends_at":"2027-04-23T00:00:00Z"`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid JSON", http.MethodPost, "/admin/campaigns", `{`},
+		{"missing title", http.MethodPost, "/admin/campaigns", `{"slug":"earth-day","goal_trees":1000,` + dates + `}`},
+		{"missing dates", http.MethodPost, "/admin/campaigns", `{"slug":"earth-day","title":"Earth Day","goal_trees":1000}`},
+		{"invalid slug", http.MethodPost, "/admin/campaigns", `{"slug":"Earth Day","title":"Earth Day","goal_trees":1000,` + dates + `}`},
+		{"no goal", http.MethodPost, "/admin/campaigns", `{"slug":"earth-day","title":"Earth Day",` + dates + `}`},
+		{"amount without currency", http.MethodPost, "/admin/campaigns", `{"slug":"earth-day","title":"Earth Day","goal_amount_minor":500000,` + dates + `}`},
+		{"invalid project ID", http.MethodPost, "/admin/campaigns", `{"slug":"earth-day","title":"Earth Day","goal_trees":1000,"project_ids":["nope"],` + dates + `}`},
+		{"invalid campaign ID", http.MethodPut, "/admin/campaigns/not-a-uuid", `{"slug":"earth-day","title":"Earth Day","goal_trees":1000,` + dates + `}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
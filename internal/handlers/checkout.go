@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CheckoutHandler serves the hosted test checkout pages of the fake payment provider
+type CheckoutHandler struct {
+	paymentService *payments.Service
+	provider       *payments.FakeProvider
+}
+
+// NewCheckoutHandler creates a new checkout handler
+func NewCheckoutHandler(paymentService *payments.Service, provider *payments.FakeProvider) *CheckoutHandler {
+	return &CheckoutHandler{
+		paymentService: paymentService,
+		provider:       provider,
+	}
+}
+
+// PaymentPage shows a fake payment and the outcomes the tester can choose
+func (h *CheckoutHandler) PaymentPage(c *gin.Context) {
+	payment, ok := h.payment(c)
+	if !ok {
+		return
+	}
+	h.renderPayment(c, http.StatusOK, payment, "")
+}
+
+// CompletePayment turns the chosen outcome into a signed webhook and processes it
+func (h *CheckoutHandler) CompletePayment(c *gin.Context) {
+	payment, ok := h.payment(c)
+	if !ok {
+		return
+	}
+
+	action := c.PostForm("action")
+	if !slices.Contains(payments.FakePaymentActions(payment.Status), action) {
+		h.renderPayment(c, http.StatusBadRequest, payment, "This action is not available for the payment")
+		return
+	}
+
+	req, err := h.provider.PaymentWebhook(payment, action)
+	if err == nil {
+		_, err = h.paymentService.ProcessWebhook(h.provider, req)
+	}
+	if err != nil {
+		logrus.WithField("payment_id", payment.ID).Errorf("Fake checkout %s failed: %v", action, err)
+		h.renderPayment(c, http.StatusInternalServerError, payment, "Failed to process the payment: "+err.Error())
+		return
+	}
+
+	returnURL := payments.MetaString(payment.Meta, "fail_return_url")
+	if action == payments.FakeActionSucceed {
+		returnURL = payments.MetaString(payment.Meta, "success_return_url")
+	}
+	if returnURL == "" || action == payments.FakeActionRefund {
+		returnURL = c.Request.URL.Path
+	}
+	c.Redirect(http.StatusSeeOther, returnURL)
+}
+
+// SubscriptionPage shows a fake subscription and the outcomes the tester can choose
+func (h *CheckoutHandler) SubscriptionPage(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+	h.renderSubscription(c, http.StatusOK, subscription, "")
+}
+
+// CompleteSubscription turns the chosen outcome into a signed webhook and processes it
+func (h *CheckoutHandler) CompleteSubscription(c *gin.Context) {
+	subscription, ok := h.subscription(c)
+	if !ok {
+		return
+	}
+
+	action := c.PostForm("action")
+	if !slices.Contains(payments.FakeSubscriptionActions(subscription.Status), action) {
+		h.renderSubscription(c, http.StatusBadRequest, subscription, "This action is not available for the subscription")
+		return
+	}
+
+	// The first successful charge sends the donor back to the app, later ones stay on the page
+	firstCharge := subscription.Status == models.SubscriptionStatusIncomplete
+
+	req, err := h.provider.SubscriptionWebhook(subscription, action)
+	if err == nil {
+		_, err = h.paymentService.ProcessWebhook(h.provider, req)
+	}
+	if err != nil {
+		logrus.WithField("subscription_id", subscription.ID).Errorf("Fake checkout %s failed: %v", action, err)
+		h.renderSubscription(c, http.StatusInternalServerError, subscription, "Failed to process the subscription: "+err.Error())
+		return
+	}
+
+	returnURL := c.Request.URL.Path
+	if firstCharge {
+		key := "fail_return_url"
+		if action == payments.FakeActionSucceed {
+			key = "success_return_url"
+		}
+		if url := payments.MetaString(subscription.Meta, key); url != "" {
+			returnURL = url
+		}
+	}
+	c.Redirect(http.StatusSeeOther, returnURL)
+}
+
+func (h *CheckoutHandler) payment(c *gin.Context) (*models.Payment, bool) {
+	payment, err := h.paymentService.GetPaymentByID(c.Param("id"))
+	if err != nil || payment.Provider != h.provider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return nil, false
+	}
+	return payment, true
+}
+
+func (h *CheckoutHandler) subscription(c *gin.Context) (*models.Subscription, bool) {
+	subscription, err := h.paymentService.GetSubscriptionByID(c.Param("id"))
+	if err != nil || subscription.Provider != h.provider.Name() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return nil, false
+	}
+	return subscription, true
+}
+
+func (h *CheckoutHandler) renderPayment(c *gin.Context, status int, payment *models.Payment, message string) {
+	c.HTML(status, "fake_checkout.html", gin.H{
+		"title":    "Test payment",
+		"id":       payment.ID,
+		"amount":   payments.FormatAmountMinor(payment.AmountMinor),
+		"currency": payment.Currency,
+		"status":   payment.Status,
+		"actions":  payments.FakePaymentActions(payment.Status),
+		"error":    message,
+	})
+}
+
+func (h *CheckoutHandler) renderSubscription(c *gin.Context, status int, subscription *models.Subscription, message string) {
+	c.HTML(status, "fake_checkout.html", gin.H{
+		"title":          "Test subscription",
+		"id":             subscription.ID,
+		"amount":         payments.FormatAmountMinor(subscription.AmountMinor),
+		"currency":       subscription.Currency,
+		"status":         subscription.Status,
+		"intervalMonths": subscription.IntervalMonths,
+		"actions":        payments.FakeSubscriptionActions(subscription.Status),
+		"error":          message,
+	})
+}
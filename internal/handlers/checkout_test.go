@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCheckoutHandler(t *testing.T) {
+	provider := payments.NewFakeProvider("http://localhost:8080", "secret")
+	paymentService := payments.NewService(payments.NewRegistry(provider), payments.AmountLimits{})
+
+	handler := NewCheckoutHandler(paymentService, provider)
+	assert.NotNil(t, handler)
+	assert.Equal(t, paymentService, handler.paymentService)
+	assert.Equal(t, provider, handler.provider)
+}
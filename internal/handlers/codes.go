@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/codes"
+	"github.com/4planet/backend/pkg/pagination"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// CodesHandler handles prepaid tree certificate and promo code requests
+type CodesHandler struct {
+	codeService *codes.Service
+}
+
+// NewCodesHandler creates a new codes handler
+func NewCodesHandler(codeService *codes.Service) *CodesHandler {
+	return &CodesHandler{
+		codeService: codeService,
+	}
+}
+
+// redeemBody is the body of code redemptions
+type redeemBody struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// generateCodesBody is the body of admin code generation requests
+type generateCodesBody struct {
+	Code           string     `json:"code"`
+	Prefix         string     `json:"prefix"`
+	Count          int        `json:"count"`
+	Batch          *string    `json:"batch"`
+	TreesCount     int        `json:"trees_count" binding:"required"`
+	MaxRedemptions int        `json:"max_redemptions"`
+	ProjectID      *uuid.UUID `json:"project_id"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// Redeem creates a donation of the trees of a code for the current user
+func (h *CodesHandler) Redeem(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	u := user.(*models.User)
+
+	var req redeemBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	redemption, err := h.codeService.Redeem(u.AuthUserID, req.Code, time.Now())
+	switch {
+	case errors.Is(err, codes.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Code not found"})
+		return
+	case errors.Is(err, codes.ErrExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "Code has expired"})
+		return
+	case errors.Is(err, codes.ErrUsedUp):
+		c.JSON(http.StatusConflict, gin.H{"error": "Code has been used up"})
+		return
+	case errors.Is(err, codes.ErrAlreadyRedeemed):
+		c.JSON(http.StatusConflict, gin.H{"error": "You already redeemed this code"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to redeem code: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem code"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, redemption)
+}
+
+// ListCodes returns a page of the codes of a batch, or of every code
+func (h *CodesHandler) ListCodes(c *gin.Context) {
+	params := pagination.ExtractPagination(c)
+
+	list, total, err := h.codeService.ListCodes(c.Query("batch"), params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pagination.NewPaginatedResponse(list, total, params))
+}
+
+// GenerateCodes creates a custom code or a batch of random codes
+func (h *CodesHandler) GenerateCodes(c *gin.Context) {
+	var req generateCodesBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list, err := h.codeService.Generate(&codes.GenerateInput{
+		Code:           req.Code,
+		Prefix:         req.Prefix,
+		Count:          req.Count,
+		Batch:          req.Batch,
+		TreesCount:     req.TreesCount,
+		MaxRedemptions: req.MaxRedemptions,
+		ProjectID:      req.ProjectID,
+		ExpiresAt:      req.ExpiresAt,
+	})
+	switch {
+	case errors.Is(err, codes.ErrInvalidCodes):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, codes.ErrUnknownProject):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+		return
+	case errors.Is(err, codes.ErrCodeTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": "Code already exists"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to generate codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate codes"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, list)
+}
+
+// ExportCodes downloads the codes of a batch, or every code, as a CSV file
+func (h *CodesHandler) ExportCodes(c *gin.Context) {
+	batch := c.Query("batch")
+	list, err := h.codeService.ExportCodes(batch)
+	if err != nil {
+		logrus.Errorf("Failed to export codes: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export codes"})
+		return
+	}
+
+	filename := "codes.csv"
+	if batch != "" {
+		filename = "codes-" + batch + ".csv"
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+	if err := codes.WriteCSV(c.Writer, list); err != nil {
+		logrus.Errorf("Failed to write codes CSV: %v", err)
+	}
+}
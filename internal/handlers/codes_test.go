@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/achievements"
+	"github.com/4planet/backend/pkg/codes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodesHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewCodesHandler(codes.NewService(achievements.NewService()))
+	router := gin.New()
+	router.POST("/admin/codes", handler.GenerateCodes)
+	router.POST("/v1/me/redeem", func(c *gin.Context) {
+		c.Set("user", &models.User{AuthUserID: "user-1"})
+		handler.Redeem(c)
+	})
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"invalid JSON", "/admin/codes", `{`},
+		{"missing trees", "/admin/codes", `{"count":100}`},
+		{"missing count", "/admin/codes", `{"trees_count":1}`},
+		{"too many codes", "/admin/codes", `{"count":10001,"trees_count":1}`},
+		{"custom code with count", "/admin/codes", `{"code":"EARTHDAY","count":5,"trees_count":1}`},
+		{"invalid custom code", "/admin/codes", `{"code":"EARTH DAY","trees_count":1}`},
+		{"invalid project ID", "/admin/codes", `{"count":1,"trees_count":1,"project_id":"nope"}`},
+		{"missing code", "/v1/me/redeem", `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
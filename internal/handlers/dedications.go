@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/4planet/backend/pkg/dedications"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DedicationsHandler handles tree certificates of gift donations
+type DedicationsHandler struct {
+	dedicationService *dedications.Service
+}
+
+// NewDedicationsHandler creates a new dedications handler
+func NewDedicationsHandler(dedicationService *dedications.Service) *DedicationsHandler {
+	return &DedicationsHandler{
+		dedicationService: dedicationService,
+	}
+}
+
+// GetCertificate returns the tree certificate of a gift donation. The token in the link
+// emailed to the recipient is the only way to find a certificate.
+func (h *DedicationsHandler) GetCertificate(c *gin.Context) {
+	certificate, err := h.dedicationService.GetCertificate(c.Param("token"))
+	if errors.Is(err, dedications.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to fetch certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch certificate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
+
+// ClaimCertificate adds the trees of a gift donation to the current user's profile instead
+// of the donor's
+func (h *DedicationsHandler) ClaimCertificate(c *gin.Context) {
+	authUserID := c.GetString("user_id")
+
+	certificate, err := h.dedicationService.Claim(authUserID, c.Param("token"))
+	switch {
+	case errors.Is(err, dedications.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+		return
+	case errors.Is(err, dedications.ErrAlreadyClaimed):
+		c.JSON(http.StatusConflict, gin.H{"error": "These trees were already claimed"})
+		return
+	case errors.Is(err, dedications.ErrOwnGift):
+		c.JSON(http.StatusConflict, gin.H{"error": "You cannot claim trees you gave"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to claim certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to claim certificate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
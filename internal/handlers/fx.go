@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/config"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRatesFileSize limits uploaded rate files; the full ECB history is about 6 MB
+const maxRatesFileSize = 16 << 20
+
+// FXHandler handles admin exchange rate and reporting requests
+type FXHandler struct {
+	fxService *fx.Service
+	config    *config.Config
+}
+
+// NewFXHandler creates a new FX handler
+func NewFXHandler(fxService *fx.Service, config *config.Config) *FXHandler {
+	return &FXHandler{
+		fxService: fxService,
+		config:    config,
+	}
+}
+
+// ImportRates loads exchange rates from the request body: a CSV file (text/csv) or an ECB
+// reference rates file (application/xml)
+func (h *FXHandler) ImportRates(c *gin.Context) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	var parse func(io.Reader) ([]models.FXRate, error)
+	switch mediaType {
+	case "text/csv":
+		parse = fx.ParseCSV
+	case "application/xml", "text/xml":
+		parse = fx.ParseECBXML
+	default:
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Rates must be sent as text/csv or application/xml"})
+		return
+	}
+
+	rates, err := parse(http.MaxBytesReader(c.Writer, c.Request.Body, maxRatesFileSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	imported, err := h.fxService.ImportRates(rates)
+	if err != nil {
+		logrus.Errorf("Failed to import exchange rates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imported": imported})
+}
+
+// GetRates lists the exchange rates between two days, the last 30 days by default
+func (h *FXHandler) GetRates(c *gin.Context) {
+	to := time.Now()
+	from := to.AddDate(0, 0, -30)
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse("2006-01-02", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+
+	rates, err := h.fxService.GetRates(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// GetMonthlyGross reports gross donations per month in the reporting currency. The
+// months are given as YYYY-MM and default to the last 12 months.
+func (h *FXHandler) GetMonthlyGross(c *gin.Context) {
+	currency := models.Currency(c.DefaultQuery("currency", h.config.FX.ReportingCurrency))
+	if !currency.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency"})
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, -11, 0)
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse("2006-01", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from month, expected YYYY-MM"})
+			return
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse("2006-01", value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to month, expected YYYY-MM"})
+			return
+		}
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+
+	report, err := h.fxService.GetMonthlyGross(currency, from, to)
+	if err != nil {
+		logrus.Errorf("Failed to build monthly gross report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
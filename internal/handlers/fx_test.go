@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/internal/config"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFXTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{}
+	cfg.FX.ReportingCurrency = "EUR"
+	handler := NewFXHandler(fx.NewService(), cfg)
+
+	router := gin.New()
+	router.POST("/admin/fx-rates", handler.ImportRates)
+	router.GET("/admin/fx-rates", handler.GetRates)
+	router.GET("/admin/reports/gross-monthly", handler.GetMonthlyGross)
+	return router
+}
+
+func TestFXHandler_ImportRates_InvalidRequest(t *testing.T) {
+	router := newFXTestRouter()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"unsupported content type", "application/json", `{}`, http.StatusUnsupportedMediaType},
+		{"invalid CSV", "text/csv", "date,rate\n2025-03-14,1.08\n", http.StatusBadRequest},
+		{"invalid XML", "application/xml", "<Envelope>", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/admin/fx-rates", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestFXHandler_InvalidQuery(t *testing.T) {
+	router := newFXTestRouter()
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"invalid rates date", "/admin/fx-rates?from=14.03.2025"},
+		{"unsupported currency", "/admin/reports/gross-monthly?currency=GBP"},
+		{"invalid month", "/admin/reports/gross-monthly?from=2025-13"},
+		{"reversed months", "/admin/reports/gross-monthly?from=2025-06&to=2025-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// idempotencyKeyHeader lets clients safely retry requests that create resources
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// beginIdempotentRequest reserves the request's Idempotency-Key, if it has one. It returns
+// handled when the response was already written: a replay of the first request with the
+// key, or a conflict. The returned record is nil when the request carries no key.
+func beginIdempotentRequest(c *gin.Context, service *idempotency.Service, scope, authUserID string, req interface{}) (record *models.IdempotencyKey, handled bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		return nil, false
+	}
+	if len(key) > idempotency.MaxKeyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+		return nil, true
+	}
+
+	fingerprint, err := idempotency.Fingerprint(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return nil, true
+	}
+
+	record, err = service.Begin(authUserID, scope, key, fingerprint)
+	switch {
+	case errors.Is(err, idempotency.ErrKeyReused):
+		c.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used with a different request"})
+		return nil, true
+	case errors.Is(err, idempotency.ErrRequestInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+		return nil, true
+	case err != nil:
+		logrus.Errorf("Failed to reserve idempotency key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return nil, true
+	}
+
+	if record.ResponseStatus != nil {
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(*record.ResponseStatus, "application/json; charset=utf-8", []byte(*record.ResponseBody))
+		return nil, true
+	}
+	return record, false
+}
+
+// finishIdempotentRequest writes the response and stores it for replay. Server errors
+// are not stored; the key is released so that the client can retry. A request whose key
+// was taken over leaves the key to its new holder.
+func finishIdempotentRequest(c *gin.Context, service *idempotency.Service, record *models.IdempotencyKey, status int, body interface{}) {
+	if record != nil {
+		var err error
+		if status >= http.StatusInternalServerError {
+			err = service.Release(record)
+		} else {
+			err = service.Complete(record, status, body)
+		}
+		switch {
+		case errors.Is(err, idempotency.ErrLeaseLost):
+			// The request ran past its lease; the response of the request that took the key over is kept
+			logrus.Warnf("Idempotent request finished after its key was taken over: %s", record.Key)
+		case err != nil:
+			logrus.Errorf("Failed to finish idempotent request: %v", err)
+		}
+	}
+
+	c.JSON(status, body)
+}
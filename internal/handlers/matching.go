@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/matching"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// MatchingHandler handles sponsor matching pool requests
+type MatchingHandler struct {
+	matchingService *matching.Service
+}
+
+// NewMatchingHandler creates a new matching handler
+func NewMatchingHandler(matchingService *matching.Service) *MatchingHandler {
+	return &MatchingHandler{
+		matchingService: matchingService,
+	}
+}
+
+// matchingPoolBody is the body of admin matching pool requests
+type matchingPoolBody struct {
+	SponsorName    string     `json:"sponsor_name" binding:"required"`
+	SponsorEmail   string     `json:"sponsor_email" binding:"required"`
+	ProjectID      *uuid.UUID `json:"project_id"`
+	CampaignID     *uuid.UUID `json:"campaign_id"`
+	Currency       string     `json:"currency" binding:"required"`
+	CapTrees       *int       `json:"cap_trees"`
+	CapAmountMinor *int64     `json:"cap_amount_minor"`
+	StartsAt       time.Time  `json:"starts_at" binding:"required"`
+	EndsAt         time.Time  `json:"ends_at" binding:"required"`
+}
+
+// ListPools returns every matching pool
+func (h *MatchingHandler) ListPools(c *gin.Context) {
+	pools, err := h.matchingService.ListPools()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch matching pools"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pools)
+}
+
+// CreatePool creates a matching pool for a sponsor account
+func (h *MatchingHandler) CreatePool(c *gin.Context) {
+	var req matchingPoolBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pool, err := h.matchingService.CreatePool(&matching.PoolInput{
+		SponsorName:    req.SponsorName,
+		SponsorEmail:   req.SponsorEmail,
+		ProjectID:      req.ProjectID,
+		CampaignID:     req.CampaignID,
+		Currency:       models.Currency(req.Currency),
+		CapTrees:       req.CapTrees,
+		CapAmountMinor: req.CapAmountMinor,
+		StartsAt:       req.StartsAt,
+		EndsAt:         req.EndsAt,
+	})
+	switch {
+	case errors.Is(err, matching.ErrInvalidPool):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, matching.ErrUnknownSponsor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No account uses the sponsor email"})
+		return
+	case errors.Is(err, matching.ErrUnknownProject):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+		return
+	case errors.Is(err, matching.ErrUnknownCampaign):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Campaign not found"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to create matching pool: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create matching pool"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, pool)
+}
+
+// GetPoolReport returns how much of a matching pool was used
+func (h *MatchingHandler) GetPoolReport(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid matching pool ID"})
+		return
+	}
+
+	report, err := h.matchingService.GetReport(id, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Matching pool not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to build matching pool report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetMyMatchingPools returns the usage reports of the pools the current user sponsors
+func (h *MatchingHandler) GetMyMatchingPools(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	u := user.(*models.User)
+
+	reports, err := h.matchingService.GetSponsorReports(u.AuthUserID, time.Now())
+	if err != nil {
+		logrus.Errorf("Failed to build sponsor reports: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch matching pools"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reports)
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/pkg/matching"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchingHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewMatchingHandler(matching.NewService())
+	router := gin.New()
+	router.POST("/admin/matching-pools", handler.CreatePool)
+	router.GET("/admin/matching-pools/:id/report", handler.GetPoolReport)
+
+	sponsor := `"sponsor_name":"Company X","sponsor_email":"csr@company-x.example"`
+	dates := `"starts_at":"2027-10-01T00:00:00Z","ends_at":"2027-11-01T00:00:00Z"`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid JSON", http.MethodPost, "/admin/matching-pools", `{`},
+		{"missing sponsor", http.MethodPost, "/admin/matching-pools", `{"currency":"EUR","cap_trees":1000,` + dates + `}`},
+		{"missing dates", http.MethodPost, "/admin/matching-pools", `{` + sponsor + `,"currency":"EUR","cap_trees":1000}`},
+		{"no cap", http.MethodPost, "/admin/matching-pools", `{` + sponsor + `,"currency":"EUR",` + dates + `}`},
+		{"unsupported currency", http.MethodPost, "/admin/matching-pools", `{` + sponsor + `,"currency":"GBP","cap_trees":1000,` + dates + `}`},
+		{"invalid project ID", http.MethodPost, "/admin/matching-pools", `{` + sponsor + `,"currency":"EUR","cap_trees":1000,"project_id":"nope",` + dates + `}`},
+		{"invalid pool ID", http.MethodGet, "/admin/matching-pools/not-a-uuid/report", ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
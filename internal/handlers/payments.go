@@ -1,69 +1,379 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/auth"
+	"github.com/4planet/backend/pkg/idempotency"
 	"github.com/4planet/backend/pkg/payments"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// paymentStreamPollInterval is how often a status stream re-reads the payment, for
+	// webhooks that were handled by another instance
+	paymentStreamPollInterval = 2 * time.Second
+	// paymentStreamKeepAlive is how often an idle status stream sends a comment
+	paymentStreamKeepAlive = 15 * time.Second
+	// paymentStreamTimeout closes status streams of payments that stay pending
+	paymentStreamTimeout = 5 * time.Minute
 )
 
 // PaymentsHandler handles payment-related requests
 type PaymentsHandler struct {
-	paymentService *payments.CloudPaymentsService
+	paymentService     *payments.Service
+	idempotencyService *idempotency.Service
+	authService        *auth.Service
 }
 
 // NewPaymentsHandler creates a new payments handler
-func NewPaymentsHandler(paymentService *payments.CloudPaymentsService) *PaymentsHandler {
+func NewPaymentsHandler(paymentService *payments.Service, idempotencyService *idempotency.Service, authService *auth.Service) *PaymentsHandler {
 	return &PaymentsHandler{
-		paymentService: paymentService,
+		paymentService:     paymentService,
+		idempotencyService: idempotencyService,
+		authService:        authService,
+	}
+}
+
+// paymentIntentBody is the body of a payment intent request
+type paymentIntentBody struct {
+	Provider         string  `json:"provider" binding:"required"`
+	AmountMinor      int64   `json:"amount_minor"`
+	Currency         string  `json:"currency"`
+	SuccessReturnURL string  `json:"success_return_url"`
+	FailReturnURL    string  `json:"fail_return_url"`
+	Description      *string `json:"description"`
+	ProjectID        *string `json:"project_id"`
+	ReferralUserID   *string `json:"referral_user_id"`
+	CampaignID       *string `json:"campaign_id"`
+	// Dedication makes the donation a gift; the recipient is emailed a tree certificate
+	Dedication *payments.Dedication `json:"dedication"`
+}
+
+// paymentRequest converts the body to a payment intent request
+func (b *paymentIntentBody) paymentRequest() (*payments.PaymentIntentRequest, *payments.FieldError) {
+	var projectID *uuid.UUID
+	if b.ProjectID != nil && *b.ProjectID != "" {
+		parsedID, err := uuid.Parse(*b.ProjectID)
+		if err != nil {
+			return nil, &payments.FieldError{
+				Field:   "project_id",
+				Code:    payments.FieldErrorInvalid,
+				Message: "Project ID must be a UUID",
+			}
+		}
+		projectID = &parsedID
 	}
+	var campaignID *uuid.UUID
+	if b.CampaignID != nil && *b.CampaignID != "" {
+		parsedID, err := uuid.Parse(*b.CampaignID)
+		if err != nil {
+			return nil, &payments.FieldError{
+				Field:   "campaign_id",
+				Code:    payments.FieldErrorInvalid,
+				Message: "Campaign ID must be a UUID",
+			}
+		}
+		campaignID = &parsedID
+	}
+
+	return &payments.PaymentIntentRequest{
+		Provider:         b.Provider,
+		AmountMinor:      b.AmountMinor,
+		Currency:         b.Currency,
+		SuccessReturnURL: b.SuccessReturnURL,
+		FailReturnURL:    b.FailReturnURL,
+		Description:      b.Description,
+		ProjectID:        projectID,
+		ReferralUserID:   b.ReferralUserID,
+		CampaignID:       campaignID,
+		Dedication:       b.Dedication,
+	}, nil
 }
 
-// CreatePaymentIntent creates a new payment intent
+// CreatePaymentIntent creates a new payment intent. Requests with an Idempotency-Key
+// header create at most one payment per key.
 func (h *PaymentsHandler) CreatePaymentIntent(c *gin.Context) {
-	authUserID := c.GetString("auth_user_id")
+	authUserID := c.GetString("user_id")
 
-	var req struct {
-		Provider         string  `json:"provider" binding:"required"`
-		AmountMinor      int64   `json:"amount_minor" binding:"required"`
-		Currency         string  `json:"currency" binding:"required"`
-		SuccessReturnURL string  `json:"success_return_url" binding:"required"`
-		FailReturnURL    string  `json:"fail_return_url" binding:"required"`
-		Description      *string `json:"description"`
-		ProjectID        *string `json:"project_id"`
-		ReferralUserID   *string `json:"referral_user_id"`
+	var req paymentIntentBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	paymentReq, fieldErr := req.paymentRequest()
+	if fieldErr != nil {
+		c.JSON(http.StatusBadRequest, fieldErrorsResponse(*fieldErr))
+		return
+	}
+
+	record, handled := beginIdempotentRequest(c, h.idempotencyService, idempotency.ScopePaymentIntent, authUserID, req)
+	if handled {
+		return
 	}
 
+	status, body := h.createPaymentIntent(paymentReq, authUserID)
+	finishIdempotentRequest(c, h.idempotencyService, record, status, body)
+}
+
+// CreateGuestPaymentIntent creates a payment intent for a donor without an account. The
+// donation is made by a guest account for the email, which the donor can claim later by
+// registering with the same email. The guest account is only created for valid intents.
+func (h *PaymentsHandler) CreateGuestPaymentIntent(c *gin.Context) {
+	var req struct {
+		paymentIntentBody
+		Email string `json:"email" binding:"required,email"`
+	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Parse project ID if provided
-	var projectID *uuid.UUID
-	if req.ProjectID != nil {
-		if parsedID, err := uuid.Parse(*req.ProjectID); err == nil {
-			projectID = &parsedID
+	paymentReq, fieldErr := req.paymentRequest()
+	if fieldErr != nil {
+		c.JSON(http.StatusBadRequest, fieldErrorsResponse(*fieldErr))
+		return
+	}
+	if err := h.paymentService.ValidatePaymentIntent(paymentReq); err != nil {
+		status, body := paymentIntentErrorResponse(err)
+		c.JSON(status, body)
+		return
+	}
+
+	guest, err := h.authService.GetOrCreateGuestUser(req.Email)
+	if errors.Is(err, auth.ErrAccountExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email exists, please log in to donate"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to create guest account: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		return
+	}
+
+	record, handled := beginIdempotentRequest(c, h.idempotencyService, idempotency.ScopePaymentIntent, guest.AuthUserID, req)
+	if handled {
+		return
+	}
+
+	status, body := h.createPaymentIntent(paymentReq, guest.AuthUserID)
+	finishIdempotentRequest(c, h.idempotencyService, record, status, body)
+}
+
+// createPaymentIntent creates the payment intent with the requested provider
+func (h *PaymentsHandler) createPaymentIntent(req *payments.PaymentIntentRequest, authUserID string) (int, interface{}) {
+	response, err := h.paymentService.CreatePaymentIntent(req, authUserID)
+	if err != nil {
+		return paymentIntentErrorResponse(err)
+	}
+	return http.StatusOK, response
+}
+
+// paymentIntentErrorResponse maps an error creating or validating a payment intent to a response
+func paymentIntentErrorResponse(err error) (int, gin.H) {
+	var validationErr *payments.ValidationError
+	switch {
+	case errors.Is(err, payments.ErrUnknownProvider):
+		return http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"}
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest, fieldErrorsResponse(validationErr.Fields...)
+	case errors.Is(err, payments.ErrUnsupportedCurrency):
+		return http.StatusBadRequest, unsupportedCurrencyResponse()
+	case errors.Is(err, payments.ErrUnknownProject):
+		return http.StatusBadRequest, gin.H{"error": "Project not found"}
+	default:
+		return http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"}
+	}
+}
+
+// fieldErrorsResponse is the response for requests with invalid fields
+func fieldErrorsResponse(fields ...payments.FieldError) gin.H {
+	return gin.H{"error": "Invalid request", "fields": fields}
+}
+
+// unsupportedCurrencyResponse is the response for currencies the chosen provider cannot charge
+func unsupportedCurrencyResponse() gin.H {
+	return fieldErrorsResponse(payments.FieldError{
+		Field:   "currency",
+		Code:    payments.FieldErrorUnsupported,
+		Message: "Currency is not supported by the payment provider",
+	})
+}
+
+// PaymentResponse is the status of a payment as seen by its owner
+type PaymentResponse struct {
+	ID          string                   `json:"id"`
+	Provider    models.PaymentProvider   `json:"provider"`
+	Status      models.PaymentStatus     `json:"status"`
+	AmountMinor int64                    `json:"amount_minor"`
+	Currency    models.Currency          `json:"currency"`
+	OccurredAt  *time.Time               `json:"occurred_at"`
+	CreatedAt   time.Time                `json:"created_at"`
+	Donation    *PaymentDonationResponse `json:"donation"`
+}
+
+// PaymentDonationResponse is the donation a succeeded payment produced
+type PaymentDonationResponse struct {
+	ID         string     `json:"id"`
+	TreesCount int        `json:"trees_count"`
+	PriceMinor int64      `json:"price_minor"`
+	ProjectID  *uuid.UUID `json:"project_id"`
+	// MatchedTrees are the trees sponsors planted to match the donation
+	MatchedTrees int       `json:"matched_trees"`
+	MatchedBy    []string  `json:"matched_by"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// newPaymentResponse builds the response for a payment loaded with its donation
+func newPaymentResponse(payment *models.Payment) PaymentResponse {
+	response := PaymentResponse{
+		ID:          payment.ID.String(),
+		Provider:    payment.Provider,
+		Status:      payment.Status,
+		AmountMinor: payment.AmountMinor,
+		Currency:    payment.Currency,
+		OccurredAt:  payment.OccurredAt,
+		CreatedAt:   payment.CreatedAt,
+	}
+	if payment.Donation != nil {
+		response.Donation = &PaymentDonationResponse{
+			ID:         payment.Donation.ID.String(),
+			TreesCount: payment.Donation.TreesCount,
+			PriceMinor: payment.Donation.PriceMinor,
+			ProjectID:  payment.Donation.ProjectID,
+			MatchedBy:  []string{},
+			CreatedAt:  payment.Donation.CreatedAt,
+		}
+		for _, match := range payment.Donation.Matches {
+			if match.TreesCount == 0 {
+				continue
+			}
+			response.Donation.MatchedTrees += match.TreesCount
+			if match.MatchingPool != nil {
+				response.Donation.MatchedBy = append(response.Donation.MatchedBy, match.MatchingPool.SponsorName)
+			}
 		}
 	}
+	return response
+}
 
-	paymentReq := &payments.PaymentIntentRequest{
-		Provider:         req.Provider,
-		AmountMinor:      req.AmountMinor,
-		Currency:         req.Currency,
-		SuccessReturnURL: req.SuccessReturnURL,
-		FailReturnURL:    req.FailReturnURL,
-		Description:      req.Description,
-		ProjectID:        projectID,
-		ReferralUserID:   req.ReferralUserID,
+// GetPayment returns the status of one of the current user's payments, for the page the
+// gateway redirects back to
+func (h *PaymentsHandler) GetPayment(c *gin.Context) {
+	payment, ok := h.loadUserPayment(c)
+	if !ok {
+		return
 	}
 
-	response, err := h.paymentService.CreatePaymentIntent(paymentReq, authUserID)
+	c.JSON(http.StatusOK, newPaymentResponse(payment))
+}
+
+// GetGuestPayment returns the status of a guest payment, for the return page of donors
+// without an account
+func (h *PaymentsHandler) GetGuestPayment(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	payment, err := h.paymentService.GetGuestPayment(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newPaymentResponse(payment))
+}
+
+// StreamPayment streams the status of one of the current user's payments as server-sent
+// events. A "payment" event is sent right away and on every change; the stream ends once
+// the payment leaves the pending state and, if it succeeded, its donation was created.
+func (h *PaymentsHandler) StreamPayment(c *gin.Context) {
+	payment, ok := h.loadUserPayment(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	updates, stop := h.paymentService.WatchPayment(payment.ID)
+	defer stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	authUserID := c.GetString("user_id")
+	poll := time.NewTicker(paymentStreamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(paymentStreamKeepAlive)
+	defer keepAlive.Stop()
+	timeout := time.NewTimer(paymentStreamTimeout)
+	defer timeout.Stop()
+
+	status := payment.Status
+	c.SSEvent("payment", newPaymentResponse(payment))
+	c.Writer.Flush()
+
+	for !payments.IsSettled(payment) {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timeout.C:
+			return
+		case <-keepAlive.C:
+			if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			continue
+		case <-updates:
+		case <-poll.C:
+		}
+
+		current, err := h.paymentService.GetUserPayment(authUserID, payment.ID.String())
+		if err != nil {
+			logrus.Errorf("Failed to reload payment %s: %v", payment.ID, err)
+			return
+		}
+		credited := payment.Donation != nil
+		payment = current
+		if payment.Status == status && (payment.Donation != nil) == credited {
+			continue
+		}
+		status = payment.Status
+		c.SSEvent("payment", newPaymentResponse(payment))
+		c.Writer.Flush()
+	}
+}
+
+// loadUserPayment loads the payment in the id path parameter if it belongs to the
+// current user, and answers the request otherwise
+func (h *PaymentsHandler) loadUserPayment(c *gin.Context) (*models.Payment, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return nil, false
+	}
+
+	payment, err := h.paymentService.GetUserPayment(c.GetString("user_id"), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment"})
+		return nil, false
+	}
+	return payment, true
 }
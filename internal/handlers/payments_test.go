@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/pkg/auth"
+	"github.com/4planet/backend/pkg/idempotency"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPaymentsHandler(t *testing.T) {
+	paymentService := newCloudPaymentsService()
+
+	idempotencyService := idempotency.NewService()
+
+	handler := NewPaymentsHandler(paymentService, idempotencyService, auth.NewService())
+	assert.NotNil(t, handler)
+	assert.Equal(t, paymentService, handler.paymentService)
+	assert.Equal(t, idempotencyService, handler.idempotencyService)
+}
+
+func TestPaymentsHandler_UnsupportedProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/payments/intents", NewPaymentsHandler(newCloudPaymentsService(), idempotency.NewService(), auth.NewService()).CreatePaymentIntent)
+
+	body := `{"provider":"tribute","amount_minor":19000,"currency":"RUB",` +
+		`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/payments/intents", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Unsupported payment provider"}`, w.Body.String())
+}
+
+func TestPaymentsHandler_IdempotencyKeyTooLong(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/payments/intents", NewPaymentsHandler(newCloudPaymentsService(), idempotency.NewService(), auth.NewService()).CreatePaymentIntent)
+
+	body := `{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB",` +
+		`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/payments/intents", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", strings.Repeat("k", idempotency.MaxKeyLength+1))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPaymentsHandler_GetPaymentInvalidID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewPaymentsHandler(newCloudPaymentsService(), idempotency.NewService(), auth.NewService())
+	router.GET("/v1/payments/:id", handler.GetPayment)
+	router.GET("/v1/payments/:id/events", handler.StreamPayment)
+
+	for _, path := range []string{"/v1/payments/not-a-uuid", "/v1/payments/not-a-uuid/events"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":"Invalid payment ID"}`, w.Body.String())
+	}
+}
+
+func TestSubscriptionsHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewSubscriptionsHandler(newCloudPaymentsService(), idempotency.NewService())
+	router.POST("/v1/me/subscriptions/:id/cancel", handler.CancelSubscription)
+	router.PATCH("/v1/me/subscriptions/:id", handler.UpdateSubscription)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid subscription ID", http.MethodPost, "/v1/me/subscriptions/not-a-uuid/cancel", ""},
+		{"negative amount", http.MethodPatch, "/v1/me/subscriptions/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10", `{"amount_minor":-100}`},
+		{"negative interval", http.MethodPatch, "/v1/me/subscriptions/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10", `{"interval_months":-1}`},
+		{"invalid project ID", http.MethodPatch, "/v1/me/subscriptions/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10", `{"project_id":"not-a-uuid"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestPaymentsHandler_FieldErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/payments/intents", NewPaymentsHandler(newCloudPaymentsService(), idempotency.NewService(), auth.NewService()).CreatePaymentIntent)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			"unknown currency",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"GBP",` +
+				`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`,
+			`{"error":"Invalid request","fields":[{"field":"currency","code":"unsupported","message":"Currency \"GBP\" is not supported"}]}`,
+		},
+		{
+			"missing fields",
+			`{"provider":"cloudpayments","amount_minor":19000}`,
+			`{"error":"Invalid request","fields":[` +
+				`{"field":"success_return_url","code":"required","message":"Success return URL is required"},` +
+				`{"field":"fail_return_url","code":"required","message":"Fail return URL is required"},` +
+				`{"field":"currency","code":"required","message":"Currency is required"}]}`,
+		},
+		{
+			"invalid project ID",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB","project_id":"not-a-uuid",` +
+				`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`,
+			`{"error":"Invalid request","fields":[{"field":"project_id","code":"invalid","message":"Project ID must be a UUID"}]}`,
+		},
+		{
+			"invalid campaign ID",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB","campaign_id":"earth-day",` +
+				`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`,
+			`{"error":"Invalid request","fields":[{"field":"campaign_id","code":"invalid","message":"Campaign ID must be a UUID"}]}`,
+		},
+		{
+			"invalid dedication",
+			`{"provider":"cloudpayments","amount_minor":19000,"dedication":{"recipient_name":"Anna","recipient_email":"anna"},` +
+				`"success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`,
+			`{"error":"Invalid request","fields":[` +
+				`{"field":"dedication.recipient_email","code":"invalid","message":"Recipient email is not a valid email address"},` +
+				`{"field":"currency","code":"required","message":"Currency is required"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/v1/payments/intents", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.JSONEq(t, tt.want, w.Body.String())
+		})
+	}
+}
+
+func TestSubscriptionsHandler_IntentFieldErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/subscriptions/intents", NewSubscriptionsHandler(newCloudPaymentsService(), idempotency.NewService()).CreateSubscriptionIntent)
+
+	body := `{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB","interval":"weekly",` +
+		`"project_id":"not-a-uuid","success_return_url":"https://app.local/ok","fail_return_url":"https://app.local/fail"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/subscriptions/intents", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"error":"Invalid request","fields":[`+
+		`{"field":"interval","code":"invalid","message":"Interval must be 'monthly' or 'yearly'"},`+
+		`{"field":"interval_count","code":"too_small","message":"Interval count must be at least 1"},`+
+		`{"field":"project_id","code":"invalid","message":"Project ID must be a UUID"}]}`, w.Body.String())
+}
+
+func TestPaymentsHandler_GuestInvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewPaymentsHandler(newCloudPaymentsService(), idempotency.NewService(), auth.NewService())
+	router.POST("/v1/guest/payments/intents", handler.CreateGuestPaymentIntent)
+	router.GET("/v1/guest/payments/:id", handler.GetGuestPayment)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{
+			"missing email", http.MethodPost, "/v1/guest/payments/intents",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB"}`,
+		},
+		{
+			"invalid email", http.MethodPost, "/v1/guest/payments/intents",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB","email":"not-an-email"}`,
+		},
+		{
+			"invalid project ID", http.MethodPost, "/v1/guest/payments/intents",
+			`{"provider":"cloudpayments","amount_minor":19000,"currency":"RUB","email":"donor@example.com","project_id":"not-a-uuid"}`,
+		},
+		{
+			// Rejected before a guest account is created for the email
+			"unsupported provider", http.MethodPost, "/v1/guest/payments/intents",
+			`{"provider":"unknown","amount_minor":19000,"currency":"RUB","email":"donor@example.com"}`,
+		},
+		{"invalid payment ID", http.MethodGet, "/v1/guest/payments/not-a-uuid", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
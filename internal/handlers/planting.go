@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/planting"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// PlantingHandler handles planting batch and planting evidence requests
+type PlantingHandler struct {
+	plantingService *planting.Service
+}
+
+// NewPlantingHandler creates a new planting handler
+func NewPlantingHandler(plantingService *planting.Service) *PlantingHandler {
+	return &PlantingHandler{
+		plantingService: plantingService,
+	}
+}
+
+// plantingBatchBody is the body of admin planting batch requests
+type plantingBatchBody struct {
+	PlantedOn  string          `json:"planted_on" binding:"required"`
+	Species    string          `json:"species" binding:"required"`
+	TreesCount int             `json:"trees_count" binding:"required"`
+	Area       json.RawMessage `json:"area" binding:"required"`
+	Notes      *string         `json:"notes"`
+}
+
+// evidenceBody is the body of requests attaching evidence to a batch
+type evidenceBody struct {
+	Kind     string  `json:"kind"`
+	URL      string  `json:"url" binding:"required"`
+	MimeType *string `json:"mime_type"`
+	Title    *string `json:"title"`
+	AltText  *string `json:"alt_text"`
+}
+
+// GetDonationPlanting returns where and when the trees of one of the current user's
+// donations were planted
+func (h *PlantingHandler) GetDonationPlanting(c *gin.Context) {
+	user, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+		return
+	}
+
+	u := user.(*models.User)
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid donation ID"})
+		return
+	}
+
+	result, err := h.plantingService.GetDonationPlanting(u.AuthUserID, id)
+	if errors.Is(err, planting.ErrDonationNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Donation not found"})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to fetch donation planting: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch planting"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListBatches returns the planting batches of a project
+func (h *PlantingHandler) ListBatches(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	batches, err := h.plantingService.ListBatches(projectID)
+	if err != nil {
+		logrus.Errorf("Failed to fetch planting batches: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch planting batches"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batches)
+}
+
+// CreateBatch records a planting batch and links funded trees of the project to it
+func (h *PlantingHandler) CreateBatch(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+
+	var req plantingBatchBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plantedOn, err := time.Parse("2006-01-02", req.PlantedOn)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid planted_on date, expected YYYY-MM-DD"})
+		return
+	}
+
+	batch, err := h.plantingService.CreateBatch(projectID, &planting.BatchInput{
+		PlantedOn:  plantedOn,
+		Species:    req.Species,
+		TreesCount: req.TreesCount,
+		Area:       req.Area,
+		Notes:      req.Notes,
+	}, time.Now())
+	switch {
+	case errors.Is(err, planting.ErrInvalidBatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, planting.ErrProjectNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to create planting batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create planting batch"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, batch)
+}
+
+// AddEvidence attaches a photo, video or document to a planting batch
+func (h *PlantingHandler) AddEvidence(c *gin.Context) {
+	batchID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid planting batch ID"})
+		return
+	}
+
+	var req evidenceBody
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	evidence, err := h.plantingService.AddEvidence(batchID, &planting.MediaInput{
+		Kind:     models.MediaKind(req.Kind),
+		URL:      req.URL,
+		MimeType: req.MimeType,
+		Title:    req.Title,
+		AltText:  req.AltText,
+	})
+	switch {
+	case errors.Is(err, planting.ErrInvalidMedia):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, planting.ErrBatchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Planting batch not found"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to attach planting evidence: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to attach evidence"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, evidence)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/planting"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlantingHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewPlantingHandler(planting.NewService())
+	router := gin.New()
+	router.POST("/admin/projects/:id/batches", handler.CreateBatch)
+	router.POST("/admin/batches/:id/media", handler.AddEvidence)
+	router.GET("/v1/me/donations/:id/planting", func(c *gin.Context) {
+		c.Set("user", &models.User{AuthUserID: "user-1"})
+		handler.GetDonationPlanting(c)
+	})
+
+	batches := "/admin/projects/1b4e28ba-2fa1-41d2-883f-0016d3cca427/batches"
+	media := "/admin/batches/1b4e28ba-2fa1-41d2-883f-0016d3cca427/media"
+	area := `"area":{"type":"Polygon","coordinates":[[[37.61,55.75],[37.62,55.75],[37.62,55.76],[37.61,55.75]]]}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid donation ID", http.MethodGet, "/v1/me/donations/nope/planting", ``},
+		{"invalid project ID", http.MethodPost, "/admin/projects/nope/batches", `{}`},
+		{"invalid JSON", http.MethodPost, batches, `{`},
+		{"missing area", http.MethodPost, batches, `{"planted_on":"2027-10-05","species":"Scots pine","trees_count":1200}`},
+		{"invalid date", http.MethodPost, batches, `{"planted_on":"05.10.2027","species":"Scots pine","trees_count":1200,` + area + `}`},
+		{"planted in the future", http.MethodPost, batches, `{"planted_on":"2999-01-01","species":"Scots pine","trees_count":1200,` + area + `}`},
+		{"point area", http.MethodPost, batches, `{"planted_on":"2027-10-05","species":"Scots pine","trees_count":1200,"area":{"type":"Point","coordinates":[37.61,55.75]}}`},
+		{"invalid batch ID", http.MethodPost, "/admin/batches/nope/media", `{"url":"https://cdn.example.com/a.jpg"}`},
+		{"missing URL", http.MethodPost, media, `{"kind":"image"}`},
+		{"unsupported kind", http.MethodPost, media, `{"kind":"audio","url":"https://cdn.example.com/a.mp3"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/4planet/backend/internal/config"
 	"github.com/4planet/backend/internal/models"
 	"github.com/4planet/backend/pkg/prices"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type PricesHandler struct {
@@ -49,3 +53,82 @@ func (h *PricesHandler) GetPriceByCurrency(c *gin.Context) {
 
 	c.JSON(http.StatusOK, price)
 }
+
+// GetPriceHistory retrieves every tree price a currency had, newest first
+func (h *PricesHandler) GetPriceHistory(c *gin.Context) {
+	currency := models.Currency(c.Param("currency"))
+
+	prices, err := h.pricesService.GetPriceHistory(currency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price history"})
+		return
+	}
+	if len(prices) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found for currency"})
+		return
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
+
+// SetProjectPrice overrides the tree price of a currency for a project, from now on or
+// from effective_from
+func (h *PricesHandler) SetProjectPrice(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	currency := models.Currency(c.Param("currency"))
+
+	var req struct {
+		PriceMinor    int64      `json:"price_minor" binding:"required,min=1"`
+		EffectiveFrom *time.Time `json:"effective_from"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	effectiveFrom := time.Now()
+	if req.EffectiveFrom != nil {
+		effectiveFrom = *req.EffectiveFrom
+	}
+
+	price, err := h.pricesService.SchedulePrice(currency, &projectID, req.PriceMinor, effectiveFrom)
+	switch {
+	case errors.Is(err, prices.ErrUnknownProject):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+		return
+	case errors.Is(err, prices.ErrPriceBackdated):
+		c.JSON(http.StatusConflict, gin.H{"error": "The price cannot take effect before the project's latest price"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set project price"})
+		return
+	}
+
+	c.JSON(http.StatusOK, price)
+}
+
+// DeleteProjectPrice ends a project's tree price override of a currency, so that the
+// project falls back to the global price
+func (h *PricesHandler) DeleteProjectPrice(c *gin.Context) {
+	projectID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+		return
+	}
+	currency := models.Currency(c.Param("currency"))
+
+	err = h.pricesService.EndProjectPrice(projectID, currency)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project has no price for currency"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project price"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/4planet/backend/internal/config"
 	"github.com/4planet/backend/pkg/prices"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -59,3 +63,39 @@ func TestPricesHandler_GetPrices_MethodExists(t *testing.T) {
 	// Test that it's a function
 	assert.NotNil(t, handler.GetPrices)
 }
+
+func TestPricesHandler_GetPriceHistory_MethodExists(t *testing.T) {
+	handler := NewPricesHandler(&prices.Service{}, &config.Config{})
+	assert.NotNil(t, handler.GetPriceHistory)
+}
+
+func TestPricesHandler_ProjectPrice_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewPricesHandler(prices.NewService(), &config.Config{})
+	router.PUT("/admin/projects/:id/prices/:currency", handler.SetProjectPrice)
+	router.DELETE("/admin/projects/:id/prices/:currency", handler.DeleteProjectPrice)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"invalid project ID", http.MethodPut, "/admin/projects/not-a-uuid/prices/RUB", `{"price_minor":1200}`},
+		{"missing price", http.MethodPut, "/admin/projects/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10/prices/RUB", `{}`},
+		{"zero price", http.MethodPut, "/admin/projects/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10/prices/RUB", `{"price_minor":0}`},
+		{"invalid project ID on delete", http.MethodDelete, "/admin/projects/not-a-uuid/prices/RUB", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
@@ -4,19 +4,30 @@ import (
 	"net/http"
 
 	"github.com/4planet/backend/internal/config"
+	"github.com/4planet/backend/internal/models"
 	"github.com/4planet/backend/pkg/pagination"
+	"github.com/4planet/backend/pkg/prices"
 	"github.com/4planet/backend/pkg/projects"
 	"github.com/gin-gonic/gin"
 )
 
 type ProjectsHandler struct {
 	projectsService *projects.Service
+	pricesService   *prices.Service
 	config          *config.Config
 }
 
-func NewProjectsHandler(projectsService *projects.Service, config *config.Config) *ProjectsHandler {
+// ProjectResponse is a project with the tree prices donations to it are made at
+type ProjectResponse struct {
+	*models.Project
+	// TreePrices maps currencies to the project's tree price in minor units
+	TreePrices map[string]int64 `json:"tree_prices"`
+}
+
+func NewProjectsHandler(projectsService *projects.Service, pricesService *prices.Service, config *config.Config) *ProjectsHandler {
 	return &ProjectsHandler{
 		projectsService: projectsService,
+		pricesService:   pricesService,
 		config:          config,
 	}
 }
@@ -42,5 +53,15 @@ func (h *ProjectsHandler) GetProject(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project"})
 		return
 	}
-	c.JSON(http.StatusOK, project)
+
+	treePrices, err := h.pricesService.GetProjectPrices(project.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch project prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ProjectResponse{
+		Project:    project,
+		TreePrices: treePrices,
+	})
 }
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// RefundsHandler handles admin refund requests
+type RefundsHandler struct {
+	paymentService *payments.Service
+}
+
+// NewRefundsHandler creates a new refunds handler
+func NewRefundsHandler(paymentService *payments.Service) *RefundsHandler {
+	return &RefundsHandler{
+		paymentService: paymentService,
+	}
+}
+
+// CreateRefund refunds a payment through its provider. Without an amount the whole
+// remaining amount is refunded.
+func (h *RefundsHandler) CreateRefund(c *gin.Context) {
+	paymentID := c.Param("id")
+	if _, err := uuid.Parse(paymentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var req struct {
+		AmountMinor int64   `json:"amount_minor" binding:"min=0"`
+		Reason      *string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	refund, err := h.paymentService.RefundPayment(paymentID, &payments.RefundRequest{
+		AmountMinor: req.AmountMinor,
+		Reason:      req.Reason,
+		InitiatedBy: c.GetString(gin.AuthUserKey),
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.Is(err, payments.ErrRefundNotAllowed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only succeeded payments can be refunded"})
+		return
+	case errors.Is(err, payments.ErrInvalidRefundAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount exceeds the amount left on the payment"})
+		return
+	case errors.Is(err, payments.ErrRefundNotSupported), errors.Is(err, payments.ErrUnknownProvider):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The payment provider does not support refunds"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to refund payment %s: %v", paymentID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, refund)
+}
+
+// GetRefunds lists the refunds of a payment
+func (h *RefundsHandler) GetRefunds(c *gin.Context) {
+	paymentID := c.Param("id")
+	if _, err := uuid.Parse(paymentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	refunds, err := h.paymentService.GetPaymentRefunds(paymentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch refunds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, refunds)
+}
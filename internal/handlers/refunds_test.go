@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRefundsHandler(t *testing.T) {
+	paymentService := newCloudPaymentsService()
+
+	handler := NewRefundsHandler(paymentService)
+	assert.NotNil(t, handler)
+	assert.Equal(t, paymentService, handler.paymentService)
+}
+
+func TestRefundsHandler_InvalidRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/payments/:id/refunds", NewRefundsHandler(newCloudPaymentsService()).CreateRefund)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"invalid payment ID", "/admin/payments/not-a-uuid/refunds", `{}`},
+		{"negative amount", "/admin/payments/2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10/refunds", `{"amount_minor":-100}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
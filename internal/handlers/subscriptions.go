@@ -1,37 +1,49 @@
 package handlers
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/idempotency"
 	"github.com/4planet/backend/pkg/payments"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // SubscriptionsHandler handles subscription-related requests
 type SubscriptionsHandler struct {
-	paymentService *payments.CloudPaymentsService
+	paymentService     *payments.Service
+	idempotencyService *idempotency.Service
 }
 
 // NewSubscriptionsHandler creates a new subscriptions handler
-func NewSubscriptionsHandler(paymentService *payments.CloudPaymentsService) *SubscriptionsHandler {
+func NewSubscriptionsHandler(paymentService *payments.Service, idempotencyService *idempotency.Service) *SubscriptionsHandler {
 	return &SubscriptionsHandler{
-		paymentService: paymentService,
+		paymentService:     paymentService,
+		idempotencyService: idempotencyService,
 	}
 }
 
-// CreateSubscriptionIntent creates a new subscription intent
+// CreateSubscriptionIntent creates a new subscription intent. Requests with an
+// Idempotency-Key header create at most one subscription per key.
 func (h *SubscriptionsHandler) CreateSubscriptionIntent(c *gin.Context) {
-	authUserID := c.GetString("auth_user_id")
+	authUserID := c.GetString("user_id")
 
 	var req struct {
 		Provider         string  `json:"provider" binding:"required"`
-		AmountMinor      int64   `json:"amount_minor" binding:"required"`
-		Currency         string  `json:"currency" binding:"required"`
-		SuccessReturnURL string  `json:"success_return_url" binding:"required"`
-		FailReturnURL    string  `json:"fail_return_url" binding:"required"`
+		AmountMinor      int64   `json:"amount_minor"`
+		Currency         string  `json:"currency"`
+		SuccessReturnURL string  `json:"success_return_url"`
+		FailReturnURL    string  `json:"fail_return_url"`
 		Description      *string `json:"description"`
-		Interval         string  `json:"interval" binding:"required"`
-		IntervalCount    int     `json:"interval_count" binding:"required"`
+		Interval         string  `json:"interval"`
+		IntervalCount    int     `json:"interval_count"`
+		ProjectID        *string `json:"project_id"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -39,9 +51,30 @@ func (h *SubscriptionsHandler) CreateSubscriptionIntent(c *gin.Context) {
 		return
 	}
 
-	// Validate interval
+	errs := &payments.ValidationError{}
 	if req.Interval != "monthly" && req.Interval != "yearly" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval. Must be 'monthly' or 'yearly'"})
+		errs.Add("interval", payments.FieldErrorInvalid, "Interval must be 'monthly' or 'yearly'")
+	}
+	if req.IntervalCount < 1 {
+		errs.Add("interval_count", payments.FieldErrorTooSmall, "Interval count must be at least 1")
+	}
+
+	var projectID *uuid.UUID
+	if req.ProjectID != nil && *req.ProjectID != "" {
+		parsedID, err := uuid.Parse(*req.ProjectID)
+		if err != nil {
+			errs.Add("project_id", payments.FieldErrorInvalid, "Project ID must be a UUID")
+		} else {
+			projectID = &parsedID
+		}
+	}
+	if len(errs.Fields) > 0 {
+		c.JSON(http.StatusBadRequest, fieldErrorsResponse(errs.Fields...))
+		return
+	}
+
+	record, handled := beginIdempotentRequest(c, h.idempotencyService, idempotency.ScopeSubscriptionIntent, authUserID, req)
+	if handled {
 		return
 	}
 
@@ -62,15 +95,176 @@ func (h *SubscriptionsHandler) CreateSubscriptionIntent(c *gin.Context) {
 		SuccessReturnURL: req.SuccessReturnURL,
 		FailReturnURL:    req.FailReturnURL,
 		IntervalMonths:   intervalMonths,
+		ProjectID:        projectID,
 		Description:      req.Description,
 	}
 
-	// Create subscription intent using CloudPayments service
-	response, err := h.paymentService.CreateSubscriptionIntent(subscriptionReq, authUserID)
+	status, body := h.createSubscriptionIntent(subscriptionReq, authUserID)
+	finishIdempotentRequest(c, h.idempotencyService, record, status, body)
+}
+
+// createSubscriptionIntent creates the subscription intent with the requested provider
+func (h *SubscriptionsHandler) createSubscriptionIntent(req *payments.SubscriptionIntentRequest, authUserID string) (int, interface{}) {
+	response, err := h.paymentService.CreateSubscriptionIntent(req, authUserID)
+	var validationErr *payments.ValidationError
+	switch {
+	case errors.Is(err, payments.ErrUnknownProvider):
+		return http.StatusBadRequest, gin.H{"error": "Unsupported payment provider"}
+	case errors.As(err, &validationErr):
+		return http.StatusBadRequest, fieldErrorsResponse(validationErr.Fields...)
+	case errors.Is(err, payments.ErrUnsupportedCurrency):
+		return http.StatusBadRequest, unsupportedCurrencyResponse()
+	case errors.Is(err, payments.ErrSubscriptionsNotSupported):
+		return http.StatusBadRequest, gin.H{"error": "Payment provider does not support subscriptions"}
+	case errors.Is(err, payments.ErrUnknownProject):
+		return http.StatusBadRequest, gin.H{"error": "Project not found"}
+	case err != nil:
+		return http.StatusInternalServerError, gin.H{"error": "Failed to create subscription intent"}
+	}
+	return http.StatusOK, response
+}
+
+// SubscriptionResponse is a subscription after a lifecycle change
+type SubscriptionResponse struct {
+	ID             string                    `json:"id"`
+	Provider       models.PaymentProvider    `json:"provider"`
+	Status         models.SubscriptionStatus `json:"status"`
+	AmountMinor    int64                     `json:"amount_minor"`
+	Currency       models.Currency           `json:"currency"`
+	IntervalMonths int                       `json:"interval_months"`
+	ProjectID      *uuid.UUID                `json:"project_id"`
+	StartedAt      time.Time                 `json:"started_at"`
+	CanceledAt     *time.Time                `json:"canceled_at"`
+	// RedirectURL is set when the donor has to approve the change with the provider
+	RedirectURL string `json:"redirect_url,omitempty"`
+	// The pending terms take effect once the donor approved the change with the provider
+	PendingAmountMinor    *int64 `json:"pending_amount_minor,omitempty"`
+	PendingIntervalMonths *int   `json:"pending_interval_months,omitempty"`
+}
+
+// CancelSubscription cancels one of the current user's subscriptions
+func (h *SubscriptionsHandler) CancelSubscription(c *gin.Context) {
+	h.updateSubscription(c, payments.SubscriptionActionCancel)
+}
+
+// PauseSubscription stops charging one of the current user's subscriptions until it is resumed
+func (h *SubscriptionsHandler) PauseSubscription(c *gin.Context) {
+	h.updateSubscription(c, payments.SubscriptionActionPause)
+}
+
+// ResumeSubscription resumes a paused subscription of the current user
+func (h *SubscriptionsHandler) ResumeSubscription(c *gin.Context) {
+	h.updateSubscription(c, payments.SubscriptionActionResume)
+}
+
+// UpdateSubscription changes the amount or interval of one of the current user's subscriptions
+func (h *SubscriptionsHandler) UpdateSubscription(c *gin.Context) {
+	h.updateSubscription(c, payments.SubscriptionActionChange)
+}
+
+// GetSubscriptionChanges returns the audit of one of the current user's subscriptions
+func (h *SubscriptionsHandler) GetSubscriptionChanges(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	changes, err := h.paymentService.GetSubscriptionChanges(c.GetString("user_id"), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription intent"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch subscription changes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+// updateSubscription applies a lifecycle action to the subscription in the id path parameter
+func (h *SubscriptionsHandler) updateSubscription(c *gin.Context, action payments.SubscriptionAction) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+		return
+	}
+
+	// The body is optional for cancel, pause and resume. An empty project_id makes the
+	// subscription a general donation; without project_id the project is kept.
+	var req struct {
+		AmountMinor    int64   `json:"amount_minor" binding:"min=0"`
+		IntervalMonths int     `json:"interval_months" binding:"min=0"`
+		ProjectID      *string `json:"project_id"`
+		Reason         *string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	update := &payments.SubscriptionUpdate{
+		Action: action,
+		Reason: req.Reason,
+	}
+	if action == payments.SubscriptionActionChange {
+		update.AmountMinor = req.AmountMinor
+		update.IntervalMonths = req.IntervalMonths
+		if req.ProjectID != nil {
+			update.ChangeProject = true
+			if *req.ProjectID != "" {
+				projectID, err := uuid.Parse(*req.ProjectID)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project ID"})
+					return
+				}
+				update.ProjectID = &projectID
+			}
+		}
+	}
+
+	subscription, result, err := h.paymentService.UpdateSubscription(c.GetString("user_id"), id, update)
+	var validationErr *payments.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, fieldErrorsResponse(validationErr.Fields...))
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	case errors.Is(err, payments.ErrSubscriptionTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, payments.ErrInvalidSubscriptionUpdate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, payments.ErrUnknownProject):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Project not found"})
+		return
+	case errors.Is(err, payments.ErrSubscriptionChangeNotSupported), errors.Is(err, payments.ErrSubscriptionsNotSupported),
+		errors.Is(err, payments.ErrUnsupportedCurrency):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "The payment provider does not support this change"})
+		return
+	case err != nil:
+		logrus.Errorf("Failed to %s subscription %s: %v", action, id, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to update subscription"})
 		return
 	}
 
-	c.JSON(http.StatusOK, response)
+	c.JSON(http.StatusOK, SubscriptionResponse{
+		ID:             subscription.ID.String(),
+		Provider:       subscription.Provider,
+		Status:         subscription.Status,
+		AmountMinor:    subscription.AmountMinor,
+		Currency:       subscription.Currency,
+		IntervalMonths: subscription.IntervalMonths,
+		ProjectID:      subscription.ProjectID,
+		StartedAt:      subscription.StartedAt,
+		CanceledAt:     subscription.CanceledAt,
+		RedirectURL:    result.RedirectURL,
+
+		PendingAmountMinor:    subscription.PendingAmountMinor,
+		PendingIntervalMonths: subscription.PendingIntervalMonths,
+	})
 }
@@ -31,6 +31,18 @@ type LeaderboardUser struct {
 	CreatedAt      time.Time  `json:"created_at"`
 }
 
+// CreditBalanceResponse is the user's credit towards the next tree in one currency
+type CreditBalanceResponse struct {
+	Currency     models.Currency `json:"currency"`
+	BalanceMinor int64           `json:"balance_minor"`
+}
+
+// MeResponse is the current user with their credit balances
+type MeResponse struct {
+	*models.User
+	CreditBalances []CreditBalanceResponse `json:"credit_balances"`
+}
+
 // NewUserHandler creates a new user handler
 func NewUserHandler(userService *user.Service, donationService *donations.Service, subscriptionService *subscriptions.Service, achievementsService *achievements.Service) *UserHandler {
 	return &UserHandler{
@@ -41,15 +53,33 @@ func NewUserHandler(userService *user.Service, donationService *donations.Servic
 	}
 }
 
-// Me returns the current authenticated user
+// Me returns the current authenticated user with the credit that did not add up to a
+// whole tree yet
 func (h *UserHandler) Me(c *gin.Context) {
 	user, exists := c.Get("user")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
 		return
 	}
+	u := user.(*models.User)
+
+	balances, err := h.userService.GetCreditBalances(u.AuthUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credit balances"})
+		return
+	}
 
-	c.JSON(http.StatusOK, user)
+	response := MeResponse{
+		User:           u,
+		CreditBalances: make([]CreditBalanceResponse, 0, len(balances)),
+	}
+	for _, balance := range balances {
+		response.CreditBalances = append(response.CreditBalances, CreditBalanceResponse{
+			Currency:     balance.Currency,
+			BalanceMinor: balance.BalanceMinor,
+		})
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // GetMyDonations returns the current user's donations
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// WebhooksHandler handles payment provider webhooks
+type WebhooksHandler struct {
+	paymentService *payments.Service
+}
+
+// NewWebhooksHandler creates a new webhooks handler
+func NewWebhooksHandler(paymentService *payments.Service) *WebhooksHandler {
+	return &WebhooksHandler{
+		paymentService: paymentService,
+	}
+}
+
+// HandleWebhook receives a provider notification and dispatches it to the payment service.
+// Providers that post each notification kind to its own URL use /webhooks/{provider}/{kind}.
+func (h *WebhooksHandler) HandleWebhook(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, err := h.paymentService.Provider(models.PaymentProvider(providerName))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported provider"})
+		return
+	}
+
+	// The signature is computed over the raw body, so it must be read before any parsing
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	req := &payments.WebhookRequest{
+		Kind:     c.Param("kind"),
+		Method:   c.Request.Method,
+		Header:   c.Request.Header,
+		Query:    c.Request.URL.Query(),
+		Body:     payload,
+		RemoteIP: c.ClientIP(),
+	}
+
+	event, err := h.paymentService.ProcessWebhook(provider, req)
+	if errors.Is(err, payments.ErrUnsupportedWebhook) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown notification type"})
+		return
+	}
+
+	status, body := provider.WebhookResponse(event, err)
+	if err != nil {
+		logger := logrus.WithFields(logrus.Fields{"provider": providerName, "kind": req.Kind})
+		if status >= http.StatusInternalServerError {
+			// The provider retries the notification
+			logger.Errorf("Failed to process webhook: %v", err)
+		} else {
+			// Rejections are answered in the provider's protocol; redelivering them will not help
+			logger.Warnf("Rejected webhook: %v", err)
+		}
+	}
+
+	if body == nil {
+		c.Status(status)
+		return
+	}
+	c.JSON(status, body)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCloudPaymentsService() *payments.Service {
+	return payments.NewService(payments.NewRegistry(
+		payments.NewCloudPaymentsProvider("public-id", "secret", "", ""),
+	), payments.AmountLimits{})
+}
+
+func newWebhooksRouter(handler *WebhooksHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhooks/:provider", handler.HandleWebhook)
+	router.POST("/webhooks/:provider/:kind", handler.HandleWebhook)
+	return router
+}
+
+func TestNewWebhooksHandler(t *testing.T) {
+	paymentService := newCloudPaymentsService()
+
+	handler := NewWebhooksHandler(paymentService)
+	assert.NotNil(t, handler)
+	assert.Equal(t, paymentService, handler.paymentService)
+}
+
+func TestWebhooksHandler_UnsupportedProvider(t *testing.T) {
+	handler := NewWebhooksHandler(newCloudPaymentsService())
+	router := newWebhooksRouter(handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/tribute/pay", strings.NewReader(`{}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWebhooksHandler_UnknownNotification(t *testing.T) {
+	handler := NewWebhooksHandler(newCloudPaymentsService())
+	router := newWebhooksRouter(handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/cloudpayments/unknown", strings.NewReader(""))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestWebhooksHandler_InvalidSignature(t *testing.T) {
+	handler := NewWebhooksHandler(newCloudPaymentsService())
+	router := newWebhooksRouter(handler)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/cloudpayments/pay", strings.NewReader("TransactionId=1&Amount=10.00"))
+	req.Header.Set("Content-HMAC", "bogus")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"code":13}`, w.Body.String())
+}
@@ -130,6 +130,8 @@ const (
 	PaymentProviderKaspi         PaymentProvider = "kaspi"
 	PaymentProviderPayPal        PaymentProvider = "paypal"
 	PaymentProviderTribute       PaymentProvider = "tribute"
+	// PaymentProviderFake is the built-in test provider used in development
+	PaymentProviderFake PaymentProvider = "fake"
 )
 
 func (pp PaymentProvider) String() string {
@@ -233,6 +235,16 @@ const (
 	MediaKindDocument MediaKind = "document"
 )
 
+// IsValid checks if the MediaKind value is valid
+func (mk MediaKind) IsValid() bool {
+	switch mk {
+	case MediaKindImage, MediaKindVideo, MediaKindDocument:
+		return true
+	default:
+		return false
+	}
+}
+
 func (mk MediaKind) String() string {
 	return string(mk)
 }
@@ -267,6 +279,16 @@ const (
 	CurrencyEUR Currency = "EUR"
 )
 
+// IsValid checks if the Currency value is one of the supported currencies
+func (c Currency) IsValid() bool {
+	switch c {
+	case CurrencyRUB, CurrencyKZT, CurrencyUSD, CurrencyEUR:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c Currency) String() string {
 	return string(c)
 }
@@ -51,3 +51,25 @@ func TestParseNewsType(t *testing.T) {
 		})
 	}
 }
+
+func TestCurrency_IsValid(t *testing.T) {
+	tests := []struct {
+		name     string
+		currency Currency
+		expected bool
+	}{
+		{"valid RUB", CurrencyRUB, true},
+		{"valid KZT", CurrencyKZT, true},
+		{"valid USD", CurrencyUSD, true},
+		{"valid EUR", CurrencyEUR, true},
+		{"invalid empty", "", false},
+		{"invalid unsupported", "GBP", false},
+		{"invalid lower case", "eur", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.currency.IsValid())
+		})
+	}
+}
@@ -35,9 +35,12 @@ type UserAuth struct {
 	Email        string     `gorm:"column:email;uniqueIndex;type:text;not null"`
 	PasswordHash *string    `gorm:"column:password_hash;type:text"`
 	Status       UserStatus `gorm:"column:status;type:user_status;not null;default:'pending'"`
-	VerifiedAt   *time.Time `gorm:"column:verified_at;type:timestamptz"`
-	CreatedAt    time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
-	UpdatedAt    time.Time  `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+	// Guest accounts are created for donors who paid with only an email. Registering with
+	// the email claims the account once the email is verified.
+	Guest      bool       `gorm:"column:guest;type:boolean;not null;default:false"`
+	VerifiedAt *time.Time `gorm:"column:verified_at;type:timestamptz"`
+	CreatedAt  time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
 
 	// Relationships
 	EmailVerificationTokens []EmailVerificationToken `gorm:"foreignKey:AuthUserID;constraint:OnDelete:CASCADE"`
@@ -104,18 +107,28 @@ func (PasswordResetToken) TableName() string {
 	return "password_reset_tokens"
 }
 
-// TreePrice represents the tree_prices table
+// TreePrice represents the tree_prices table. Prices are versioned: a price is in force
+// from EffectiveFrom until EffectiveTo, and the current price has no EffectiveTo. Prices
+// with a ProjectID override the global price of their currency for that project.
 type TreePrice struct {
-	Currency   Currency  `gorm:"column:currency;primaryKey;type:text"`
-	PriceMinor int64     `gorm:"column:price_minor;type:bigint;not null"`
-	UpdatedAt  time.Time `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+	ID            uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	Currency      Currency   `gorm:"column:currency;type:text;not null;index:idx_tree_prices_currency_effective_from"`
+	ProjectID     *uuid.UUID `gorm:"column:project_id;type:uuid;index"`
+	PriceMinor    int64      `gorm:"column:price_minor;type:bigint;not null"`
+	EffectiveFrom time.Time  `gorm:"column:effective_from;type:timestamptz;not null;default:now();index:idx_tree_prices_currency_effective_from"`
+	EffectiveTo   *time.Time `gorm:"column:effective_to;type:timestamptz"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Project *Project `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE" json:"-"`
 }
 
 func (TreePrice) TableName() string {
 	return "tree_prices"
 }
 
-// Project represents the projects table
+// Project represents the projects table. TreesFunded counts the trees the allocation
+// ledger assigns to the project.
 type Project struct {
 	ID              uuid.UUID     `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
 	Title           string        `gorm:"column:title;type:text;not null"`
@@ -127,6 +140,7 @@ type Project struct {
 	Region          *string       `gorm:"column:region;type:text"`
 	LocationGeoJSON interface{}   `gorm:"column:location_geojson;type:jsonb;not null;index:type:gin"`
 	TreesTarget     *int          `gorm:"column:trees_target;type:integer"`
+	TreesFunded     int           `gorm:"column:trees_funded;type:integer;not null;default:0"`
 	TreesPlanted    *int          `gorm:"column:trees_planted;type:integer"`
 	CoverURL        *string       `gorm:"column:cover_url;type:text"`
 	CreatedAt       time.Time     `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
@@ -141,6 +155,37 @@ func (Project) TableName() string {
 	return "projects"
 }
 
+// Campaign represents the campaigns table. A campaign is a time-boxed fundraising drive
+// with a goal in trees, in money or both; donations made while it runs can be attributed
+// to it.
+type Campaign struct {
+	ID          uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	Slug        string    `gorm:"column:slug;type:text;uniqueIndex;not null"`
+	Title       string    `gorm:"column:title;type:text;not null"`
+	Description *string   `gorm:"column:description;type:text"`
+	GoalTrees   *int      `gorm:"column:goal_trees;type:integer"`
+	// GoalAmountMinor is the money goal in GoalCurrency; donations in other currencies are
+	// converted at the exchange rate of the day they were paid
+	GoalAmountMinor *int64    `gorm:"column:goal_amount_minor;type:bigint"`
+	GoalCurrency    *Currency `gorm:"column:goal_currency;type:text"`
+	StartsAt        time.Time `gorm:"column:starts_at;type:timestamptz;not null"`
+	EndsAt          time.Time `gorm:"column:ends_at;type:timestamptz;not null"`
+	CreatedAt       time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	UpdatedAt       time.Time `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Projects []Project `gorm:"many2many:campaign_projects;constraint:OnDelete:CASCADE"`
+}
+
+func (Campaign) TableName() string {
+	return "campaigns"
+}
+
+// IsRunning reports whether donations can be attributed to the campaign at a time
+func (c *Campaign) IsRunning(at time.Time) bool {
+	return !at.Before(c.StartsAt) && at.Before(c.EndsAt)
+}
+
 // MediaFile represents the media_files table
 type MediaFile struct {
 	ID        uuid.UUID   `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
@@ -152,6 +197,8 @@ type MediaFile struct {
 	AltText   *string     `gorm:"column:alt_text;type:text"`
 	Meta      interface{} `gorm:"column:meta;type:jsonb;default:'{}'::jsonb"`
 	CreatedAt time.Time   `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	// PlantingBatchID is set on evidence of a planting batch
+	PlantingBatchID *uuid.UUID `gorm:"column:planting_batch_id;type:uuid;index"`
 
 	// Relationships
 	Project Project `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE" json:"-"`
@@ -161,6 +208,46 @@ func (MediaFile) TableName() string {
 	return "media_files"
 }
 
+// PlantingBatch represents the planting_batches table: trees of one species planted in a
+// project on one day within an area, with photos and documents as evidence
+type PlantingBatch struct {
+	ID         uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	ProjectID  uuid.UUID `gorm:"column:project_id;type:uuid;not null;index"`
+	PlantedOn  time.Time `gorm:"column:planted_on;type:date;not null"`
+	Species    string    `gorm:"column:species;type:text;not null"`
+	TreesCount int       `gorm:"column:trees_count;type:integer;not null"`
+	// AreaGeoJSON is the GeoJSON polygon of the GPS coordinates the trees were planted in
+	AreaGeoJSON interface{} `gorm:"column:area_geojson;type:jsonb;not null"`
+	Notes       *string     `gorm:"column:notes;type:text"`
+	CreatedAt   time.Time   `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Project    Project     `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE" json:"-"`
+	MediaFiles []MediaFile `gorm:"foreignKey:PlantingBatchID;constraint:OnDelete:SET NULL"`
+}
+
+func (PlantingBatch) TableName() string {
+	return "planting_batches"
+}
+
+// TreePlanting represents the tree_plantings table, which links funded trees of a donation
+// to the batch that planted them
+type TreePlanting struct {
+	ID              uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	PlantingBatchID uuid.UUID `gorm:"column:planting_batch_id;type:uuid;not null;index"`
+	DonationID      uuid.UUID `gorm:"column:donation_id;type:uuid;not null;index"`
+	Trees           int       `gorm:"column:trees;type:integer;not null"`
+	CreatedAt       time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	PlantingBatch PlantingBatch `gorm:"foreignKey:PlantingBatchID;constraint:OnDelete:CASCADE" json:"-"`
+	Donation      Donation      `gorm:"foreignKey:DonationID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (TreePlanting) TableName() string {
+	return "tree_plantings"
+}
+
 // News represents the news table
 type News struct {
 	ID          uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
@@ -223,13 +310,27 @@ type Subscription struct {
 	AmountMinor            int64              `gorm:"column:amount_minor;type:bigint;not null"`
 	Currency               Currency           `gorm:"column:currency;type:text;not null"`
 	IntervalMonths         int                `gorm:"column:interval_months;type:integer;not null;default:1"`
+	ProjectID              *uuid.UUID         `gorm:"column:project_id;type:uuid;index"`
 	Status                 SubscriptionStatus `gorm:"column:status;type:subscription_status;not null"`
 	StartedAt              time.Time          `gorm:"column:started_at;type:timestamptz;not null;default:now()"`
 	CanceledAt             *time.Time         `gorm:"column:canceled_at;type:timestamptz"`
 	Meta                   interface{}        `gorm:"column:meta;type:jsonb;default:'{}'::jsonb"`
 
+	// Dunning state of a past due subscription; reset by the next successful charge
+	FailedCharges int        `gorm:"column:failed_charges;type:integer;not null;default:0"`
+	PastDueSince  *time.Time `gorm:"column:past_due_since;type:timestamptz"`
+	DunningStep   int        `gorm:"column:dunning_step;type:integer;not null;default:0"`
+	NextDunningAt *time.Time `gorm:"column:next_dunning_at;type:timestamptz;index"`
+
+	// Terms of a change the donor still has to approve with the provider; they replace
+	// the current terms once the provider confirms the change
+	PendingAmountMinor    *int64  `gorm:"column:pending_amount_minor;type:bigint"`
+	PendingIntervalMonths *int    `gorm:"column:pending_interval_months;type:integer"`
+	PendingPlanID         *string `gorm:"column:pending_plan_id;type:text"`
+
 	// Relationships
 	User     User      `gorm:"foreignKey:AuthUserID;constraint:OnDelete:CASCADE" json:"-"`
+	Project  *Project  `gorm:"foreignKey:ProjectID;constraint:OnDelete:SET NULL" json:"-"`
 	Payments []Payment `gorm:"foreignKey:SubscriptionID;constraint:OnDelete:SET NULL"`
 }
 
@@ -237,6 +338,33 @@ func (Subscription) TableName() string {
 	return "subscriptions"
 }
 
+// SubscriptionChange represents the subscription_changes table, an audit of every
+// lifecycle change of a subscription
+type SubscriptionChange struct {
+	ID             uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	SubscriptionID uuid.UUID `gorm:"column:subscription_id;type:uuid;not null;index"`
+	// ActorID is the user who made the change; nil for changes reported by the provider
+	ActorID            *string            `gorm:"column:actor_id;type:text"`
+	Action             string             `gorm:"column:action;type:text;not null"`
+	FromStatus         SubscriptionStatus `gorm:"column:from_status;type:subscription_status;not null"`
+	ToStatus           SubscriptionStatus `gorm:"column:to_status;type:subscription_status;not null"`
+	FromAmountMinor    int64              `gorm:"column:from_amount_minor;type:bigint;not null"`
+	ToAmountMinor      int64              `gorm:"column:to_amount_minor;type:bigint;not null"`
+	FromIntervalMonths int                `gorm:"column:from_interval_months;type:integer;not null"`
+	ToIntervalMonths   int                `gorm:"column:to_interval_months;type:integer;not null"`
+	FromProjectID      *uuid.UUID         `gorm:"column:from_project_id;type:uuid"`
+	ToProjectID        *uuid.UUID         `gorm:"column:to_project_id;type:uuid"`
+	Reason             *string            `gorm:"column:reason;type:text"`
+	CreatedAt          time.Time          `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Subscription Subscription `gorm:"foreignKey:SubscriptionID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (SubscriptionChange) TableName() string {
+	return "subscription_changes"
+}
+
 // Payment represents the payments table
 type Payment struct {
 	ID                uuid.UUID       `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
@@ -247,9 +375,13 @@ type Payment struct {
 	AmountMinor       int64           `gorm:"column:amount_minor;type:bigint;not null"`
 	Currency          Currency        `gorm:"column:currency;type:text;not null"`
 	Status            PaymentStatus   `gorm:"column:status;type:payment_status;not null;index"`
-	OccurredAt        *time.Time      `gorm:"column:occurred_at;type:timestamptz"`
-	Meta              interface{}     `gorm:"column:meta;type:jsonb;default:'{}'::jsonb"`
-	CreatedAt         time.Time       `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	// RefundedAmountMinor is the sum of all refunds; the payment becomes refunded once it reaches AmountMinor
+	RefundedAmountMinor int64 `gorm:"column:refunded_amount_minor;type:bigint;not null;default:0"`
+	// RefundReservedMinor is held by admin refunds the provider has not confirmed yet
+	RefundReservedMinor int64       `gorm:"column:refund_reserved_minor;type:bigint;not null;default:0"`
+	OccurredAt          *time.Time  `gorm:"column:occurred_at;type:timestamptz"`
+	Meta                interface{} `gorm:"column:meta;type:jsonb;default:'{}'::jsonb"`
+	CreatedAt           time.Time   `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
 
 	// Relationships
 	User         *User         `gorm:"foreignKey:AuthUserID;constraint:OnDelete:SET NULL"`
@@ -261,28 +393,124 @@ func (Payment) TableName() string {
 	return "payments"
 }
 
-// Donation represents the donations table
+// Donation represents the donations table. Matched donations are made by the sponsor of
+// a matching pool for another donation and have no payment of their own.
 type Donation struct {
 	ID             uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
 	AuthUserID     string     `gorm:"column:auth_user_id;type:text;not null;index"`
-	PaymentID      uuid.UUID  `gorm:"column:payment_id;type:uuid;uniqueIndex;not null"`
+	PaymentID      *uuid.UUID `gorm:"column:payment_id;type:uuid;uniqueIndex"`
 	ProjectID      *uuid.UUID `gorm:"column:project_id;type:uuid;index"`
 	ReferralUserID *string    `gorm:"column:referral_user_id;type:text;index"`
-	TreesCount     int        `gorm:"column:trees_count;type:integer;not null"`
-	CreatedAt      time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	CampaignID     *uuid.UUID `gorm:"column:campaign_id;type:uuid;index"`
+	// MatchedDonationID and MatchingPoolID are set on matched donations
+	MatchedDonationID *uuid.UUID `gorm:"column:matched_donation_id;type:uuid;index"`
+	MatchingPoolID    *uuid.UUID `gorm:"column:matching_pool_id;type:uuid;index"`
+	// RedemptionCodeID is set on donations made by redeeming a code
+	RedemptionCodeID *uuid.UUID `gorm:"column:redemption_code_id;type:uuid;index"`
+	TreesCount       int        `gorm:"column:trees_count;type:integer;not null"`
+	// TreePriceID and PriceMinor are the tree price that was in force when the donor paid
+	TreePriceID *uuid.UUID `gorm:"column:tree_price_id;type:uuid"`
+	PriceMinor  int64      `gorm:"column:price_minor;type:bigint;not null;default:0"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
 
 	// Relationships
 	User         User         `gorm:"foreignKey:AuthUserID;constraint:OnDelete:CASCADE" json:"-"`
-	Payment      Payment      `gorm:"foreignKey:PaymentID;constraint:OnDelete:RESTRICT"`
+	Payment      *Payment     `gorm:"foreignKey:PaymentID;constraint:OnDelete:RESTRICT"`
 	Project      *Project     `gorm:"foreignKey:ProjectID;constraint:OnDelete:SET NULL" json:"-"`
+	Campaign     *Campaign    `gorm:"foreignKey:CampaignID;constraint:OnDelete:SET NULL" json:"-"`
 	ReferralUser *User        `gorm:"foreignKey:ReferralUserID;constraint:OnDelete:SET NULL" json:"-"`
+	TreePrice    *TreePrice   `gorm:"foreignKey:TreePriceID;constraint:OnDelete:SET NULL" json:"-"`
 	ShareTokens  []ShareToken `gorm:"foreignKey:RefID;constraint:OnDelete:CASCADE" json:"-"`
+	Dedication   *Dedication  `gorm:"foreignKey:DonationID;constraint:OnDelete:CASCADE"`
+	// Matches are the donations sponsors made to match this one
+	Matches        []Donation      `gorm:"foreignKey:MatchedDonationID;constraint:OnDelete:CASCADE"`
+	MatchingPool   *MatchingPool   `gorm:"foreignKey:MatchingPoolID;constraint:OnDelete:RESTRICT" json:"-"`
+	RedemptionCode *RedemptionCode `gorm:"foreignKey:RedemptionCodeID;constraint:OnDelete:RESTRICT" json:"-"`
 }
 
 func (Donation) TableName() string {
 	return "donations"
 }
 
+// Dedication represents the dedications table. A donation can be dedicated to someone as a
+// gift; the recipient gets a tree certificate by email and can claim the trees onto their
+// own profile instead of the donor's.
+type Dedication struct {
+	ID             uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	DonationID     uuid.UUID `gorm:"column:donation_id;type:uuid;uniqueIndex;not null"`
+	RecipientName  string    `gorm:"column:recipient_name;type:text;not null"`
+	RecipientEmail string    `gorm:"column:recipient_email;type:text;not null"`
+	Message        *string   `gorm:"column:message;type:text"`
+	// CertificateToken is the secret part of the certificate link sent to the recipient
+	CertificateToken    string     `gorm:"column:certificate_token;type:text;uniqueIndex;not null"`
+	NotifiedAt          *time.Time `gorm:"column:notified_at;type:timestamptz"`
+	ClaimedByAuthUserID *string    `gorm:"column:claimed_by_auth_user_id;type:text;index"`
+	ClaimedAt           *time.Time `gorm:"column:claimed_at;type:timestamptz"`
+	CreatedAt           time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Donation Donation `gorm:"foreignKey:DonationID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Dedication) TableName() string {
+	return "dedications"
+}
+
+// MatchingPool represents the matching_pools table. A sponsor matches every tree donated
+// while the pool is open with another tree, until the pool's cap in trees or money is
+// used up. Pools can be limited to a project or a campaign.
+type MatchingPool struct {
+	ID                uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	SponsorName       string     `gorm:"column:sponsor_name;type:text;not null"`
+	SponsorAuthUserID string     `gorm:"column:sponsor_auth_user_id;type:text;not null;index"`
+	ProjectID         *uuid.UUID `gorm:"column:project_id;type:uuid;index"`
+	CampaignID        *uuid.UUID `gorm:"column:campaign_id;type:uuid;index"`
+	// Currency is what the sponsor pays in; matched trees cost the tree price of the currency
+	Currency       Currency  `gorm:"column:currency;type:text;not null"`
+	CapTrees       *int      `gorm:"column:cap_trees;type:integer"`
+	CapAmountMinor *int64    `gorm:"column:cap_amount_minor;type:bigint"`
+	StartsAt       time.Time `gorm:"column:starts_at;type:timestamptz;not null"`
+	EndsAt         time.Time `gorm:"column:ends_at;type:timestamptz;not null"`
+	// MatchedTrees and MatchedAmountMinor are how much of the cap is used up
+	MatchedTrees       int       `gorm:"column:matched_trees;type:integer;not null;default:0"`
+	MatchedAmountMinor int64     `gorm:"column:matched_amount_minor;type:bigint;not null;default:0"`
+	CreatedAt          time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	UpdatedAt          time.Time `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Sponsor  UserAuth  `gorm:"foreignKey:SponsorAuthUserID;references:AuthUserID;constraint:OnDelete:RESTRICT" json:"-"`
+	Project  *Project  `gorm:"foreignKey:ProjectID;constraint:OnDelete:SET NULL" json:"-"`
+	Campaign *Campaign `gorm:"foreignKey:CampaignID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+func (MatchingPool) TableName() string {
+	return "matching_pools"
+}
+
+// RedemptionCode represents the redemption_codes table. Codes are prepaid tree
+// certificates sold offline or promo codes; redeeming one creates a donation of its trees
+// without a payment.
+type RedemptionCode struct {
+	ID   uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	Code string    `gorm:"column:code;uniqueIndex;type:text;not null"`
+	// Batch groups codes generated together, e.g. for one print run or promo
+	Batch      *string    `gorm:"column:batch;type:text;index"`
+	TreesCount int        `gorm:"column:trees_count;type:integer;not null"`
+	ProjectID  *uuid.UUID `gorm:"column:project_id;type:uuid;index"`
+	// MaxRedemptions is 1 for single-use codes; each user can redeem a code once
+	MaxRedemptions   int        `gorm:"column:max_redemptions;type:integer;not null;default:1"`
+	RedemptionsCount int        `gorm:"column:redemptions_count;type:integer;not null;default:0"`
+	ExpiresAt        *time.Time `gorm:"column:expires_at;type:timestamptz"`
+	CreatedAt        time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Project *Project `gorm:"foreignKey:ProjectID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+func (RedemptionCode) TableName() string {
+	return "redemption_codes"
+}
+
 // ShareToken represents the share_tokens table
 type ShareToken struct {
 	ID         uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
@@ -317,6 +545,137 @@ func (WebhookEvent) TableName() string {
 	return "webhook_events"
 }
 
+// Refund represents the refunds table. Every full or partial refund of a payment is
+// recorded with the trees it took back from the payment's donation.
+type Refund struct {
+	ID               uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	PaymentID        uuid.UUID `gorm:"column:payment_id;type:uuid;not null;index"`
+	ProviderRefundID *string   `gorm:"column:provider_refund_id;type:text;uniqueIndex"`
+	AmountMinor      int64     `gorm:"column:amount_minor;type:bigint;not null"`
+	TreesReversed    int       `gorm:"column:trees_reversed;type:integer;not null;default:0"`
+	Reason           *string   `gorm:"column:reason;type:text"`
+	// InitiatedBy is the admin who requested the refund; nil for refunds reported by the provider
+	InitiatedBy *string   `gorm:"column:initiated_by;type:text"`
+	CreatedAt   time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Payment Payment `gorm:"foreignKey:PaymentID;constraint:OnDelete:RESTRICT" json:"-"`
+}
+
+func (Refund) TableName() string {
+	return "refunds"
+}
+
+// CreditBalance represents the credit_balances table: the money a user donated in one
+// currency that did not add up to a whole tree yet. It is applied to the user's next
+// donation in that currency.
+type CreditBalance struct {
+	AuthUserID   string    `gorm:"column:auth_user_id;primaryKey;type:text"`
+	Currency     Currency  `gorm:"column:currency;primaryKey;type:text"`
+	BalanceMinor int64     `gorm:"column:balance_minor;type:bigint;not null;default:0"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	User User `gorm:"foreignKey:AuthUserID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (CreditBalance) TableName() string {
+	return "credit_balances"
+}
+
+// CreditEntry represents the credit_entries table, the ledger of every change of a
+// credit balance
+type CreditEntry struct {
+	ID         uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	AuthUserID string    `gorm:"column:auth_user_id;type:text;not null;index:idx_credit_entries_user_currency"`
+	Currency   Currency  `gorm:"column:currency;type:text;not null;index:idx_credit_entries_user_currency"`
+	// AmountMinor is positive when credit is added and negative when it is used up
+	AmountMinor int64      `gorm:"column:amount_minor;type:bigint;not null"`
+	Kind        string     `gorm:"column:kind;type:text;not null"`
+	DonationID  *uuid.UUID `gorm:"column:donation_id;type:uuid;index"`
+	CreatedAt   time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	User     User      `gorm:"foreignKey:AuthUserID;constraint:OnDelete:CASCADE" json:"-"`
+	Donation *Donation `gorm:"foreignKey:DonationID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+func (CreditEntry) TableName() string {
+	return "credit_entries"
+}
+
+// TreeAllocation represents the tree_allocations table, the ledger that assigns every
+// donated tree to a project
+type TreeAllocation struct {
+	ID         uuid.UUID `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	DonationID uuid.UUID `gorm:"column:donation_id;type:uuid;not null;index"`
+	ProjectID  uuid.UUID `gorm:"column:project_id;type:uuid;not null;index"`
+	// Trees is positive when trees are allocated and negative when a refund takes them back
+	Trees     int       `gorm:"column:trees;type:integer;not null"`
+	Kind      string    `gorm:"column:kind;type:text;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+
+	// Relationships
+	Donation Donation `gorm:"foreignKey:DonationID;constraint:OnDelete:CASCADE" json:"-"`
+	Project  Project  `gorm:"foreignKey:ProjectID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (TreeAllocation) TableName() string {
+	return "tree_allocations"
+}
+
+// IdempotencyKey represents the idempotency_keys table. A key is reserved by the first
+// request carrying it and stores that request's response for replay.
+type IdempotencyKey struct {
+	ID             uuid.UUID  `gorm:"column:id;primaryKey;type:uuid;default:gen_random_uuid()"`
+	AuthUserID     string     `gorm:"column:auth_user_id;type:text;not null;uniqueIndex:idx_idempotency_keys_user_scope_key"`
+	Scope          string     `gorm:"column:scope;type:text;not null;uniqueIndex:idx_idempotency_keys_user_scope_key"`
+	Key            string     `gorm:"column:key;type:text;not null;uniqueIndex:idx_idempotency_keys_user_scope_key"`
+	RequestHash    string     `gorm:"column:request_hash;type:text;not null"`
+	ResponseStatus *int       `gorm:"column:response_status;type:integer"`
+	ResponseBody   *string    `gorm:"column:response_body;type:text"`
+	CreatedAt      time.Time  `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+	CompletedAt    *time.Time `gorm:"column:completed_at;type:timestamptz"`
+	// LockedUntil ends the lease of the request in progress; another request may take
+	// the key over afterwards and gets a new LeaseToken
+	LockedUntil time.Time `gorm:"column:locked_until;type:timestamptz;not null;default:now()"`
+	LeaseToken  uuid.UUID `gorm:"column:lease_token;type:uuid;not null;default:gen_random_uuid()"`
+}
+
+func (IdempotencyKey) TableName() string {
+	return "idempotency_keys"
+}
+
+// ProviderPlan represents the provider_plans table: the billing plan a provider charges
+// an amount every IntervalMonths with. Plans are created once and shared by subscriptions.
+type ProviderPlan struct {
+	Provider       PaymentProvider `gorm:"column:provider;primaryKey;type:payment_provider"`
+	AmountMinor    int64           `gorm:"column:amount_minor;primaryKey;type:bigint"`
+	Currency       Currency        `gorm:"column:currency;primaryKey;type:text"`
+	IntervalMonths int             `gorm:"column:interval_months;primaryKey;type:integer"`
+	ProviderPlanID string          `gorm:"column:provider_plan_id;type:text;not null"`
+	CreatedAt      time.Time       `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+}
+
+func (ProviderPlan) TableName() string {
+	return "provider_plans"
+}
+
+// FXRate represents the fx_rates table: on Date, one unit of BaseCurrency was worth Rate
+// units of Currency. ECB reference rates use EUR as the base.
+type FXRate struct {
+	Date         time.Time `gorm:"column:date;primaryKey;type:date"`
+	BaseCurrency Currency  `gorm:"column:base_currency;primaryKey;type:text"`
+	Currency     Currency  `gorm:"column:currency;primaryKey;type:text"`
+	Rate         float64   `gorm:"column:rate;type:numeric(20,10);not null"`
+	Source       string    `gorm:"column:source;type:text;not null"`
+	CreatedAt    time.Time `gorm:"column:created_at;type:timestamptz;not null;default:now()"`
+}
+
+func (FXRate) TableName() string {
+	return "fx_rates"
+}
+
 // UserStats represents the user_stats view
 type UserStats struct {
 	AuthUserID     string     `gorm:"column:auth_user_id"`
@@ -0,0 +1,12 @@
+-- Remove the fake payment provider
+-- PostgreSQL cannot drop an enum value, so the type is recreated without it.
+-- Rows created by the fake provider have to be removed before rolling back.
+
+ALTER TYPE payment_provider RENAME TO payment_provider_old;
+CREATE TYPE payment_provider AS ENUM ('cloudpayments', 'kaspi', 'paypal', 'tribute');
+
+ALTER TABLE subscriptions ALTER COLUMN provider TYPE payment_provider USING provider::text::payment_provider;
+ALTER TABLE payments ALTER COLUMN provider TYPE payment_provider USING provider::text::payment_provider;
+ALTER TABLE webhook_events ALTER COLUMN provider TYPE payment_provider USING provider::text::payment_provider;
+
+DROP TYPE payment_provider_old;
@@ -0,0 +1,3 @@
+-- Add the built-in fake payment provider used for local development and e2e tests
+
+ALTER TYPE payment_provider ADD VALUE IF NOT EXISTS 'fake';
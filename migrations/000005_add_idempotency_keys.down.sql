@@ -0,0 +1,3 @@
+-- Remove idempotency_keys table
+
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,19 @@
+-- Add idempotency_keys table
+-- Intent endpoints store the response of the first request carrying an Idempotency-Key
+-- header and replay it for repeated requests with the same key
+
+CREATE TABLE idempotency_keys (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    auth_user_id text NOT NULL,
+    scope text NOT NULL,
+    key text NOT NULL,
+    request_hash text NOT NULL,
+    response_status integer,
+    response_body text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    completed_at timestamptz,
+    CONSTRAINT fk_idempotency_keys_user_auth FOREIGN KEY (auth_user_id) REFERENCES user_auth(auth_user_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX idx_idempotency_keys_user_scope_key ON idempotency_keys(auth_user_id, scope, key);
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys(created_at);
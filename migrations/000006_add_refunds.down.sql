@@ -0,0 +1,4 @@
+-- Remove refunds table
+
+DROP TABLE IF EXISTS refunds;
+ALTER TABLE payments DROP COLUMN IF EXISTS refunded_amount_minor;
@@ -0,0 +1,19 @@
+-- Add refunds table
+-- Full and partial refunds are recorded per payment together with the trees they took
+-- back from the donation; payments keep the running refunded amount
+
+ALTER TABLE payments ADD COLUMN refunded_amount_minor bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE refunds (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    payment_id uuid NOT NULL,
+    provider_refund_id text UNIQUE,
+    amount_minor bigint NOT NULL,
+    trees_reversed integer NOT NULL DEFAULT 0,
+    reason text,
+    initiated_by text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_refunds_payment FOREIGN KEY (payment_id) REFERENCES payments(id) ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_refunds_payment_id ON refunds(payment_id);
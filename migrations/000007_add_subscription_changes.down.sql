@@ -0,0 +1,3 @@
+-- Remove subscription_changes table
+
+DROP TABLE IF EXISTS subscription_changes;
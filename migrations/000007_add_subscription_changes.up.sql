@@ -0,0 +1,22 @@
+-- Add subscription_changes table
+-- Audits every cancel, pause, resume and change of amount or interval of a subscription,
+-- whether requested by the donor or reported by the payment provider
+
+CREATE TABLE subscription_changes (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id uuid NOT NULL,
+    actor_id text,
+    action text NOT NULL,
+    from_status subscription_status NOT NULL,
+    to_status subscription_status NOT NULL,
+    from_amount_minor bigint NOT NULL,
+    to_amount_minor bigint NOT NULL,
+    from_interval_months integer NOT NULL,
+    to_interval_months integer NOT NULL,
+    reason text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_subscription_changes_subscription FOREIGN KEY (subscription_id) REFERENCES subscriptions(id) ON DELETE CASCADE,
+    CONSTRAINT fk_subscription_changes_actor FOREIGN KEY (actor_id) REFERENCES user_auth(auth_user_id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_subscription_changes_subscription_id ON subscription_changes(subscription_id);
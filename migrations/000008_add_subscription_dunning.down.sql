@@ -0,0 +1,7 @@
+-- Remove dunning state from subscriptions
+
+DROP INDEX IF EXISTS idx_subscriptions_next_dunning_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS next_dunning_at;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS dunning_step;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS past_due_since;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS failed_charges;
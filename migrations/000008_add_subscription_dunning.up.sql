@@ -0,0 +1,10 @@
+-- Add dunning state to subscriptions
+-- A failed recurring charge makes a subscription past due; the dunning worker retries
+-- the charge and reminds the donor on a schedule and cancels after too many failures
+
+ALTER TABLE subscriptions ADD COLUMN failed_charges integer NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN past_due_since timestamptz;
+ALTER TABLE subscriptions ADD COLUMN dunning_step integer NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN next_dunning_at timestamptz;
+
+CREATE INDEX idx_subscriptions_next_dunning_at ON subscriptions(next_dunning_at);
@@ -0,0 +1,8 @@
+-- Remove project_id from subscriptions
+
+ALTER TABLE subscription_changes DROP COLUMN IF EXISTS to_project_id;
+ALTER TABLE subscription_changes DROP COLUMN IF EXISTS from_project_id;
+
+DROP INDEX IF EXISTS idx_subscriptions_project_id;
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS fk_subscriptions_project;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS project_id;
@@ -0,0 +1,16 @@
+-- Add project_id to subscriptions
+-- Recurring donations can target a project; every charge's donation is attributed to it.
+-- Project changes of a subscription are audited like its other changes.
+
+ALTER TABLE subscriptions ADD COLUMN project_id uuid;
+ALTER TABLE subscriptions ADD CONSTRAINT fk_subscriptions_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL;
+CREATE INDEX idx_subscriptions_project_id ON subscriptions(project_id);
+
+-- Subscriptions used to keep the project in their meta only
+UPDATE subscriptions s
+SET project_id = p.id
+FROM projects p
+WHERE s.meta->>'project_id' = p.id::text;
+
+ALTER TABLE subscription_changes ADD COLUMN from_project_id uuid;
+ALTER TABLE subscription_changes ADD COLUMN to_project_id uuid;
@@ -0,0 +1,4 @@
+-- Remove credit_balances and credit_entries tables
+
+DROP TABLE IF EXISTS credit_entries;
+DROP TABLE IF EXISTS credit_balances;
@@ -0,0 +1,28 @@
+-- Add credit_balances and credit_entries tables
+-- The part of a donation that does not add up to a whole tree is kept as credit per user
+-- and currency and applied to the user's next donation in that currency
+
+CREATE TABLE credit_balances (
+    auth_user_id text NOT NULL,
+    currency text NOT NULL,
+    balance_minor bigint NOT NULL DEFAULT 0,
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (auth_user_id, currency),
+    CONSTRAINT fk_credit_balances_user_auth FOREIGN KEY (auth_user_id) REFERENCES user_auth(auth_user_id) ON DELETE CASCADE,
+    CONSTRAINT chk_credit_balances_balance CHECK (balance_minor >= 0)
+);
+
+CREATE TABLE credit_entries (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    auth_user_id text NOT NULL,
+    currency text NOT NULL,
+    amount_minor bigint NOT NULL,
+    kind text NOT NULL,
+    donation_id uuid,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_credit_entries_user_auth FOREIGN KEY (auth_user_id) REFERENCES user_auth(auth_user_id) ON DELETE CASCADE,
+    CONSTRAINT fk_credit_entries_donation FOREIGN KEY (donation_id) REFERENCES donations(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_credit_entries_user_currency ON credit_entries(auth_user_id, currency);
+CREATE INDEX idx_credit_entries_donation_id ON credit_entries(donation_id);
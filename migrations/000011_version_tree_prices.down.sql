@@ -0,0 +1,16 @@
+-- Keep only the current tree price per currency
+
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_tree_price;
+ALTER TABLE donations DROP COLUMN IF EXISTS price_minor;
+ALTER TABLE donations DROP COLUMN IF EXISTS tree_price_id;
+
+DELETE FROM tree_prices WHERE effective_to IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_tree_prices_current;
+DROP INDEX IF EXISTS idx_tree_prices_currency_effective_from;
+ALTER TABLE tree_prices DROP CONSTRAINT IF EXISTS chk_tree_prices_effective;
+ALTER TABLE tree_prices DROP COLUMN effective_to;
+ALTER TABLE tree_prices DROP COLUMN effective_from;
+ALTER TABLE tree_prices DROP CONSTRAINT tree_prices_pkey;
+ALTER TABLE tree_prices DROP COLUMN id;
+ALTER TABLE tree_prices ADD PRIMARY KEY (currency);
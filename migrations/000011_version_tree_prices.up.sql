@@ -0,0 +1,27 @@
+-- Version tree_prices
+-- A price change adds a new version instead of overwriting the price, so that every
+-- donation can be explained by the price in force when it was paid. The current price of
+-- a currency is its only version without effective_to.
+
+ALTER TABLE tree_prices DROP CONSTRAINT tree_prices_pkey;
+ALTER TABLE tree_prices ADD COLUMN id uuid NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE tree_prices ADD PRIMARY KEY (id);
+
+-- Earlier prices were overwritten; the existing price stands in for all of them
+ALTER TABLE tree_prices ADD COLUMN effective_from timestamptz NOT NULL DEFAULT now();
+ALTER TABLE tree_prices ADD COLUMN effective_to timestamptz;
+UPDATE tree_prices SET effective_from = '1970-01-01 00:00:00+00';
+
+ALTER TABLE tree_prices ADD CONSTRAINT chk_tree_prices_effective CHECK (effective_to IS NULL OR effective_to > effective_from);
+CREATE INDEX idx_tree_prices_currency_effective_from ON tree_prices(currency, effective_from);
+CREATE UNIQUE INDEX idx_tree_prices_current ON tree_prices(currency) WHERE effective_to IS NULL;
+
+-- Donations keep the price they were made at
+ALTER TABLE donations ADD COLUMN tree_price_id uuid;
+ALTER TABLE donations ADD COLUMN price_minor bigint NOT NULL DEFAULT 0;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_tree_price FOREIGN KEY (tree_price_id) REFERENCES tree_prices(id) ON DELETE RESTRICT;
+
+UPDATE donations d
+SET tree_price_id = tp.id, price_minor = tp.price_minor
+FROM payments p, tree_prices tp
+WHERE p.id = d.payment_id AND tp.currency = p.currency;
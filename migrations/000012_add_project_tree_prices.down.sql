@@ -0,0 +1,16 @@
+-- Remove per-project tree prices
+
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_tree_price;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_tree_price FOREIGN KEY (tree_price_id) REFERENCES tree_prices(id) ON DELETE RESTRICT;
+
+UPDATE donations SET tree_price_id = NULL
+WHERE tree_price_id IN (SELECT id FROM tree_prices WHERE project_id IS NOT NULL);
+DELETE FROM tree_prices WHERE project_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_tree_prices_project_current;
+DROP INDEX IF EXISTS idx_tree_prices_current;
+CREATE UNIQUE INDEX idx_tree_prices_current ON tree_prices(currency) WHERE effective_to IS NULL;
+
+DROP INDEX IF EXISTS idx_tree_prices_project_id;
+ALTER TABLE tree_prices DROP CONSTRAINT IF EXISTS fk_tree_prices_project;
+ALTER TABLE tree_prices DROP COLUMN IF EXISTS project_id;
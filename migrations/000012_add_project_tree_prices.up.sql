@@ -0,0 +1,16 @@
+-- Add per-project tree prices
+-- A tree price with a project_id overrides the global price of its currency for that
+-- project. Overrides are versioned like global prices.
+
+ALTER TABLE tree_prices ADD COLUMN project_id uuid;
+ALTER TABLE tree_prices ADD CONSTRAINT fk_tree_prices_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE;
+CREATE INDEX idx_tree_prices_project_id ON tree_prices(project_id);
+
+-- One current price per currency globally and per project
+DROP INDEX IF EXISTS idx_tree_prices_current;
+CREATE UNIQUE INDEX idx_tree_prices_current ON tree_prices(currency) WHERE effective_to IS NULL AND project_id IS NULL;
+CREATE UNIQUE INDEX idx_tree_prices_project_current ON tree_prices(project_id, currency) WHERE effective_to IS NULL AND project_id IS NOT NULL;
+
+-- Donations keep their price_minor when a deleted project takes its prices along
+ALTER TABLE donations DROP CONSTRAINT fk_donations_tree_price;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_tree_price FOREIGN KEY (tree_price_id) REFERENCES tree_prices(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+-- Remove fx_rates table
+
+DROP TABLE IF EXISTS fx_rates;
@@ -0,0 +1,16 @@
+-- Add fx_rates table
+-- Daily exchange rates used to report payments in a single currency: on date, one unit of
+-- base_currency was worth rate units of currency
+
+CREATE TABLE fx_rates (
+    date date NOT NULL,
+    base_currency text NOT NULL,
+    currency text NOT NULL,
+    rate numeric(20,10) NOT NULL,
+    source text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (date, base_currency, currency),
+    CONSTRAINT chk_fx_rates_rate CHECK (rate > 0)
+);
+
+CREATE INDEX idx_fx_rates_currency_date ON fx_rates(currency, date);
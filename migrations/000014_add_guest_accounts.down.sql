@@ -0,0 +1,4 @@
+-- Remove guest accounts
+
+DROP INDEX IF EXISTS idx_user_auth_guest;
+ALTER TABLE user_auth DROP COLUMN IF EXISTS guest;
@@ -0,0 +1,7 @@
+-- Add guest accounts
+-- Donors can pay with only an email; their donations are made by a guest account that is
+-- claimed when they register with the same email
+
+ALTER TABLE user_auth ADD COLUMN guest boolean NOT NULL DEFAULT false;
+
+CREATE INDEX idx_user_auth_guest ON user_auth(auth_user_id) WHERE guest;
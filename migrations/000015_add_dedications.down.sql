@@ -0,0 +1,3 @@
+-- Remove dedications table
+
+DROP TABLE IF EXISTS dedications;
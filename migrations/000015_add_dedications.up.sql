@@ -0,0 +1,23 @@
+-- Add dedications table
+-- A donation can be dedicated to someone as a gift. The recipient is emailed a tree
+-- certificate link and can claim the trees onto their own profile.
+
+CREATE TABLE dedications (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    donation_id uuid NOT NULL,
+    recipient_name text NOT NULL,
+    recipient_email text NOT NULL,
+    message text,
+    certificate_token text NOT NULL,
+    notified_at timestamptz,
+    claimed_by_auth_user_id text,
+    claimed_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_dedications_donation FOREIGN KEY (donation_id) REFERENCES donations(id) ON DELETE CASCADE,
+    CONSTRAINT fk_dedications_claimed_by FOREIGN KEY (claimed_by_auth_user_id) REFERENCES user_auth(auth_user_id) ON DELETE SET NULL
+);
+
+CREATE UNIQUE INDEX idx_dedications_donation_id ON dedications(donation_id);
+CREATE UNIQUE INDEX idx_dedications_certificate_token ON dedications(certificate_token);
+CREATE INDEX idx_dedications_claimed_by_auth_user_id ON dedications(claimed_by_auth_user_id);
+CREATE INDEX idx_dedications_pending ON dedications(created_at) WHERE notified_at IS NULL;
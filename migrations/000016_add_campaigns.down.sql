@@ -0,0 +1,8 @@
+-- Remove campaigns
+
+DROP INDEX IF EXISTS idx_donations_campaign_id;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_campaign;
+ALTER TABLE donations DROP COLUMN IF EXISTS campaign_id;
+
+DROP TABLE IF EXISTS campaign_projects;
+DROP TABLE IF EXISTS campaigns;
@@ -0,0 +1,36 @@
+-- Add campaigns
+-- Time-boxed fundraising campaigns with a goal in trees, in money or both, optionally
+-- linked to projects. Donations made while a campaign runs can be attributed to it.
+
+CREATE TABLE campaigns (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    slug text NOT NULL,
+    title text NOT NULL,
+    description text,
+    goal_trees integer,
+    goal_amount_minor bigint,
+    goal_currency text,
+    starts_at timestamptz NOT NULL,
+    ends_at timestamptz NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT chk_campaigns_goal CHECK (goal_trees IS NOT NULL OR goal_amount_minor IS NOT NULL),
+    CONSTRAINT chk_campaigns_goal_trees CHECK (goal_trees IS NULL OR goal_trees > 0),
+    CONSTRAINT chk_campaigns_goal_amount CHECK (goal_amount_minor IS NULL OR (goal_amount_minor > 0 AND goal_currency IS NOT NULL)),
+    CONSTRAINT chk_campaigns_dates CHECK (ends_at > starts_at)
+);
+
+CREATE UNIQUE INDEX idx_campaigns_slug ON campaigns(slug);
+CREATE INDEX idx_campaigns_ends_at ON campaigns(ends_at);
+
+CREATE TABLE campaign_projects (
+    campaign_id uuid NOT NULL,
+    project_id uuid NOT NULL,
+    PRIMARY KEY (campaign_id, project_id),
+    CONSTRAINT fk_campaign_projects_campaign FOREIGN KEY (campaign_id) REFERENCES campaigns(id) ON DELETE CASCADE,
+    CONSTRAINT fk_campaign_projects_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE
+);
+
+ALTER TABLE donations ADD COLUMN campaign_id uuid;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_campaign FOREIGN KEY (campaign_id) REFERENCES campaigns(id) ON DELETE SET NULL;
+CREATE INDEX idx_donations_campaign_id ON donations(campaign_id);
@@ -0,0 +1,14 @@
+-- Remove matching pools
+
+DELETE FROM donations WHERE matched_donation_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_donations_matching_pool_id;
+DROP INDEX IF EXISTS idx_donations_matched_donation_id;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS chk_donations_source;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_matching_pool;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_matched_donation;
+ALTER TABLE donations DROP COLUMN IF EXISTS matching_pool_id;
+ALTER TABLE donations DROP COLUMN IF EXISTS matched_donation_id;
+ALTER TABLE donations ALTER COLUMN payment_id SET NOT NULL;
+
+DROP TABLE IF EXISTS matching_pools;
@@ -0,0 +1,43 @@
+-- Add matching pools
+-- Sponsors match every tree donated while a pool is open with another tree, up to a cap
+-- in trees or money. Matched donations are made by the sponsor's account, have no payment
+-- and reference the donation they match.
+
+CREATE TABLE matching_pools (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    sponsor_name text NOT NULL,
+    sponsor_auth_user_id text NOT NULL,
+    project_id uuid,
+    campaign_id uuid,
+    currency text NOT NULL,
+    cap_trees integer,
+    cap_amount_minor bigint,
+    starts_at timestamptz NOT NULL,
+    ends_at timestamptz NOT NULL,
+    matched_trees integer NOT NULL DEFAULT 0,
+    matched_amount_minor bigint NOT NULL DEFAULT 0,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_matching_pools_sponsor FOREIGN KEY (sponsor_auth_user_id) REFERENCES user_auth(auth_user_id) ON DELETE RESTRICT,
+    CONSTRAINT fk_matching_pools_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL,
+    CONSTRAINT fk_matching_pools_campaign FOREIGN KEY (campaign_id) REFERENCES campaigns(id) ON DELETE SET NULL,
+    CONSTRAINT chk_matching_pools_cap CHECK (cap_trees IS NOT NULL OR cap_amount_minor IS NOT NULL),
+    CONSTRAINT chk_matching_pools_cap_trees CHECK (cap_trees IS NULL OR cap_trees > 0),
+    CONSTRAINT chk_matching_pools_cap_amount CHECK (cap_amount_minor IS NULL OR cap_amount_minor > 0),
+    CONSTRAINT chk_matching_pools_dates CHECK (ends_at > starts_at),
+    CONSTRAINT chk_matching_pools_usage CHECK (matched_trees >= 0 AND matched_amount_minor >= 0)
+);
+
+CREATE INDEX idx_matching_pools_sponsor_auth_user_id ON matching_pools(sponsor_auth_user_id);
+CREATE INDEX idx_matching_pools_project_id ON matching_pools(project_id);
+CREATE INDEX idx_matching_pools_campaign_id ON matching_pools(campaign_id);
+CREATE INDEX idx_matching_pools_window ON matching_pools(starts_at, ends_at);
+
+ALTER TABLE donations ALTER COLUMN payment_id DROP NOT NULL;
+ALTER TABLE donations ADD COLUMN matched_donation_id uuid;
+ALTER TABLE donations ADD COLUMN matching_pool_id uuid;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_matched_donation FOREIGN KEY (matched_donation_id) REFERENCES donations(id) ON DELETE CASCADE;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_matching_pool FOREIGN KEY (matching_pool_id) REFERENCES matching_pools(id) ON DELETE RESTRICT;
+ALTER TABLE donations ADD CONSTRAINT chk_donations_source CHECK ((payment_id IS NULL) = (matched_donation_id IS NOT NULL));
+CREATE INDEX idx_donations_matched_donation_id ON donations(matched_donation_id);
+CREATE INDEX idx_donations_matching_pool_id ON donations(matching_pool_id);
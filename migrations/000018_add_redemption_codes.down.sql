@@ -0,0 +1,12 @@
+-- Remove redemption codes
+
+DELETE FROM donations WHERE redemption_code_id IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_donations_redemption_code_user;
+DROP INDEX IF EXISTS idx_donations_redemption_code_id;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS chk_donations_source;
+ALTER TABLE donations ADD CONSTRAINT chk_donations_source CHECK ((payment_id IS NULL) = (matched_donation_id IS NOT NULL));
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS fk_donations_redemption_code;
+ALTER TABLE donations DROP COLUMN IF EXISTS redemption_code_id;
+
+DROP TABLE IF EXISTS redemption_codes;
@@ -0,0 +1,29 @@
+-- Add redemption codes
+-- Prepaid tree certificates and promo codes are worth a number of trees. Redeeming a code
+-- creates a donation without a payment; each user can redeem a code once.
+
+CREATE TABLE redemption_codes (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    code text NOT NULL,
+    batch text,
+    trees_count integer NOT NULL,
+    project_id uuid,
+    max_redemptions integer NOT NULL DEFAULT 1,
+    redemptions_count integer NOT NULL DEFAULT 0,
+    expires_at timestamptz,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_redemption_codes_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE SET NULL,
+    CONSTRAINT chk_redemption_codes_trees CHECK (trees_count > 0),
+    CONSTRAINT chk_redemption_codes_redemptions CHECK (max_redemptions > 0 AND redemptions_count BETWEEN 0 AND max_redemptions)
+);
+
+CREATE UNIQUE INDEX idx_redemption_codes_code ON redemption_codes(code);
+CREATE INDEX idx_redemption_codes_batch ON redemption_codes(batch);
+CREATE INDEX idx_redemption_codes_project_id ON redemption_codes(project_id);
+
+ALTER TABLE donations ADD COLUMN redemption_code_id uuid;
+ALTER TABLE donations ADD CONSTRAINT fk_donations_redemption_code FOREIGN KEY (redemption_code_id) REFERENCES redemption_codes(id) ON DELETE RESTRICT;
+ALTER TABLE donations DROP CONSTRAINT IF EXISTS chk_donations_source;
+ALTER TABLE donations ADD CONSTRAINT chk_donations_source CHECK (num_nonnulls(payment_id, matched_donation_id, redemption_code_id) = 1);
+CREATE INDEX idx_donations_redemption_code_id ON donations(redemption_code_id);
+CREATE UNIQUE INDEX idx_donations_redemption_code_user ON donations(redemption_code_id, auth_user_id) WHERE redemption_code_id IS NOT NULL;
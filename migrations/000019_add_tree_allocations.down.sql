@@ -0,0 +1,6 @@
+-- Remove the tree allocation ledger
+
+DROP TABLE IF EXISTS tree_allocations;
+
+ALTER TABLE projects DROP CONSTRAINT IF EXISTS chk_projects_trees;
+ALTER TABLE projects DROP COLUMN IF EXISTS trees_funded;
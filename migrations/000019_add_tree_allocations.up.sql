@@ -0,0 +1,37 @@
+-- Add the tree allocation ledger
+-- Every donated tree is allocated to a project: the one the donor chose or, for general
+-- donations, one picked by the auto-allocation rule. projects.trees_funded is the sum of
+-- a project's allocations.
+
+ALTER TABLE projects ADD COLUMN trees_funded integer NOT NULL DEFAULT 0;
+ALTER TABLE projects ADD CONSTRAINT chk_projects_trees CHECK (
+    trees_funded >= 0 AND (trees_target IS NULL OR trees_target >= 0) AND (trees_planted IS NULL OR trees_planted >= 0)
+);
+
+CREATE TABLE tree_allocations (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    donation_id uuid NOT NULL,
+    project_id uuid NOT NULL,
+    trees integer NOT NULL,
+    kind text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_tree_allocations_donation FOREIGN KEY (donation_id) REFERENCES donations(id) ON DELETE CASCADE,
+    CONSTRAINT fk_tree_allocations_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    CONSTRAINT chk_tree_allocations_trees CHECK (trees <> 0),
+    CONSTRAINT chk_tree_allocations_kind CHECK (kind IN ('donor_choice', 'auto'))
+);
+
+CREATE INDEX idx_tree_allocations_donation_id ON tree_allocations(donation_id);
+CREATE INDEX idx_tree_allocations_project_id ON tree_allocations(project_id);
+
+-- Donations to a project are allocated to it; general donations are allocated by
+-- POST /admin/allocations/run once projects with targets are set up
+INSERT INTO tree_allocations (donation_id, project_id, trees, kind, created_at)
+SELECT id, project_id, trees_count, 'donor_choice', created_at
+FROM donations
+WHERE project_id IS NOT NULL AND trees_count > 0;
+
+UPDATE projects p
+SET trees_funded = a.trees
+FROM (SELECT project_id, SUM(trees) AS trees FROM tree_allocations GROUP BY project_id) a
+WHERE a.project_id = p.id;
@@ -0,0 +1,8 @@
+-- Remove planting batches
+
+DROP INDEX IF EXISTS idx_media_planting_batch;
+ALTER TABLE media_files DROP CONSTRAINT IF EXISTS fk_media_planting_batch;
+ALTER TABLE media_files DROP COLUMN IF EXISTS planting_batch_id;
+
+DROP TABLE IF EXISTS tree_plantings;
+DROP TABLE IF EXISTS planting_batches;
@@ -0,0 +1,37 @@
+-- Add planting batches
+-- A batch records trees of one species planted in a project on one day within a GPS
+-- polygon. Funded trees of the project's donations are linked to the batch that planted
+-- them, oldest allocation first, and media files can be attached as evidence.
+
+CREATE TABLE planting_batches (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    project_id uuid NOT NULL,
+    planted_on date NOT NULL,
+    species text NOT NULL,
+    trees_count integer NOT NULL,
+    area_geojson jsonb NOT NULL,
+    notes text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_planting_batches_project FOREIGN KEY (project_id) REFERENCES projects(id) ON DELETE CASCADE,
+    CONSTRAINT chk_planting_batches_trees CHECK (trees_count > 0)
+);
+
+CREATE INDEX idx_planting_batches_project_id ON planting_batches(project_id, planted_on);
+
+CREATE TABLE tree_plantings (
+    id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+    planting_batch_id uuid NOT NULL,
+    donation_id uuid NOT NULL,
+    trees integer NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT fk_tree_plantings_batch FOREIGN KEY (planting_batch_id) REFERENCES planting_batches(id) ON DELETE CASCADE,
+    CONSTRAINT fk_tree_plantings_donation FOREIGN KEY (donation_id) REFERENCES donations(id) ON DELETE CASCADE,
+    CONSTRAINT chk_tree_plantings_trees CHECK (trees > 0)
+);
+
+CREATE INDEX idx_tree_plantings_planting_batch_id ON tree_plantings(planting_batch_id);
+CREATE INDEX idx_tree_plantings_donation_id ON tree_plantings(donation_id);
+
+ALTER TABLE media_files ADD COLUMN planting_batch_id uuid;
+ALTER TABLE media_files ADD CONSTRAINT fk_media_planting_batch FOREIGN KEY (planting_batch_id) REFERENCES planting_batches(id) ON DELETE SET NULL;
+CREATE INDEX idx_media_planting_batch ON media_files(planting_batch_id);
@@ -0,0 +1,6 @@
+-- Remove pending changes from subscriptions
+
+ALTER TABLE subscriptions DROP CONSTRAINT IF EXISTS chk_subscriptions_pending_terms;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pending_plan_id;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pending_interval_months;
+ALTER TABLE subscriptions DROP COLUMN IF EXISTS pending_amount_minor;
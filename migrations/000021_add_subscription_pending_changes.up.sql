@@ -0,0 +1,10 @@
+-- Add pending changes to subscriptions
+-- Providers such as PayPal only apply new terms once the donor approves them, so the
+-- requested amount and interval wait here until the provider confirms the change
+
+ALTER TABLE subscriptions ADD COLUMN pending_amount_minor bigint;
+ALTER TABLE subscriptions ADD COLUMN pending_interval_months integer;
+ALTER TABLE subscriptions ADD COLUMN pending_plan_id text;
+
+ALTER TABLE subscriptions ADD CONSTRAINT chk_subscriptions_pending_terms
+    CHECK ((pending_amount_minor IS NULL) = (pending_interval_months IS NULL));
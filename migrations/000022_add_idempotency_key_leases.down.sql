@@ -0,0 +1,4 @@
+-- Remove leases from idempotency_keys
+
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS lease_token;
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS locked_until;
@@ -0,0 +1,7 @@
+-- Add leases to idempotency_keys
+-- A request holds its key until locked_until; a key whose request never finished, for example
+-- because the server stopped, can be taken over afterwards. Existing unfinished keys are expired.
+-- lease_token changes with every takeover, so that only the current holder can finish the key.
+
+ALTER TABLE idempotency_keys ADD COLUMN locked_until timestamptz NOT NULL DEFAULT now();
+ALTER TABLE idempotency_keys ADD COLUMN lease_token uuid NOT NULL DEFAULT gen_random_uuid();
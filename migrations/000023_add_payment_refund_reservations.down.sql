@@ -0,0 +1,4 @@
+-- Remove refund reservations from payments
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS chk_payments_refund_reserved;
+ALTER TABLE payments DROP COLUMN IF EXISTS refund_reserved_minor;
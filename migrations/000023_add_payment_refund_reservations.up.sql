@@ -0,0 +1,7 @@
+-- Add refund reservations to payments
+-- An admin refund reserves its amount before the provider is asked to refund it, so that
+-- concurrent refunds of a payment cannot send more money back than it brought in
+
+ALTER TABLE payments ADD COLUMN refund_reserved_minor bigint NOT NULL DEFAULT 0;
+
+ALTER TABLE payments ADD CONSTRAINT chk_payments_refund_reserved CHECK (refund_reserved_minor >= 0);
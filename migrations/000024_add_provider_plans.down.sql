@@ -0,0 +1,3 @@
+-- Remove provider_plans table
+
+DROP TABLE IF EXISTS provider_plans;
@@ -0,0 +1,13 @@
+-- Add provider_plans table
+-- PayPal charges subscriptions through billing plans; the plan of every amount, currency
+-- and interval is created once and reused across restarts and instances
+
+CREATE TABLE provider_plans (
+    provider payment_provider NOT NULL,
+    amount_minor bigint NOT NULL,
+    currency text NOT NULL,
+    interval_months integer NOT NULL,
+    provider_plan_id text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (provider, amount_minor, currency, interval_months)
+);
@@ -116,3 +116,12 @@ func (s *Service) CheckAndAwardTreeBasedAchievements(authUserID string, totalTre
 
 	return nil
 }
+
+// RevokeTreeBasedAchievements removes tree-based achievements a user no longer qualifies
+// for, e.g. after a refund. It runs on tx so that callers can revoke within the
+// transaction that lowered the user's tree count.
+func RevokeTreeBasedAchievements(tx *gorm.DB, authUserID string, totalTrees int) error {
+	return tx.Where("auth_user_id = ? AND achievement_id IN (?)", authUserID,
+		tx.Model(&models.Achievement{}).Select("id").Where("threshold_trees IS NOT NULL AND threshold_trees > ?", totalTrees)).
+		Delete(&models.UserAchievement{}).Error
+}
@@ -0,0 +1,332 @@
+package allocations
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of allocation ledger entries. Trees a refund takes back are recorded with the kind
+// of the allocation they undo.
+const (
+	// KindDonorChoice allocates the trees of a donation to the project the donor chose
+	KindDonorChoice = "donor_choice"
+	// KindAuto allocates the trees of a general donation by the auto-allocation rule
+	KindAuto = "auto"
+)
+
+var (
+	// ErrProjectNotFound is returned for unknown projects
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrInvalidTarget is returned for negative tree targets
+	ErrInvalidTarget = errors.New("tree target must not be negative")
+)
+
+// Funding is a project's progress towards its tree target
+type Funding struct {
+	ProjectID   uuid.UUID            `json:"project_id"`
+	Title       string               `json:"title"`
+	Status      models.ProjectStatus `json:"status"`
+	TreesTarget *int                 `json:"trees_target"`
+	TreesFunded int                  `json:"trees_funded"`
+	// TreesChosen were funded by donors who chose the project, TreesAutoAllocated by
+	// general donations
+	TreesChosen        int `json:"trees_chosen"`
+	TreesAutoAllocated int `json:"trees_auto_allocated"`
+	TreesPlanted       int `json:"trees_planted"`
+	// TreesRemaining is how many more trees the project needs to reach its target
+	TreesRemaining *int     `json:"trees_remaining"`
+	FundedPercent  *float64 `json:"funded_percent"`
+	// PlantedPercent is the share of funded trees that were planted
+	PlantedPercent *float64 `json:"planted_percent"`
+}
+
+// share is a number of trees allocated to one project
+type share struct {
+	projectID uuid.UUID
+	trees     int
+	kind      string
+}
+
+// Service reports project funding and allocates general donations that are still waiting
+// for a project
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new allocations service
+func NewService() *Service {
+	return &Service{
+		db: database.GetDB(),
+	}
+}
+
+// Allocate assigns the trees of a new donation: to the project the donor chose or, for
+// general donations, by the auto-allocation rule. Trees no project needs stay unallocated
+// until AllocatePending runs.
+func Allocate(tx *gorm.DB, donation *models.Donation) error {
+	if donation.TreesCount <= 0 {
+		return nil
+	}
+	if donation.ProjectID != nil {
+		return record(tx, donation.ID, share{projectID: *donation.ProjectID, trees: donation.TreesCount, kind: KindDonorChoice})
+	}
+	_, err := autoAllocate(tx, donation.ID, donation.TreesCount)
+	return err
+}
+
+// Release takes back allocated trees a donation no longer has, latest project first, after
+// a refund reduced its trees. Unallocated trees are taken back before allocated ones.
+func Release(tx *gorm.DB, donationID uuid.UUID) error {
+	var donation models.Donation
+	if err := tx.Select("id", "trees_count").Where("id = ?", donationID).First(&donation).Error; err != nil {
+		return fmt.Errorf("failed to find donation: %w", err)
+	}
+	var entries []models.TreeAllocation
+	if err := tx.Where("donation_id = ?", donationID).Order("created_at ASC").Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to find allocations: %w", err)
+	}
+
+	for _, s := range releases(entries, donation.TreesCount) {
+		if err := record(tx, donationID, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoAllocate gives trees to the projects that still need trees to reach their target,
+// least funded first: the project with the lowest share of its target funded gets as many
+// trees as it needs and the next one the rest. Completed projects and projects without a
+// target are left out. It returns how many trees were allocated.
+func autoAllocate(tx *gorm.DB, donationID uuid.UUID, trees int) (int, error) {
+	var projects []models.Project
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("status <> ? AND trees_target IS NOT NULL AND trees_funded < trees_target", models.ProjectStatusCompleted).
+		Order("trees_funded::float8 / trees_target ASC, created_at ASC").
+		Find(&projects).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find projects to allocate trees to: %w", err)
+	}
+
+	allocated := 0
+	for _, s := range autoShares(projects, trees) {
+		if err := record(tx, donationID, s); err != nil {
+			return 0, err
+		}
+		allocated += s.trees
+	}
+	return allocated, nil
+}
+
+// autoShares splits trees across projects in order, each getting up to what it needs to
+// reach its target
+func autoShares(projects []models.Project, trees int) []share {
+	var shares []share
+	for _, project := range projects {
+		if trees <= 0 {
+			break
+		}
+		if project.TreesTarget == nil {
+			continue
+		}
+		need := *project.TreesTarget - project.TreesFunded
+		if need <= 0 {
+			continue
+		}
+		n := min(need, trees)
+		shares = append(shares, share{projectID: project.ID, trees: n, kind: KindAuto})
+		trees -= n
+	}
+	return shares
+}
+
+// releases returns the trees to take back from each project so that no more than
+// remainingTrees of a donation stay allocated. Projects are released in the reverse order
+// they were first allocated.
+func releases(entries []models.TreeAllocation, remainingTrees int) []share {
+	net := make(map[uuid.UUID]int)
+	kinds := make(map[uuid.UUID]string)
+	var order []uuid.UUID
+	total := 0
+	for _, entry := range entries {
+		if _, ok := net[entry.ProjectID]; !ok {
+			order = append(order, entry.ProjectID)
+			kinds[entry.ProjectID] = entry.Kind
+		}
+		net[entry.ProjectID] += entry.Trees
+		total += entry.Trees
+	}
+
+	excess := total - max(remainingTrees, 0)
+	var shares []share
+	for i := len(order) - 1; i >= 0 && excess > 0; i-- {
+		n := min(net[order[i]], excess)
+		if n <= 0 {
+			continue
+		}
+		shares = append(shares, share{projectID: order[i], trees: -n, kind: kinds[order[i]]})
+		excess -= n
+	}
+	return shares
+}
+
+// record adds an allocation to the ledger and to the project's funded trees
+func record(tx *gorm.DB, donationID uuid.UUID, s share) error {
+	entry := &models.TreeAllocation{
+		ID:         uuid.New(),
+		DonationID: donationID,
+		ProjectID:  s.projectID,
+		Trees:      s.trees,
+		Kind:       s.kind,
+	}
+	if err := tx.Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to record tree allocation: %w", err)
+	}
+	if err := tx.Model(&models.Project{}).Where("id = ?", s.projectID).
+		Update("trees_funded", gorm.Expr("trees_funded + ?", s.trees)).Error; err != nil {
+		return fmt.Errorf("failed to update project counters: %w", err)
+	}
+	return nil
+}
+
+// AllocatePending allocates the trees of general donations that no project needed when they
+// were made, oldest donation first. It returns how many trees were allocated and how many
+// are still waiting for a project.
+func (s *Service) AllocatePending() (int, int, error) {
+	var pending []struct {
+		ID    uuid.UUID
+		Trees int
+	}
+	err := s.db.Raw(`SELECT d.id, d.trees_count - COALESCE(SUM(a.trees), 0) AS trees
+		FROM donations d LEFT JOIN tree_allocations a ON a.donation_id = d.id
+		WHERE d.project_id IS NULL
+		GROUP BY d.id
+		HAVING d.trees_count - COALESCE(SUM(a.trees), 0) > 0
+		ORDER BY d.created_at ASC`).Scan(&pending).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find unallocated donations: %w", err)
+	}
+
+	allocated, unallocated := 0, 0
+	for _, donation := range pending {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			// A refund may have taken trees back since the donation was found
+			var locked models.Donation
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "trees_count").
+				Where("id = ?", donation.ID).First(&locked).Error; err != nil {
+				return fmt.Errorf("failed to find donation: %w", err)
+			}
+			var allocatedTrees int
+			if err := tx.Model(&models.TreeAllocation{}).Select("COALESCE(SUM(trees), 0)").
+				Where("donation_id = ?", donation.ID).Scan(&allocatedTrees).Error; err != nil {
+				return fmt.Errorf("failed to sum allocations: %w", err)
+			}
+			trees := locked.TreesCount - allocatedTrees
+			if trees <= 0 {
+				return nil
+			}
+
+			n, err := autoAllocate(tx, donation.ID, trees)
+			if err != nil {
+				return err
+			}
+			allocated += n
+			unallocated += trees - n
+			return nil
+		})
+		if err != nil {
+			return allocated, 0, err
+		}
+	}
+	return allocated, unallocated, nil
+}
+
+// GetFunding returns a project's progress towards its tree target
+func (s *Service) GetFunding(projectID uuid.UUID) (*Funding, error) {
+	var project models.Project
+	err := s.db.Where("id = ?", projectID).First(&project).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find project: %w", err)
+	}
+
+	var kinds []struct {
+		Kind  string
+		Trees int
+	}
+	err = s.db.Model(&models.TreeAllocation{}).
+		Select("kind, COALESCE(SUM(trees), 0) AS trees").
+		Where("project_id = ?", projectID).
+		Group("kind").
+		Scan(&kinds).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum allocations: %w", err)
+	}
+
+	funding := newFunding(&project)
+	for _, kind := range kinds {
+		switch kind.Kind {
+		case KindDonorChoice:
+			funding.TreesChosen = kind.Trees
+		case KindAuto:
+			funding.TreesAutoAllocated = kind.Trees
+		}
+	}
+	return funding, nil
+}
+
+// SetTarget changes how many trees a project aims for; nil removes the target, so general
+// donations are no longer allocated to the project. Trees already funded stay allocated.
+func (s *Service) SetTarget(projectID uuid.UUID, target *int) (*Funding, error) {
+	if target != nil && *target < 0 {
+		return nil, ErrInvalidTarget
+	}
+	result := s.db.Model(&models.Project{}).Where("id = ?", projectID).Update("trees_target", target)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update project: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrProjectNotFound
+	}
+	return s.GetFunding(projectID)
+}
+
+// newFunding reports the counters of a project without the breakdown of its allocations
+func newFunding(project *models.Project) *Funding {
+	funding := &Funding{
+		ProjectID:   project.ID,
+		Title:       project.Title,
+		Status:      project.Status,
+		TreesTarget: project.TreesTarget,
+		TreesFunded: project.TreesFunded,
+	}
+	if project.TreesPlanted != nil {
+		funding.TreesPlanted = *project.TreesPlanted
+	}
+	if project.TreesTarget != nil {
+		remaining := max(*project.TreesTarget-project.TreesFunded, 0)
+		funding.TreesRemaining = &remaining
+		funding.FundedPercent = percent(project.TreesFunded, *project.TreesTarget)
+	}
+	funding.PlantedPercent = percent(funding.TreesPlanted, project.TreesFunded)
+	return funding
+}
+
+// percent returns value as a percentage of total rounded to one decimal, or nil when the
+// total is zero
+func percent(value, total int) *float64 {
+	if total <= 0 {
+		return nil
+	}
+	p := math.Round(float64(value)*1000/float64(total)) / 10
+	return &p
+}
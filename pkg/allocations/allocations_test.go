@@ -0,0 +1,99 @@
+package allocations
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	moscow = uuid.MustParse("1b4e28ba-2fa1-41d2-883f-0016d3cca427")
+	almaty = uuid.MustParse("6fa459ea-ee8a-4ca4-894e-db77e160355e")
+	steppe = uuid.MustParse("9c5b94b1-35ad-49bb-b118-8e8fc24abf80")
+)
+
+func target(n int) *int { return &n }
+
+func TestAutoShares(t *testing.T) {
+	projects := []models.Project{
+		{ID: moscow, TreesTarget: target(100), TreesFunded: 95},
+		{ID: almaty, TreesTarget: target(50), TreesFunded: 50},
+		{ID: steppe, TreesTarget: target(1000), TreesFunded: 600},
+	}
+
+	tests := []struct {
+		name     string
+		trees    int
+		expected []share
+	}{
+		{"first project needs all trees", 3, []share{{moscow, 3, KindAuto}}},
+		{"first project fills up", 5, []share{{moscow, 5, KindAuto}}},
+		{"rest goes to the next project that needs trees", 12, []share{{moscow, 5, KindAuto}, {steppe, 7, KindAuto}}},
+		{"more than every project needs", 500, []share{{moscow, 5, KindAuto}, {steppe, 400, KindAuto}}},
+		{"no trees", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, autoShares(projects, tt.trees))
+		})
+	}
+}
+
+func TestAutoShares_SkipsProjectsWithoutTarget(t *testing.T) {
+	projects := []models.Project{{ID: moscow}, {ID: almaty, TreesTarget: target(10)}}
+	assert.Equal(t, []share{{almaty, 4, KindAuto}}, autoShares(projects, 4))
+}
+
+func TestReleases(t *testing.T) {
+	autoEntries := []models.TreeAllocation{
+		{ProjectID: moscow, Trees: 5, Kind: KindAuto},
+		{ProjectID: steppe, Trees: 7, Kind: KindAuto},
+	}
+
+	tests := []struct {
+		name      string
+		entries   []models.TreeAllocation
+		remaining int
+		expected  []share
+	}{
+		{"nothing to release", autoEntries, 12, nil},
+		{"latest project first", autoEntries, 9, []share{{steppe, -3, KindAuto}}},
+		{"across projects", autoEntries, 2, []share{{steppe, -7, KindAuto}, {moscow, -3, KindAuto}}},
+		{"full refund", autoEntries, 0, []share{{steppe, -7, KindAuto}, {moscow, -5, KindAuto}}},
+		{
+			"after an earlier release",
+			append(autoEntries, models.TreeAllocation{ProjectID: steppe, Trees: -7, Kind: KindAuto}),
+			0,
+			[]share{{moscow, -5, KindAuto}},
+		},
+		{"unallocated trees first", []models.TreeAllocation{{ProjectID: moscow, Trees: 5, Kind: KindAuto}}, 8, nil},
+		{"donor choice", []models.TreeAllocation{{ProjectID: almaty, Trees: 10, Kind: KindDonorChoice}}, 4, []share{{almaty, -6, KindDonorChoice}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, releases(tt.entries, tt.remaining))
+		})
+	}
+}
+
+func TestNewFunding(t *testing.T) {
+	planted := 300
+	funding := newFunding(&models.Project{ID: moscow, TreesTarget: target(1000), TreesFunded: 400, TreesPlanted: &planted})
+	assert.Equal(t, 600, *funding.TreesRemaining)
+	assert.Equal(t, 40.0, *funding.FundedPercent)
+	assert.Equal(t, 75.0, *funding.PlantedPercent)
+
+	overfunded := newFunding(&models.Project{ID: almaty, TreesTarget: target(100), TreesFunded: 150})
+	assert.Equal(t, 0, *overfunded.TreesRemaining)
+	assert.Equal(t, 150.0, *overfunded.FundedPercent)
+	assert.Equal(t, 0.0, *overfunded.PlantedPercent)
+
+	unfunded := newFunding(&models.Project{ID: steppe})
+	assert.Nil(t, unfunded.TreesRemaining)
+	assert.Nil(t, unfunded.FundedPercent)
+	assert.Nil(t, unfunded.PlantedPercent)
+}
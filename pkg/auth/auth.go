@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
 
@@ -11,8 +12,12 @@ import (
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrAccountExists is returned for guest checkouts with the email of a registered account
+var ErrAccountExists = errors.New("an account with this email exists")
+
 // Service provides authentication functionality
 type Service struct {
 	db *gorm.DB
@@ -265,17 +270,105 @@ func (s *Service) CreateUser(email, username, passwordHash string, displayName *
 	return user, nil
 }
 
+// GetOrCreateGuestUser returns the guest account of an email, creating it on the first
+// guest checkout. Emails of registered accounts return ErrAccountExists.
+func (s *Service) GetOrCreateGuestUser(email string) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		userAuth := &models.UserAuth{
+			ID:         uuid.New(),
+			AuthUserID: uuid.New().String(),
+			Email:      email,
+			Status:     models.UserStatusPending,
+			Guest:      true,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(userAuth)
+		if result.Error != nil {
+			return fmt.Errorf("failed to create guest user auth: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			// The email is taken; only a guest account can be reused
+			if err := tx.Where("email = ?", email).First(userAuth).Error; err != nil {
+				return fmt.Errorf("failed to find user auth: %w", err)
+			}
+			if !userAuth.Guest {
+				return ErrAccountExists
+			}
+			return tx.Where("auth_user_id = ?", userAuth.AuthUserID).First(&user).Error
+		}
+
+		user = models.User{
+			AuthUserID: userAuth.AuthUserID,
+			Email:      email,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create guest user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ClaimGuestUser sets the credentials of a registration on the guest account of its email.
+// The account stays a guest account until the email is verified, and verification links
+// sent for earlier claims stop working, so only the owner of the email can claim it.
+func (s *Service) ClaimGuestUser(authUserID, username, passwordHash string, displayName *string) (*models.User, error) {
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.UserAuth{}).
+			Where("auth_user_id = ? AND guest", authUserID).
+			Updates(map[string]interface{}{
+				"password_hash": passwordHash,
+				"updated_at":    time.Now(),
+			})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update user auth: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrAccountExists
+		}
+
+		err := tx.Where("auth_user_id = ? AND used_at IS NULL", authUserID).
+			Delete(&models.EmailVerificationToken{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to revoke verification tokens: %w", err)
+		}
+
+		if err := tx.Where("auth_user_id = ?", authUserID).First(&user).Error; err != nil {
+			return err
+		}
+		user.Username = &username
+		user.DisplayName = displayName
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"username":     username,
+			"display_name": displayName,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 // UpdateUserPassword updates a user's password in UserAuth
 func (s *Service) UpdateUserPassword(authUserID, passwordHash string) error {
 	return s.db.Model(&models.UserAuth{}).Where("auth_user_id = ?", authUserID).Update("password_hash", passwordHash).Error
 }
 
-// VerifyUserEmail verifies a user's email in UserAuth
+// VerifyUserEmail verifies a user's email in UserAuth. Verifying a claimed guest account
+// turns it into a regular account, keeping its donations and trees.
 func (s *Service) VerifyUserEmail(authUserID string) error {
 	now := time.Now()
 	return s.db.Model(&models.UserAuth{}).Where("auth_user_id = ?", authUserID).Updates(map[string]interface{}{
 		"verified_at": now,
 		"status":      models.UserStatusActive,
+		"guest":       false,
 	}).Error
 }
 
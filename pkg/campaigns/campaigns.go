@@ -0,0 +1,410 @@
+package campaigns
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidCampaign is returned for campaigns with missing or inconsistent fields
+	ErrInvalidCampaign = errors.New("invalid campaign")
+	// ErrSlugTaken is returned when another campaign already uses a slug
+	ErrSlugTaken = errors.New("campaign slug is already taken")
+	// ErrUnknownProject is returned when a campaign is linked to a project that does not exist
+	ErrUnknownProject = errors.New("unknown project")
+)
+
+// Statuses of a campaign, derived from its dates
+const (
+	StatusUpcoming = "upcoming"
+	StatusActive   = "active"
+	StatusEnded    = "ended"
+)
+
+// slugPattern is the format of campaign slugs, e.g. earth-day-2027
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// countedStatuses are the statuses of payments whose donations count towards a money goal;
+// refunded amounts are deducted
+var countedStatuses = []models.PaymentStatus{models.PaymentStatusSucceeded, models.PaymentStatusRefunded}
+
+// CampaignInput is what admins set when creating or updating a campaign
+type CampaignInput struct {
+	Slug            string
+	Title           string
+	Description     *string
+	GoalTrees       *int
+	GoalAmountMinor *int64
+	GoalCurrency    *models.Currency
+	StartsAt        time.Time
+	EndsAt          time.Time
+	ProjectIDs      []uuid.UUID
+}
+
+// ProjectSummary is a project linked to a campaign
+type ProjectSummary struct {
+	ID    uuid.UUID `json:"id"`
+	Title string    `json:"title"`
+}
+
+// Progress is the public view of a campaign and how far it got towards its goals
+type Progress struct {
+	ID          uuid.UUID        `json:"id"`
+	Slug        string           `json:"slug"`
+	Title       string           `json:"title"`
+	Description *string          `json:"description,omitempty"`
+	StartsAt    time.Time        `json:"starts_at"`
+	EndsAt      time.Time        `json:"ends_at"`
+	Status      string           `json:"status"`
+	Projects    []ProjectSummary `json:"projects"`
+	Donations   int              `json:"donations"`
+
+	Trees        int      `json:"trees"`
+	GoalTrees    *int     `json:"goal_trees,omitempty"`
+	TreesPercent *float64 `json:"trees_percent,omitempty"`
+
+	// RaisedMinor is the net amount donated, converted to the goal currency
+	RaisedMinor     int64            `json:"raised_minor"`
+	GoalAmountMinor *int64           `json:"goal_amount_minor,omitempty"`
+	GoalCurrency    *models.Currency `json:"goal_currency,omitempty"`
+	AmountPercent   *float64         `json:"amount_percent,omitempty"`
+	// Unconverted counts payments left out of RaisedMinor because no rate was known for them
+	Unconverted int `json:"unconverted"`
+}
+
+// Service manages fundraising campaigns and reports their progress
+type Service struct {
+	db        *gorm.DB
+	fxService *fx.Service
+}
+
+// NewService creates a new campaigns service
+func NewService(fxService *fx.Service) *Service {
+	return &Service{
+		db:        database.GetDB(),
+		fxService: fxService,
+	}
+}
+
+// ListCampaigns returns every campaign with its projects, latest first
+func (s *Service) ListCampaigns() ([]models.Campaign, error) {
+	var campaigns []models.Campaign
+	err := s.db.Preload("Projects").Order("starts_at DESC").Find(&campaigns).Error
+	return campaigns, err
+}
+
+// CreateCampaign creates a campaign
+func (s *Service) CreateCampaign(input *CampaignInput) (*models.Campaign, error) {
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+	campaign := &models.Campaign{ID: uuid.New()}
+	applyInput(campaign, input)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkSlug(tx, input.Slug, nil); err != nil {
+			return err
+		}
+		projects, err := findProjects(tx, input.ProjectIDs)
+		if err != nil {
+			return err
+		}
+		campaign.Projects = projects
+		if err := tx.Omit("Projects.*").Create(campaign).Error; err != nil {
+			return fmt.Errorf("failed to create campaign: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return campaign, nil
+}
+
+// UpdateCampaign replaces the fields and projects of a campaign. Donations already
+// attributed to it stay attributed.
+func (s *Service) UpdateCampaign(id uuid.UUID, input *CampaignInput) (*models.Campaign, error) {
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+
+	var campaign models.Campaign
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ?", id).First(&campaign).Error; err != nil {
+			return err
+		}
+		if err := checkSlug(tx, input.Slug, &id); err != nil {
+			return err
+		}
+		projects, err := findProjects(tx, input.ProjectIDs)
+		if err != nil {
+			return err
+		}
+
+		applyInput(&campaign, input)
+		campaign.UpdatedAt = time.Now()
+		if err := tx.Omit("Projects").Save(&campaign).Error; err != nil {
+			return fmt.Errorf("failed to update campaign: %w", err)
+		}
+		if err := tx.Model(&campaign).Omit("Projects.*").Association("Projects").Replace(projects); err != nil {
+			return fmt.Errorf("failed to update campaign projects: %w", err)
+		}
+		campaign.Projects = projects
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// GetCampaigns returns the progress of every campaign that has not ended, soonest first
+func (s *Service) GetCampaigns(now time.Time) ([]Progress, error) {
+	var campaigns []models.Campaign
+	err := s.db.Preload("Projects").Where("ends_at > ?", now).Order("starts_at ASC").Find(&campaigns).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find campaigns: %w", err)
+	}
+
+	result := make([]Progress, 0, len(campaigns))
+	for i := range campaigns {
+		progress, err := s.progress(&campaigns[i], now)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *progress)
+	}
+	return result, nil
+}
+
+// GetProgress returns the progress of the campaign with a slug
+func (s *Service) GetProgress(slug string, now time.Time) (*Progress, error) {
+	var campaign models.Campaign
+	if err := s.db.Preload("Projects").Where("slug = ?", slug).First(&campaign).Error; err != nil {
+		return nil, err
+	}
+	return s.progress(&campaign, now)
+}
+
+// progress sums the donations attributed to a campaign. Money is converted to the goal
+// currency at the rate of the day each payment was made.
+func (s *Service) progress(campaign *models.Campaign, now time.Time) (*Progress, error) {
+	var totals struct {
+		Trees     int
+		Donations int
+	}
+	err := s.db.Model(&models.Donation{}).
+		Select("COALESCE(SUM(trees_count), 0) AS trees, COUNT(*) AS donations").
+		Where("campaign_id = ?", campaign.ID).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum campaign donations: %w", err)
+	}
+
+	progress := newProgress(campaign, now)
+	progress.addDonations(totals.Trees, totals.Donations)
+	if campaign.GoalCurrency == nil {
+		return progress, nil
+	}
+
+	var payments []models.Payment
+	err = s.db.Joins("JOIN donations ON donations.payment_id = payments.id").
+		Where("donations.campaign_id = ? AND payments.status IN ?", campaign.ID, countedStatuses).
+		Find(&payments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load campaign payments: %w", err)
+	}
+	var raisedMinor int64
+	var unconverted int
+	if len(payments) > 0 {
+		from, to := paymentsPeriod(payments)
+		rates, err := s.fxService.LoadRates(from, to)
+		if err != nil {
+			return nil, err
+		}
+		raisedMinor, unconverted = raised(payments, rates, *campaign.GoalCurrency)
+	}
+	progress.addRaised(raisedMinor, unconverted)
+	return progress, nil
+}
+
+// newProgress returns the progress of a campaign without any donations
+func newProgress(campaign *models.Campaign, now time.Time) *Progress {
+	projects := make([]ProjectSummary, 0, len(campaign.Projects))
+	for _, project := range campaign.Projects {
+		projects = append(projects, ProjectSummary{ID: project.ID, Title: project.Title})
+	}
+	return &Progress{
+		ID:              campaign.ID,
+		Slug:            campaign.Slug,
+		Title:           campaign.Title,
+		Description:     campaign.Description,
+		StartsAt:        campaign.StartsAt,
+		EndsAt:          campaign.EndsAt,
+		Status:          Status(campaign, now),
+		Projects:        projects,
+		GoalTrees:       campaign.GoalTrees,
+		GoalAmountMinor: campaign.GoalAmountMinor,
+		GoalCurrency:    campaign.GoalCurrency,
+	}
+}
+
+// addDonations sets the trees and donations of a campaign and how much of its tree goal they reach
+func (p *Progress) addDonations(trees, donations int) {
+	p.Trees = trees
+	p.Donations = donations
+	if p.GoalTrees != nil {
+		treesPercent := percent(int64(trees), int64(*p.GoalTrees))
+		p.TreesPercent = &treesPercent
+	}
+}
+
+// addRaised sets the amount raised by a campaign and how much of its money goal it reaches
+func (p *Progress) addRaised(raisedMinor int64, unconverted int) {
+	p.RaisedMinor = raisedMinor
+	p.Unconverted = unconverted
+	if p.GoalAmountMinor != nil {
+		amountPercent := percent(raisedMinor, *p.GoalAmountMinor)
+		p.AmountPercent = &amountPercent
+	}
+}
+
+// Status returns whether a campaign is upcoming, active or ended at a time
+func Status(campaign *models.Campaign, now time.Time) string {
+	switch {
+	case now.Before(campaign.StartsAt):
+		return StatusUpcoming
+	case campaign.IsRunning(now):
+		return StatusActive
+	default:
+		return StatusEnded
+	}
+}
+
+// raised sums the net amounts of payments in a currency. It also returns how many
+// payments could not be converted.
+func raised(payments []models.Payment, rates *fx.Rates, currency models.Currency) (int64, int) {
+	var total int64
+	unconverted := 0
+	for i := range payments {
+		payment := &payments[i]
+		net, err := rates.Convert(payment.AmountMinor-payment.RefundedAmountMinor, payment.Currency, currency, fx.PaymentTime(payment))
+		if err != nil {
+			unconverted++
+			continue
+		}
+		total += net
+	}
+	return total, unconverted
+}
+
+// paymentsPeriod returns when the first and the last of some payments were made
+func paymentsPeriod(payments []models.Payment) (time.Time, time.Time) {
+	from := fx.PaymentTime(&payments[0])
+	to := from
+	for i := range payments[1:] {
+		at := fx.PaymentTime(&payments[i+1])
+		if at.Before(from) {
+			from = at
+		}
+		if at.After(to) {
+			to = at
+		}
+	}
+	return from, to
+}
+
+// percent returns how much of a goal was reached, in percent with one decimal
+func percent(value, goal int64) float64 {
+	if goal <= 0 {
+		return 0
+	}
+	return math.Round(float64(value)*1000/float64(goal)) / 10
+}
+
+// validateInput checks the fields of a campaign
+func validateInput(input *CampaignInput) error {
+	input.Slug = strings.TrimSpace(input.Slug)
+	input.Title = strings.TrimSpace(input.Title)
+	switch {
+	case !slugPattern.MatchString(input.Slug):
+		return fmt.Errorf("%w: slug must be lowercase letters, digits and dashes", ErrInvalidCampaign)
+	case input.Title == "":
+		return fmt.Errorf("%w: title is required", ErrInvalidCampaign)
+	case input.GoalTrees == nil && input.GoalAmountMinor == nil:
+		return fmt.Errorf("%w: a tree or money goal is required", ErrInvalidCampaign)
+	case input.GoalTrees != nil && *input.GoalTrees <= 0:
+		return fmt.Errorf("%w: goal_trees must be positive", ErrInvalidCampaign)
+	case input.GoalAmountMinor != nil && *input.GoalAmountMinor <= 0:
+		return fmt.Errorf("%w: goal_amount_minor must be positive", ErrInvalidCampaign)
+	case input.GoalAmountMinor != nil && input.GoalCurrency == nil:
+		return fmt.Errorf("%w: goal_currency is required with goal_amount_minor", ErrInvalidCampaign)
+	case input.GoalCurrency != nil && !input.GoalCurrency.IsValid():
+		return fmt.Errorf("%w: unsupported goal_currency %q", ErrInvalidCampaign, *input.GoalCurrency)
+	case !input.EndsAt.After(input.StartsAt):
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidCampaign)
+	}
+	return nil
+}
+
+// applyInput copies the fields of an input to a campaign
+func applyInput(campaign *models.Campaign, input *CampaignInput) {
+	campaign.Slug = input.Slug
+	campaign.Title = input.Title
+	campaign.Description = input.Description
+	campaign.GoalTrees = input.GoalTrees
+	campaign.GoalAmountMinor = input.GoalAmountMinor
+	campaign.GoalCurrency = input.GoalCurrency
+	campaign.StartsAt = input.StartsAt
+	campaign.EndsAt = input.EndsAt
+}
+
+// checkSlug makes sure that no other campaign uses a slug
+func checkSlug(tx *gorm.DB, slug string, exceptID *uuid.UUID) error {
+	query := tx.Model(&models.Campaign{}).Where("slug = ?", slug)
+	if exceptID != nil {
+		query = query.Where("id <> ?", *exceptID)
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check slug: %w", err)
+	}
+	if count > 0 {
+		return ErrSlugTaken
+	}
+	return nil
+}
+
+// findProjects loads the projects with some IDs, failing if any of them does not exist
+func findProjects(tx *gorm.DB, ids []uuid.UUID) ([]models.Project, error) {
+	projects := []models.Project{}
+	if len(ids) == 0 {
+		return projects, nil
+	}
+	if err := tx.Where("id IN ?", ids).Find(&projects).Error; err != nil {
+		return nil, fmt.Errorf("failed to find projects: %w", err)
+	}
+	if len(projects) != len(uniqueIDs(ids)) {
+		return nil, ErrUnknownProject
+	}
+	return projects, nil
+}
+
+// uniqueIDs returns IDs without duplicates
+func uniqueIDs(ids []uuid.UUID) map[uuid.UUID]struct{} {
+	unique := make(map[uuid.UUID]struct{}, len(ids))
+	for _, id := range ids {
+		unique[id] = struct{}{}
+	}
+	return unique
+}
@@ -0,0 +1,165 @@
+package campaigns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/fx"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	earthDayStart = time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)
+	earthDayEnd   = time.Date(2027, 4, 23, 0, 0, 0, 0, time.UTC)
+)
+
+func TestValidateInput(t *testing.T) {
+	goalTrees := 1000000
+	zero := 0
+	amount := int64(5000000)
+	negative := int64(-1)
+	eur := models.CurrencyEUR
+	gbp := models.Currency("GBP")
+
+	tests := []struct {
+		name            string
+		slug            string
+		title           string
+		goalTrees       *int
+		goalAmountMinor *int64
+		goalCurrency    *models.Currency
+		endsAt          time.Time
+		wantErr         bool
+	}{
+		{"valid tree goal", "earth-day-2027", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayEnd, false},
+		{"valid money goal", "earth-day-2027", "1 million trees by Earth Day", nil, &amount, &eur, earthDayEnd, false},
+		{"both goals", "earth-day-2027", "1 million trees by Earth Day", &goalTrees, &amount, &eur, earthDayEnd, false},
+		{"slug with spaces", "earth day", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayEnd, true},
+		{"upper case slug", "Earth-Day", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayEnd, true},
+		{"trailing dash", "earth-", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayEnd, true},
+		{"blank title", "earth-day-2027", "  ", &goalTrees, nil, nil, earthDayEnd, true},
+		{"no goal", "earth-day-2027", "1 million trees by Earth Day", nil, nil, nil, earthDayEnd, true},
+		{"zero trees", "earth-day-2027", "1 million trees by Earth Day", &zero, nil, nil, earthDayEnd, true},
+		{"negative amount", "earth-day-2027", "1 million trees by Earth Day", nil, &negative, &eur, earthDayEnd, true},
+		{"amount without currency", "earth-day-2027", "1 million trees by Earth Day", nil, &amount, nil, earthDayEnd, true},
+		{"unsupported currency", "earth-day-2027", "1 million trees by Earth Day", nil, &amount, &gbp, earthDayEnd, true},
+		{"ends before start", "earth-day-2027", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayStart.Add(-time.Hour), true},
+		{"ends at start", "earth-day-2027", "1 million trees by Earth Day", &goalTrees, nil, nil, earthDayStart, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInput(&CampaignInput{
+				Slug:            tt.slug,
+				Title:           tt.title,
+				GoalTrees:       tt.goalTrees,
+				GoalAmountMinor: tt.goalAmountMinor,
+				GoalCurrency:    tt.goalCurrency,
+				StartsAt:        earthDayStart,
+				EndsAt:          tt.endsAt,
+			})
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidCampaign)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	campaign := &models.Campaign{StartsAt: earthDayStart, EndsAt: earthDayEnd}
+
+	assert.Equal(t, StatusUpcoming, Status(campaign, earthDayStart.Add(-time.Second)))
+	assert.Equal(t, StatusActive, Status(campaign, earthDayStart))
+	assert.Equal(t, StatusActive, Status(campaign, earthDayEnd.Add(-time.Second)))
+	assert.Equal(t, StatusEnded, Status(campaign, earthDayEnd))
+}
+
+func TestPercent(t *testing.T) {
+	assert.Equal(t, 0.0, percent(0, 1000))
+	assert.Equal(t, 12.3, percent(123, 1000))
+	assert.Equal(t, 33.3, percent(1, 3))
+	assert.Equal(t, 150.0, percent(1500, 1000))
+	assert.Equal(t, 0.0, percent(10, 0))
+}
+
+func TestRaised(t *testing.T) {
+	march := time.Date(2027, 3, 10, 12, 0, 0, 0, time.UTC)
+	rates := fx.NewRates([]models.FXRate{
+		{Date: time.Date(2027, 3, 10, 0, 0, 0, 0, time.UTC), BaseCurrency: models.CurrencyEUR, Currency: models.CurrencyUSD, Rate: 1.25},
+	})
+	payments := []models.Payment{
+		{AmountMinor: 10000, Currency: models.CurrencyEUR, OccurredAt: &march},
+		{AmountMinor: 12500, Currency: models.CurrencyUSD, OccurredAt: &march},
+		{AmountMinor: 12500, RefundedAmountMinor: 2500, Currency: models.CurrencyUSD, OccurredAt: &march},
+		{AmountMinor: 190000, Currency: models.CurrencyRUB, OccurredAt: &march},
+	}
+
+	total, unconverted := raised(payments, rates, models.CurrencyEUR)
+	assert.Equal(t, int64(28000), total)
+	assert.Equal(t, 1, unconverted)
+}
+
+func TestPaymentsPeriod(t *testing.T) {
+	first := time.Date(2027, 3, 2, 0, 0, 0, 0, time.UTC)
+	last := time.Date(2027, 4, 20, 0, 0, 0, 0, time.UTC)
+	payments := []models.Payment{
+		{CreatedAt: first.Add(24 * time.Hour)},
+		{OccurredAt: &last},
+		{CreatedAt: first},
+	}
+
+	from, to := paymentsPeriod(payments)
+	assert.Equal(t, first, from)
+	assert.Equal(t, last, to)
+}
+
+func TestProgress(t *testing.T) {
+	goalTrees := 1000000
+	goalAmount := int64(5000000)
+	eur := models.CurrencyEUR
+	now := earthDayStart.Add(24 * time.Hour)
+
+	t.Run("tree goal", func(t *testing.T) {
+		campaign := &models.Campaign{StartsAt: earthDayStart, EndsAt: earthDayEnd, GoalTrees: &goalTrees}
+		progress := newProgress(campaign, now)
+		progress.addDonations(250000, 1200)
+
+		assert.Equal(t, StatusActive, progress.Status)
+		assert.Equal(t, 250000, progress.Trees)
+		assert.Equal(t, 1200, progress.Donations)
+		if assert.NotNil(t, progress.TreesPercent) {
+			assert.Equal(t, 25.0, *progress.TreesPercent)
+		}
+		assert.Nil(t, progress.AmountPercent)
+	})
+
+	t.Run("money goal", func(t *testing.T) {
+		campaign := &models.Campaign{StartsAt: earthDayStart, EndsAt: earthDayEnd, GoalAmountMinor: &goalAmount, GoalCurrency: &eur}
+		progress := newProgress(campaign, now)
+		progress.addDonations(300, 12)
+		progress.addRaised(6000000, 2)
+
+		assert.Nil(t, progress.TreesPercent)
+		assert.Equal(t, int64(6000000), progress.RaisedMinor)
+		assert.Equal(t, 2, progress.Unconverted)
+		if assert.NotNil(t, progress.AmountPercent) {
+			assert.Equal(t, 120.0, *progress.AmountPercent)
+		}
+	})
+
+	t.Run("no donations yet", func(t *testing.T) {
+		campaign := &models.Campaign{StartsAt: earthDayStart, EndsAt: earthDayEnd, GoalTrees: &goalTrees, GoalAmountMinor: &goalAmount, GoalCurrency: &eur}
+		progress := newProgress(campaign, earthDayStart.Add(-time.Hour))
+		progress.addDonations(0, 0)
+		progress.addRaised(0, 0)
+
+		assert.Equal(t, StatusUpcoming, progress.Status)
+		if assert.NotNil(t, progress.TreesPercent) && assert.NotNil(t, progress.AmountPercent) {
+			assert.Equal(t, 0.0, *progress.TreesPercent)
+			assert.Equal(t, 0.0, *progress.AmountPercent)
+		}
+	})
+}
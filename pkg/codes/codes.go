@@ -0,0 +1,346 @@
+package codes
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/achievements"
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidCodes is returned for code requests with missing or inconsistent fields
+	ErrInvalidCodes = errors.New("invalid codes")
+	// ErrCodeTaken is returned when a custom code already exists
+	ErrCodeTaken = errors.New("code is already taken")
+	// ErrUnknownProject is returned for codes of a project that does not exist
+	ErrUnknownProject = errors.New("unknown project")
+	// ErrNotFound is returned for codes that do not exist
+	ErrNotFound = errors.New("code not found")
+	// ErrExpired is returned for codes redeemed after they expired
+	ErrExpired = errors.New("code has expired")
+	// ErrUsedUp is returned for codes redeemed as often as they may be
+	ErrUsedUp = errors.New("code has been used up")
+	// ErrAlreadyRedeemed is returned when a user redeems a code a second time
+	ErrAlreadyRedeemed = errors.New("code already redeemed")
+)
+
+// MaxBatchSize limits how many codes one request generates
+const MaxBatchSize = 10000
+
+// codeAlphabet leaves out letters and digits that are easily confused, such as O and 0
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Generated codes are groups of random characters joined by dashes, e.g. 7KQM-XW2P-HC9D
+const (
+	codeGroups      = 3
+	codeGroupLength = 4
+)
+
+// codePattern is the format of custom codes and prefixes, e.g. EARTHDAY-2027
+var codePattern = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]+)*$`)
+
+// GenerateInput is what admins set when generating codes
+type GenerateInput struct {
+	// Code is a custom code, e.g. for a promo; random codes are generated when it is empty
+	Code string
+	// Prefix starts every random code, e.g. SHOP-7KQM-XW2P-HC9D
+	Prefix string
+	// Count is how many random codes to generate
+	Count          int
+	Batch          *string
+	TreesCount     int
+	MaxRedemptions int
+	ProjectID      *uuid.UUID
+	ExpiresAt      *time.Time
+}
+
+// Redemption is the donation a user made by redeeming a code
+type Redemption struct {
+	Code       string     `json:"code"`
+	DonationID uuid.UUID  `json:"donation_id"`
+	TreesCount int        `json:"trees_count"`
+	ProjectID  *uuid.UUID `json:"project_id"`
+	RedeemedAt time.Time  `json:"redeemed_at"`
+}
+
+// Service generates prepaid tree certificates and promo codes and turns redeemed codes
+// into donations
+type Service struct {
+	db           *gorm.DB
+	achievements *achievements.Service
+}
+
+// NewService creates a new codes service
+func NewService(achievementsService *achievements.Service) *Service {
+	return &Service{
+		db:           database.GetDB(),
+		achievements: achievementsService,
+	}
+}
+
+// ListCodes returns the codes of a batch, or every code when batch is empty, latest first
+func (s *Service) ListCodes(batch string, limit, offset int) ([]models.RedemptionCode, int, error) {
+	query := s.db.Model(&models.RedemptionCode{})
+	if batch != "" {
+		query = query.Where("batch = ?", batch)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count codes: %w", err)
+	}
+
+	var codes []models.RedemptionCode
+	if err := query.Order("created_at DESC, code ASC").Limit(limit).Offset(offset).Find(&codes).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch codes: %w", err)
+	}
+	return codes, int(total), nil
+}
+
+// ExportCodes returns every code of a batch, or every code when batch is empty, in the
+// order they were generated
+func (s *Service) ExportCodes(batch string) ([]models.RedemptionCode, error) {
+	query := s.db.Order("created_at ASC, code ASC")
+	if batch != "" {
+		query = query.Where("batch = ?", batch)
+	}
+	var codes []models.RedemptionCode
+	if err := query.Find(&codes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch codes: %w", err)
+	}
+	return codes, nil
+}
+
+// Generate creates a custom code or a number of random codes with the same tree value,
+// project, use limit and expiry
+func (s *Service) Generate(input *GenerateInput) ([]models.RedemptionCode, error) {
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+
+	values := []string{input.Code}
+	if input.Code == "" {
+		var err error
+		if values, err = randomCodes(input.Prefix, input.Count); err != nil {
+			return nil, err
+		}
+	}
+
+	codes := make([]models.RedemptionCode, 0, len(values))
+	for _, value := range values {
+		codes = append(codes, models.RedemptionCode{
+			ID:             uuid.New(),
+			Code:           value,
+			Batch:          input.Batch,
+			TreesCount:     input.TreesCount,
+			ProjectID:      input.ProjectID,
+			MaxRedemptions: input.MaxRedemptions,
+			ExpiresAt:      input.ExpiresAt,
+		})
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if input.ProjectID != nil {
+			var count int64
+			if err := tx.Model(&models.Project{}).Where("id = ?", *input.ProjectID).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to check project: %w", err)
+			}
+			if count == 0 {
+				return ErrUnknownProject
+			}
+		}
+
+		var taken int64
+		if err := tx.Model(&models.RedemptionCode{}).Where("code IN ?", values).Count(&taken).Error; err != nil {
+			return fmt.Errorf("failed to check codes: %w", err)
+		}
+		if taken > 0 {
+			return ErrCodeTaken
+		}
+
+		if err := tx.CreateInBatches(codes, 500).Error; err != nil {
+			return fmt.Errorf("failed to create codes: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Redeem creates a donation of a code's trees for a user. The code is matched regardless
+// of case and surrounding spaces.
+func (s *Service) Redeem(authUserID, code string, now time.Time) (*Redemption, error) {
+	var redemption *Redemption
+	var totalTrees int
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var redemptionCode models.RedemptionCode
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code = ?", NormalizeCode(code)).
+			First(&redemptionCode).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find code: %w", err)
+		}
+		if err := checkRedeemable(tx, &redemptionCode, authUserID, now); err != nil {
+			return err
+		}
+
+		donation := &models.Donation{
+			ID:               uuid.New(),
+			AuthUserID:       authUserID,
+			ProjectID:        redemptionCode.ProjectID,
+			RedemptionCodeID: &redemptionCode.ID,
+			TreesCount:       redemptionCode.TreesCount,
+		}
+		if err := tx.Create(donation).Error; err != nil {
+			return fmt.Errorf("failed to create donation: %w", err)
+		}
+		if err := allocations.Allocate(tx, donation); err != nil {
+			return err
+		}
+		if err := tx.Model(&redemptionCode).Update("redemptions_count", gorm.Expr("redemptions_count + 1")).Error; err != nil {
+			return fmt.Errorf("failed to update code: %w", err)
+		}
+
+		updates := map[string]interface{}{
+			"total_trees":      gorm.Expr("total_trees + ?", donation.TreesCount),
+			"donations_count":  gorm.Expr("donations_count + 1"),
+			"last_donation_at": now,
+		}
+		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", authUserID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update user counters: %w", err)
+		}
+		var user models.User
+		if err := tx.Where("auth_user_id = ?", authUserID).First(&user).Error; err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+		totalTrees = user.TotalTrees
+
+		redemption = &Redemption{
+			Code:       redemptionCode.Code,
+			DonationID: donation.ID,
+			TreesCount: donation.TreesCount,
+			ProjectID:  donation.ProjectID,
+			RedeemedAt: donation.CreatedAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.achievements.CheckAndAwardTreeBasedAchievements(authUserID, totalTrees); err != nil {
+		logrus.WithField("auth_user_id", authUserID).Errorf("Failed to award achievements: %v", err)
+	}
+	return redemption, nil
+}
+
+// checkRedeemable makes sure that a user can still redeem a code
+func checkRedeemable(tx *gorm.DB, code *models.RedemptionCode, authUserID string, now time.Time) error {
+	if code.ExpiresAt != nil && !now.Before(*code.ExpiresAt) {
+		return ErrExpired
+	}
+
+	var redeemed int64
+	err := tx.Model(&models.Donation{}).
+		Where("redemption_code_id = ? AND auth_user_id = ?", code.ID, authUserID).
+		Count(&redeemed).Error
+	if err != nil {
+		return fmt.Errorf("failed to check redemptions: %w", err)
+	}
+	if redeemed > 0 {
+		return ErrAlreadyRedeemed
+	}
+
+	if code.RedemptionsCount >= code.MaxRedemptions {
+		return ErrUsedUp
+	}
+	return nil
+}
+
+// NormalizeCode returns a code the way it is stored
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validateInput checks the fields of a code request and normalizes the custom code and
+// prefix
+func validateInput(input *GenerateInput) error {
+	input.Code = NormalizeCode(input.Code)
+	input.Prefix = NormalizeCode(input.Prefix)
+	if input.MaxRedemptions == 0 {
+		input.MaxRedemptions = 1
+	}
+
+	switch {
+	case input.Code != "" && (len(input.Code) < 4 || len(input.Code) > 64 || !codePattern.MatchString(input.Code)):
+		return fmt.Errorf("%w: code must be 4 to 64 letters, digits and dashes", ErrInvalidCodes)
+	case input.Code != "" && (input.Prefix != "" || input.Count > 1):
+		return fmt.Errorf("%w: a custom code cannot have a prefix or count", ErrInvalidCodes)
+	case input.Code == "" && (input.Count < 1 || input.Count > MaxBatchSize):
+		return fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidCodes, MaxBatchSize)
+	case input.Prefix != "" && (len(input.Prefix) > 16 || !codePattern.MatchString(input.Prefix)):
+		return fmt.Errorf("%w: prefix must be up to 16 letters, digits and dashes", ErrInvalidCodes)
+	case input.TreesCount <= 0:
+		return fmt.Errorf("%w: trees_count must be positive", ErrInvalidCodes)
+	case input.MaxRedemptions < 0:
+		return fmt.Errorf("%w: max_redemptions must be positive", ErrInvalidCodes)
+	case input.Batch != nil && strings.TrimSpace(*input.Batch) == "":
+		return fmt.Errorf("%w: batch must not be blank", ErrInvalidCodes)
+	}
+	return nil
+}
+
+// randomCodes generates distinct random codes, each starting with the prefix if any
+func randomCodes(prefix string, count int) ([]string, error) {
+	seen := make(map[string]struct{}, count)
+	codes := make([]string, 0, count)
+	for len(codes) < count {
+		code, err := randomCode()
+		if err != nil {
+			return nil, err
+		}
+		if prefix != "" {
+			code = prefix + "-" + code
+		}
+		if _, ok := seen[code]; ok {
+			continue
+		}
+		seen[code] = struct{}{}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// randomCode returns groups of random characters of codeAlphabet joined by dashes
+func randomCode() (string, error) {
+	var b strings.Builder
+	limit := big.NewInt(int64(len(codeAlphabet)))
+	for i := 0; i < codeGroups*codeGroupLength; i++ {
+		if i > 0 && i%codeGroupLength == 0 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, limit)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate code: %w", err)
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,93 @@
+package codes
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateInput(t *testing.T) {
+	blank := " "
+
+	tests := []struct {
+		name    string
+		input   GenerateInput
+		wantErr bool
+	}{
+		{"random codes", GenerateInput{Count: 500, TreesCount: 1}, false},
+		{"random codes with prefix", GenerateInput{Prefix: "shop", Count: 10, TreesCount: 5}, false},
+		{"custom code", GenerateInput{Code: "earthday-2027", TreesCount: 1, MaxRedemptions: 1000}, false},
+		{"custom code with count of one", GenerateInput{Code: "EARTHDAY", Count: 1, TreesCount: 1}, false},
+		{"no count", GenerateInput{TreesCount: 1}, true},
+		{"too many codes", GenerateInput{Count: MaxBatchSize + 1, TreesCount: 1}, true},
+		{"no trees", GenerateInput{Count: 1}, true},
+		{"negative redemptions", GenerateInput{Count: 1, TreesCount: 1, MaxRedemptions: -1}, true},
+		{"short custom code", GenerateInput{Code: "ABC", TreesCount: 1}, true},
+		{"custom code with spaces", GenerateInput{Code: "EARTH DAY", TreesCount: 1}, true},
+		{"custom code with count", GenerateInput{Code: "EARTHDAY", Count: 5, TreesCount: 1}, true},
+		{"custom code with prefix", GenerateInput{Code: "EARTHDAY", Prefix: "SHOP", TreesCount: 1}, true},
+		{"invalid prefix", GenerateInput{Prefix: "SHOP_", Count: 1, TreesCount: 1}, true},
+		{"blank batch", GenerateInput{Count: 1, TreesCount: 1, Batch: &blank}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInput(&tt.input)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalidCodes))
+			} else {
+				assert.NoError(t, err)
+				assert.GreaterOrEqual(t, tt.input.MaxRedemptions, 1)
+			}
+		})
+	}
+}
+
+func TestValidateInput_Normalizes(t *testing.T) {
+	input := GenerateInput{Code: " earthday-2027 ", TreesCount: 1}
+	assert.NoError(t, validateInput(&input))
+	assert.Equal(t, "EARTHDAY-2027", input.Code)
+	assert.Equal(t, 1, input.MaxRedemptions)
+}
+
+func TestRandomCodes(t *testing.T) {
+	pattern := regexp.MustCompile(`^SHOP-[` + codeAlphabet + `]{4}-[` + codeAlphabet + `]{4}-[` + codeAlphabet + `]{4}$`)
+
+	codes, err := randomCodes("SHOP", 1000)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 1000)
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		assert.Regexp(t, pattern, code)
+		assert.False(t, seen[code], "duplicate code %s", code)
+		seen[code] = true
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	assert.Equal(t, "7KQM-XW2P-HC9D", NormalizeCode("  7kqm-xw2p-hc9d\n"))
+}
+
+func TestWriteCSV(t *testing.T) {
+	batch := "shop-2027-10"
+	projectID := uuid.MustParse("8f14e45f-ceea-467a-9575-0a8e1a0b7c3d")
+	expiresAt := time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2027, 10, 1, 9, 30, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, []models.RedemptionCode{
+		{Code: "SHOP-7KQM-XW2P-HC9D", Batch: &batch, TreesCount: 5, ProjectID: &projectID, MaxRedemptions: 1, ExpiresAt: &expiresAt, CreatedAt: createdAt},
+		{Code: "EARTHDAY", TreesCount: 1, MaxRedemptions: 1000, RedemptionsCount: 42, CreatedAt: createdAt},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "code,batch,trees_count,project_id,max_redemptions,redemptions_count,expires_at,created_at\n"+
+		"SHOP-7KQM-XW2P-HC9D,shop-2027-10,5,8f14e45f-ceea-467a-9575-0a8e1a0b7c3d,1,0,2028-01-01T00:00:00Z,2027-10-01T09:30:00Z\n"+
+		"EARTHDAY,,1,,1000,42,,2027-10-01T09:30:00Z\n", buf.String())
+}
@@ -0,0 +1,48 @@
+package codes
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+)
+
+// csvHeader names the columns of exported codes
+var csvHeader = []string{"code", "batch", "trees_count", "project_id", "max_redemptions", "redemptions_count", "expires_at", "created_at"}
+
+// WriteCSV writes codes as CSV with a header row, e.g. for printing certificates. Empty
+// columns are codes without a batch, project or expiry.
+func WriteCSV(w io.Writer, codes []models.RedemptionCode) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		record := []string{
+			code.Code,
+			"",
+			strconv.Itoa(code.TreesCount),
+			"",
+			strconv.Itoa(code.MaxRedemptions),
+			strconv.Itoa(code.RedemptionsCount),
+			"",
+			code.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if code.Batch != nil {
+			record[1] = *code.Batch
+		}
+		if code.ProjectID != nil {
+			record[3] = code.ProjectID.String()
+		}
+		if code.ExpiresAt != nil {
+			record[6] = code.ExpiresAt.UTC().Format(time.RFC3339)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,276 @@
+package dedications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/achievements"
+	"github.com/4planet/backend/pkg/mailer"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrNotFound is returned for unknown certificate tokens
+	ErrNotFound = errors.New("dedication not found")
+	// ErrAlreadyClaimed is returned when someone else already claimed the trees of a gift
+	ErrAlreadyClaimed = errors.New("dedication already claimed")
+	// ErrOwnGift is returned when donors try to claim their own gift
+	ErrOwnGift = errors.New("donors cannot claim their own gift")
+)
+
+// anonymousDonor names donors without a public name in certificates
+const anonymousDonor = "A friend"
+
+// notifyBatchSize limits how many certificate emails one run of the worker sends
+const notifyBatchSize = 100
+
+// Config controls certificate links and emails
+type Config struct {
+	// BaseURL is the address of the site certificate links point to
+	BaseURL string
+	// CheckInterval is how often the worker looks for certificates to email
+	CheckInterval time.Duration
+}
+
+// Certificate is the public view of a gift donation
+type Certificate struct {
+	Token         string     `json:"token"`
+	URL           string     `json:"url"`
+	RecipientName string     `json:"recipient_name"`
+	DonorName     string     `json:"donor_name"`
+	Message       *string    `json:"message,omitempty"`
+	TreesCount    int        `json:"trees_count"`
+	ProjectID     *uuid.UUID `json:"project_id,omitempty"`
+	ProjectTitle  *string    `json:"project_title,omitempty"`
+	DonatedAt     time.Time  `json:"donated_at"`
+	Claimed       bool       `json:"claimed"`
+	ClaimedAt     *time.Time `json:"claimed_at,omitempty"`
+}
+
+// Service emails tree certificates to the recipients of gift donations and moves the
+// trees of claimed gifts onto the recipient's profile
+type Service struct {
+	db           *gorm.DB
+	mailer       mailer.Mailer
+	achievements *achievements.Service
+	cfg          Config
+}
+
+// NewService creates a new dedications service
+func NewService(mailer mailer.Mailer, achievementsService *achievements.Service, cfg Config) *Service {
+	return &Service{
+		db:           database.GetDB(),
+		mailer:       mailer,
+		achievements: achievementsService,
+		cfg:          cfg,
+	}
+}
+
+// CertificateURL returns the link to the certificate with a token
+func (s *Service) CertificateURL(token string) string {
+	return s.cfg.BaseURL + "/certificates/" + token
+}
+
+// Run emails pending certificates every check interval until the context is canceled. A
+// check interval of zero disables certificate emails.
+func (s *Service) Run(ctx context.Context) {
+	if s.cfg.CheckInterval <= 0 {
+		logrus.Warn("Gift certificate emails are disabled")
+		return
+	}
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if sent, err := s.NotifyPending(time.Now()); err != nil {
+			logrus.Errorf("Failed to send gift certificates: %v", err)
+		} else if sent > 0 {
+			logrus.Infof("Sent %d gift certificates", sent)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// NotifyPending emails certificates that were not sent yet. It returns how many were sent.
+// A certificate whose email fails is retried on the next run.
+func (s *Service) NotifyPending(now time.Time) (int, error) {
+	var pending []models.Dedication
+	err := s.db.Preload("Donation").
+		Where("notified_at IS NULL").
+		Order("created_at ASC").
+		Limit(notifyBatchSize).
+		Find(&pending).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find pending dedications: %w", err)
+	}
+
+	sent := 0
+	for i := range pending {
+		dedication := &pending[i]
+		certificate, err := s.certificate(dedication)
+		if err != nil {
+			logrus.WithField("dedication_id", dedication.ID).Errorf("Failed to build gift certificate: %v", err)
+			continue
+		}
+		gift := mailer.GiftCertificate{
+			RecipientName: certificate.RecipientName,
+			DonorName:     certificate.DonorName,
+			Trees:         certificate.TreesCount,
+			URL:           certificate.URL,
+		}
+		if certificate.Message != nil {
+			gift.Message = *certificate.Message
+		}
+		if err := s.mailer.SendGiftCertificateEmail(dedication.RecipientEmail, gift); err != nil {
+			logrus.WithField("dedication_id", dedication.ID).Errorf("Failed to send gift certificate: %v", err)
+			continue
+		}
+		if err := s.db.Model(dedication).Update("notified_at", now).Error; err != nil {
+			return sent, fmt.Errorf("failed to update dedication: %w", err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// GetCertificate returns the certificate with a token
+func (s *Service) GetCertificate(token string) (*Certificate, error) {
+	var dedication models.Dedication
+	err := s.db.Preload("Donation").Where("certificate_token = ?", token).First(&dedication).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.certificate(&dedication)
+}
+
+// Claim moves the trees of a gift from the donor's profile onto the profile of the user
+// claiming the certificate. Claiming a certificate again with the same user does nothing.
+func (s *Service) Claim(authUserID, token string) (*Certificate, error) {
+	var totalTrees int
+	claimed := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var dedication models.Dedication
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("certificate_token = ?", token).
+			First(&dedication).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find dedication: %w", err)
+		}
+		if dedication.ClaimedByAuthUserID != nil {
+			if *dedication.ClaimedByAuthUserID == authUserID {
+				return nil
+			}
+			return ErrAlreadyClaimed
+		}
+
+		var donation models.Donation
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", dedication.DonationID).First(&donation).Error; err != nil {
+			return fmt.Errorf("failed to find donation: %w", err)
+		}
+		if donation.AuthUserID == authUserID {
+			return ErrOwnGift
+		}
+
+		now := time.Now()
+		if err := tx.Model(&dedication).Updates(map[string]interface{}{
+			"claimed_by_auth_user_id": authUserID,
+			"claimed_at":              now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to claim dedication: %w", err)
+		}
+
+		// The donor keeps the donation itself, only the trees move
+		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", donation.AuthUserID).
+			Update("total_trees", gorm.Expr("GREATEST(total_trees - ?, 0)", donation.TreesCount)).Error; err != nil {
+			return fmt.Errorf("failed to update donor counters: %w", err)
+		}
+		var donor models.User
+		if err := tx.Where("auth_user_id = ?", donation.AuthUserID).First(&donor).Error; err != nil {
+			return fmt.Errorf("failed to find donor: %w", err)
+		}
+		if err := achievements.RevokeTreeBasedAchievements(tx, donor.AuthUserID, donor.TotalTrees); err != nil {
+			return fmt.Errorf("failed to revoke achievements: %w", err)
+		}
+
+		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", authUserID).
+			Update("total_trees", gorm.Expr("total_trees + ?", donation.TreesCount)).Error; err != nil {
+			return fmt.Errorf("failed to update recipient counters: %w", err)
+		}
+		var recipient models.User
+		if err := tx.Where("auth_user_id = ?", authUserID).First(&recipient).Error; err != nil {
+			return fmt.Errorf("failed to find recipient: %w", err)
+		}
+		totalTrees = recipient.TotalTrees
+		claimed = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claimed {
+		if err := s.achievements.CheckAndAwardTreeBasedAchievements(authUserID, totalTrees); err != nil {
+			logrus.WithField("auth_user_id", authUserID).Errorf("Failed to award achievements: %v", err)
+		}
+	}
+	return s.GetCertificate(token)
+}
+
+// certificate builds the public view of a dedication loaded with its donation
+func (s *Service) certificate(dedication *models.Dedication) (*Certificate, error) {
+	certificate := &Certificate{
+		Token:         dedication.CertificateToken,
+		URL:           s.CertificateURL(dedication.CertificateToken),
+		RecipientName: dedication.RecipientName,
+		DonorName:     anonymousDonor,
+		Message:       dedication.Message,
+		TreesCount:    dedication.Donation.TreesCount,
+		ProjectID:     dedication.Donation.ProjectID,
+		DonatedAt:     dedication.Donation.CreatedAt,
+		Claimed:       dedication.ClaimedByAuthUserID != nil,
+		ClaimedAt:     dedication.ClaimedAt,
+	}
+
+	var donor models.User
+	if err := s.db.Where("auth_user_id = ?", dedication.Donation.AuthUserID).First(&donor).Error; err != nil {
+		return nil, fmt.Errorf("failed to find donor: %w", err)
+	}
+	certificate.DonorName = donorName(&donor)
+
+	if dedication.Donation.ProjectID != nil {
+		var project models.Project
+		if err := s.db.Select("id", "title").Where("id = ?", *dedication.Donation.ProjectID).First(&project).Error; err == nil {
+			certificate.ProjectTitle = &project.Title
+		}
+	}
+	return certificate, nil
+}
+
+// donorName returns the public name of a donor; donors without one stay anonymous
+func donorName(user *models.User) string {
+	if user.DisplayName != nil && *user.DisplayName != "" {
+		return *user.DisplayName
+	}
+	if user.Username != nil && *user.Username != "" {
+		return *user.Username
+	}
+	return anonymousDonor
+}
@@ -0,0 +1,40 @@
+package dedications
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewService(t *testing.T) {
+	service := NewService(nil, nil, Config{BaseURL: "https://4planet.example"})
+	assert.NotNil(t, service)
+}
+
+func TestService_CertificateURL(t *testing.T) {
+	service := NewService(nil, nil, Config{BaseURL: "https://4planet.example"})
+	assert.Equal(t, "https://4planet.example/certificates/abc123", service.CertificateURL("abc123"))
+}
+
+func TestDonorName(t *testing.T) {
+	displayName := "Anna K."
+	username := "anna"
+	empty := ""
+
+	tests := []struct {
+		name string
+		user models.User
+		want string
+	}{
+		{"display name", models.User{DisplayName: &displayName, Username: &username}, displayName},
+		{"username", models.User{DisplayName: &empty, Username: &username}, username},
+		{"anonymous", models.User{Email: "anna@example.com"}, anonymousDonor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, donorName(&tt.user))
+		})
+	}
+}
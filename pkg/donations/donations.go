@@ -31,6 +31,8 @@ func (s *Service) GetUserDonations(authUserID string, limit int, offset int) ([]
 	err = s.db.Where("auth_user_id = ?", authUserID).
 		Preload("Payment").
 		Preload("Project").
+		Preload("Dedication").
+		Preload("Matches").
 		Order("created_at DESC").
 		Omit("User").
 		Limit(limit).
@@ -0,0 +1,178 @@
+package dunning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/mailer"
+	"github.com/4planet/backend/pkg/payments"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// cancelReason is stored in the audit of subscriptions canceled by dunning
+const cancelReason = "Canceled after repeated failed charges"
+
+// Config controls how past due subscriptions are chased
+type Config struct {
+	// RetrySchedule lists when to retry the charge, measured from the first failed charge
+	RetrySchedule []time.Duration
+	// MaxFailures cancels the subscription once this many charges failed in a row
+	MaxFailures int
+	// GracePeriod is how long the last retry has to succeed before the subscription is canceled
+	GracePeriod time.Duration
+	// CheckInterval is how often the worker looks for due subscriptions
+	CheckInterval time.Duration
+}
+
+// Action is what a dunning step does with a past due subscription
+type Action int
+
+const (
+	// ActionRemind only emails the donor; the provider has just declined the charge
+	ActionRemind Action = iota
+	// ActionRetry charges the subscription again and emails the donor
+	ActionRetry
+	// ActionCancel gives up and cancels the subscription
+	ActionCancel
+)
+
+// Service retries failed recurring charges, reminds donors to update their card and
+// cancels subscriptions that stay unpaid
+type Service struct {
+	db             *gorm.DB
+	paymentService *payments.Service
+	mailer         mailer.Mailer
+	cfg            Config
+}
+
+// NewService creates a new dunning service
+func NewService(paymentService *payments.Service, mailer mailer.Mailer, cfg Config) *Service {
+	return &Service{
+		db:             database.GetDB(),
+		paymentService: paymentService,
+		mailer:         mailer,
+		cfg:            cfg,
+	}
+}
+
+// Run processes due subscriptions every check interval until the context is canceled.
+// A check interval of zero disables dunning.
+func (s *Service) Run(ctx context.Context) {
+	if s.cfg.CheckInterval <= 0 {
+		logrus.Warn("Dunning is disabled; past due subscriptions are not retried")
+		return
+	}
+	ticker := time.NewTicker(s.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if processed, err := s.ProcessDue(time.Now()); err != nil {
+			logrus.Errorf("Failed to process past due subscriptions: %v", err)
+		} else if processed > 0 {
+			logrus.Infof("Processed %d past due subscriptions", processed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue takes the next dunning step of every past due subscription that is due. It
+// returns how many subscriptions were processed.
+func (s *Service) ProcessDue(now time.Time) (int, error) {
+	subscriptions, err := s.paymentService.ClaimDueDunningSubscriptions(now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find past due subscriptions: %w", err)
+	}
+
+	processed := 0
+	for i := range subscriptions {
+		if err := s.process(&subscriptions[i], now); err != nil {
+			logrus.WithField("subscription_id", subscriptions[i].ID).Errorf("Failed to process past due subscription: %v", err)
+			continue
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// process takes the next dunning step of one subscription
+func (s *Service) process(subscription *models.Subscription, now time.Time) error {
+	action, next := NextStep(s.cfg, subscription, now)
+
+	if action == ActionCancel {
+		err := s.paymentService.CancelUnpaidSubscription(subscription, cancelReason)
+		if errors.Is(err, payments.ErrSubscriptionTransition) {
+			// The subscription was paid or changed since it was claimed
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to cancel subscription: %w", err)
+		}
+		s.notify(subscription, s.mailer.SendSubscriptionCanceledEmail)
+		return nil
+	}
+
+	if action == ActionRetry {
+		// The outcome of the retry arrives by webhook
+		err := s.paymentService.RetrySubscriptionCharge(subscription)
+		if err != nil && !errors.Is(err, payments.ErrSubscriptionChangeNotSupported) {
+			logrus.WithField("subscription_id", subscription.ID).Warnf("Failed to retry subscription charge: %v", err)
+		}
+	}
+
+	if err := s.paymentService.ScheduleDunning(subscription, subscription.DunningStep+1, next); err != nil {
+		return err
+	}
+	s.notify(subscription, s.mailer.SendPaymentFailedEmail)
+	return nil
+}
+
+// notify emails the donor of a subscription. A failed email does not hold up dunning.
+func (s *Service) notify(subscription *models.Subscription, send func(to, subscriptionID string) error) {
+	var userAuth models.UserAuth
+	if err := s.db.Where("auth_user_id = ?", subscription.AuthUserID).First(&userAuth).Error; err != nil {
+		logrus.WithField("subscription_id", subscription.ID).Errorf("Failed to find donor email: %v", err)
+		return
+	}
+	if err := send(userAuth.Email, subscription.ID.String()); err != nil {
+		logrus.WithField("subscription_id", subscription.ID).Errorf("Failed to send dunning email: %v", err)
+	}
+}
+
+// NextStep returns the action of the next dunning step of a past due subscription and when
+// the step after it is due. The first step only reminds the donor; each later step retries
+// the charge at its point in the retry schedule. The subscription is canceled once the
+// grace period after the last retry ran out or too many charges failed.
+func NextStep(cfg Config, subscription *models.Subscription, now time.Time) (Action, time.Time) {
+	if cfg.MaxFailures > 0 && subscription.FailedCharges >= cfg.MaxFailures {
+		return ActionCancel, time.Time{}
+	}
+
+	step := subscription.DunningStep
+	if step > len(cfg.RetrySchedule) {
+		return ActionCancel, time.Time{}
+	}
+
+	action := ActionRetry
+	if step == 0 {
+		action = ActionRemind
+	}
+
+	since := now
+	if subscription.PastDueSince != nil {
+		since = *subscription.PastDueSince
+	}
+	if step < len(cfg.RetrySchedule) {
+		return action, since.Add(cfg.RetrySchedule[step])
+	}
+	return action, now.Add(cfg.GracePeriod)
+}
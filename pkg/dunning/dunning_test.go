@@ -0,0 +1,51 @@
+package dunning
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextStep(t *testing.T) {
+	cfg := Config{
+		RetrySchedule: []time.Duration{24 * time.Hour, 72 * time.Hour},
+		MaxFailures:   4,
+		GracePeriod:   48 * time.Hour,
+	}
+	since := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	now := since.Add(time.Hour)
+
+	tests := []struct {
+		name          string
+		step          int
+		failedCharges int
+		wantAction    Action
+		wantNext      time.Time
+	}{
+		{"first step reminds", 0, 1, ActionRemind, since.Add(24 * time.Hour)},
+		{"first retry", 1, 1, ActionRetry, since.Add(72 * time.Hour)},
+		{"last retry starts grace period", 2, 2, ActionRetry, now.Add(48 * time.Hour)},
+		{"grace period ran out", 3, 3, ActionCancel, time.Time{}},
+		{"too many failures", 1, 4, ActionCancel, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscription := &models.Subscription{
+				PastDueSince:  &since,
+				DunningStep:   tt.step,
+				FailedCharges: tt.failedCharges,
+			}
+			action, next := NextStep(cfg, subscription, now)
+			assert.Equal(t, tt.wantAction, action)
+			assert.Equal(t, tt.wantNext, next)
+		})
+	}
+}
+
+func TestNewService(t *testing.T) {
+	service := NewService(nil, nil, Config{})
+	assert.NotNil(t, service)
+}
@@ -0,0 +1,174 @@
+package fx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrRateNotFound is returned when no recent rate connects two currencies
+	ErrRateNotFound = errors.New("exchange rate not found")
+	// ErrInvalidRates is returned for rate files that cannot be read
+	ErrInvalidRates = errors.New("invalid exchange rates")
+)
+
+// reportedStatuses are the statuses of payments that were charged and count as gross
+// donations; refunded payments count as refunds as well
+var reportedStatuses = []models.PaymentStatus{models.PaymentStatusSucceeded, models.PaymentStatusRefunded}
+
+// MonthlyGross is the gross donations of one month converted to a reporting currency
+type MonthlyGross struct {
+	Month         string          `json:"month"`
+	Currency      models.Currency `json:"currency"`
+	GrossMinor    int64           `json:"gross_minor"`
+	RefundedMinor int64           `json:"refunded_minor"`
+	NetMinor      int64           `json:"net_minor"`
+	Payments      int             `json:"payments"`
+	// Unconverted counts payments left out of the totals because no rate was known for them
+	Unconverted int `json:"unconverted"`
+}
+
+// Service stores exchange rates and converts payments to a reporting currency
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new FX service
+func NewService() *Service {
+	return &Service{
+		db: database.GetDB(),
+	}
+}
+
+// ImportRates stores rates, replacing rates already stored for the same day and pair. It
+// returns how many rates were stored.
+func (s *Service) ImportRates(rates []models.FXRate) (int, error) {
+	if len(rates) == 0 {
+		return 0, nil
+	}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "base_currency"}, {Name: "currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "created_at"}),
+	}).CreateInBatches(rates, 500).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to store exchange rates: %w", err)
+	}
+	return len(rates), nil
+}
+
+// GetRates returns the rates published between two days, inclusive, oldest first
+func (s *Service) GetRates(from, to time.Time) ([]models.FXRate, error) {
+	var rates []models.FXRate
+	err := s.db.Where("date BETWEEN ? AND ?", truncateDay(from), truncateDay(to)).
+		Order("date ASC, base_currency ASC, currency ASC").
+		Find(&rates).Error
+	return rates, err
+}
+
+// LoadRates returns the rates needed to convert amounts between two times
+func (s *Service) LoadRates(from, to time.Time) (*Rates, error) {
+	rates, err := s.GetRates(from.Add(-maxRateAge), to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load exchange rates: %w", err)
+	}
+	return NewRates(rates), nil
+}
+
+// Convert converts an amount in minor units between currencies at a time
+func (s *Service) Convert(amountMinor int64, from, to models.Currency, at time.Time) (int64, error) {
+	if from == to {
+		return amountMinor, nil
+	}
+	rates, err := s.LoadRates(at, at)
+	if err != nil {
+		return 0, err
+	}
+	return rates.Convert(amountMinor, from, to, at)
+}
+
+// ConvertPayment converts the amount of a payment to a currency at the time it occurred
+func (s *Service) ConvertPayment(payment *models.Payment, to models.Currency) (int64, error) {
+	return s.Convert(payment.AmountMinor, payment.Currency, to, PaymentTime(payment))
+}
+
+// GetMonthlyGross reports the gross donations of every month from the month of from up to
+// the month of to, converted to a currency at the rates of the days the payments occurred.
+// Refunds are converted at the rate of their payment and reported in its month.
+func (s *Service) GetMonthlyGross(currency models.Currency, from, to time.Time) ([]MonthlyGross, error) {
+	start := startOfMonth(from)
+	end := startOfMonth(to).AddDate(0, 1, 0)
+
+	var payments []models.Payment
+	err := s.db.Where("status IN ? AND COALESCE(occurred_at, created_at) >= ? AND COALESCE(occurred_at, created_at) < ?", reportedStatuses, start, end).
+		Find(&payments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load payments: %w", err)
+	}
+
+	rates, err := s.LoadRates(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return monthlyGross(payments, rates, currency, start, end), nil
+}
+
+// monthlyGross sums payments per month in a currency. Every month in [start, end) is
+// reported, including months without payments.
+func monthlyGross(payments []models.Payment, rates *Rates, currency models.Currency, start, end time.Time) []MonthlyGross {
+	var report []MonthlyGross
+	index := make(map[string]int)
+	for month := start; month.Before(end); month = month.AddDate(0, 1, 0) {
+		key := month.Format("2006-01")
+		index[key] = len(report)
+		report = append(report, MonthlyGross{Month: key, Currency: currency})
+	}
+
+	for i := range payments {
+		payment := &payments[i]
+		at := PaymentTime(payment)
+		n, ok := index[at.UTC().Format("2006-01")]
+		if !ok {
+			continue
+		}
+		month := &report[n]
+
+		rate, err := rates.Rate(payment.Currency, currency, at)
+		if err != nil {
+			month.Unconverted++
+			continue
+		}
+		gross := convertMinor(payment.AmountMinor, rate)
+		refunded := convertMinor(payment.RefundedAmountMinor, rate)
+		month.GrossMinor += gross
+		month.RefundedMinor += refunded
+		month.NetMinor += gross - refunded
+		month.Payments++
+	}
+	return report
+}
+
+// PaymentTime returns when a payment was made; older payments only have a creation time
+func PaymentTime(payment *models.Payment) time.Time {
+	if payment.OccurredAt != nil && !payment.OccurredAt.IsZero() {
+		return *payment.OccurredAt
+	}
+	return payment.CreatedAt
+}
+
+// convertMinor applies a rate to an amount in minor units, rounding to the nearest unit
+func convertMinor(amountMinor int64, rate float64) int64 {
+	return int64(math.Round(float64(amountMinor) * rate))
+}
+
+// startOfMonth returns the start of the UTC month of a time
+func startOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
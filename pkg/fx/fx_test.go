@@ -0,0 +1,42 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewService(t *testing.T) {
+	service := NewService()
+	assert.NotNil(t, service)
+}
+
+func TestMonthlyGross(t *testing.T) {
+	march := day("2025-03-14")
+	payments := []models.Payment{
+		{AmountMinor: 10000, Currency: models.CurrencyEUR, OccurredAt: &march},
+		{AmountMinor: 12500, Currency: models.CurrencyUSD, RefundedAmountMinor: 2500, CreatedAt: march},
+		{AmountMinor: 10000, Currency: models.CurrencyRUB, OccurredAt: &march},
+	}
+
+	report := monthlyGross(payments, testRates(), models.CurrencyEUR, day("2025-02-01"), day("2025-04-01"))
+	assert.Len(t, report, 2)
+
+	assert.Equal(t, MonthlyGross{Month: "2025-02", Currency: models.CurrencyEUR}, report[0])
+	assert.Equal(t, MonthlyGross{
+		Month:         "2025-03",
+		Currency:      models.CurrencyEUR,
+		GrossMinor:    20000,
+		RefundedMinor: 2000,
+		NetMinor:      18000,
+		Payments:      2,
+		Unconverted:   1,
+	}, report[1])
+}
+
+func TestStartOfMonth(t *testing.T) {
+	at := time.Date(2025, 3, 14, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600))
+	assert.Equal(t, day("2025-03-01"), startOfMonth(at))
+}
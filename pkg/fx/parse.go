@@ -0,0 +1,133 @@
+package fx
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+)
+
+// Sources of imported rates
+const (
+	SourceCSV = "csv"
+	SourceECB = "ecb"
+)
+
+// dateLayout is the format of rate dates in files and reports
+const dateLayout = "2006-01-02"
+
+// ParseCSV reads rates from a CSV file with a header row naming the columns date, currency,
+// rate and optionally base_currency, which defaults to EUR. Rates of currencies the
+// platform does not accept are skipped.
+func ParseCSV(r io.Reader) ([]models.FXRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing header: %v", ErrInvalidRates, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"date", "currency", "rate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidRates, name)
+		}
+	}
+
+	var rates []models.FXRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidRates, line, err)
+		}
+
+		base := string(models.CurrencyEUR)
+		if i, ok := columns["base_currency"]; ok && record[i] != "" {
+			base = record[i]
+		}
+		rate, ok, err := newRate(record[columns["date"]], base, record[columns["currency"]], record[columns["rate"]], SourceCSV)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if ok {
+			rates = append(rates, rate)
+		}
+	}
+	return rates, nil
+}
+
+// ecbEnvelope is the layout of the ECB euro foreign exchange reference rates feed
+type ecbEnvelope struct {
+	Cube struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// ParseECBXML reads rates from an ECB euro reference rates file, daily or historical. All
+// rates are against EUR. Rates of currencies the platform does not accept are skipped.
+func ParseECBXML(r io.Reader) ([]models.FXRate, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRates, err)
+	}
+
+	var rates []models.FXRate
+	for _, day := range envelope.Cube.Days {
+		for _, quote := range day.Rates {
+			rate, ok, err := newRate(day.Time, string(models.CurrencyEUR), quote.Currency, quote.Rate, SourceECB)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				rates = append(rates, rate)
+			}
+		}
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("%w: no rates found", ErrInvalidRates)
+	}
+	return rates, nil
+}
+
+// newRate validates one rate. It reports false for currencies the platform does not accept.
+func newRate(date, base, currency, value, source string) (models.FXRate, bool, error) {
+	day, err := time.Parse(dateLayout, strings.TrimSpace(date))
+	if err != nil {
+		return models.FXRate{}, false, fmt.Errorf("%w: invalid date %q", ErrInvalidRates, date)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || rate <= 0 {
+		return models.FXRate{}, false, fmt.Errorf("%w: invalid rate %q", ErrInvalidRates, value)
+	}
+
+	baseCurrency := models.Currency(strings.ToUpper(strings.TrimSpace(base)))
+	quoteCurrency := models.Currency(strings.ToUpper(strings.TrimSpace(currency)))
+	if !baseCurrency.IsValid() || !quoteCurrency.IsValid() || baseCurrency == quoteCurrency {
+		return models.FXRate{}, false, nil
+	}
+	return models.FXRate{
+		Date:         day,
+		BaseCurrency: baseCurrency,
+		Currency:     quoteCurrency,
+		Rate:         rate,
+		Source:       source,
+	}, true, nil
+}
@@ -0,0 +1,83 @@
+package fx
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+const ecbDaily = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<gesmes:Sender>
+		<gesmes:name>European Central Bank</gesmes:name>
+	</gesmes:Sender>
+	<Cube>
+		<Cube time="2025-03-14">
+			<Cube currency="USD" rate="1.0879"/>
+			<Cube currency="JPY" rate="161.69"/>
+		</Cube>
+		<Cube time="2025-03-13">
+			<Cube currency="USD" rate="1.0859"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func TestParseECBXML(t *testing.T) {
+	rates, err := ParseECBXML(strings.NewReader(ecbDaily))
+	assert.NoError(t, err)
+	assert.Len(t, rates, 2)
+
+	assert.Equal(t, "2025-03-14", rates[0].Date.Format(dateLayout))
+	assert.Equal(t, models.CurrencyEUR, rates[0].BaseCurrency)
+	assert.Equal(t, models.CurrencyUSD, rates[0].Currency)
+	assert.Equal(t, 1.0879, rates[0].Rate)
+	assert.Equal(t, SourceECB, rates[0].Source)
+	assert.Equal(t, "2025-03-13", rates[1].Date.Format(dateLayout))
+}
+
+func TestParseECBXML_Invalid(t *testing.T) {
+	_, err := ParseECBXML(strings.NewReader("not xml"))
+	assert.ErrorIs(t, err, ErrInvalidRates)
+
+	_, err = ParseECBXML(strings.NewReader(`<Envelope><Cube><Cube time="2025-03-14"><Cube currency="USD" rate="abc"/></Cube></Cube></Envelope>`))
+	assert.ErrorIs(t, err, ErrInvalidRates)
+}
+
+func TestParseCSV(t *testing.T) {
+	input := "date,currency,rate,base_currency\n" +
+		"2025-03-14,USD,1.0879,\n" +
+		"2025-03-14,KZT,560.12,EUR\n" +
+		"2025-03-14,RUB,0.0113,KZT\n" +
+		"2025-03-14,GBP,0.84,\n"
+
+	rates, err := ParseCSV(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Len(t, rates, 3)
+
+	assert.Equal(t, models.CurrencyEUR, rates[0].BaseCurrency)
+	assert.Equal(t, models.CurrencyKZT, rates[2].BaseCurrency)
+	assert.Equal(t, models.CurrencyRUB, rates[2].Currency)
+	assert.Equal(t, SourceCSV, rates[2].Source)
+}
+
+func TestParseCSV_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"missing column", "date,currency\n2025-03-14,USD\n"},
+		{"invalid date", "date,currency,rate\n14.03.2025,USD,1.08\n"},
+		{"invalid rate", "date,currency,rate\n2025-03-14,USD,-1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCSV(strings.NewReader(tt.input))
+			assert.ErrorIs(t, err, ErrInvalidRates)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package fx
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+)
+
+// maxRateAge is how old the latest rate before a date may be and still be used for it.
+// Reference rates are not published on weekends and holidays.
+const maxRateAge = 10 * 24 * time.Hour
+
+// pair identifies the rates of one currency against a base currency
+type pair struct {
+	base     models.Currency
+	currency models.Currency
+}
+
+// Rates looks up exchange rates in memory. It converts between any two currencies that
+// are quoted against a common base currency.
+type Rates struct {
+	series map[pair][]models.FXRate
+	bases  []models.Currency
+}
+
+// NewRates indexes a set of rates for lookups
+func NewRates(rates []models.FXRate) *Rates {
+	r := &Rates{series: make(map[pair][]models.FXRate)}
+	for _, rate := range rates {
+		key := pair{base: rate.BaseCurrency, currency: rate.Currency}
+		if !r.hasBase(rate.BaseCurrency) {
+			r.bases = append(r.bases, rate.BaseCurrency)
+		}
+		r.series[key] = append(r.series[key], rate)
+	}
+	for key := range r.series {
+		series := r.series[key]
+		sort.Slice(series, func(i, j int) bool { return series[i].Date.Before(series[j].Date) })
+	}
+	sort.Slice(r.bases, func(i, j int) bool { return r.bases[i] < r.bases[j] })
+	return r
+}
+
+func (r *Rates) hasBase(base models.Currency) bool {
+	for _, b := range r.bases {
+		if b == base {
+			return true
+		}
+	}
+	return false
+}
+
+// Rate returns how many units of to one unit of from was worth at a time, using the latest
+// rates published on or before that day
+func (r *Rates) Rate(from, to models.Currency, at time.Time) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if rate, ok := r.lookup(from, to, at); ok {
+		return rate, nil
+	}
+	if rate, ok := r.lookup(to, from, at); ok {
+		return 1 / rate, nil
+	}
+	for _, base := range r.bases {
+		fromRate, ok := r.baseRate(base, from, at)
+		if !ok {
+			continue
+		}
+		toRate, ok := r.baseRate(base, to, at)
+		if !ok {
+			continue
+		}
+		return toRate / fromRate, nil
+	}
+	return 0, fmt.Errorf("%w: %s to %s on %s", ErrRateNotFound, from, to, at.Format(dateLayout))
+}
+
+// Convert converts an amount in minor units between currencies at a time. All supported
+// currencies have two minor digits, so minor units convert directly.
+func (r *Rates) Convert(amountMinor int64, from, to models.Currency, at time.Time) (int64, error) {
+	rate, err := r.Rate(from, to, at)
+	if err != nil {
+		return 0, err
+	}
+	return convertMinor(amountMinor, rate), nil
+}
+
+// baseRate returns the rate of a currency against a base, which is 1 for the base itself
+func (r *Rates) baseRate(base, currency models.Currency, at time.Time) (float64, bool) {
+	if base == currency {
+		return 1, true
+	}
+	return r.lookup(base, currency, at)
+}
+
+// lookup returns the latest rate of a pair published on or before the day of at
+func (r *Rates) lookup(base, currency models.Currency, at time.Time) (float64, bool) {
+	series := r.series[pair{base: base, currency: currency}]
+	day := truncateDay(at)
+	i := sort.Search(len(series), func(i int) bool { return series[i].Date.After(day) })
+	if i == 0 {
+		return 0, false
+	}
+	rate := series[i-1]
+	if day.Sub(rate.Date) > maxRateAge {
+		return 0, false
+	}
+	return rate.Rate, true
+}
+
+// truncateDay returns the start of the UTC day of a time
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,71 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse(dateLayout, s)
+	return t
+}
+
+func testRates() *Rates {
+	return NewRates([]models.FXRate{
+		{Date: day("2025-03-14"), BaseCurrency: models.CurrencyEUR, Currency: models.CurrencyUSD, Rate: 1.25},
+		{Date: day("2025-03-13"), BaseCurrency: models.CurrencyEUR, Currency: models.CurrencyUSD, Rate: 1.2},
+		{Date: day("2025-03-14"), BaseCurrency: models.CurrencyEUR, Currency: models.CurrencyKZT, Rate: 500},
+	})
+}
+
+func TestRates_Convert(t *testing.T) {
+	rates := testRates()
+	friday := day("2025-03-14").Add(15 * time.Hour)
+
+	tests := []struct {
+		name     string
+		amount   int64
+		from, to models.Currency
+		at       time.Time
+		want     int64
+	}{
+		{"same currency", 1000, models.CurrencyRUB, models.CurrencyRUB, friday, 1000},
+		{"direct", 1000, models.CurrencyEUR, models.CurrencyUSD, friday, 1250},
+		{"inverse", 1250, models.CurrencyUSD, models.CurrencyEUR, friday, 1000},
+		{"through the base", 50000, models.CurrencyKZT, models.CurrencyUSD, friday, 125},
+		{"earlier day", 1000, models.CurrencyEUR, models.CurrencyUSD, day("2025-03-13"), 1200},
+		{"weekend uses friday", 1000, models.CurrencyEUR, models.CurrencyUSD, day("2025-03-16"), 1250},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rates.Convert(tt.amount, tt.from, tt.to, tt.at)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRates_Convert_NotFound(t *testing.T) {
+	rates := testRates()
+
+	tests := []struct {
+		name     string
+		from, to models.Currency
+		at       time.Time
+	}{
+		{"unknown currency", models.CurrencyRUB, models.CurrencyEUR, day("2025-03-14")},
+		{"before the first rate", models.CurrencyEUR, models.CurrencyUSD, day("2025-03-12")},
+		{"rate too old", models.CurrencyEUR, models.CurrencyUSD, day("2025-04-30")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := rates.Convert(100, tt.from, tt.to, tt.at)
+			assert.ErrorIs(t, err, ErrRateNotFound)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Scopes separate the keys of different endpoints, so that a client may reuse a key
+// for a payment and a subscription
+const (
+	ScopePaymentIntent      = "payment_intent"
+	ScopeSubscriptionIntent = "subscription_intent"
+)
+
+// MaxKeyLength is the longest accepted idempotency key
+const MaxKeyLength = 255
+
+// LeaseDuration is how long a request holds its key. A request that has not finished by
+// then is considered lost, and the next request with the key takes it over.
+const LeaseDuration = 5 * time.Minute
+
+var (
+	// ErrKeyReused is returned when a key is sent again with a different request
+	ErrKeyReused = errors.New("idempotency key was already used with a different request")
+	// ErrRequestInProgress is returned when the first request with a key has not finished yet
+	ErrRequestInProgress = errors.New("a request with this idempotency key is still in progress")
+	// ErrLeaseLost is returned when a request finishes a key that another request took over
+	ErrLeaseLost = errors.New("idempotency key was taken over by another request")
+)
+
+// Service stores idempotency keys and the responses they replay
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new idempotency service
+func NewService() *Service {
+	return &Service{
+		db: database.GetDB(),
+	}
+}
+
+// Fingerprint returns a hash identifying a decoded request body. Requests that only
+// differ in formatting or key order have the same fingerprint.
+func Fingerprint(req interface{}) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Begin reserves a key for a request. When the key was already used for the same
+// request, the stored record is returned and its response must be replayed; otherwise
+// the caller owns the returned record and must Complete or Release it. A key whose
+// lease expired before its request finished is taken over.
+func (s *Service) Begin(authUserID, scope, key, fingerprint string) (*models.IdempotencyKey, error) {
+	now := time.Now()
+	record := &models.IdempotencyKey{
+		ID:          uuid.New(),
+		AuthUserID:  authUserID,
+		Scope:       scope,
+		Key:         key,
+		RequestHash: fingerprint,
+		LockedUntil: now.Add(LeaseDuration),
+		LeaseToken:  uuid.New(),
+	}
+
+	// Concurrent requests race for the unique index; exactly one of them inserts the key
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to reserve idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return record, nil
+	}
+
+	existing := &models.IdempotencyKey{}
+	err := s.db.Where("auth_user_id = ? AND scope = ? AND key = ?", authUserID, scope, key).First(existing).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find idempotency key: %w", err)
+	}
+	if existing.RequestHash != fingerprint {
+		return nil, ErrKeyReused
+	}
+	if existing.ResponseStatus != nil {
+		return existing, nil
+	}
+	if !leaseExpired(existing, now) {
+		return nil, ErrRequestInProgress
+	}
+
+	// Concurrent requests also race for a lost key; the lease moves on for only one of them
+	lockedUntil := now.Add(LeaseDuration)
+	leaseToken := uuid.New()
+	result = s.db.Model(existing).
+		Where("response_status IS NULL AND lease_token = ?", existing.LeaseToken).
+		Updates(map[string]interface{}{"locked_until": lockedUntil, "lease_token": leaseToken})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to take over idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrRequestInProgress
+	}
+	existing.LockedUntil = lockedUntil
+	existing.LeaseToken = leaseToken
+	return existing, nil
+}
+
+// leaseExpired reports whether the request holding an unfinished key is considered lost
+func leaseExpired(record *models.IdempotencyKey, now time.Time) bool {
+	return record.ResponseStatus == nil && !record.LockedUntil.After(now)
+}
+
+// Complete stores the response of the request that reserved the key. It fails with
+// ErrLeaseLost when another request took the key over in the meantime.
+func (s *Service) Complete(record *models.IdempotencyKey, status int, body interface{}) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	responseBody := string(encoded)
+	now := time.Now()
+
+	updates := map[string]interface{}{
+		"response_status": status,
+		"response_body":   responseBody,
+		"completed_at":    now,
+	}
+	result := s.db.Model(record).Where("lease_token = ? AND response_status IS NULL", record.LeaseToken).Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+
+	record.ResponseStatus = &status
+	record.ResponseBody = &responseBody
+	record.CompletedAt = &now
+	return nil
+}
+
+// Release frees a key whose request failed unexpectedly, so that it can be retried. It
+// fails with ErrLeaseLost when another request took the key over in the meantime.
+func (s *Service) Release(record *models.IdempotencyKey) error {
+	result := s.db.Where("lease_token = ? AND response_status IS NULL", record.LeaseToken).Delete(record)
+	if result.Error != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewService(t *testing.T) {
+	service := NewService()
+	assert.NotNil(t, service)
+	// Note: service.db might be nil if database connection is not available during testing
+}
+
+func TestFingerprint(t *testing.T) {
+	type request struct {
+		Provider    string `json:"provider"`
+		AmountMinor int64  `json:"amount_minor"`
+	}
+
+	first, err := Fingerprint(request{Provider: "cloudpayments", AmountMinor: 19000})
+	require.NoError(t, err)
+	assert.Len(t, first, 64)
+
+	same, err := Fingerprint(request{Provider: "cloudpayments", AmountMinor: 19000})
+	require.NoError(t, err)
+	assert.Equal(t, first, same)
+
+	other, err := Fingerprint(request{Provider: "cloudpayments", AmountMinor: 38000})
+	require.NoError(t, err)
+	assert.NotEqual(t, first, other)
+
+	_, err = Fingerprint(make(chan int))
+	assert.Error(t, err)
+}
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+	status := 201
+
+	tests := []struct {
+		name     string
+		record   models.IdempotencyKey
+		expected bool
+	}{
+		{"request in progress", models.IdempotencyKey{LockedUntil: now.Add(LeaseDuration)}, false},
+		{"request lost", models.IdempotencyKey{LockedUntil: now.Add(-time.Second)}, true},
+		{"lease ends now", models.IdempotencyKey{LockedUntil: now}, true},
+		{"request finished", models.IdempotencyKey{LockedUntil: now.Add(-time.Hour), ResponseStatus: &status}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, leaseExpired(&tt.record, now))
+		})
+	}
+}
@@ -11,6 +11,18 @@ type Mailer interface {
 	SendEmail(to, subject, body string) error
 	SendVerificationEmail(to, token string) error
 	SendPasswordResetEmail(to, token string) error
+	SendPaymentFailedEmail(to, subscriptionID string) error
+	SendSubscriptionCanceledEmail(to, subscriptionID string) error
+	SendGiftCertificateEmail(to string, gift GiftCertificate) error
+}
+
+// GiftCertificate describes trees donated as a gift, for the email to their recipient
+type GiftCertificate struct {
+	RecipientName string
+	DonorName     string
+	Message       string
+	Trees         int
+	URL           string
 }
 
 // SMTPMailer implements Mailer interface using SMTP
@@ -93,6 +105,71 @@ Best regards,
 	return m.SendEmail(to, subject, strings.TrimSpace(body))
 }
 
+// SendPaymentFailedEmail reminds a donor to update the card of a past due subscription
+func (m *SMTPMailer) SendPaymentFailedEmail(to, subscriptionID string) error {
+	subject := "Your monthly donation could not be charged"
+	body := fmt.Sprintf(`
+Hello!
+
+We could not charge the card for your recurring donation. We will try again shortly,
+but the charge will probably keep failing until you update your card:
+
+https://4planet.local/subscriptions/%s/payment-method
+
+If we cannot charge the card after several attempts, your recurring donation will be canceled.
+
+Best regards,
+4Planet Team
+`, subscriptionID)
+
+	return m.SendEmail(to, subject, strings.TrimSpace(body))
+}
+
+// SendSubscriptionCanceledEmail tells a donor that a subscription was canceled for non-payment
+func (m *SMTPMailer) SendSubscriptionCanceledEmail(to, subscriptionID string) error {
+	subject := "Your monthly donation was canceled"
+	body := fmt.Sprintf(`
+Hello!
+
+We could not charge the card for your recurring donation after several attempts,
+so we have canceled it. Thank you for the trees you have planted so far!
+
+You can start a new recurring donation at any time:
+
+https://4planet.local/subscriptions/new?from=%s
+
+Best regards,
+4Planet Team
+`, subscriptionID)
+
+	return m.SendEmail(to, subject, strings.TrimSpace(body))
+}
+
+// SendGiftCertificateEmail sends the recipient of a gift donation a link to their tree certificate
+func (m *SMTPMailer) SendGiftCertificateEmail(to string, gift GiftCertificate) error {
+	subject := fmt.Sprintf("%s planted trees for you", gift.DonorName)
+	message := ""
+	if gift.Message != "" {
+		message = fmt.Sprintf("\n%s wrote:\n\n%s\n", gift.DonorName, gift.Message)
+	}
+	body := fmt.Sprintf(`
+Hello, %s!
+
+%s has planted %d trees in your name with 4Planet.
+%s
+Your tree certificate is here:
+
+%s
+
+Sign in or create an account from the certificate page to add the trees to your own profile.
+
+Best regards,
+4Planet Team
+`, gift.RecipientName, gift.DonorName, gift.Trees, message, gift.URL)
+
+	return m.SendEmail(to, subject, strings.TrimSpace(body))
+}
+
 // NoOpMailer is a mock mailer for development/testing
 type NoOpMailer struct{}
 
@@ -119,3 +196,21 @@ func (m *NoOpMailer) SendPasswordResetEmail(to, token string) error {
 	fmt.Printf("[MAILER] Would send password reset email to %s with token %s\n", to, token)
 	return nil
 }
+
+// SendPaymentFailedEmail does nothing (for development)
+func (m *NoOpMailer) SendPaymentFailedEmail(to, subscriptionID string) error {
+	fmt.Printf("[MAILER] Would send payment failed email to %s for subscription %s\n", to, subscriptionID)
+	return nil
+}
+
+// SendSubscriptionCanceledEmail does nothing (for development)
+func (m *NoOpMailer) SendSubscriptionCanceledEmail(to, subscriptionID string) error {
+	fmt.Printf("[MAILER] Would send subscription canceled email to %s for subscription %s\n", to, subscriptionID)
+	return nil
+}
+
+// SendGiftCertificateEmail does nothing (for development)
+func (m *NoOpMailer) SendGiftCertificateEmail(to string, gift GiftCertificate) error {
+	fmt.Printf("[MAILER] Would send gift certificate email to %s with link %s\n", to, gift.URL)
+	return nil
+}
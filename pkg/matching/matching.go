@@ -0,0 +1,263 @@
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidPool is returned for pools with missing or inconsistent fields
+	ErrInvalidPool = errors.New("invalid matching pool")
+	// ErrUnknownSponsor is returned when no account has the sponsor's email
+	ErrUnknownSponsor = errors.New("unknown sponsor")
+	// ErrUnknownProject is returned for pools limited to a project that does not exist
+	ErrUnknownProject = errors.New("unknown project")
+	// ErrUnknownCampaign is returned for pools limited to a campaign that does not exist
+	ErrUnknownCampaign = errors.New("unknown campaign")
+)
+
+// Statuses of a matching pool
+const (
+	StatusUpcoming  = "upcoming"
+	StatusOpen      = "open"
+	StatusExhausted = "exhausted"
+	StatusClosed    = "closed"
+)
+
+// PoolInput is what admins set when creating a matching pool
+type PoolInput struct {
+	SponsorName    string
+	SponsorEmail   string
+	ProjectID      *uuid.UUID
+	CampaignID     *uuid.UUID
+	Currency       models.Currency
+	CapTrees       *int
+	CapAmountMinor *int64
+	StartsAt       time.Time
+	EndsAt         time.Time
+}
+
+// ProjectUsage is what a pool matched for donations to one project, or to no project
+type ProjectUsage struct {
+	ProjectID   *uuid.UUID `json:"project_id"`
+	Donations   int        `json:"donations"`
+	Trees       int        `json:"trees"`
+	AmountMinor int64      `json:"amount_minor"`
+}
+
+// Report shows a sponsor how much of a matching pool was used
+type Report struct {
+	ID                   uuid.UUID       `json:"id"`
+	SponsorName          string          `json:"sponsor_name"`
+	ProjectID            *uuid.UUID      `json:"project_id,omitempty"`
+	CampaignID           *uuid.UUID      `json:"campaign_id,omitempty"`
+	Currency             models.Currency `json:"currency"`
+	StartsAt             time.Time       `json:"starts_at"`
+	EndsAt               time.Time       `json:"ends_at"`
+	Status               string          `json:"status"`
+	CapTrees             *int            `json:"cap_trees,omitempty"`
+	CapAmountMinor       *int64          `json:"cap_amount_minor,omitempty"`
+	MatchedTrees         int             `json:"matched_trees"`
+	MatchedAmountMinor   int64           `json:"matched_amount_minor"`
+	RemainingTrees       *int            `json:"remaining_trees,omitempty"`
+	RemainingAmountMinor *int64          `json:"remaining_amount_minor,omitempty"`
+	// Donations counts the matched donations the pool made
+	Donations int            `json:"donations"`
+	ByProject []ProjectUsage `json:"by_project"`
+}
+
+// Service manages the matching pools of sponsors
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new matching service
+func NewService() *Service {
+	return &Service{
+		db: database.GetDB(),
+	}
+}
+
+// ListPools returns every matching pool, latest first
+func (s *Service) ListPools() ([]models.MatchingPool, error) {
+	var pools []models.MatchingPool
+	err := s.db.Order("starts_at DESC").Find(&pools).Error
+	return pools, err
+}
+
+// CreatePool creates a matching pool for the account with the sponsor's email. Matched
+// donations are made by that account.
+func (s *Service) CreatePool(input *PoolInput) (*models.MatchingPool, error) {
+	if err := validateInput(input); err != nil {
+		return nil, err
+	}
+
+	var sponsor models.User
+	err := s.db.Where("LOWER(email) = LOWER(?)", input.SponsorEmail).First(&sponsor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUnknownSponsor
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sponsor: %w", err)
+	}
+	if err := s.checkExists(&models.Project{}, input.ProjectID, ErrUnknownProject); err != nil {
+		return nil, err
+	}
+	if err := s.checkExists(&models.Campaign{}, input.CampaignID, ErrUnknownCampaign); err != nil {
+		return nil, err
+	}
+
+	pool := &models.MatchingPool{
+		ID:                uuid.New(),
+		SponsorName:       input.SponsorName,
+		SponsorAuthUserID: sponsor.AuthUserID,
+		ProjectID:         input.ProjectID,
+		CampaignID:        input.CampaignID,
+		Currency:          input.Currency,
+		CapTrees:          input.CapTrees,
+		CapAmountMinor:    input.CapAmountMinor,
+		StartsAt:          input.StartsAt,
+		EndsAt:            input.EndsAt,
+	}
+	if err := s.db.Create(pool).Error; err != nil {
+		return nil, fmt.Errorf("failed to create matching pool: %w", err)
+	}
+	return pool, nil
+}
+
+// GetReport returns the usage report of a pool
+func (s *Service) GetReport(id uuid.UUID, now time.Time) (*Report, error) {
+	var pool models.MatchingPool
+	if err := s.db.Where("id = ?", id).First(&pool).Error; err != nil {
+		return nil, err
+	}
+	return s.report(&pool, now)
+}
+
+// GetSponsorReports returns the usage reports of a sponsor's pools, latest first
+func (s *Service) GetSponsorReports(authUserID string, now time.Time) ([]Report, error) {
+	var pools []models.MatchingPool
+	if err := s.db.Where("sponsor_auth_user_id = ?", authUserID).Order("starts_at DESC").Find(&pools).Error; err != nil {
+		return nil, fmt.Errorf("failed to find matching pools: %w", err)
+	}
+
+	reports := make([]Report, 0, len(pools))
+	for i := range pools {
+		report, err := s.report(&pools[i], now)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+// report adds up the matched donations of a pool per project
+func (s *Service) report(pool *models.MatchingPool, now time.Time) (*Report, error) {
+	var usage []ProjectUsage
+	err := s.db.Model(&models.Donation{}).
+		Select("project_id, COUNT(*) AS donations, COALESCE(SUM(trees_count), 0) AS trees, COALESCE(SUM(trees_count * price_minor), 0) AS amount_minor").
+		Where("matching_pool_id = ? AND trees_count > 0", pool.ID).
+		Group("project_id").
+		Order("trees DESC").
+		Scan(&usage).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum matched donations: %w", err)
+	}
+
+	report := newReport(pool, now)
+	report.ByProject = append(report.ByProject, usage...)
+	for _, project := range usage {
+		report.Donations += project.Donations
+	}
+	return report, nil
+}
+
+// newReport reports the usage of a pool without the breakdown of its donations
+func newReport(pool *models.MatchingPool, now time.Time) *Report {
+	report := &Report{
+		ID:                 pool.ID,
+		SponsorName:        pool.SponsorName,
+		ProjectID:          pool.ProjectID,
+		CampaignID:         pool.CampaignID,
+		Currency:           pool.Currency,
+		StartsAt:           pool.StartsAt,
+		EndsAt:             pool.EndsAt,
+		Status:             Status(pool, now),
+		CapTrees:           pool.CapTrees,
+		CapAmountMinor:     pool.CapAmountMinor,
+		MatchedTrees:       pool.MatchedTrees,
+		MatchedAmountMinor: pool.MatchedAmountMinor,
+		ByProject:          []ProjectUsage{},
+	}
+	if pool.CapTrees != nil {
+		remaining := max(*pool.CapTrees-pool.MatchedTrees, 0)
+		report.RemainingTrees = &remaining
+	}
+	if pool.CapAmountMinor != nil {
+		remaining := max(*pool.CapAmountMinor-pool.MatchedAmountMinor, 0)
+		report.RemainingAmountMinor = &remaining
+	}
+	return report
+}
+
+// Status returns whether a pool is upcoming, open, used up or closed at a time. A pool
+// whose money cap cannot buy another tree is only closed by its end date.
+func Status(pool *models.MatchingPool, now time.Time) string {
+	switch {
+	case now.Before(pool.StartsAt):
+		return StatusUpcoming
+	case !now.Before(pool.EndsAt):
+		return StatusClosed
+	case pool.CapTrees != nil && pool.MatchedTrees >= *pool.CapTrees,
+		pool.CapAmountMinor != nil && pool.MatchedAmountMinor >= *pool.CapAmountMinor:
+		return StatusExhausted
+	default:
+		return StatusOpen
+	}
+}
+
+// validateInput checks the fields of a pool
+func validateInput(input *PoolInput) error {
+	input.SponsorName = strings.TrimSpace(input.SponsorName)
+	input.SponsorEmail = strings.TrimSpace(input.SponsorEmail)
+	switch {
+	case input.SponsorName == "":
+		return fmt.Errorf("%w: sponsor_name is required", ErrInvalidPool)
+	case input.SponsorEmail == "":
+		return fmt.Errorf("%w: sponsor_email is required", ErrInvalidPool)
+	case !input.Currency.IsValid():
+		return fmt.Errorf("%w: unsupported currency %q", ErrInvalidPool, input.Currency)
+	case input.CapTrees == nil && input.CapAmountMinor == nil:
+		return fmt.Errorf("%w: a tree or money cap is required", ErrInvalidPool)
+	case input.CapTrees != nil && *input.CapTrees <= 0:
+		return fmt.Errorf("%w: cap_trees must be positive", ErrInvalidPool)
+	case input.CapAmountMinor != nil && *input.CapAmountMinor <= 0:
+		return fmt.Errorf("%w: cap_amount_minor must be positive", ErrInvalidPool)
+	case !input.EndsAt.After(input.StartsAt):
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidPool)
+	}
+	return nil
+}
+
+// checkExists makes sure that the record with an optional ID exists
+func (s *Service) checkExists(model interface{}, id *uuid.UUID, notFound error) error {
+	if id == nil {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(model).Where("id = ?", *id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check %v: %w", notFound, err)
+	}
+	if count == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package matching
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	octoberStart = time.Date(2027, 10, 1, 0, 0, 0, 0, time.UTC)
+	octoberEnd   = time.Date(2027, 11, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func TestValidateInput(t *testing.T) {
+	capTrees := 5000
+	zero := 0
+	amount := int64(1000000)
+	negative := int64(-1)
+
+	tests := []struct {
+		name           string
+		sponsorName    string
+		sponsorEmail   string
+		currency       models.Currency
+		capTrees       *int
+		capAmountMinor *int64
+		endsAt         time.Time
+		wantErr        bool
+	}{
+		{"valid tree cap", "Company X", "csr@company-x.example", models.CurrencyEUR, &capTrees, nil, octoberEnd, false},
+		{"valid money cap", "Company X", "csr@company-x.example", models.CurrencyEUR, nil, &amount, octoberEnd, false},
+		{"both caps", "Company X", "csr@company-x.example", models.CurrencyEUR, &capTrees, &amount, octoberEnd, false},
+		{"blank sponsor name", "  ", "csr@company-x.example", models.CurrencyEUR, &capTrees, nil, octoberEnd, true},
+		{"blank sponsor email", "Company X", "", models.CurrencyEUR, &capTrees, nil, octoberEnd, true},
+		{"unsupported currency", "Company X", "csr@company-x.example", "GBP", &capTrees, nil, octoberEnd, true},
+		{"no cap", "Company X", "csr@company-x.example", models.CurrencyEUR, nil, nil, octoberEnd, true},
+		{"zero trees", "Company X", "csr@company-x.example", models.CurrencyEUR, &zero, nil, octoberEnd, true},
+		{"negative amount", "Company X", "csr@company-x.example", models.CurrencyEUR, &capTrees, &negative, octoberEnd, true},
+		{"ends before start", "Company X", "csr@company-x.example", models.CurrencyEUR, &capTrees, nil, octoberStart.Add(-time.Hour), true},
+		{"ends at start", "Company X", "csr@company-x.example", models.CurrencyEUR, &capTrees, nil, octoberStart, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateInput(&PoolInput{
+				SponsorName:    tt.sponsorName,
+				SponsorEmail:   tt.sponsorEmail,
+				Currency:       tt.currency,
+				CapTrees:       tt.capTrees,
+				CapAmountMinor: tt.capAmountMinor,
+				StartsAt:       octoberStart,
+				EndsAt:         tt.endsAt,
+			})
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPool)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	capTrees := 100
+	capAmount := int64(100000)
+
+	tests := []struct {
+		name     string
+		pool     models.MatchingPool
+		at       time.Time
+		expected string
+	}{
+		{"before start", models.MatchingPool{CapTrees: &capTrees}, octoberStart.Add(-time.Second), StatusUpcoming},
+		{"at start", models.MatchingPool{CapTrees: &capTrees}, octoberStart, StatusOpen},
+		{"tree cap used up", models.MatchingPool{CapTrees: &capTrees, MatchedTrees: 100}, octoberStart, StatusExhausted},
+		{"money cap used up", models.MatchingPool{CapAmountMinor: &capAmount, MatchedAmountMinor: 100000}, octoberStart, StatusExhausted},
+		{"at end", models.MatchingPool{CapTrees: &capTrees, MatchedTrees: 100}, octoberEnd, StatusClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.pool.StartsAt, tt.pool.EndsAt = octoberStart, octoberEnd
+			assert.Equal(t, tt.expected, Status(&tt.pool, tt.at))
+		})
+	}
+}
+
+func TestNewReport(t *testing.T) {
+	capTrees := 100
+	capAmount := int64(100000)
+	pool := &models.MatchingPool{
+		SponsorName:        "Company X",
+		Currency:           models.CurrencyEUR,
+		CapTrees:           &capTrees,
+		CapAmountMinor:     &capAmount,
+		StartsAt:           octoberStart,
+		EndsAt:             octoberEnd,
+		MatchedTrees:       6,
+		MatchedAmountMinor: 114000,
+	}
+
+	report := newReport(pool, octoberStart)
+	assert.Equal(t, StatusExhausted, report.Status)
+	assert.Equal(t, 94, *report.RemainingTrees)
+	assert.Equal(t, int64(0), *report.RemainingAmountMinor)
+	assert.Empty(t, report.ByProject)
+}
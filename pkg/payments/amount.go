@@ -0,0 +1,48 @@
+package payments
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseAmountMinor converts a decimal amount such as "190.50" to minor units. Signed
+// amounts are rejected.
+func ParseAmountMinor(amount string) (int64, error) {
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return 0, fmt.Errorf("%w: empty amount", ErrInvalidPayload)
+	}
+	// ParseInt accepts a sign, and "-0" parses as zero, so "-0.50" would pass as 0.50
+	if strings.HasPrefix(amount, "-") || strings.HasPrefix(amount, "+") {
+		return 0, fmt.Errorf("%w: invalid amount %q", ErrInvalidPayload, amount)
+	}
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if len(fraction) > 2 {
+		// Anything past the second decimal must be zero padding
+		if strings.Trim(fraction[2:], "0") != "" {
+			return 0, fmt.Errorf("%w: too many decimals in amount %q", ErrInvalidPayload, amount)
+		}
+		fraction = fraction[:2]
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major < 0 {
+		return 0, fmt.Errorf("%w: invalid amount %q", ErrInvalidPayload, amount)
+	}
+	minor, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || minor < 0 {
+		return 0, fmt.Errorf("%w: invalid amount %q", ErrInvalidPayload, amount)
+	}
+
+	return major*100 + minor, nil
+}
+
+// FormatAmountMinor converts minor units to a decimal amount such as "190.50"
+func FormatAmountMinor(amountMinor int64) string {
+	return fmt.Sprintf("%d.%02d", amountMinor/100, amountMinor%100)
+}
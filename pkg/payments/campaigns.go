@@ -0,0 +1,65 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// checkCampaign makes sure that a donation attributed to a campaign is made while the
+// campaign runs and, for campaigns linked to projects, that a project donation goes to one
+// of them. No campaign means the donation is not attributed to any.
+func (s *Service) checkCampaign(campaignID, projectID *uuid.UUID, at time.Time) error {
+	if campaignID == nil {
+		return nil
+	}
+	var campaign models.Campaign
+	err := s.db.Preload("Projects").Where("id = ?", *campaignID).First(&campaign).Error
+	errs := &ValidationError{}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		errs.Add("campaign_id", FieldErrorInvalid, "Campaign not found")
+		return errs.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find campaign: %w", err)
+	}
+
+	if !campaign.IsRunning(at) {
+		errs.Add("campaign_id", FieldErrorInvalid, "Campaign is not running")
+	}
+	if projectID != nil && !campaignHasProject(&campaign, *projectID) {
+		errs.Add("project_id", FieldErrorInvalid, "Project is not part of the campaign")
+	}
+	return errs.Err()
+}
+
+// campaignHasProject reports whether donations to a project count towards a campaign.
+// Campaigns without linked projects accept donations to any project.
+func campaignHasProject(campaign *models.Campaign, projectID uuid.UUID) bool {
+	if len(campaign.Projects) == 0 {
+		return true
+	}
+	for _, project := range campaign.Projects {
+		if project.ID == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// metaUUID returns the UUID stored under a key of a payment's meta, if any
+func metaUUID(meta map[string]interface{}, key string) *uuid.UUID {
+	value, ok := meta[key].(string)
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
@@ -0,0 +1,35 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCampaignHasProject(t *testing.T) {
+	linked := uuid.New()
+	other := uuid.New()
+
+	open := &models.Campaign{}
+	assert.True(t, campaignHasProject(open, other))
+
+	restricted := &models.Campaign{Projects: []models.Project{{ID: linked}}}
+	assert.True(t, campaignHasProject(restricted, linked))
+	assert.False(t, campaignHasProject(restricted, other))
+}
+
+func TestMetaUUID(t *testing.T) {
+	id := uuid.New()
+	meta := map[string]interface{}{
+		"campaign_id": id.String(),
+		"invalid":     "not-a-uuid",
+		"missing":     nil,
+	}
+
+	assert.Equal(t, &id, metaUUID(meta, "campaign_id"))
+	assert.Nil(t, metaUUID(meta, "invalid"))
+	assert.Nil(t, metaUUID(meta, "missing"))
+	assert.Nil(t, metaUUID(meta, "absent"))
+}
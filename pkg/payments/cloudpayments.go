@@ -1,118 +1,63 @@
 package payments
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/hex"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/4planet/backend/internal/database"
 	"github.com/4planet/backend/internal/models"
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
-// CloudPaymentsService handles CloudPayments integration
-type CloudPaymentsService struct {
-	db       *gorm.DB
-	publicID string
-	secret   string
-	baseURL  string
-}
+// DefaultCloudPaymentsAPIURL is the CloudPayments REST API endpoint
+const DefaultCloudPaymentsAPIURL = "https://api.cloudpayments.ru"
 
-// NewCloudPaymentsService creates a new CloudPayments service
-func NewCloudPaymentsService(publicID, secret, baseURL string) *CloudPaymentsService {
-	return &CloudPaymentsService{
-		db:       database.GetDB(),
-		publicID: publicID,
-		secret:   secret,
-		baseURL:  baseURL,
-	}
+// CloudPaymentsProvider handles CloudPayments integration
+type CloudPaymentsProvider struct {
+	publicID   string
+	secret     string
+	baseURL    string
+	apiURL     string
+	httpClient *http.Client
 }
 
-// PaymentIntentRequest represents a payment intent request
-type PaymentIntentRequest struct {
-	Provider         string     `json:"provider"`
-	AmountMinor      int64      `json:"amount_minor"`
-	Currency         string     `json:"currency"`
-	SuccessReturnURL string     `json:"success_return_url"`
-	FailReturnURL    string     `json:"fail_return_url"`
-	Description      *string    `json:"description,omitempty"`
-	ProjectID        *uuid.UUID `json:"project_id,omitempty"`
-	ReferralUserID   *string    `json:"referral_user_id,omitempty"`
-}
-
-// PaymentIntentResponse represents a payment intent response
-type PaymentIntentResponse struct {
-	Provider        string                 `json:"provider"`
-	RedirectURL     string                 `json:"redirect_url"`
-	ProviderPayload map[string]interface{} `json:"provider_payload"`
-}
-
-// SubscriptionIntentRequest represents a subscription intent request
-type SubscriptionIntentRequest struct {
-	Provider         string     `json:"provider"`
-	AmountMinor      int64      `json:"amount_minor"`
-	Currency         string     `json:"currency"`
-	SuccessReturnURL string     `json:"success_return_url"`
-	FailReturnURL    string     `json:"fail_return_url"`
-	ProjectID        *uuid.UUID `json:"project_id,omitempty"`
-	IntervalMonths   int        `json:"interval_months"`
-	Description      *string    `json:"description,omitempty"`
-}
-
-// SubscriptionIntentResponse represents a subscription intent response
-type SubscriptionIntentResponse struct {
-	Provider        string                 `json:"provider"`
-	RedirectURL     string                 `json:"redirect_url"`
-	ProviderPayload map[string]interface{} `json:"provider_payload"`
+// NewCloudPaymentsProvider creates a new CloudPayments provider. The API secret both
+// signs notifications and authenticates API calls; without it every notification is
+// rejected.
+func NewCloudPaymentsProvider(publicID, secret, baseURL, apiURL string) *CloudPaymentsProvider {
+	if apiURL == "" {
+		apiURL = DefaultCloudPaymentsAPIURL
+	}
+	return &CloudPaymentsProvider{
+		publicID:   publicID,
+		secret:     secret,
+		baseURL:    baseURL,
+		apiURL:     apiURL,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
 }
 
-// WebhookPayload represents a CloudPayments webhook payload
-type WebhookPayload struct {
-	Type           string  `json:"Type"`
-	TransactionID  string  `json:"TransactionId"`
-	Amount         float64 `json:"Amount"`
-	Currency       string  `json:"Currency"`
-	Status         string  `json:"Status"`
-	AccountID      string  `json:"AccountId"`
-	OccurredAt     string  `json:"OccurredAt"`
-	SubscriptionID *string `json:"SubscriptionId,omitempty"`
-	Reason         *string `json:"Reason,omitempty"`
+// Name returns the provider name
+func (p *CloudPaymentsProvider) Name() models.PaymentProvider {
+	return models.PaymentProviderCloudPayments
 }
 
-// CreatePaymentIntent creates a payment intent for one-time payment
-func (s *CloudPaymentsService) CreatePaymentIntent(req *PaymentIntentRequest, authUserID string) (*PaymentIntentResponse, error) {
-	// Create payment record
-	payment := &models.Payment{
-		ID:          uuid.New(),
-		Provider:    models.PaymentProviderCloudPayments,
-		AuthUserID:  &authUserID,
-		AmountMinor: req.AmountMinor,
-		Currency:    models.Currency(req.Currency),
-		Status:      models.PaymentStatusPending,
-		Meta: map[string]interface{}{
-			"success_return_url": req.SuccessReturnURL,
-			"fail_return_url":    req.FailReturnURL,
-			"description":        req.Description,
-			"project_id":         req.ProjectID,
-			"referral_user_id":   req.ReferralUserID,
-		},
-	}
-
-	if err := s.db.Create(payment).Error; err != nil {
-		return nil, fmt.Errorf("failed to create payment: %w", err)
-	}
-
+// CreatePaymentIntent builds the widget payload for a one-time payment
+func (p *CloudPaymentsProvider) CreatePaymentIntent(payment *models.Payment, req *PaymentIntentRequest) (*PaymentIntentResponse, error) {
 	// Generate redirect URL (in production, this would be the actual CloudPayments URL)
-	redirectURL := fmt.Sprintf("%s/pay/%s", s.baseURL, payment.ID.String())
+	redirectURL := fmt.Sprintf("%s/pay/%s", p.baseURL, payment.ID.String())
 
-	// Create provider payload
+	// Create provider payload; the widget takes the amount in major units
 	providerPayload := map[string]interface{}{
-		"publicId": s.publicID,
-		"amount":   req.AmountMinor,
+		"publicId": p.publicID,
+		"amount":   json.Number(FormatAmountMinor(req.AmountMinor)),
 		"currency": req.Currency,
 		"description": func() string {
 			if req.Description != nil {
@@ -120,42 +65,23 @@ func (s *CloudPaymentsService) CreatePaymentIntent(req *PaymentIntentRequest, au
 			}
 			return "Tree planting donation"
 		}(),
-		"accountId": authUserID,
+		"accountId": *payment.AuthUserID,
 		"paymentId": payment.ID.String(),
+		// CloudPayments echoes invoiceId back as InvoiceId in every notification
+		"invoiceId": payment.ID.String(),
 	}
 
 	return &PaymentIntentResponse{
-		Provider:        "cloudpayments",
+		Provider:        string(p.Name()),
 		RedirectURL:     redirectURL,
 		ProviderPayload: providerPayload,
 	}, nil
 }
 
-// CreateSubscriptionIntent creates a subscription intent for recurring payments
-func (s *CloudPaymentsService) CreateSubscriptionIntent(req *SubscriptionIntentRequest, authUserID string) (*SubscriptionIntentResponse, error) {
-	// Create subscription record
-	subscription := &models.Subscription{
-		ID:             uuid.New(),
-		AuthUserID:     authUserID,
-		Provider:       models.PaymentProviderCloudPayments,
-		AmountMinor:    req.AmountMinor,
-		Currency:       models.Currency(req.Currency),
-		IntervalMonths: req.IntervalMonths,
-		Status:         models.SubscriptionStatusIncomplete,
-		Meta: map[string]interface{}{
-			"success_return_url": req.SuccessReturnURL,
-			"fail_return_url":    req.FailReturnURL,
-			"project_id":         req.ProjectID,
-			"description":        req.Description,
-		},
-	}
-
-	if err := s.db.Create(subscription).Error; err != nil {
-		return nil, fmt.Errorf("failed to create subscription: %w", err)
-	}
-
+// CreateSubscriptionIntent builds the widget payload for a recurring payment
+func (p *CloudPaymentsProvider) CreateSubscriptionIntent(subscription *models.Subscription, req *SubscriptionIntentRequest) (*SubscriptionIntentResponse, error) {
 	// Generate redirect URL (in production, this would be the actual CloudPayments subscription URL)
-	redirectURL := fmt.Sprintf("%s/subscribe/%s", s.baseURL, subscription.ID.String())
+	redirectURL := fmt.Sprintf("%s/subscribe/%s", p.baseURL, subscription.ID.String())
 
 	// Determine interval description
 	intervalDesc := "monthly"
@@ -167,10 +93,10 @@ func (s *CloudPaymentsService) CreateSubscriptionIntent(req *SubscriptionIntentR
 		}
 	}
 
-	// Create provider payload
+	// Create provider payload; the widget takes the amount in major units
 	providerPayload := map[string]interface{}{
-		"publicId": s.publicID,
-		"amount":   req.AmountMinor,
+		"publicId": p.publicID,
+		"amount":   json.Number(FormatAmountMinor(req.AmountMinor)),
 		"currency": req.Currency,
 		"description": func() string {
 			if req.Description != nil {
@@ -178,240 +104,306 @@ func (s *CloudPaymentsService) CreateSubscriptionIntent(req *SubscriptionIntentR
 			}
 			return fmt.Sprintf("%s tree planting subscription", intervalDesc)
 		}(),
-		"accountId":      authUserID,
+		"accountId":      subscription.AuthUserID,
 		"subscriptionId": subscription.ID.String(),
+		"invoiceId":      subscription.ID.String(),
 		"interval":       intervalDesc,
 		"intervalMonths": req.IntervalMonths,
+		"data": map[string]interface{}{
+			"CloudPayments": map[string]interface{}{
+				"recurrent": map[string]interface{}{
+					"interval": "Month",
+					"period":   req.IntervalMonths,
+				},
+			},
+		},
 	}
 
 	return &SubscriptionIntentResponse{
-		Provider:        "cloudpayments",
+		Provider:        string(p.Name()),
 		RedirectURL:     redirectURL,
 		ProviderPayload: providerPayload,
 	}, nil
 }
 
-// ProcessWebhook processes a CloudPayments webhook
-func (s *CloudPaymentsService) ProcessWebhook(payload []byte, signature string) error {
-	// Verify signature if secret is provided
-	if s.secret != "" {
-		if !s.verifySignature(payload, signature) {
-			return fmt.Errorf("invalid signature")
-		}
+// VerifyWebhook checks the notification kind and its signature
+func (p *CloudPaymentsProvider) VerifyWebhook(req *WebhookRequest) error {
+	if !NotificationKind(req.Kind).IsValid() {
+		return fmt.Errorf("%w: %q", ErrUnsupportedWebhook, req.Kind)
 	}
-
-	// Parse webhook payload
-	var webhookPayload WebhookPayload
-	if err := json.Unmarshal(payload, &webhookPayload); err != nil {
-		return fmt.Errorf("failed to parse webhook payload: %w", err)
+	if p.secret == "" {
+		return fmt.Errorf("%w: CloudPayments secret is not configured", ErrInvalidSignature)
+	}
+	if !p.verifyNotificationSignature(req.Body, req.Header) {
+		return ErrInvalidSignature
 	}
+	return nil
+}
 
-	// Create webhook event record
-	webhookEvent := &models.WebhookEvent{
-		ID:               uuid.New(),
-		Provider:         models.PaymentProviderCloudPayments,
-		EventType:        webhookPayload.Type,
-		EventIdempotency: &webhookPayload.TransactionID,
-		RawPayload:       webhookPayload,
-		SignatureOK:      s.secret == "" || s.verifySignature(payload, signature),
+// ParseWebhook translates a CloudPayments notification into a WebhookEvent
+func (p *CloudPaymentsProvider) ParseWebhook(req *WebhookRequest) (*WebhookEvent, error) {
+	kind := NotificationKind(req.Kind)
+	notification, err := ParseNotification(kind, req.Body)
+	if err != nil {
+		return nil, err
 	}
 
-	// Check for duplicate events
-	var existingEvent models.WebhookEvent
-	if err := s.db.Where("event_idempotency = ?", webhookPayload.TransactionID).First(&existingEvent).Error; err == nil {
-		// Event already processed
-		webhookEvent.ProcessedOK = true
-		if err := s.db.Create(webhookEvent).Error; err != nil {
-			return fmt.Errorf("failed to create duplicate webhook event: %w", err)
-		}
-		return nil
+	event := &WebhookEvent{
+		Kind:           string(kind),
+		IdempotencyKey: notificationIdempotencyKey(kind, notification),
+		Raw:            notification,
 	}
 
-	// Process the webhook based on type
-	if err := s.processWebhookEvent(&webhookPayload); err != nil {
-		errStr := err.Error()
-		webhookEvent.ProcessingError = &errStr
-		if err := s.db.Create(webhookEvent).Error; err != nil {
-			return fmt.Errorf("failed to create webhook event: %w", err)
+	switch n := notification.(type) {
+	case *CheckNotification:
+		event.Type = EventPaymentCheck
+		if err := transactionEvent(event, &n.TransactionNotification); err != nil {
+			// Check must be answered with a code instead of being retried
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+		}
+	case *PayNotification:
+		event.Type = EventPaymentSucceeded
+		// Two-step payments are only authorized here and get captured by Confirm
+		if n.Status == "Authorized" {
+			event.Type = EventPaymentAuthorized
+		}
+		if err := transactionEvent(event, &n.TransactionNotification); err != nil {
+			return nil, err
+		}
+	case *ConfirmNotification:
+		event.Type = EventPaymentSucceeded
+		if err := transactionEvent(event, &n.TransactionNotification); err != nil {
+			return nil, err
+		}
+	case *FailNotification:
+		event.Type = EventPaymentFailed
+		// Declined charges are recorded even when the amount cannot be read
+		_ = transactionEvent(event, &n.TransactionNotification)
+		event.Meta = map[string]interface{}{
+			"failure_reason":      n.Reason,
+			"failure_reason_code": n.ReasonCode,
+		}
+	case *RefundNotification:
+		amountMinor, err := ParseAmountMinor(n.Amount)
+		if err != nil {
+			return nil, err
+		}
+		event.Type = EventPaymentRefunded
+		event.InvoiceID = n.InvoiceID
+		event.AccountID = n.AccountID
+		event.ProviderPaymentID = n.PaymentTransactionID
+		event.RefundID = n.TransactionID
+		event.AmountMinor = amountMinor
+		event.OccurredAt = n.DateTime
+	case *CancelNotification:
+		event.Type = EventPaymentCanceled
+		event.InvoiceID = n.InvoiceID
+		event.AccountID = n.AccountID
+		event.ProviderPaymentID = n.TransactionID
+		event.OccurredAt = n.DateTime
+	case *RecurrentNotification:
+		status, ok := SubscriptionStatusFromRecurrent(n.Status)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown subscription status %q", ErrInvalidPayload, n.Status)
+		}
+		event.Type = EventSubscriptionUpdated
+		event.AccountID = n.AccountID
+		event.ProviderSubscriptionID = n.ID
+		event.SubscriptionStatus = status
+		event.Meta = map[string]interface{}{
+			"successful_transactions": n.SuccessfulTransactionsNumber,
+			"failed_transactions":     n.FailedTransactionsNumber,
+			"next_transaction_date":   n.NextTransactionDate,
 		}
-		return fmt.Errorf("failed to process webhook: %w", err)
+	default:
+		return nil, fmt.Errorf("%w: unsupported notification %T", ErrInvalidPayload, notification)
 	}
 
-	webhookEvent.ProcessedOK = true
-	if err := s.db.Create(webhookEvent).Error; err != nil {
-		return fmt.Errorf("failed to create webhook event: %w", err)
-	}
+	return event, nil
+}
 
+// transactionEvent copies the fields of a transaction notification into an event
+func transactionEvent(event *WebhookEvent, n *TransactionNotification) error {
+	event.InvoiceID = n.InvoiceID
+	event.AccountID = n.AccountID
+	event.ProviderPaymentID = n.TransactionID
+	event.ProviderSubscriptionID = n.SubscriptionID
+	event.Currency = models.Currency(n.Currency)
+	event.OccurredAt = n.DateTime
+
+	amountMinor, err := ParseAmountMinor(n.Amount)
+	if err != nil {
+		return err
+	}
+	event.AmountMinor = amountMinor
 	return nil
 }
 
-// processWebhookEvent processes different types of webhook events
-func (s *CloudPaymentsService) processWebhookEvent(payload *WebhookPayload) error {
-	switch payload.Type {
-	case "Payment":
-		return s.processPaymentEvent(payload)
-	case "SubscriptionCharge":
-		return s.processSubscriptionChargeEvent(payload)
-	case "Refund":
-		return s.processRefundEvent(payload)
+// WebhookResponse answers a notification with a CloudPayments result code. Errors that
+// redelivery may fix are answered with a server error so that CloudPayments retries.
+func (p *CloudPaymentsProvider) WebhookResponse(event *WebhookEvent, err error) (int, interface{}) {
+	code, final := NotificationCode(err)
+	switch {
+	case errors.Is(err, ErrInvalidSignature):
+		return http.StatusUnauthorized, map[string]interface{}{"code": code}
+	case final:
+		return http.StatusOK, map[string]interface{}{"code": code}
 	default:
-		return fmt.Errorf("unknown webhook type: %s", payload.Type)
+		return http.StatusInternalServerError, map[string]interface{}{"error": "Failed to process webhook"}
 	}
 }
 
-// processPaymentEvent processes a payment event
-func (s *CloudPaymentsService) processPaymentEvent(payload *WebhookPayload) error {
-	if payload.Status != "Succeeded" {
-		return nil // Only process successful payments
-	}
+// cloudPaymentsResponse is the envelope of every CloudPayments API response
+type cloudPaymentsResponse struct {
+	Success bool            `json:"Success"`
+	Message *string         `json:"Message"`
+	Model   json.RawMessage `json:"Model"`
+}
 
-	// Find payment by transaction ID
-	var payment models.Payment
-	if err := s.db.Where("provider_payment_id = ?", payload.TransactionID).First(&payment).Error; err != nil {
-		return fmt.Errorf("payment not found: %w", err)
+// Refund refunds amountMinor of a captured payment through the CloudPayments API
+func (p *CloudPaymentsProvider) Refund(payment *models.Payment, amountMinor int64) (*RefundResult, error) {
+	if payment.ProviderPaymentID == nil {
+		return nil, fmt.Errorf("payment %s has no CloudPayments transaction", payment.ID)
+	}
+	transactionID, err := strconv.ParseInt(*payment.ProviderPaymentID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CloudPayments transaction ID %q", *payment.ProviderPaymentID)
 	}
 
-	// Update payment status
-	occurredAt, _ := time.Parse(time.RFC3339, payload.OccurredAt)
-	updates := map[string]interface{}{
-		"status":      models.PaymentStatusSucceeded,
-		"occurred_at": occurredAt,
-		"meta":        map[string]interface{}{"webhook_processed": true},
+	body, err := json.Marshal(map[string]interface{}{
+		"TransactionId": transactionID,
+		"Amount":        json.Number(FormatAmountMinor(amountMinor)),
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.db.Model(&payment).Updates(updates).Error; err != nil {
-		return fmt.Errorf("failed to update payment: %w", err)
+	var model struct {
+		TransactionID int64 `json:"TransactionId"`
+	}
+	if err := p.call("/payments/refund", body, &model); err != nil {
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
 	}
 
-	// Create donation
-	return s.createDonation(&payment)
+	return &RefundResult{ProviderRefundID: strconv.FormatInt(model.TransactionID, 10)}, nil
 }
 
-// processSubscriptionChargeEvent processes a subscription charge event
-func (s *CloudPaymentsService) processSubscriptionChargeEvent(payload *WebhookPayload) error {
-	if payload.Status != "Succeeded" {
-		return nil // Only process successful charges
-	}
-
-	// Find subscription
-	var subscription models.Subscription
-	if err := s.db.Where("provider_subscription_id = ?", payload.SubscriptionID).First(&subscription).Error; err != nil {
-		return fmt.Errorf("subscription not found: %w", err)
-	}
-
-	// Create payment record for this charge
-	occurredAt, _ := time.Parse(time.RFC3339, payload.OccurredAt)
-	amountMinor := int64(payload.Amount * 100) // Assuming amount is in major units
-	payment := &models.Payment{
-		ID:                uuid.New(),
-		Provider:          models.PaymentProviderCloudPayments,
-		ProviderPaymentID: &payload.TransactionID,
-		AuthUserID:        &subscription.AuthUserID,
-		SubscriptionID:    &subscription.ID,
-		AmountMinor:       amountMinor,
-		Currency:          subscription.Currency,
-		Status:            models.PaymentStatusSucceeded,
-		OccurredAt:        &occurredAt,
-		Meta: map[string]interface{}{
-			"subscription_charge": true,
-			"webhook_processed":   true,
-		},
+// UpdateSubscription cancels a CloudPayments subscription or changes its amount and
+// period. CloudPayments subscriptions cannot be paused.
+func (p *CloudPaymentsProvider) UpdateSubscription(subscription *models.Subscription, update *SubscriptionUpdate) (*SubscriptionUpdateResult, error) {
+	if subscription.ProviderSubscriptionID == nil {
+		return nil, fmt.Errorf("subscription %s has no CloudPayments subscription", subscription.ID)
+	}
+
+	var path string
+	request := map[string]interface{}{
+		"Id": *subscription.ProviderSubscriptionID,
+	}
+	switch update.Action {
+	case SubscriptionActionCancel:
+		path = "/subscriptions/cancel"
+	case SubscriptionActionChange:
+		path = "/subscriptions/update"
+		request["Amount"] = json.Number(FormatAmountMinor(update.AmountMinor))
+		request["Currency"] = string(subscription.Currency)
+		request["Interval"] = "Month"
+		request["Period"] = update.IntervalMonths
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSubscriptionChangeNotSupported, update.Action)
 	}
 
-	if err := s.db.Create(payment).Error; err != nil {
-		return fmt.Errorf("failed to create payment: %w", err)
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.call(path, body, nil); err != nil {
+		return nil, fmt.Errorf("failed to %s subscription: %w", update.Action, err)
 	}
+	return &SubscriptionUpdateResult{}, nil
+}
 
-	// Create donation
-	return s.createDonation(payment)
+// RetrySubscriptionCharge is not supported: CloudPayments retries failed recurring
+// charges on its own and reports them with the recurrent notification
+func (p *CloudPaymentsProvider) RetrySubscriptionCharge(subscription *models.Subscription) error {
+	return ErrSubscriptionChangeNotSupported
 }
 
-// processRefundEvent processes a refund event
-func (s *CloudPaymentsService) processRefundEvent(payload *WebhookPayload) error {
-	// Find payment by transaction ID
-	var payment models.Payment
-	if err := s.db.Where("provider_payment_id = ?", payload.TransactionID).First(&payment).Error; err != nil {
-		return fmt.Errorf("payment not found: %w", err)
+// call performs an authenticated CloudPayments API request and decodes the response model
+func (p *CloudPaymentsProvider) call(path string, body []byte, model interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, p.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
 	}
+	req.SetBasicAuth(p.publicID, p.secret)
+	req.Header.Set("Content-Type", "application/json")
 
-	// Update payment status to refunded
-	occurredAt, _ := time.Parse(time.RFC3339, payload.OccurredAt)
-	updates := map[string]interface{}{
-		"status":      models.PaymentStatusRefunded,
-		"occurred_at": occurredAt,
-		"meta":        map[string]interface{}{"refund_reason": payload.Reason, "webhook_processed": true},
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	return s.db.Model(&payment).Updates(updates).Error
-}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CloudPayments API returned status %d", resp.StatusCode)
+	}
 
-// createDonation creates a donation record and updates user counters
-func (s *CloudPaymentsService) createDonation(payment *models.Payment) error {
-	// Get tree price for the payment currency
-	var treePrice models.TreePrice
-	if err := s.db.Where("currency = ?", payment.Currency).First(&treePrice).Error; err != nil {
-		return fmt.Errorf("tree price not found for currency %s: %w", payment.Currency, err)
-	}
-
-	// Calculate trees count
-	treesCount := int(payment.AmountMinor / treePrice.PriceMinor)
-
-	// Get project ID from payment meta if available
-	var projectID *uuid.UUID
-	var referralUserID *string
-	if meta, ok := payment.Meta.(map[string]interface{}); ok {
-		if projectIDStr, exists := meta["project_id"]; exists && projectIDStr != nil {
-			if id, ok := projectIDStr.(string); ok {
-				if parsedID, err := uuid.Parse(id); err == nil {
-					projectID = &parsedID
-				}
-			}
-		}
-		// Get referral user ID from payment meta if available
-		if refUserID, exists := meta["referral_user_id"]; exists && refUserID != nil {
-			if id, ok := refUserID.(string); ok {
-				referralUserID = &id
-			}
+	var result cloudPaymentsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode CloudPayments response: %w", err)
+	}
+	if !result.Success {
+		message := "request declined"
+		if result.Message != nil && *result.Message != "" {
+			message = *result.Message
 		}
+		return fmt.Errorf("CloudPayments API: %s", message)
 	}
 
-	// Create donation in a transaction
-	return s.db.Transaction(func(tx *gorm.DB) error {
-		// Create donation
-		donation := &models.Donation{
-			ID:             uuid.New(),
-			AuthUserID:     *payment.AuthUserID,
-			PaymentID:      payment.ID,
-			ProjectID:      projectID,
-			ReferralUserID: referralUserID,
-			TreesCount:     treesCount,
+	if model != nil && len(result.Model) > 0 && string(result.Model) != "null" {
+		if err := json.Unmarshal(result.Model, model); err != nil {
+			return fmt.Errorf("failed to decode CloudPayments response: %w", err)
 		}
+	}
+	return nil
+}
 
-		if err := tx.Create(donation).Error; err != nil {
-			return fmt.Errorf("failed to create donation: %w", err)
-		}
+// SubscriptionStatusFromRecurrent maps a CloudPayments subscription status to ours
+func SubscriptionStatusFromRecurrent(status string) (models.SubscriptionStatus, bool) {
+	switch status {
+	case "Active":
+		return models.SubscriptionStatusActive, true
+	case "PastDue":
+		return models.SubscriptionStatusPastDue, true
+	case "Cancelled", "Rejected", "Expired":
+		return models.SubscriptionStatusCanceled, true
+	default:
+		return "", false
+	}
+}
 
-		// Update user counters
-		updates := map[string]interface{}{
-			"total_trees":      gorm.Expr("total_trees + ?", treesCount),
-			"donations_count":  gorm.Expr("donations_count + 1"),
-			"last_donation_at": time.Now(),
-		}
+// verifyNotificationSignature verifies the Content-HMAC header, falling back to
+// X-Content-HMAC which is computed over the URL-decoded payload
+func (p *CloudPaymentsProvider) verifyNotificationSignature(payload []byte, header http.Header) bool {
+	if signature := header.Get("Content-HMAC"); signature != "" {
+		return p.verifySignature(payload, signature)
+	}
 
-		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", *payment.AuthUserID).Updates(updates).Error; err != nil {
-			return fmt.Errorf("failed to update user counters: %w", err)
+	if signature := header.Get("X-Content-HMAC"); signature != "" {
+		decoded, err := url.QueryUnescape(string(payload))
+		if err != nil {
+			return false
 		}
+		return p.verifySignature([]byte(decoded), signature)
+	}
 
-		return nil
-	})
+	return false
 }
 
-// verifySignature verifies the webhook signature
-func (s *CloudPaymentsService) verifySignature(payload []byte, signature string) bool {
-	// Create HMAC-SHA256 hash
-	h := hmac.New(sha256.New, []byte(s.secret))
+// verifySignature verifies a base64 encoded HMAC-SHA256 signature of the payload
+func (p *CloudPaymentsProvider) verifySignature(payload []byte, signature string) bool {
+	h := hmac.New(sha256.New, []byte(p.secret))
 	h.Write(payload)
-	expectedSignature := hex.EncodeToString(h.Sum(nil))
+	expectedSignature := base64.StdEncoding.EncodeToString(h.Sum(nil))
 
-	return signature == expectedSignature
+	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
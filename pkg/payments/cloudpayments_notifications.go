@@ -0,0 +1,215 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// NotificationKind identifies a CloudPayments notification. CloudPayments posts every
+// kind to its own URL, e.g. /webhooks/cloudpayments/pay.
+type NotificationKind string
+
+const (
+	NotificationCheck     NotificationKind = "check"
+	NotificationPay       NotificationKind = "pay"
+	NotificationFail      NotificationKind = "fail"
+	NotificationConfirm   NotificationKind = "confirm"
+	NotificationRefund    NotificationKind = "refund"
+	NotificationRecurrent NotificationKind = "recurrent"
+	NotificationCancel    NotificationKind = "cancel"
+)
+
+// IsValid checks if the NotificationKind value is valid
+func (k NotificationKind) IsValid() bool {
+	switch k {
+	case NotificationCheck, NotificationPay, NotificationFail, NotificationConfirm,
+		NotificationRefund, NotificationRecurrent, NotificationCancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloudPayments notification result codes
+const (
+	CodeOK             = 0
+	CodeInvalidInvoice = 10
+	CodeInvalidAccount = 11
+	CodeInvalidAmount  = 12
+	CodeRejected       = 13
+	CodeExpired        = 20
+)
+
+// NotificationCode maps a processing error to a CloudPayments result code. The returned
+// flag reports whether the answer is final; otherwise the notification should be retried.
+func NotificationCode(err error) (int, bool) {
+	switch {
+	case err == nil:
+		return CodeOK, true
+	case errors.Is(err, ErrUnknownInvoice):
+		return CodeInvalidInvoice, true
+	case errors.Is(err, ErrAccountMismatch):
+		return CodeInvalidAccount, true
+	case errors.Is(err, ErrInvalidAmount):
+		return CodeInvalidAmount, true
+	case errors.Is(err, ErrPaymentRejected), errors.Is(err, ErrInvalidPayload), errors.Is(err, ErrInvalidSignature):
+		return CodeRejected, true
+	default:
+		return CodeRejected, false
+	}
+}
+
+// TransactionNotification holds the fields shared by Check, Pay, Fail and Confirm notifications
+type TransactionNotification struct {
+	TransactionID   string    `form:"TransactionId" json:"TransactionId"`
+	Amount          string    `form:"Amount" json:"Amount"`
+	Currency        string    `form:"Currency" json:"Currency"`
+	PaymentAmount   string    `form:"PaymentAmount" json:"PaymentAmount,omitempty"`
+	PaymentCurrency string    `form:"PaymentCurrency" json:"PaymentCurrency,omitempty"`
+	OperationType   string    `form:"OperationType" json:"OperationType,omitempty"`
+	DateTime        time.Time `form:"DateTime" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"DateTime"`
+	InvoiceID       string    `form:"InvoiceId" json:"InvoiceId,omitempty"`
+	AccountID       string    `form:"AccountId" json:"AccountId,omitempty"`
+	SubscriptionID  string    `form:"SubscriptionId" json:"SubscriptionId,omitempty"`
+	Name            string    `form:"Name" json:"Name,omitempty"`
+	Email           string    `form:"Email" json:"Email,omitempty"`
+	IPAddress       string    `form:"IpAddress" json:"IpAddress,omitempty"`
+	CardFirstSix    string    `form:"CardFirstSix" json:"CardFirstSix,omitempty"`
+	CardLastFour    string    `form:"CardLastFour" json:"CardLastFour,omitempty"`
+	CardType        string    `form:"CardType" json:"CardType,omitempty"`
+	CardExpDate     string    `form:"CardExpDate" json:"CardExpDate,omitempty"`
+	Issuer          string    `form:"Issuer" json:"Issuer,omitempty"`
+	Status          string    `form:"Status" json:"Status,omitempty"`
+	TestMode        bool      `form:"TestMode" json:"TestMode"`
+	Data            string    `form:"Data" json:"Data,omitempty"`
+}
+
+// CheckNotification is sent before a payment is authorized and may reject it
+type CheckNotification struct {
+	TransactionNotification
+}
+
+// PayNotification is sent after a payment succeeded
+type PayNotification struct {
+	TransactionNotification
+	Token string `form:"Token" json:"Token,omitempty"`
+}
+
+// FailNotification is sent after a payment was declined
+type FailNotification struct {
+	TransactionNotification
+	Reason     string `form:"Reason" json:"Reason,omitempty"`
+	ReasonCode int    `form:"ReasonCode" json:"ReasonCode"`
+}
+
+// ConfirmNotification is sent when a two-step payment is confirmed
+type ConfirmNotification struct {
+	TransactionNotification
+}
+
+// RefundNotification is sent after a payment was refunded, fully or partially
+type RefundNotification struct {
+	TransactionID        string    `form:"TransactionId" json:"TransactionId"`
+	PaymentTransactionID string    `form:"PaymentTransactionId" json:"PaymentTransactionId"`
+	Amount               string    `form:"Amount" json:"Amount"`
+	DateTime             time.Time `form:"DateTime" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"DateTime"`
+	OperationType        string    `form:"OperationType" json:"OperationType,omitempty"`
+	InvoiceID            string    `form:"InvoiceId" json:"InvoiceId,omitempty"`
+	AccountID            string    `form:"AccountId" json:"AccountId,omitempty"`
+	Email                string    `form:"Email" json:"Email,omitempty"`
+	Data                 string    `form:"Data" json:"Data,omitempty"`
+}
+
+// CancelNotification is sent when an authorized payment is voided
+type CancelNotification struct {
+	TransactionID string    `form:"TransactionId" json:"TransactionId"`
+	Amount        string    `form:"Amount" json:"Amount"`
+	DateTime      time.Time `form:"DateTime" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"DateTime"`
+	InvoiceID     string    `form:"InvoiceId" json:"InvoiceId,omitempty"`
+	AccountID     string    `form:"AccountId" json:"AccountId,omitempty"`
+	Email         string    `form:"Email" json:"Email,omitempty"`
+	Data          string    `form:"Data" json:"Data,omitempty"`
+}
+
+// RecurrentNotification is sent when a subscription changes its status
+type RecurrentNotification struct {
+	ID                           string     `form:"Id" json:"Id"`
+	AccountID                    string     `form:"AccountId" json:"AccountId"`
+	Description                  string     `form:"Description" json:"Description,omitempty"`
+	Email                        string     `form:"Email" json:"Email,omitempty"`
+	Amount                       string     `form:"Amount" json:"Amount"`
+	Currency                     string     `form:"Currency" json:"Currency"`
+	RequireConfirmation          bool       `form:"RequireConfirmation" json:"RequireConfirmation"`
+	StartDate                    time.Time  `form:"StartDate" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"StartDate"`
+	Interval                     string     `form:"Interval" json:"Interval"`
+	Period                       int        `form:"Period" json:"Period"`
+	Status                       string     `form:"Status" json:"Status"`
+	SuccessfulTransactionsNumber int        `form:"SuccessfulTransactionsNumber" json:"SuccessfulTransactionsNumber"`
+	FailedTransactionsNumber     int        `form:"FailedTransactionsNumber" json:"FailedTransactionsNumber"`
+	MaxPeriods                   *int       `form:"MaxPeriods" json:"MaxPeriods,omitempty"`
+	LastTransactionDate          *time.Time `form:"LastTransactionDate" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"LastTransactionDate,omitempty"`
+	NextTransactionDate          *time.Time `form:"NextTransactionDate" time_format:"2006-01-02 15:04:05" time_utc:"1" json:"NextTransactionDate,omitempty"`
+}
+
+// ParseNotification decodes a form-encoded CloudPayments notification of the given kind
+func ParseNotification(kind NotificationKind, payload []byte) (interface{}, error) {
+	form, err := url.ParseQuery(string(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	var notification interface{}
+	switch kind {
+	case NotificationCheck:
+		notification = &CheckNotification{}
+	case NotificationPay:
+		notification = &PayNotification{}
+	case NotificationFail:
+		notification = &FailNotification{}
+	case NotificationConfirm:
+		notification = &ConfirmNotification{}
+	case NotificationRefund:
+		notification = &RefundNotification{}
+	case NotificationRecurrent:
+		notification = &RecurrentNotification{}
+	case NotificationCancel:
+		notification = &CancelNotification{}
+	default:
+		return nil, fmt.Errorf("%w: unknown notification type %q", ErrInvalidPayload, kind)
+	}
+
+	if err := binding.MapFormWithTag(notification, form, "form"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	return notification, nil
+}
+
+// notificationIdempotencyKey returns the key used to deduplicate redelivered notifications.
+// Check notifications are never deduplicated because they must be answered every time.
+func notificationIdempotencyKey(kind NotificationKind, notification interface{}) *string {
+	var key string
+	switch n := notification.(type) {
+	case *PayNotification:
+		key = n.TransactionID
+	case *FailNotification:
+		key = n.TransactionID
+	case *ConfirmNotification:
+		key = n.TransactionID
+	case *RefundNotification:
+		key = n.TransactionID
+	case *CancelNotification:
+		key = n.TransactionID
+	case *RecurrentNotification:
+		key = fmt.Sprintf("%s:%s:%d:%d", n.ID, n.Status, n.SuccessfulTransactionsNumber, n.FailedTransactionsNumber)
+	default:
+		return nil
+	}
+
+	key = string(kind) + ":" + key
+	return &key
+}
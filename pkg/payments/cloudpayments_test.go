@@ -0,0 +1,396 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func sign(secret string, payload []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write(payload)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func TestParseNotification_Golden(t *testing.T) {
+	tests := []struct {
+		name string
+		kind NotificationKind
+	}{
+		{"check", NotificationCheck},
+		{"pay", NotificationPay},
+		{"pay_subscription", NotificationPay},
+		{"fail", NotificationFail},
+		{"confirm", NotificationConfirm},
+		{"refund", NotificationRefund},
+		{"recurrent", NotificationRecurrent},
+		{"cancel", NotificationCancel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "cloudpayments", tt.name+".form"))
+			require.NoError(t, err)
+
+			notification, err := ParseNotification(tt.kind, payload)
+			require.NoError(t, err)
+
+			got, err := json.MarshalIndent(notification, "", "  ")
+			require.NoError(t, err)
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "cloudpayments", tt.name+".golden")
+			if *update {
+				require.NoError(t, os.WriteFile(golden, got, 0o644))
+			}
+
+			want, err := os.ReadFile(golden)
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+func TestParseNotification_UnknownKind(t *testing.T) {
+	_, err := ParseNotification("unknown", []byte("TransactionId=1"))
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestParseNotification_InvalidField(t *testing.T) {
+	_, err := ParseNotification(NotificationPay, []byte("TransactionId=1&DateTime=yesterday"))
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestParseAmountMinor(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"190.00", 19000, false},
+		{"190", 19000, false},
+		{"190.5", 19050, false},
+		{"0.01", 1, false},
+		{"12.3400", 1234, false},
+		{"12.345", 0, true},
+		{"", 0, true},
+		{"-1.00", 0, true},
+		{"-1", 0, true},
+		{"-0.50", 0, true},
+		{"+1.00", 0, true},
+		{"1.-5", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := ParseAmountMinor(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPayload)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestVerifyNotificationSignature(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+	payload, err := os.ReadFile(filepath.Join("testdata", "cloudpayments", "pay.form"))
+	require.NoError(t, err)
+
+	header := http.Header{}
+	header.Set("Content-HMAC", sign("secret", payload))
+	assert.True(t, provider.verifyNotificationSignature(payload, header))
+
+	header.Set("Content-HMAC", sign("other-secret", payload))
+	assert.False(t, provider.verifyNotificationSignature(payload, header))
+
+	decoded, err := url.QueryUnescape(string(payload))
+	require.NoError(t, err)
+	header = http.Header{}
+	header.Set("X-Content-HMAC", sign("secret", []byte(decoded)))
+	assert.True(t, provider.verifyNotificationSignature(payload, header))
+
+	assert.False(t, provider.verifyNotificationSignature(payload, http.Header{}))
+}
+
+func TestCloudPaymentsVerifyWebhook(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+	payload := []byte("TransactionId=1")
+
+	err := provider.VerifyWebhook(&WebhookRequest{Kind: "pay", Body: payload, Header: http.Header{}})
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	err = provider.VerifyWebhook(&WebhookRequest{Kind: "unknown", Body: payload, Header: http.Header{}})
+	assert.ErrorIs(t, err, ErrUnsupportedWebhook)
+
+	header := http.Header{}
+	header.Set("Content-HMAC", sign("secret", payload))
+	assert.NoError(t, provider.VerifyWebhook(&WebhookRequest{Kind: "pay", Body: payload, Header: header}))
+
+	// Without a secret every notification is rejected, including ones signed with an empty key
+	unconfigured := NewCloudPaymentsProvider("public-id", "", "", "")
+	header.Set("Content-HMAC", sign("", payload))
+	err = unconfigured.VerifyWebhook(&WebhookRequest{Kind: "pay", Body: payload, Header: header})
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
+
+func TestCloudPaymentsParseWebhook(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+
+	tests := []struct {
+		name                   string
+		kind                   NotificationKind
+		expectedType           EventType
+		expectedAmountMinor    int64
+		expectedProviderID     string
+		expectedSubscriptionID string
+	}{
+		{"check", NotificationCheck, EventPaymentCheck, 19000, "504", ""},
+		{"pay", NotificationPay, EventPaymentSucceeded, 19000, "504", ""},
+		{"pay_subscription", NotificationPay, EventPaymentSucceeded, 50000, "731", "sc_8cf8a9338fb8ebf7202b08d09c938"},
+		{"fail", NotificationFail, EventPaymentFailed, 19000, "505", ""},
+		{"refund", NotificationRefund, EventPaymentRefunded, 9500, "504", ""},
+		{"recurrent", NotificationRecurrent, EventSubscriptionUpdated, 0, "", "sc_8cf8a9338fb8ebf7202b08d09c938"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", "cloudpayments", tt.name+".form"))
+			require.NoError(t, err)
+
+			event, err := provider.ParseWebhook(&WebhookRequest{Kind: string(tt.kind), Body: payload})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedType, event.Type)
+			assert.Equal(t, string(tt.kind), event.Kind)
+			assert.Equal(t, tt.expectedAmountMinor, event.AmountMinor)
+			assert.Equal(t, tt.expectedProviderID, event.ProviderPaymentID)
+			assert.Equal(t, tt.expectedSubscriptionID, event.ProviderSubscriptionID)
+		})
+	}
+}
+
+func TestCloudPaymentsParseWebhook_CheckInvalidAmount(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+
+	_, err := provider.ParseWebhook(&WebhookRequest{Kind: "check", Body: []byte("TransactionId=1&Amount=1.234")})
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestCloudPaymentsIntentAmountMatchesCheck(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+	authUserID := "user-1"
+	payment := &models.Payment{ID: uuid.New(), AuthUserID: &authUserID, AmountMinor: 19050, Currency: models.CurrencyRUB}
+	subscription := &models.Subscription{ID: uuid.New(), AuthUserID: authUserID, AmountMinor: 50000, Currency: models.CurrencyRUB}
+
+	intent, err := provider.CreatePaymentIntent(payment, &PaymentIntentRequest{AmountMinor: payment.AmountMinor, Currency: string(payment.Currency)})
+	require.NoError(t, err)
+	subscriptionIntent, err := provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{AmountMinor: subscription.AmountMinor, Currency: string(subscription.Currency), IntervalMonths: 1})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		payload     map[string]interface{}
+		amountMinor int64
+	}{
+		{"payment", intent.ProviderPayload, payment.AmountMinor},
+		{"subscription", subscriptionIntent.ProviderPayload, subscription.AmountMinor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// CloudPayments charges the widget amount and reports it back in the Check notification
+			form := url.Values{
+				"TransactionId": {"504"},
+				"Amount":        {fmt.Sprint(tt.payload["amount"])},
+				"Currency":      {"RUB"},
+				"InvoiceId":     {fmt.Sprint(tt.payload["invoiceId"])},
+			}
+			event, err := provider.ParseWebhook(&WebhookRequest{Kind: string(NotificationCheck), Body: []byte(form.Encode())})
+			require.NoError(t, err)
+			assert.NoError(t, checkAmount(event.AmountMinor, event.Currency, tt.amountMinor, models.CurrencyRUB))
+		})
+	}
+}
+
+func TestCloudPaymentsWebhookResponse(t *testing.T) {
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", "")
+
+	status, body := provider.WebhookResponse(nil, nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"code": CodeOK}, body)
+
+	status, body = provider.WebhookResponse(nil, ErrInvalidAmount)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"code": CodeInvalidAmount}, body)
+
+	status, body = provider.WebhookResponse(nil, ErrInvalidSignature)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, map[string]interface{}{"code": CodeRejected}, body)
+
+	status, _ = provider.WebhookResponse(nil, errors.New("connection refused"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+}
+
+func TestCloudPaymentsRefund(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/payments/refund", r.URL.Path)
+		user, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "public-id", user)
+		assert.Equal(t, "secret", password)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, float64(504), body["TransactionId"])
+		assert.Equal(t, 95.5, body["Amount"])
+
+		w.Write([]byte(`{"Success":true,"Message":null,"Model":{"TransactionId":811}}`))
+	}))
+	defer server.Close()
+
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", server.URL)
+	transactionID := "504"
+	result, err := provider.Refund(&models.Payment{ProviderPaymentID: &transactionID}, 9550)
+	require.NoError(t, err)
+	assert.Equal(t, "811", result.ProviderRefundID)
+}
+
+func TestCloudPaymentsRefund_Declined(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Success":false,"Message":"Refund amount exceeds payment amount"}`))
+	}))
+	defer server.Close()
+
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", server.URL)
+	transactionID := "504"
+	_, err := provider.Refund(&models.Payment{ProviderPaymentID: &transactionID}, 9550)
+	assert.ErrorContains(t, err, "Refund amount exceeds payment amount")
+}
+
+func TestNotificationCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		expectedCode  int
+		expectedFinal bool
+	}{
+		{"success", nil, CodeOK, true},
+		{"unknown invoice", ErrUnknownInvoice, CodeInvalidInvoice, true},
+		{"account mismatch", ErrAccountMismatch, CodeInvalidAccount, true},
+		{"invalid amount", ErrInvalidAmount, CodeInvalidAmount, true},
+		{"rejected", ErrPaymentRejected, CodeRejected, true},
+		{"invalid payload", ErrInvalidPayload, CodeRejected, true},
+		{"database error", errors.New("connection refused"), CodeRejected, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, final := NotificationCode(tt.err)
+			assert.Equal(t, tt.expectedCode, code)
+			assert.Equal(t, tt.expectedFinal, final)
+		})
+	}
+}
+
+func TestNotificationIdempotencyKey(t *testing.T) {
+	check := &CheckNotification{TransactionNotification{TransactionID: "504"}}
+	assert.Nil(t, notificationIdempotencyKey(NotificationCheck, check))
+
+	pay := &PayNotification{TransactionNotification: TransactionNotification{TransactionID: "504"}}
+	key := notificationIdempotencyKey(NotificationPay, pay)
+	require.NotNil(t, key)
+	assert.Equal(t, "pay:504", *key)
+}
+
+func TestSubscriptionStatusFromRecurrent(t *testing.T) {
+	tests := []struct {
+		input      string
+		expected   models.SubscriptionStatus
+		expectedOk bool
+	}{
+		{"Active", models.SubscriptionStatusActive, true},
+		{"PastDue", models.SubscriptionStatusPastDue, true},
+		{"Cancelled", models.SubscriptionStatusCanceled, true},
+		{"Rejected", models.SubscriptionStatusCanceled, true},
+		{"Expired", models.SubscriptionStatusCanceled, true},
+		{"Paused", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			status, ok := SubscriptionStatusFromRecurrent(tt.input)
+			assert.Equal(t, tt.expected, status)
+			assert.Equal(t, tt.expectedOk, ok)
+		})
+	}
+}
+
+func TestCheckAmount(t *testing.T) {
+	assert.NoError(t, checkAmount(19000, models.CurrencyRUB, 19000, models.CurrencyRUB))
+	assert.NoError(t, checkAmount(19000, "", 19000, models.CurrencyRUB))
+	assert.ErrorIs(t, checkAmount(100, models.CurrencyRUB, 19000, models.CurrencyRUB), ErrInvalidAmount)
+	assert.ErrorIs(t, checkAmount(19000, models.CurrencyUSD, 19000, models.CurrencyRUB), ErrInvalidAmount)
+	assert.ErrorIs(t, checkAmount(0, models.CurrencyRUB, 0, models.CurrencyRUB), ErrInvalidAmount)
+}
+
+func TestMetaMap(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{}, metaMap(nil))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, metaMap(map[string]interface{}{"a": "b"}))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, metaMap([]byte(`{"a":"b"}`)))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, metaMap(`{"a":"b"}`))
+}
+
+func TestCloudPaymentsUpdateSubscription(t *testing.T) {
+	var requests []map[string]interface{}
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		paths = append(paths, r.URL.Path)
+		requests = append(requests, body)
+		w.Write([]byte(`{"Success":true,"Message":null}`))
+	}))
+	defer server.Close()
+
+	provider := NewCloudPaymentsProvider("public-id", "secret", "", server.URL)
+	providerSubscriptionID := "sc_8cf8a9338fb8ebf7202b08d09c938"
+	subscription := &models.Subscription{ProviderSubscriptionID: &providerSubscriptionID, Currency: models.CurrencyRUB}
+
+	_, err := provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionChange, AmountMinor: 38000, IntervalMonths: 3})
+	require.NoError(t, err)
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionCancel})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"/subscriptions/update", "/subscriptions/cancel"}, paths)
+	assert.Equal(t, map[string]interface{}{
+		"Id":       providerSubscriptionID,
+		"Amount":   float64(380),
+		"Currency": "RUB",
+		"Interval": "Month",
+		"Period":   float64(3),
+	}, requests[0])
+	assert.Equal(t, map[string]interface{}{"Id": providerSubscriptionID}, requests[1])
+
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionPause})
+	assert.ErrorIs(t, err, ErrSubscriptionChangeNotSupported)
+}
@@ -0,0 +1,141 @@
+package payments
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of credit ledger entries
+const (
+	// CreditEntryRemainder adds the part of a donation that did not buy a whole tree
+	CreditEntryRemainder = "remainder"
+	// CreditEntryApplied uses up credit towards the trees of a donation
+	CreditEntryApplied = "applied"
+	// CreditEntryReversed undoes the credit changes of a refunded donation
+	CreditEntryReversed = "reversed"
+)
+
+// splitDonation returns how many trees an amount plus the donor's credit buys and the
+// credit that is left over
+func splitDonation(amountMinor, creditMinor, priceMinor int64) (trees int, remainderMinor int64) {
+	available := amountMinor + creditMinor
+	return int(available / priceMinor), available % priceMinor
+}
+
+// lockCreditBalance returns the credit balance of a user in a currency, locked until the
+// end of the transaction. Users without a balance get an empty one.
+func lockCreditBalance(tx *gorm.DB, authUserID string, currency models.Currency) (*models.CreditBalance, error) {
+	balance := &models.CreditBalance{
+		AuthUserID: authUserID,
+		Currency:   currency,
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(balance).Error; err != nil {
+		return nil, fmt.Errorf("failed to create credit balance: %w", err)
+	}
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("auth_user_id = ? AND currency = ?", authUserID, currency).
+		First(balance).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock credit balance: %w", err)
+	}
+	return balance, nil
+}
+
+// setCreditBalance moves a locked credit balance to balanceMinor and records the change
+// in the ledger. Credit used up and credit added by a donation are recorded separately.
+func setCreditBalance(tx *gorm.DB, balance *models.CreditBalance, donationID *uuid.UUID, usedMinor, addedMinor int64, kind string) error {
+	entries := make([]models.CreditEntry, 0, 2)
+	if usedMinor > 0 {
+		entries = append(entries, models.CreditEntry{
+			ID:          uuid.New(),
+			AuthUserID:  balance.AuthUserID,
+			Currency:    balance.Currency,
+			AmountMinor: -usedMinor,
+			Kind:        CreditEntryApplied,
+			DonationID:  donationID,
+		})
+	}
+	if addedMinor != 0 {
+		entries = append(entries, models.CreditEntry{
+			ID:          uuid.New(),
+			AuthUserID:  balance.AuthUserID,
+			Currency:    balance.Currency,
+			AmountMinor: addedMinor,
+			Kind:        kind,
+			DonationID:  donationID,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := tx.Create(&entries).Error; err != nil {
+		return fmt.Errorf("failed to record credit entries: %w", err)
+	}
+	balance.BalanceMinor += addedMinor - usedMinor
+	updates := map[string]interface{}{
+		"balance_minor": balance.BalanceMinor,
+		"updated_at":    time.Now(),
+	}
+	err := tx.Model(&models.CreditBalance{}).
+		Where("auth_user_id = ? AND currency = ?", balance.AuthUserID, balance.Currency).
+		Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to update credit balance: %w", err)
+	}
+	return nil
+}
+
+// donationRemainder returns the credit a donation added because its amount did not buy
+// a whole tree
+func donationRemainder(tx *gorm.DB, donationID uuid.UUID) (int64, error) {
+	var remainderMinor int64
+	err := tx.Model(&models.CreditEntry{}).Where("donation_id = ? AND kind = ?", donationID, CreditEntryRemainder).
+		Select("COALESCE(SUM(amount_minor), 0)").Scan(&remainderMinor).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to sum credit entries: %w", err)
+	}
+	return remainderMinor, nil
+}
+
+// reverseRefundedRemainder takes back the part of a donation's remainder credit that a
+// partial refund returned to the donor. Like for full refunds, the balance never drops
+// below zero.
+func reverseRefundedRemainder(tx *gorm.DB, donation *models.Donation, currency models.Currency, refundedMinor int64) error {
+	balance, err := lockCreditBalance(tx, donation.AuthUserID, currency)
+	if err != nil {
+		return err
+	}
+	reversal := min(refundedMinor, balance.BalanceMinor)
+	return setCreditBalance(tx, balance, &donation.ID, 0, -reversal, CreditEntryReversed)
+}
+
+// reverseDonationCredit undoes the credit changes of a fully refunded donation: the
+// remainder it added is taken back and the credit it used is returned. The balance never
+// drops below zero; credit that was spent on later donations stays spent.
+func reverseDonationCredit(tx *gorm.DB, donation *models.Donation, currency models.Currency) error {
+	var netMinor int64
+	err := tx.Model(&models.CreditEntry{}).Where("donation_id = ?", donation.ID).
+		Select("COALESCE(SUM(amount_minor), 0)").Scan(&netMinor).Error
+	if err != nil {
+		return fmt.Errorf("failed to sum credit entries: %w", err)
+	}
+	if netMinor == 0 {
+		return nil
+	}
+
+	balance, err := lockCreditBalance(tx, donation.AuthUserID, currency)
+	if err != nil {
+		return err
+	}
+	reversal := -netMinor
+	if balance.BalanceMinor+reversal < 0 {
+		reversal = -balance.BalanceMinor
+	}
+	return setCreditBalance(tx, balance, &donation.ID, 0, reversal, CreditEntryReversed)
+}
@@ -0,0 +1,30 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDonation(t *testing.T) {
+	tests := []struct {
+		name          string
+		amountMinor   int64
+		creditMinor   int64
+		wantTrees     int
+		wantRemainder int64
+	}{
+		{"exact amount", 200000, 0, 2, 0},
+		{"remainder is kept", 150000, 0, 1, 50000},
+		{"credit completes a tree", 150000, 50000, 2, 0},
+		{"credit carries over", 50000, 30000, 0, 80000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trees, remainder := splitDonation(tt.amountMinor, tt.creditMinor, 100000)
+			assert.Equal(t, tt.wantTrees, trees)
+			assert.Equal(t, tt.wantRemainder, remainder)
+		})
+	}
+}
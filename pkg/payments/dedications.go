@@ -0,0 +1,111 @@
+package payments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/4planet/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// Limits of dedication fields, in characters
+const (
+	maxRecipientNameLength = 100
+	maxDedicationMessage   = 500
+)
+
+// Dedication dedicates a donation to someone as a gift. The recipient is emailed a tree
+// certificate and can claim the trees onto their own profile.
+type Dedication struct {
+	RecipientName  string  `json:"recipient_name"`
+	RecipientEmail string  `json:"recipient_email"`
+	Message        *string `json:"message,omitempty"`
+}
+
+// validateDedication records the invalid fields of a dedication
+func validateDedication(errs *ValidationError, dedication *Dedication) {
+	name := strings.TrimSpace(dedication.RecipientName)
+	switch {
+	case name == "":
+		errs.Add("dedication.recipient_name", FieldErrorRequired, "Recipient name is required")
+	case utf8.RuneCountInString(name) > maxRecipientNameLength:
+		errs.Add("dedication.recipient_name", FieldErrorTooLarge, fmt.Sprintf("Recipient name must not exceed %d characters", maxRecipientNameLength))
+	}
+
+	email := strings.TrimSpace(dedication.RecipientEmail)
+	if email == "" {
+		errs.Add("dedication.recipient_email", FieldErrorRequired, "Recipient email is required")
+	} else if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		errs.Add("dedication.recipient_email", FieldErrorInvalid, "Recipient email is not a valid email address")
+	}
+
+	if dedication.Message != nil && utf8.RuneCountInString(*dedication.Message) > maxDedicationMessage {
+		errs.Add("dedication.message", FieldErrorTooLarge, fmt.Sprintf("Message must not exceed %d characters", maxDedicationMessage))
+	}
+}
+
+// dedicationFromMeta returns the dedication stored in the meta of a payment, if any
+func dedicationFromMeta(meta map[string]interface{}) *Dedication {
+	value, ok := meta["dedication"]
+	if !ok || value == nil {
+		return nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var dedication Dedication
+	if err := json.Unmarshal(raw, &dedication); err != nil || dedication.RecipientEmail == "" {
+		return nil
+	}
+	return &dedication
+}
+
+// createDedication stores the dedication of a donation with a new certificate token. The
+// certificate email is sent later by the dedications worker.
+func createDedication(tx *gorm.DB, donation *models.Donation, dedication *Dedication) error {
+	token, err := newCertificateToken()
+	if err != nil {
+		return err
+	}
+	record := &models.Dedication{
+		DonationID:       donation.ID,
+		RecipientName:    strings.TrimSpace(dedication.RecipientName),
+		RecipientEmail:   strings.TrimSpace(dedication.RecipientEmail),
+		Message:          dedication.Message,
+		CertificateToken: token,
+	}
+	if err := tx.Create(record).Error; err != nil {
+		return fmt.Errorf("failed to create dedication: %w", err)
+	}
+	donation.Dedication = record
+	return nil
+}
+
+// newCertificateToken generates the secret of a certificate link
+func newCertificateToken() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate certificate token: %w", err)
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+// treeOwner returns the user whose profile counts the trees of a donation: the recipient
+// of a claimed gift, otherwise the donor
+func treeOwner(tx *gorm.DB, donation *models.Donation) (string, error) {
+	var dedication models.Dedication
+	err := tx.Where("donation_id = ? AND claimed_by_auth_user_id IS NOT NULL", donation.ID).Limit(1).Find(&dedication).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to find dedication: %w", err)
+	}
+	if dedication.ClaimedByAuthUserID != nil {
+		return *dedication.ClaimedByAuthUserID, nil
+	}
+	return donation.AuthUserID, nil
+}
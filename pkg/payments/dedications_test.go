@@ -0,0 +1,81 @@
+package payments
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDedication(t *testing.T) {
+	longMessage := strings.Repeat("я", maxDedicationMessage+1)
+	okMessage := strings.Repeat("я", maxDedicationMessage)
+
+	tests := []struct {
+		name       string
+		dedication Dedication
+		wantFields []string
+	}{
+		{"valid", Dedication{RecipientName: "Anna", RecipientEmail: "anna@example.com"}, nil},
+		{"valid with message", Dedication{RecipientName: "Anna", RecipientEmail: "anna@example.com", Message: &okMessage}, nil},
+		{"missing name", Dedication{RecipientName: "  ", RecipientEmail: "anna@example.com"}, []string{"dedication.recipient_name"}},
+		{"long name", Dedication{RecipientName: strings.Repeat("a", maxRecipientNameLength+1), RecipientEmail: "anna@example.com"}, []string{"dedication.recipient_name"}},
+		{"missing email", Dedication{RecipientName: "Anna"}, []string{"dedication.recipient_email"}},
+		{"invalid email", Dedication{RecipientName: "Anna", RecipientEmail: "anna"}, []string{"dedication.recipient_email"}},
+		{"email with name", Dedication{RecipientName: "Anna", RecipientEmail: "Anna <anna@example.com>"}, []string{"dedication.recipient_email"}},
+		{"long message", Dedication{RecipientName: "Anna", RecipientEmail: "anna@example.com", Message: &longMessage}, []string{"dedication.message"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := &ValidationError{}
+			validateDedication(errs, &tt.dedication)
+
+			var fields []string
+			for _, field := range errs.Fields {
+				fields = append(fields, field.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+}
+
+func TestDedicationFromMeta(t *testing.T) {
+	message := "Happy birthday!"
+
+	stored := dedicationFromMeta(map[string]interface{}{
+		"dedication": map[string]interface{}{
+			"recipient_name":  "Anna",
+			"recipient_email": "anna@example.com",
+			"message":         message,
+		},
+	})
+	if assert.NotNil(t, stored) {
+		assert.Equal(t, "Anna", stored.RecipientName)
+		assert.Equal(t, "anna@example.com", stored.RecipientEmail)
+		assert.Equal(t, &message, stored.Message)
+	}
+
+	// Meta of a payment created in this process still holds the request value
+	fresh := dedicationFromMeta(map[string]interface{}{
+		"dedication": &Dedication{RecipientName: "Anna", RecipientEmail: "anna@example.com"},
+	})
+	if assert.NotNil(t, fresh) {
+		assert.Equal(t, "anna@example.com", fresh.RecipientEmail)
+	}
+
+	var none *Dedication
+	assert.Nil(t, dedicationFromMeta(map[string]interface{}{}))
+	assert.Nil(t, dedicationFromMeta(map[string]interface{}{"dedication": nil}))
+	assert.Nil(t, dedicationFromMeta(map[string]interface{}{"dedication": none}))
+}
+
+func TestNewCertificateToken(t *testing.T) {
+	first, err := newCertificateToken()
+	assert.NoError(t, err)
+	second, err := newCertificateToken()
+	assert.NoError(t, err)
+
+	assert.Len(t, first, 48)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,166 @@
+package payments
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// dunningBatchSize limits how many past due subscriptions are handed out at once
+const dunningBatchSize = 100
+
+// dunningClaimDuration is how long a claimed subscription is withheld from other workers.
+// A worker that stopped before scheduling the next step leaves it to be claimed again.
+const dunningClaimDuration = 10 * time.Minute
+
+// ClaimDueDunningSubscriptions returns past due subscriptions whose next dunning step is
+// due and claims them for the caller. Every instance runs the dunning worker; rows another
+// worker is claiming are skipped, and claimed rows are not due again until the claim runs
+// out or ScheduleDunning sets their next step.
+func (s *Service) ClaimDueDunningSubscriptions(now time.Time) ([]models.Subscription, error) {
+	var subscriptions []models.Subscription
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_dunning_at <= ?", models.SubscriptionStatusPastDue, now).
+			Order("next_dunning_at ASC").
+			Limit(dunningBatchSize).
+			Find(&subscriptions).Error
+		if err != nil || len(subscriptions) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(subscriptions))
+		for i := range subscriptions {
+			ids[i] = subscriptions[i].ID
+		}
+		err = tx.Model(&models.Subscription{}).Where("id IN ?", ids).
+			Update("next_dunning_at", now.Add(dunningClaimDuration)).Error
+		if err != nil {
+			return fmt.Errorf("failed to claim subscriptions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// ScheduleDunning records that a dunning step was taken and when the next one is due.
+// Nothing is stored when the subscription left the past due status in the meantime.
+func (s *Service) ScheduleDunning(subscription *models.Subscription, step int, next time.Time) error {
+	updates := map[string]interface{}{
+		"dunning_step":    step,
+		"next_dunning_at": next,
+	}
+	err := s.db.Model(&models.Subscription{}).
+		Where("id = ? AND status = ?", subscription.ID, models.SubscriptionStatusPastDue).
+		Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to schedule dunning: %w", err)
+	}
+	subscription.DunningStep = step
+	subscription.NextDunningAt = &next
+	return nil
+}
+
+// RetrySubscriptionCharge asks the provider to charge a past due subscription again. The
+// outcome arrives by webhook. Providers that retry on their own schedule return
+// ErrSubscriptionChangeNotSupported.
+func (s *Service) RetrySubscriptionCharge(subscription *models.Subscription) error {
+	if subscription.ProviderSubscriptionID == nil {
+		return ErrSubscriptionChangeNotSupported
+	}
+	provider, err := s.registry.Get(subscription.Provider)
+	if err != nil {
+		return err
+	}
+	return provider.RetrySubscriptionCharge(subscription)
+}
+
+// CancelUnpaidSubscription cancels a past due subscription at the provider and here after
+// dunning gave up on it. The change is audited without an actor. A subscription that is
+// no longer past due, for example because a retry went through, is left alone with
+// ErrSubscriptionTransition.
+func (s *Service) CancelUnpaidSubscription(subscription *models.Subscription, reason string) error {
+	_, err := s.applySubscriptionUpdate(subscription, &SubscriptionUpdate{
+		Action: SubscriptionActionCancel,
+		Reason: &reason,
+	}, nil, models.SubscriptionStatusPastDue)
+	return err
+}
+
+// recordFailedCharge counts a declined recurring charge. An active subscription becomes
+// past due and enters dunning.
+func recordFailedCharge(tx *gorm.DB, subscription *models.Subscription, now time.Time) error {
+	status := subscription.Status
+	if status == models.SubscriptionStatusActive {
+		status = models.SubscriptionStatusPastDue
+	}
+
+	updates := dunningUpdates(subscription, status, now)
+	updates["failed_charges"] = gorm.Expr("failed_charges + 1")
+	if status != subscription.Status {
+		updates["status"] = status
+		if err := recordProviderStatusChange(tx, subscription, status); err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(subscription).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update subscription: %w", err)
+	}
+	return nil
+}
+
+// dunningUpdates returns the dunning columns that change when a subscription moves to
+// status. Becoming past due starts dunning right away; becoming active again ends it and
+// forgets the failed charges; pausing or canceling stops it.
+func dunningUpdates(subscription *models.Subscription, status models.SubscriptionStatus, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{}
+	switch status {
+	case models.SubscriptionStatusPastDue:
+		if subscription.PastDueSince == nil {
+			updates["past_due_since"] = now
+			updates["dunning_step"] = 0
+			updates["next_dunning_at"] = now
+		}
+	case models.SubscriptionStatusActive:
+		if subscription.PastDueSince != nil || subscription.FailedCharges > 0 {
+			updates["failed_charges"] = 0
+			updates["past_due_since"] = nil
+			updates["dunning_step"] = 0
+			updates["next_dunning_at"] = nil
+		}
+	case models.SubscriptionStatusPaused, models.SubscriptionStatusCanceled:
+		if subscription.NextDunningAt != nil {
+			updates["next_dunning_at"] = nil
+		}
+	}
+	return updates
+}
+
+// settleSubscription makes a past due subscription active again after a successful charge
+func (s *Service) settleSubscription(subscription *models.Subscription) error {
+	status := models.SubscriptionStatusActive
+	if subscription.Status != models.SubscriptionStatusPastDue {
+		status = subscription.Status
+	}
+
+	updates := dunningUpdates(subscription, models.SubscriptionStatusActive, time.Now())
+	updates["status"] = status
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if status != subscription.Status {
+			if err := recordProviderStatusChange(tx, subscription, status); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(subscription).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update subscription: %w", err)
+		}
+		return nil
+	})
+}
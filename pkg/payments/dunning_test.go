@@ -0,0 +1,43 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDunningUpdates(t *testing.T) {
+	now := time.Now()
+	since := now.Add(-48 * time.Hour)
+
+	// Becoming past due starts dunning right away
+	active := &models.Subscription{Status: models.SubscriptionStatusActive}
+	updates := dunningUpdates(active, models.SubscriptionStatusPastDue, now)
+	assert.Equal(t, now, updates["past_due_since"])
+	assert.Equal(t, now, updates["next_dunning_at"])
+	assert.Equal(t, 0, updates["dunning_step"])
+
+	// Further failures keep the original schedule
+	pastDue := &models.Subscription{
+		Status:        models.SubscriptionStatusPastDue,
+		FailedCharges: 2,
+		PastDueSince:  &since,
+		DunningStep:   1,
+		NextDunningAt: &now,
+	}
+	assert.Empty(t, dunningUpdates(pastDue, models.SubscriptionStatusPastDue, now))
+
+	// A successful charge ends dunning
+	updates = dunningUpdates(pastDue, models.SubscriptionStatusActive, now)
+	assert.Equal(t, 0, updates["failed_charges"])
+	assert.Contains(t, updates, "past_due_since")
+	assert.Nil(t, updates["past_due_since"])
+	assert.Nil(t, updates["next_dunning_at"])
+
+	// Canceling stops dunning
+	updates = dunningUpdates(pastDue, models.SubscriptionStatusCanceled, now)
+	assert.Contains(t, updates, "next_dunning_at")
+	assert.NotContains(t, updates, "past_due_since")
+}
@@ -0,0 +1,294 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// FakeSignatureHeader carries the hex HMAC-SHA256 of a fake provider webhook body
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// Fake checkout actions offered on the hosted test checkout page
+const (
+	FakeActionSucceed = "succeed"
+	FakeActionFail    = "fail"
+	FakeActionRefund  = "refund"
+	FakeActionCancel  = "cancel"
+)
+
+// FakePaymentActions returns the checkout actions available for a one-time payment in the given status
+func FakePaymentActions(status models.PaymentStatus) []string {
+	switch status {
+	case models.PaymentStatusPending:
+		return []string{FakeActionSucceed, FakeActionFail, FakeActionCancel}
+	case models.PaymentStatusSucceeded:
+		return []string{FakeActionRefund}
+	default:
+		return nil
+	}
+}
+
+// FakeSubscriptionActions returns the checkout actions available for a subscription in the given status
+func FakeSubscriptionActions(status models.SubscriptionStatus) []string {
+	switch status {
+	case models.SubscriptionStatusIncomplete:
+		return []string{FakeActionSucceed, FakeActionFail}
+	case models.SubscriptionStatusCanceled:
+		return nil
+	default:
+		return []string{FakeActionSucceed, FakeActionFail, FakeActionCancel}
+	}
+}
+
+// FakeProvider is a payment provider for local development and end-to-end tests. It
+// charges nobody: the donor picks the outcome on a checkout page served by the API
+// itself, which turns the choice into a signed webhook processed like any other.
+type FakeProvider struct {
+	baseURL string
+	secret  string
+}
+
+// NewFakeProvider creates a new fake provider whose checkout pages are served under baseURL
+func NewFakeProvider(baseURL, secret string) *FakeProvider {
+	return &FakeProvider{
+		baseURL: baseURL,
+		secret:  secret,
+	}
+}
+
+// Name returns the provider name
+func (p *FakeProvider) Name() models.PaymentProvider {
+	return models.PaymentProviderFake
+}
+
+// CreatePaymentIntent points the donor at the hosted test checkout page
+func (p *FakeProvider) CreatePaymentIntent(payment *models.Payment, req *PaymentIntentRequest) (*PaymentIntentResponse, error) {
+	checkoutURL := fmt.Sprintf("%s/pay/%s", p.baseURL, payment.ID.String())
+
+	return &PaymentIntentResponse{
+		Provider:    string(p.Name()),
+		RedirectURL: checkoutURL,
+		ProviderPayload: map[string]interface{}{
+			"paymentId":   payment.ID.String(),
+			"checkoutUrl": checkoutURL,
+		},
+	}, nil
+}
+
+// CreateSubscriptionIntent points the donor at the hosted test subscription page
+func (p *FakeProvider) CreateSubscriptionIntent(subscription *models.Subscription, req *SubscriptionIntentRequest) (*SubscriptionIntentResponse, error) {
+	checkoutURL := fmt.Sprintf("%s/subscribe/%s", p.baseURL, subscription.ID.String())
+	providerSubscriptionID := "fake_sub_" + subscription.ID.String()
+	subscription.ProviderSubscriptionID = &providerSubscriptionID
+
+	return &SubscriptionIntentResponse{
+		Provider:    string(p.Name()),
+		RedirectURL: checkoutURL,
+		ProviderPayload: map[string]interface{}{
+			"subscriptionId": providerSubscriptionID,
+			"checkoutUrl":    checkoutURL,
+		},
+	}, nil
+}
+
+// FakeWebhook is the body of a fake provider webhook. Type is one of the provider
+// independent event types.
+type FakeWebhook struct {
+	ID                     string                    `json:"id"`
+	Type                   EventType                 `json:"type"`
+	PaymentID              string                    `json:"payment_id,omitempty"`
+	TransactionID          string                    `json:"transaction_id,omitempty"`
+	ProviderSubscriptionID string                    `json:"subscription_id,omitempty"`
+	SubscriptionStatus     models.SubscriptionStatus `json:"subscription_status,omitempty"`
+	RefundID               string                    `json:"refund_id,omitempty"`
+	AmountMinor            int64                     `json:"amount_minor"`
+	Currency               models.Currency           `json:"currency"`
+	OccurredAt             time.Time                 `json:"occurred_at"`
+}
+
+// fakeEventTypes are the event types a fake provider webhook may carry
+var fakeEventTypes = map[EventType]bool{
+	EventPaymentSucceeded:    true,
+	EventPaymentFailed:       true,
+	EventPaymentCanceled:     true,
+	EventPaymentRefunded:     true,
+	EventSubscriptionUpdated: true,
+}
+
+// PaymentWebhook builds the signed webhook a checkout action on a one-time payment produces
+func (p *FakeProvider) PaymentWebhook(payment *models.Payment, action string) (*WebhookRequest, error) {
+	webhook := &FakeWebhook{
+		ID:          uuid.New().String(),
+		PaymentID:   payment.ID.String(),
+		AmountMinor: payment.AmountMinor,
+		Currency:    payment.Currency,
+		OccurredAt:  time.Now().UTC(),
+	}
+
+	switch action {
+	case FakeActionSucceed:
+		webhook.Type = EventPaymentSucceeded
+		webhook.TransactionID = "fake_txn_" + uuid.New().String()
+	case FakeActionFail:
+		webhook.Type = EventPaymentFailed
+	case FakeActionCancel:
+		webhook.Type = EventPaymentCanceled
+	case FakeActionRefund:
+		if payment.ProviderPaymentID == nil {
+			return nil, fmt.Errorf("payment %s has not been paid", payment.ID)
+		}
+		webhook.Type = EventPaymentRefunded
+		webhook.TransactionID = *payment.ProviderPaymentID
+		webhook.RefundID = "fake_refund_" + uuid.New().String()
+	default:
+		return nil, fmt.Errorf("unknown checkout action %q", action)
+	}
+
+	return p.SignedWebhook(webhook)
+}
+
+// SubscriptionWebhook builds the signed webhook a checkout action on a subscription
+// produces: succeed charges the next period, fail declines it and cancel ends the subscription
+func (p *FakeProvider) SubscriptionWebhook(subscription *models.Subscription, action string) (*WebhookRequest, error) {
+	if subscription.ProviderSubscriptionID == nil {
+		return nil, fmt.Errorf("subscription %s was not created by the fake provider", subscription.ID)
+	}
+
+	webhook := &FakeWebhook{
+		ID:                     uuid.New().String(),
+		ProviderSubscriptionID: *subscription.ProviderSubscriptionID,
+		AmountMinor:            subscription.AmountMinor,
+		Currency:               subscription.Currency,
+		OccurredAt:             time.Now().UTC(),
+	}
+
+	switch action {
+	case FakeActionSucceed:
+		webhook.Type = EventPaymentSucceeded
+		webhook.TransactionID = "fake_txn_" + uuid.New().String()
+	case FakeActionFail:
+		webhook.Type = EventPaymentFailed
+	case FakeActionCancel:
+		webhook.Type = EventSubscriptionUpdated
+		webhook.SubscriptionStatus = models.SubscriptionStatusCanceled
+	default:
+		return nil, fmt.Errorf("unknown checkout action %q", action)
+	}
+
+	return p.SignedWebhook(webhook)
+}
+
+// SignedWebhook encodes and signs a webhook the way it arrives at /webhooks/fake
+func (p *FakeProvider) SignedWebhook(webhook *FakeWebhook) (*WebhookRequest, error) {
+	body, err := json.Marshal(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(FakeSignatureHeader, p.sign(body))
+
+	return &WebhookRequest{
+		Method: http.MethodPost,
+		Header: header,
+		Body:   body,
+	}, nil
+}
+
+func (p *FakeProvider) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(p.secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the webhook signature
+func (p *FakeProvider) VerifyWebhook(req *WebhookRequest) error {
+	if req.Kind != "" {
+		return fmt.Errorf("%w: %q", ErrUnsupportedWebhook, req.Kind)
+	}
+
+	signature, err := hex.DecodeString(req.Header.Get(FakeSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return ErrInvalidSignature
+	}
+	expected, _ := hex.DecodeString(p.sign(req.Body))
+	if !hmac.Equal(signature, expected) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ParseWebhook translates a fake provider webhook into a WebhookEvent
+func (p *FakeProvider) ParseWebhook(req *WebhookRequest) (*WebhookEvent, error) {
+	var webhook FakeWebhook
+	if err := json.Unmarshal(req.Body, &webhook); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if webhook.ID == "" {
+		return nil, fmt.Errorf("%w: id is required", ErrInvalidPayload)
+	}
+
+	if !fakeEventTypes[webhook.Type] {
+		return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidPayload, webhook.Type)
+	}
+
+	return &WebhookEvent{
+		Type:                   webhook.Type,
+		Kind:                   string(webhook.Type),
+		IdempotencyKey:         &webhook.ID,
+		InvoiceID:              webhook.PaymentID,
+		ProviderPaymentID:      webhook.TransactionID,
+		ProviderSubscriptionID: webhook.ProviderSubscriptionID,
+		AmountMinor:            webhook.AmountMinor,
+		Currency:               webhook.Currency,
+		OccurredAt:             webhook.OccurredAt,
+		SubscriptionStatus:     webhook.SubscriptionStatus,
+		RefundID:               webhook.RefundID,
+		Raw:                    webhook,
+	}, nil
+}
+
+// WebhookResponse answers a webhook with a JSON status
+func (p *FakeProvider) WebhookResponse(event *WebhookEvent, err error) (int, interface{}) {
+	switch {
+	case err == nil:
+		return http.StatusOK, map[string]interface{}{"ok": true}
+	case errors.Is(err, ErrInvalidSignature):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Invalid signature"}
+	case errors.Is(err, ErrInvalidPayload):
+		return http.StatusBadRequest, map[string]interface{}{"error": "Invalid payload"}
+	case errors.Is(err, ErrUnknownInvoice), errors.Is(err, ErrAccountMismatch),
+		errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrPaymentRejected):
+		return http.StatusUnprocessableEntity, map[string]interface{}{"error": err.Error()}
+	default:
+		return http.StatusInternalServerError, map[string]interface{}{"error": "Failed to process webhook"}
+	}
+}
+
+// Refund accepts every refund; nothing is returned to anybody
+func (p *FakeProvider) Refund(payment *models.Payment, amountMinor int64) (*RefundResult, error) {
+	if payment.Status != models.PaymentStatusSucceeded {
+		return nil, fmt.Errorf("payment %s has not succeeded", payment.ID)
+	}
+	return &RefundResult{ProviderRefundID: "fake_refund_" + uuid.New().String()}, nil
+}
+
+// UpdateSubscription accepts every change; the fake subscription has no state of its own
+func (p *FakeProvider) UpdateSubscription(subscription *models.Subscription, update *SubscriptionUpdate) (*SubscriptionUpdateResult, error) {
+	return &SubscriptionUpdateResult{}, nil
+}
+
+// RetrySubscriptionCharge is not supported: retries are simulated on the test checkout page
+func (p *FakeProvider) RetrySubscriptionCharge(subscription *models.Subscription) error {
+	return ErrSubscriptionChangeNotSupported
+}
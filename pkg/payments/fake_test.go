@@ -0,0 +1,175 @@
+package payments
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakePayment() *models.Payment {
+	authUserID := "8c2d6a4e-1f0b-4b9a-b1f3-5e7c9d0a2b44"
+	return &models.Payment{
+		ID:          uuid.New(),
+		Provider:    models.PaymentProviderFake,
+		AuthUserID:  &authUserID,
+		AmountMinor: 19000,
+		Currency:    models.CurrencyRUB,
+		Status:      models.PaymentStatusPending,
+	}
+}
+
+func TestFakeCreatePaymentIntent(t *testing.T) {
+	provider := NewFakeProvider("http://localhost:8080", "secret")
+	payment := newFakePayment()
+
+	response, err := provider.CreatePaymentIntent(payment, &PaymentIntentRequest{Provider: "fake"})
+	require.NoError(t, err)
+	assert.Equal(t, "fake", response.Provider)
+	assert.Equal(t, "http://localhost:8080/pay/"+payment.ID.String(), response.RedirectURL)
+	assert.Nil(t, payment.ProviderPaymentID)
+}
+
+func TestFakeCreateSubscriptionIntent(t *testing.T) {
+	provider := NewFakeProvider("http://localhost:8080", "secret")
+	subscription := &models.Subscription{ID: uuid.New(), AmountMinor: 19000, Currency: models.CurrencyRUB, IntervalMonths: 1}
+
+	response, err := provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/subscribe/"+subscription.ID.String(), response.RedirectURL)
+	require.NotNil(t, subscription.ProviderSubscriptionID)
+	assert.Equal(t, *subscription.ProviderSubscriptionID, response.ProviderPayload["subscriptionId"])
+}
+
+func TestFakePaymentWebhook(t *testing.T) {
+	provider := NewFakeProvider("", "secret")
+	payment := newFakePayment()
+
+	req, err := provider.PaymentWebhook(payment, FakeActionSucceed)
+	require.NoError(t, err)
+	require.NoError(t, provider.VerifyWebhook(req))
+
+	event, err := provider.ParseWebhook(req)
+	require.NoError(t, err)
+	assert.Equal(t, EventPaymentSucceeded, event.Type)
+	assert.Equal(t, payment.ID.String(), event.InvoiceID)
+	assert.NotEmpty(t, event.ProviderPaymentID)
+	assert.Equal(t, int64(19000), event.AmountMinor)
+	assert.Equal(t, models.CurrencyRUB, event.Currency)
+	require.NotNil(t, event.IdempotencyKey)
+
+	// A refund references the transaction of the succeeded payment
+	_, err = provider.PaymentWebhook(payment, FakeActionRefund)
+	assert.Error(t, err)
+
+	payment.Status = models.PaymentStatusSucceeded
+	payment.ProviderPaymentID = &event.ProviderPaymentID
+	req, err = provider.PaymentWebhook(payment, FakeActionRefund)
+	require.NoError(t, err)
+	event, err = provider.ParseWebhook(req)
+	require.NoError(t, err)
+	assert.Equal(t, EventPaymentRefunded, event.Type)
+	assert.Equal(t, *payment.ProviderPaymentID, event.ProviderPaymentID)
+	assert.NotEmpty(t, event.RefundID)
+
+	_, err = provider.PaymentWebhook(payment, "explode")
+	assert.Error(t, err)
+}
+
+func TestFakeSubscriptionWebhook(t *testing.T) {
+	provider := NewFakeProvider("", "secret")
+	subscription := &models.Subscription{ID: uuid.New(), AmountMinor: 19000, Currency: models.CurrencyRUB, IntervalMonths: 1}
+
+	_, err := provider.SubscriptionWebhook(subscription, FakeActionSucceed)
+	assert.Error(t, err)
+
+	_, err = provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		action   string
+		expected EventType
+	}{
+		{FakeActionSucceed, EventPaymentSucceeded},
+		{FakeActionFail, EventPaymentFailed},
+		{FakeActionCancel, EventSubscriptionUpdated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			req, err := provider.SubscriptionWebhook(subscription, tt.action)
+			require.NoError(t, err)
+
+			event, err := provider.ParseWebhook(req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, event.Type)
+			assert.Equal(t, *subscription.ProviderSubscriptionID, event.ProviderSubscriptionID)
+		})
+	}
+}
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	provider := NewFakeProvider("", "secret")
+
+	req, err := provider.PaymentWebhook(newFakePayment(), FakeActionFail)
+	require.NoError(t, err)
+
+	// Webhooks signed with another secret are rejected
+	other := NewFakeProvider("", "other-secret")
+	assert.ErrorIs(t, other.VerifyWebhook(req), ErrInvalidSignature)
+
+	tampered := *req
+	tampered.Body = []byte(`{"id":"1","type":"payment.succeeded"}`)
+	assert.ErrorIs(t, provider.VerifyWebhook(&tampered), ErrInvalidSignature)
+
+	unsigned := &WebhookRequest{Header: http.Header{}, Body: req.Body}
+	assert.ErrorIs(t, provider.VerifyWebhook(unsigned), ErrInvalidSignature)
+
+	assert.ErrorIs(t, provider.VerifyWebhook(&WebhookRequest{Kind: "pay"}), ErrUnsupportedWebhook)
+}
+
+func TestFakeParseWebhook_InvalidType(t *testing.T) {
+	provider := NewFakeProvider("", "secret")
+
+	req, err := provider.SignedWebhook(&FakeWebhook{ID: "1", Type: EventPaymentCheck})
+	require.NoError(t, err)
+
+	_, err = provider.ParseWebhook(req)
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestFakeActions(t *testing.T) {
+	assert.Equal(t, []string{FakeActionSucceed, FakeActionFail, FakeActionCancel}, FakePaymentActions(models.PaymentStatusPending))
+	assert.Equal(t, []string{FakeActionRefund}, FakePaymentActions(models.PaymentStatusSucceeded))
+	assert.Empty(t, FakePaymentActions(models.PaymentStatusRefunded))
+
+	assert.Equal(t, []string{FakeActionSucceed, FakeActionFail}, FakeSubscriptionActions(models.SubscriptionStatusIncomplete))
+	assert.Contains(t, FakeSubscriptionActions(models.SubscriptionStatusActive), FakeActionCancel)
+	assert.Empty(t, FakeSubscriptionActions(models.SubscriptionStatusCanceled))
+}
+
+func TestFakeWebhookResponse(t *testing.T) {
+	provider := NewFakeProvider("", "secret")
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, http.StatusOK},
+		{"invalid signature", ErrInvalidSignature, http.StatusUnauthorized},
+		{"invalid payload", ErrInvalidPayload, http.StatusBadRequest},
+		{"unknown invoice", ErrUnknownInvoice, http.StatusUnprocessableEntity},
+		{"database error", assert.AnError, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := provider.WebhookResponse(nil, tt.err)
+			assert.Equal(t, tt.expected, status)
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+)
+
+// Kaspi callback commands
+const (
+	KaspiCommandCheck = "check"
+	KaspiCommandPay   = "pay"
+)
+
+// Kaspi callback result codes
+const (
+	KaspiResultOK             = 0
+	KaspiResultUnknownAccount = 1
+	KaspiResultInvalidAmount  = 2
+	KaspiResultRejected       = 3
+	KaspiResultInvalidRequest = 4
+	// KaspiResultTemporaryError makes Kaspi repeat the callback later
+	KaspiResultTemporaryError = 5
+)
+
+// kaspiSignatureParam is the callback parameter carrying the HMAC of the other parameters
+const kaspiSignatureParam = "sign"
+
+// kaspiLocation is the time zone of txn_date in Kaspi callbacks
+var kaspiLocation = time.FixedZone("ALMT", 5*60*60)
+
+// KaspiProvider handles Kaspi.kz integration. Donors pay an invoice in the Kaspi app,
+// either by following the payment link or by scanning its QR code; Kaspi then asks us to
+// check the invoice account and reports the payment with check and pay callbacks.
+type KaspiProvider struct {
+	merchantID     string
+	apiKey         string
+	apiURL         string
+	callbackSecret string
+	allowedIPs     []*net.IPNet
+	httpClient     *http.Client
+}
+
+// NewKaspiProvider creates a new Kaspi provider. Callbacks are only accepted from
+// allowedIPs, given as addresses or CIDR ranges, and when they are signed with
+// callbackSecret. Without either, every callback is rejected.
+func NewKaspiProvider(merchantID, apiKey, apiURL, callbackSecret string, allowedIPs []string) (*KaspiProvider, error) {
+	p := &KaspiProvider{
+		merchantID:     merchantID,
+		apiKey:         apiKey,
+		apiURL:         strings.TrimSuffix(apiURL, "/"),
+		callbackSecret: callbackSecret,
+		httpClient:     &http.Client{Timeout: 30 * time.Second},
+	}
+
+	for _, entry := range allowedIPs {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kaspi allowed IP %q: %w", entry, err)
+		}
+		p.allowedIPs = append(p.allowedIPs, ipNet)
+	}
+
+	return p, nil
+}
+
+// Name returns the provider name
+func (p *KaspiProvider) Name() models.PaymentProvider {
+	return models.PaymentProviderKaspi
+}
+
+// kaspiInvoice is the Kaspi API representation of an invoice
+type kaspiInvoice struct {
+	InvoiceID  string `json:"invoice_id"`
+	Status     string `json:"status"`
+	PaymentURL string `json:"payment_url"`
+	QRToken    string `json:"qr_token"`
+	QRURL      string `json:"qr_url"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// CreatePaymentIntent issues a Kaspi invoice whose account is our payment ID
+func (p *KaspiProvider) CreatePaymentIntent(payment *models.Payment, req *PaymentIntentRequest) (*PaymentIntentResponse, error) {
+	if payment.Currency != models.CurrencyKZT {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, payment.Currency)
+	}
+
+	description := "Tree planting donation"
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"merchant_id": p.merchantID,
+		"account":     payment.ID.String(),
+		"amount":      FormatAmountMinor(payment.AmountMinor),
+		"currency":    string(payment.Currency),
+		"description": description,
+		"return_url":  req.SuccessReturnURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var invoice kaspiInvoice
+	if err := p.call(http.MethodPost, "/v1/invoices", body, &invoice); err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %w", err)
+	}
+
+	// The invoice ID is replaced by the Kaspi transaction ID once the invoice is paid
+	payment.ProviderPaymentID = &invoice.InvoiceID
+
+	return &PaymentIntentResponse{
+		Provider:    string(p.Name()),
+		RedirectURL: invoice.PaymentURL,
+		ProviderPayload: map[string]interface{}{
+			"invoiceId":  invoice.InvoiceID,
+			"account":    payment.ID.String(),
+			"paymentUrl": invoice.PaymentURL,
+			"qrToken":    invoice.QRToken,
+			"qrUrl":      invoice.QRURL,
+			"expiresAt":  invoice.ExpiresAt,
+		},
+	}, nil
+}
+
+// CreateSubscriptionIntent is not supported: Kaspi invoices are paid one at a time
+func (p *KaspiProvider) CreateSubscriptionIntent(subscription *models.Subscription, req *SubscriptionIntentRequest) (*SubscriptionIntentResponse, error) {
+	return nil, ErrSubscriptionsNotSupported
+}
+
+// KaspiCallback is a check or pay callback sent by Kaspi
+type KaspiCallback struct {
+	Command string `json:"command"`
+	TxnID   string `json:"txn_id"`
+	TxnDate string `json:"txn_date,omitempty"`
+	Account string `json:"account"`
+	Sum     string `json:"sum"`
+}
+
+// kaspiParams reads callback parameters from the query string, or from a form-encoded
+// body when Kaspi is configured to POST them
+func kaspiParams(req *WebhookRequest) (url.Values, error) {
+	params := url.Values{}
+	for k, v := range req.Query {
+		params[k] = v
+	}
+	if len(req.Body) > 0 {
+		form, err := url.ParseQuery(string(req.Body))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+		}
+		for k, v := range form {
+			params[k] = v
+		}
+	}
+	return params, nil
+}
+
+// kaspiSignature computes the sign parameter of a callback: the hex HMAC-SHA256 of all
+// other parameters, sorted by name and form-encoded, keyed with the callback secret
+func kaspiSignature(params url.Values, secret string) string {
+	signed := url.Values{}
+	for k, v := range params {
+		if k != kaspiSignatureParam {
+			signed[k] = v
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseKaspiCallback reads the parameters of a check or pay callback
+func parseKaspiCallback(req *WebhookRequest) (*KaspiCallback, error) {
+	params, err := kaspiParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KaspiCallback{
+		Command: params.Get("command"),
+		TxnID:   params.Get("txn_id"),
+		TxnDate: params.Get("txn_date"),
+		Account: params.Get("account"),
+		Sum:     params.Get("sum"),
+	}, nil
+}
+
+// VerifyWebhook accepts check and pay callbacks signed with the callback secret from the
+// allowed Kaspi addresses. Callbacks are rejected until both are configured.
+func (p *KaspiProvider) VerifyWebhook(req *WebhookRequest) error {
+	if len(p.allowedIPs) == 0 || p.callbackSecret == "" {
+		return fmt.Errorf("%w: Kaspi callbacks are not configured", ErrInvalidSignature)
+	}
+
+	ip := net.ParseIP(req.RemoteIP)
+	allowed := false
+	for _, ipNet := range p.allowedIPs {
+		if ip != nil && ipNet.Contains(ip) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: callback from %s", ErrInvalidSignature, req.RemoteIP)
+	}
+
+	params, err := kaspiParams(req)
+	if err != nil {
+		return err
+	}
+	signature := params.Get(kaspiSignatureParam)
+	expected := kaspiSignature(params, p.callbackSecret)
+	if signature == "" || !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	command := params.Get("command")
+	if command != KaspiCommandCheck && command != KaspiCommandPay {
+		return fmt.Errorf("%w: command %q", ErrUnsupportedWebhook, command)
+	}
+	return nil
+}
+
+// ParseWebhook translates a Kaspi callback into a WebhookEvent
+func (p *KaspiProvider) ParseWebhook(req *WebhookRequest) (*WebhookEvent, error) {
+	callback, err := parseKaspiCallback(req)
+	if err != nil {
+		return nil, err
+	}
+	if callback.TxnID == "" || callback.Account == "" {
+		return nil, fmt.Errorf("%w: txn_id and account are required", ErrInvalidPayload)
+	}
+
+	event := &WebhookEvent{
+		Kind:              callback.Command,
+		InvoiceID:         callback.Account,
+		ProviderPaymentID: callback.TxnID,
+		Currency:          models.CurrencyKZT,
+		Raw:               callback,
+	}
+
+	amountMinor, err := ParseAmountMinor(callback.Sum)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	event.AmountMinor = amountMinor
+
+	switch callback.Command {
+	case KaspiCommandCheck:
+		event.Type = EventPaymentCheck
+	case KaspiCommandPay:
+		occurredAt, err := time.ParseInLocation("20060102150405", callback.TxnDate, kaspiLocation)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid txn_date %q", ErrInvalidPayload, callback.TxnDate)
+		}
+		key := KaspiCommandPay + ":" + callback.TxnID
+		event.Type = EventPaymentSucceeded
+		event.IdempotencyKey = &key
+		event.OccurredAt = occurredAt
+	default:
+		return nil, fmt.Errorf("%w: command %q", ErrUnsupportedWebhook, callback.Command)
+	}
+
+	return event, nil
+}
+
+// KaspiResult maps a processing error to a Kaspi result code
+func KaspiResult(err error) int {
+	switch {
+	case err == nil:
+		return KaspiResultOK
+	case errors.Is(err, ErrUnknownInvoice), errors.Is(err, ErrAccountMismatch):
+		return KaspiResultUnknownAccount
+	case errors.Is(err, ErrInvalidAmount):
+		return KaspiResultInvalidAmount
+	case errors.Is(err, ErrPaymentRejected):
+		return KaspiResultRejected
+	case errors.Is(err, ErrInvalidPayload):
+		return KaspiResultInvalidRequest
+	default:
+		return KaspiResultTemporaryError
+	}
+}
+
+// WebhookResponse answers a callback with a Kaspi result. Kaspi expects HTTP 200 for every
+// answer and repeats the callback when the result is a temporary error.
+func (p *KaspiProvider) WebhookResponse(event *WebhookEvent, err error) (int, interface{}) {
+	if errors.Is(err, ErrInvalidSignature) {
+		return http.StatusForbidden, map[string]interface{}{
+			"result":  KaspiResultRejected,
+			"comment": "Forbidden",
+		}
+	}
+
+	body := map[string]interface{}{
+		"result": KaspiResult(err),
+	}
+	if err != nil {
+		body["comment"] = err.Error()
+	}
+	if event != nil {
+		body["txn_id"] = event.ProviderPaymentID
+		body["sum"] = FormatAmountMinor(event.AmountMinor)
+		if event.Type == EventPaymentSucceeded && err == nil {
+			body["prv_txn_id"] = event.InvoiceID
+		}
+	}
+
+	return http.StatusOK, body
+}
+
+// Refund refunds amountMinor of a paid invoice through the Kaspi API
+func (p *KaspiProvider) Refund(payment *models.Payment, amountMinor int64) (*RefundResult, error) {
+	if payment.ProviderPaymentID == nil || payment.Status != models.PaymentStatusSucceeded {
+		return nil, fmt.Errorf("payment %s has no Kaspi transaction", payment.ID)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"amount": FormatAmountMinor(amountMinor),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		RefundID string `json:"refund_id"`
+	}
+	path := "/v1/payments/" + url.PathEscape(*payment.ProviderPaymentID) + "/refund"
+	if err := p.call(http.MethodPost, path, body, &result); err != nil {
+		return nil, fmt.Errorf("failed to refund payment: %w", err)
+	}
+
+	return &RefundResult{ProviderRefundID: result.RefundID}, nil
+}
+
+// call performs an authenticated Kaspi API request and decodes the response
+func (p *KaspiProvider) call(method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, p.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("Kaspi API returned status %d: %s", resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("Kaspi API returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode Kaspi response: %w", err)
+	}
+	return nil
+}
+
+// UpdateSubscription is not supported: Kaspi invoices are paid one at a time
+func (p *KaspiProvider) UpdateSubscription(subscription *models.Subscription, update *SubscriptionUpdate) (*SubscriptionUpdateResult, error) {
+	return nil, ErrSubscriptionsNotSupported
+}
+
+// RetrySubscriptionCharge is not supported: Kaspi invoices are paid one at a time
+func (p *KaspiProvider) RetrySubscriptionCharge(subscription *models.Subscription) error {
+	return ErrSubscriptionsNotSupported
+}
@@ -0,0 +1,291 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/payments/kaspitest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newKaspiPayment(amountMinor int64) *models.Payment {
+	authUserID := "8c2d6a4e-1f0b-4b9a-b1f3-5e7c9d0a2b44"
+	return &models.Payment{
+		ID:          uuid.New(),
+		Provider:    models.PaymentProviderKaspi,
+		AuthUserID:  &authUserID,
+		AmountMinor: amountMinor,
+		Currency:    models.CurrencyKZT,
+		Status:      models.PaymentStatusPending,
+	}
+}
+
+// kaspiMerchant answers Kaspi callbacks for a single payment the way Service does
+func kaspiMerchant(t *testing.T, provider *KaspiProvider, payment *models.Payment) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &WebhookRequest{Method: r.Method, Header: r.Header, Query: r.URL.Query(), RemoteIP: "127.0.0.1"}
+		require.NoError(t, provider.VerifyWebhook(req))
+
+		event, err := provider.ParseWebhook(req)
+		if err == nil {
+			switch {
+			case event.InvoiceID != payment.ID.String():
+				err = ErrUnknownInvoice
+			case event.Type == EventPaymentCheck:
+				err = checkAmount(event.AmountMinor, event.Currency, payment.AmountMinor, payment.Currency)
+			case event.Type == EventPaymentSucceeded:
+				payment.Status = models.PaymentStatusSucceeded
+				payment.ProviderPaymentID = &event.ProviderPaymentID
+			}
+		}
+
+		status, body := provider.WebhookResponse(event, err)
+		writeTestJSON(t, w, status, body)
+	})
+}
+
+func TestKaspiPaymentFlow(t *testing.T) {
+	kaspi := kaspitest.NewServer("api-key")
+	defer kaspi.Close()
+
+	provider, err := NewKaspiProvider("merchant-1", "api-key", kaspi.URL, "callback-secret", []string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	payment := newKaspiPayment(500000)
+	response, err := provider.CreatePaymentIntent(payment, &PaymentIntentRequest{
+		Provider:         "kaspi",
+		AmountMinor:      payment.AmountMinor,
+		Currency:         "KZT",
+		SuccessReturnURL: "https://app.local/return/success",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, payment.ProviderPaymentID)
+
+	invoiceID := *payment.ProviderPaymentID
+	assert.Equal(t, "kaspi", response.Provider)
+	assert.Equal(t, kaspi.URL+"/pay/"+invoiceID, response.RedirectURL)
+	assert.Equal(t, invoiceID, response.ProviderPayload["invoiceId"])
+	assert.NotEmpty(t, response.ProviderPayload["qrToken"])
+
+	invoice, ok := kaspi.Invoice(invoiceID)
+	require.True(t, ok)
+	assert.Equal(t, payment.ID.String(), invoice.Account)
+	assert.Equal(t, "5000.00", invoice.Amount)
+	assert.Equal(t, "merchant-1", invoice.MerchantID)
+
+	merchant := httptest.NewServer(kaspiMerchant(t, provider, payment))
+	defer merchant.Close()
+	kaspi.CallbackURL = merchant.URL
+	kaspi.CallbackSecret = "callback-secret"
+
+	check, pay, err := kaspi.Pay(invoiceID)
+	require.NoError(t, err)
+	assert.Equal(t, KaspiResultOK, check.Result)
+	require.NotNil(t, pay)
+	assert.Equal(t, KaspiResultOK, pay.Result)
+	assert.Equal(t, payment.ID.String(), pay.PrvTxnID)
+
+	assert.Equal(t, models.PaymentStatusSucceeded, payment.Status)
+	assert.Equal(t, pay.TxnID, *payment.ProviderPaymentID)
+
+	invoice, _ = kaspi.Invoice(invoiceID)
+	assert.Equal(t, kaspitest.InvoiceStatusPaid, invoice.Status)
+
+	result, err := provider.Refund(payment, 250000)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.ProviderRefundID)
+
+	invoice, _ = kaspi.Invoice(invoiceID)
+	assert.Equal(t, []string{"2500.00"}, invoice.Refunds)
+}
+
+func TestKaspiPaymentFlow_CheckRejectsAmount(t *testing.T) {
+	kaspi := kaspitest.NewServer("api-key")
+	defer kaspi.Close()
+
+	provider, err := NewKaspiProvider("merchant-1", "api-key", kaspi.URL, "callback-secret", []string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	payment := newKaspiPayment(500000)
+	_, err = provider.CreatePaymentIntent(payment, &PaymentIntentRequest{Provider: "kaspi"})
+	require.NoError(t, err)
+
+	// The donor is charged a different amount than the one we invoiced
+	payment.AmountMinor = 100000
+
+	merchant := httptest.NewServer(kaspiMerchant(t, provider, payment))
+	defer merchant.Close()
+	kaspi.CallbackURL = merchant.URL
+	kaspi.CallbackSecret = "callback-secret"
+
+	check, pay, err := kaspi.Pay(*payment.ProviderPaymentID)
+	require.NoError(t, err)
+	assert.Equal(t, KaspiResultInvalidAmount, check.Result)
+	assert.Nil(t, pay)
+	assert.Equal(t, models.PaymentStatusPending, payment.Status)
+}
+
+func TestKaspiCreatePaymentIntent_UnsupportedCurrency(t *testing.T) {
+	provider, err := NewKaspiProvider("merchant-1", "api-key", "http://127.0.0.1:0", "", nil)
+	require.NoError(t, err)
+
+	payment := newKaspiPayment(19000)
+	payment.Currency = models.CurrencyRUB
+	_, err = provider.CreatePaymentIntent(payment, &PaymentIntentRequest{Provider: "kaspi"})
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestKaspiCreateSubscriptionIntent(t *testing.T) {
+	provider, err := NewKaspiProvider("merchant-1", "api-key", "", "", nil)
+	require.NoError(t, err)
+
+	_, err = provider.CreateSubscriptionIntent(&models.Subscription{}, &SubscriptionIntentRequest{})
+	assert.ErrorIs(t, err, ErrSubscriptionsNotSupported)
+}
+
+func TestNewKaspiProvider_InvalidAllowedIP(t *testing.T) {
+	_, err := NewKaspiProvider("merchant-1", "api-key", "", "", []string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestKaspiVerifyWebhook(t *testing.T) {
+	provider, err := NewKaspiProvider("merchant-1", "api-key", "", "callback-secret", []string{"194.187.247.152", "10.0.0.0/8"})
+	require.NoError(t, err)
+
+	signed := func(query url.Values, secret string) url.Values {
+		query.Set("sign", kaspiSignature(query, secret))
+		return query
+	}
+	callback := func() url.Values {
+		return url.Values{"command": {"check"}, "txn_id": {"1"}, "account": {"a"}, "sum": {"1.00"}}
+	}
+	tampered := signed(callback(), "callback-secret")
+	tampered.Set("sum", "1000.00")
+
+	tests := []struct {
+		name     string
+		remoteIP string
+		query    url.Values
+		expected error
+	}{
+		{"allowed address", "194.187.247.152", signed(callback(), "callback-secret"), nil},
+		{"allowed range", "10.1.2.3", signed(callback(), "callback-secret"), nil},
+		{"forbidden address", "203.0.113.7", signed(callback(), "callback-secret"), ErrInvalidSignature},
+		{"unsigned", "10.1.2.3", callback(), ErrInvalidSignature},
+		{"wrong secret", "10.1.2.3", signed(callback(), "guess"), ErrInvalidSignature},
+		{"tampered", "10.1.2.3", tampered, ErrInvalidSignature},
+		{"unknown command", "10.1.2.3", signed(url.Values{"command": {"status"}}, "callback-secret"), ErrUnsupportedWebhook},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := provider.VerifyWebhook(&WebhookRequest{Query: tt.query, RemoteIP: tt.remoteIP})
+			if tt.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+
+	// Signed form bodies are accepted as well
+	body := signed(callback(), "callback-secret").Encode()
+	assert.NoError(t, provider.VerifyWebhook(&WebhookRequest{Method: http.MethodPost, Body: []byte(body), RemoteIP: "10.1.2.3"}))
+}
+
+func TestKaspiVerifyWebhook_NotConfigured(t *testing.T) {
+	query := url.Values{"command": {"pay"}, "txn_id": {"1"}, "account": {"a"}, "sum": {"1.00"}}
+	query.Set("sign", kaspiSignature(query, ""))
+
+	tests := []struct {
+		name       string
+		secret     string
+		allowedIPs []string
+	}{
+		{"no allowed addresses", "callback-secret", nil},
+		{"no secret", "", []string{"10.0.0.0/8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := NewKaspiProvider("merchant-1", "api-key", "", tt.secret, tt.allowedIPs)
+			require.NoError(t, err)
+
+			err = provider.VerifyWebhook(&WebhookRequest{Query: query, RemoteIP: "10.1.2.3"})
+			assert.ErrorIs(t, err, ErrInvalidSignature)
+		})
+	}
+}
+
+func TestKaspiParseWebhook(t *testing.T) {
+	provider, err := NewKaspiProvider("merchant-1", "api-key", "", "", nil)
+	require.NoError(t, err)
+
+	event, err := provider.ParseWebhook(&WebhookRequest{Query: url.Values{
+		"command":  {"pay"},
+		"txn_id":   {"1001"},
+		"txn_date": {"20250801150500"},
+		"account":  {"2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10"},
+		"sum":      {"5000.00"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, EventPaymentSucceeded, event.Type)
+	assert.Equal(t, "2f1b7d2e-6f49-4d2a-9a8f-0c6b1f4a3e10", event.InvoiceID)
+	assert.Equal(t, "1001", event.ProviderPaymentID)
+	assert.Equal(t, int64(500000), event.AmountMinor)
+	assert.Equal(t, models.CurrencyKZT, event.Currency)
+	assert.True(t, event.OccurredAt.Equal(time.Date(2025, 8, 1, 10, 5, 0, 0, time.UTC)))
+	require.NotNil(t, event.IdempotencyKey)
+	assert.Equal(t, "pay:1001", *event.IdempotencyKey)
+
+	// Callbacks may also be posted as a form
+	event, err = provider.ParseWebhook(&WebhookRequest{
+		Method: http.MethodPost,
+		Body:   []byte("command=check&txn_id=1002&account=a&sum=10"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, EventPaymentCheck, event.Type)
+	assert.Nil(t, event.IdempotencyKey)
+
+	_, err = provider.ParseWebhook(&WebhookRequest{Query: url.Values{"command": {"pay"}, "txn_id": {"1"}, "account": {"a"}, "sum": {"1.00"}}})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+
+	_, err = provider.ParseWebhook(&WebhookRequest{Query: url.Values{"command": {"check"}, "txn_id": {"1"}, "account": {"a"}, "sum": {"abc"}}})
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestKaspiResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, KaspiResultOK},
+		{"unknown invoice", ErrUnknownInvoice, KaspiResultUnknownAccount},
+		{"account mismatch", ErrAccountMismatch, KaspiResultUnknownAccount},
+		{"invalid amount", ErrInvalidAmount, KaspiResultInvalidAmount},
+		{"rejected", ErrPaymentRejected, KaspiResultRejected},
+		{"invalid payload", ErrInvalidPayload, KaspiResultInvalidRequest},
+		{"database error", errors.New("connection refused"), KaspiResultTemporaryError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, KaspiResult(tt.err))
+		})
+	}
+}
+
+func writeTestJSON(t *testing.T, w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	require.NoError(t, json.NewEncoder(w).Encode(body))
+}
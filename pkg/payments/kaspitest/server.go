@@ -0,0 +1,309 @@
+// Package kaspitest provides an in-process fake of the Kaspi merchant API so that the
+// Kaspi payment flow can be exercised offline. The fake issues invoices, accepts refunds
+// and plays the donor's side of a payment by sending check and pay callbacks.
+package kaspitest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Invoice statuses
+const (
+	InvoiceStatusPending  = "pending"
+	InvoiceStatusPaid     = "paid"
+	InvoiceStatusRefunded = "refunded"
+)
+
+// Invoice is an invoice issued by the fake server
+type Invoice struct {
+	ID          string
+	MerchantID  string
+	Account     string
+	Amount      string
+	Currency    string
+	Description string
+	ReturnURL   string
+	Status      string
+	TxnID       string
+	Refunds     []string
+}
+
+// CallbackResult is the merchant's answer to a check or pay callback
+type CallbackResult struct {
+	TxnID    string `json:"txn_id"`
+	PrvTxnID string `json:"prv_txn_id"`
+	Result   int    `json:"result"`
+	Sum      string `json:"sum"`
+	Comment  string `json:"comment"`
+}
+
+// Server is a fake Kaspi merchant API
+type Server struct {
+	*httptest.Server
+
+	// APIKey is the bearer token expected on API requests
+	APIKey string
+	// CallbackURL is the merchant's check/pay URL
+	CallbackURL string
+	// CallbackSecret signs callbacks; they are sent unsigned when it is empty
+	CallbackSecret string
+	// Now returns the payment time sent in pay callbacks
+	Now func() time.Time
+
+	mu       sync.Mutex
+	invoices map[string]*Invoice
+	txns     map[string]string // txn_id -> invoice ID
+	seq      int64
+}
+
+// NewServer starts a fake Kaspi merchant API accepting the given API key
+func NewServer(apiKey string) *Server {
+	s := &Server{
+		APIKey:   apiKey,
+		Now:      time.Now,
+		invoices: make(map[string]*Invoice),
+		txns:     make(map[string]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/invoices", s.handleCreateInvoice)
+	mux.HandleFunc("/v1/payments/", s.handleRefund)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Invoice returns a copy of the invoice with the given ID
+func (s *Server) Invoice(id string) (Invoice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoice, ok := s.invoices[id]
+	if !ok {
+		return Invoice{}, false
+	}
+	return *invoice, true
+}
+
+// Pay pays an invoice the way the Kaspi app does: it asks the merchant to check the
+// account and, if the merchant accepts, reports the payment. The pay result is nil when
+// the check was rejected.
+func (s *Server) Pay(invoiceID string) (check *CallbackResult, pay *CallbackResult, err error) {
+	s.mu.Lock()
+	invoice, ok := s.invoices[invoiceID]
+	if !ok {
+		s.mu.Unlock()
+		return nil, nil, fmt.Errorf("unknown invoice %s", invoiceID)
+	}
+	if invoice.Status != InvoiceStatusPending {
+		s.mu.Unlock()
+		return nil, nil, fmt.Errorf("invoice %s is %s", invoiceID, invoice.Status)
+	}
+	txnID := s.nextID()
+	account, amount := invoice.Account, invoice.Amount
+	s.mu.Unlock()
+
+	check, err = s.callback(url.Values{
+		"command": {"check"},
+		"txn_id":  {txnID},
+		"account": {account},
+		"sum":     {amount},
+	})
+	if err != nil || check.Result != 0 {
+		return check, nil, err
+	}
+
+	pay, err = s.callback(url.Values{
+		"command":  {"pay"},
+		"txn_id":   {txnID},
+		"txn_date": {s.Now().In(time.FixedZone("ALMT", 5*60*60)).Format("20060102150405")},
+		"account":  {account},
+		"sum":      {amount},
+	})
+	if err != nil {
+		return check, nil, err
+	}
+
+	if pay.Result == 0 {
+		s.mu.Lock()
+		invoice.Status = InvoiceStatusPaid
+		invoice.TxnID = txnID
+		s.txns[txnID] = invoice.ID
+		s.mu.Unlock()
+	}
+
+	return check, pay, nil
+}
+
+// Callback sends a raw check or pay callback to the merchant
+func (s *Server) Callback(params url.Values) (*CallbackResult, error) {
+	return s.callback(params)
+}
+
+func (s *Server) callback(params url.Values) (*CallbackResult, error) {
+	if s.CallbackURL == "" {
+		return nil, errors.New("callback URL is not set")
+	}
+
+	if s.CallbackSecret != "" {
+		params.Set("sign", sign(params, s.CallbackSecret))
+	}
+
+	sep := "?"
+	if strings.Contains(s.CallbackURL, "?") {
+		sep = "&"
+	}
+	resp, err := http.Get(s.CallbackURL + sep + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("callback returned status %d", resp.StatusCode)
+	}
+
+	var result CallbackResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode callback result: %w", err)
+	}
+	return &result, nil
+}
+
+func (s *Server) handleCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	var req struct {
+		MerchantID  string `json:"merchant_id"`
+		Account     string `json:"account"`
+		Amount      string `json:"amount"`
+		Currency    string `json:"currency"`
+		Description string `json:"description"`
+		ReturnURL   string `json:"return_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.MerchantID == "" || req.Account == "" || req.Amount == "" {
+		writeError(w, http.StatusBadRequest, "merchant_id, account and amount are required")
+		return
+	}
+	if req.Currency != "KZT" {
+		writeError(w, http.StatusBadRequest, "only KZT is supported")
+		return
+	}
+
+	s.mu.Lock()
+	invoice := &Invoice{
+		ID:          s.nextID(),
+		MerchantID:  req.MerchantID,
+		Account:     req.Account,
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		ReturnURL:   req.ReturnURL,
+		Status:      InvoiceStatusPending,
+	}
+	s.invoices[invoice.ID] = invoice
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"invoice_id":  invoice.ID,
+		"status":      invoice.Status,
+		"payment_url": s.URL + "/pay/" + invoice.ID,
+		"qr_token":    "kaspi-qr-" + invoice.ID,
+		"qr_url":      s.URL + "/qr/" + invoice.ID + ".png",
+		"expires_at":  s.Now().Add(15 * time.Minute).UTC().Format(time.RFC3339),
+	})
+}
+
+// handleRefund serves POST /v1/payments/{txn_id}/refund
+func (s *Server) handleRefund(w http.ResponseWriter, r *http.Request) {
+	txnID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/payments/"), "/refund")
+	if !ok || r.Method != http.MethodPost {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+
+	var req struct {
+		Amount string `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount == "" {
+		writeError(w, http.StatusBadRequest, "amount is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invoice, ok := s.invoices[s.txns[txnID]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown transaction")
+		return
+	}
+	if invoice.Status != InvoiceStatusPaid {
+		writeError(w, http.StatusConflict, "transaction is not refundable")
+		return
+	}
+
+	refundID := s.nextID()
+	invoice.Refunds = append(invoice.Refunds, req.Amount)
+	if req.Amount == invoice.Amount {
+		invoice.Status = InvoiceStatusRefunded
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"refund_id": refundID,
+		"status":    "refunded",
+	})
+}
+
+// sign computes the HMAC-SHA256 of the form-encoded callback parameters
+func sign(params url.Values, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(params.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer "+s.APIKey
+}
+
+// nextID returns a new numeric ID; callers must hold s.mu
+func (s *Server) nextID() string {
+	s.seq++
+	return fmt.Sprintf("%d", 1000+s.seq)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
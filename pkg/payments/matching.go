@@ -0,0 +1,167 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/4planet/backend/pkg/prices"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// matchDonation matches a donation's trees from the open pools it qualifies for, oldest
+// pool first, until every tree is matched or the pools are used up. Each pool that
+// matches trees makes a matched donation from its sponsor at the tree price of the
+// pool's currency.
+func matchDonation(tx *gorm.DB, donation *models.Donation, paidAt time.Time) error {
+	if donation.TreesCount <= 0 {
+		return nil
+	}
+
+	query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("starts_at <= ? AND ends_at > ?", paidAt, paidAt).
+		Where("sponsor_auth_user_id <> ?", donation.AuthUserID)
+	if donation.ProjectID != nil {
+		query = query.Where("project_id IS NULL OR project_id = ?", *donation.ProjectID)
+	} else {
+		query = query.Where("project_id IS NULL")
+	}
+	if donation.CampaignID != nil {
+		query = query.Where("campaign_id IS NULL OR campaign_id = ?", *donation.CampaignID)
+	} else {
+		query = query.Where("campaign_id IS NULL")
+	}
+	var pools []models.MatchingPool
+	if err := query.Order("created_at ASC").Find(&pools).Error; err != nil {
+		return fmt.Errorf("failed to find matching pools: %w", err)
+	}
+
+	unmatched := donation.TreesCount
+	for i := range pools {
+		pool := &pools[i]
+		price, err := prices.PriceAt(tx, pool.Currency, donation.ProjectID, paidAt)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("tree price not found for currency %s: %w", pool.Currency, err)
+		}
+		trees := matchableTrees(pool, unmatched, price.PriceMinor)
+		if trees == 0 {
+			continue
+		}
+
+		match := &models.Donation{
+			ID:                uuid.New(),
+			AuthUserID:        pool.SponsorAuthUserID,
+			ProjectID:         donation.ProjectID,
+			CampaignID:        donation.CampaignID,
+			MatchedDonationID: &donation.ID,
+			MatchingPoolID:    &pool.ID,
+			TreesCount:        trees,
+			TreePriceID:       &price.ID,
+			PriceMinor:        price.PriceMinor,
+		}
+		if err := tx.Create(match).Error; err != nil {
+			return fmt.Errorf("failed to create matched donation: %w", err)
+		}
+		if err := allocations.Allocate(tx, match); err != nil {
+			return err
+		}
+		if err := useMatchingPool(tx, pool, trees, int64(trees)*price.PriceMinor); err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"total_trees":      gorm.Expr("total_trees + ?", trees),
+			"donations_count":  gorm.Expr("donations_count + 1"),
+			"last_donation_at": time.Now(),
+		}
+		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", pool.SponsorAuthUserID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update sponsor counters: %w", err)
+		}
+
+		donation.Matches = append(donation.Matches, *match)
+		unmatched -= trees
+		if unmatched == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// matchableTrees returns how many of some trees a pool can still match at a tree price
+func matchableTrees(pool *models.MatchingPool, trees int, priceMinor int64) int {
+	if pool.CapTrees != nil {
+		trees = min(trees, *pool.CapTrees-pool.MatchedTrees)
+	}
+	if pool.CapAmountMinor != nil && priceMinor > 0 {
+		trees = min(trees, int((*pool.CapAmountMinor-pool.MatchedAmountMinor)/priceMinor))
+	}
+	return max(trees, 0)
+}
+
+// useMatchingPool records trees matched by a pool, or given back to it when negative
+func useMatchingPool(tx *gorm.DB, pool *models.MatchingPool, trees int, amountMinor int64) error {
+	updates := map[string]interface{}{
+		"matched_trees":        gorm.Expr("GREATEST(matched_trees + ?, 0)", trees),
+		"matched_amount_minor": gorm.Expr("GREATEST(matched_amount_minor + ?, 0)", amountMinor),
+		"updated_at":           time.Now(),
+	}
+	if err := tx.Model(&models.MatchingPool{}).Where("id = ?", pool.ID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update matching pool: %w", err)
+	}
+	pool.MatchedTrees += trees
+	pool.MatchedAmountMinor += amountMinor
+	return nil
+}
+
+// reverseMatches takes back matched trees a donation no longer has after a refund, latest
+// match first, and gives them back to their pools. A match never has more trees than the
+// donation it matches.
+func reverseMatches(tx *gorm.DB, donation *models.Donation, remainingTrees int) error {
+	var matches []models.Donation
+	if err := tx.Where("matched_donation_id = ?", donation.ID).Order("created_at DESC").Find(&matches).Error; err != nil {
+		return fmt.Errorf("failed to find matched donations: %w", err)
+	}
+
+	for i, trees := range matchReversals(matches, remainingTrees) {
+		if trees == 0 {
+			continue
+		}
+		match := &matches[i]
+		if err := reverseDonation(tx, match, trees, trees == match.TreesCount); err != nil {
+			return err
+		}
+		if match.MatchingPoolID != nil {
+			pool := &models.MatchingPool{ID: *match.MatchingPoolID}
+			if err := useMatchingPool(tx, pool, -trees, -int64(trees)*match.PriceMinor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// matchReversals returns how many trees to take back from each of some matches, in their
+// order, so that they match no more than the remaining trees of a donation
+func matchReversals(matches []models.Donation, remainingTrees int) []int {
+	excess := -remainingTrees
+	for _, match := range matches {
+		excess += match.TreesCount
+	}
+
+	reversals := make([]int, len(matches))
+	for i, match := range matches {
+		if excess <= 0 {
+			break
+		}
+		reversals[i] = min(excess, match.TreesCount)
+		excess -= reversals[i]
+	}
+	return reversals
+}
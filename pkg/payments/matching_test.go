@@ -0,0 +1,59 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchableTrees(t *testing.T) {
+	capTrees := func(n int) *int { return &n }
+	capAmount := func(n int64) *int64 { return &n }
+
+	tests := []struct {
+		name       string
+		pool       models.MatchingPool
+		trees      int
+		priceMinor int64
+		expected   int
+	}{
+		{"tree cap not reached", models.MatchingPool{CapTrees: capTrees(100), MatchedTrees: 10}, 5, 19000, 5},
+		{"tree cap reached part way", models.MatchingPool{CapTrees: capTrees(100), MatchedTrees: 97}, 5, 19000, 3},
+		{"tree cap used up", models.MatchingPool{CapTrees: capTrees(100), MatchedTrees: 100}, 5, 19000, 0},
+		{"money cap not reached", models.MatchingPool{CapAmountMinor: capAmount(1000000)}, 5, 19000, 5},
+		{"money cap buys part of the trees", models.MatchingPool{CapAmountMinor: capAmount(100000), MatchedAmountMinor: 38000}, 5, 19000, 3},
+		{"money cap below one tree", models.MatchingPool{CapAmountMinor: capAmount(100000), MatchedAmountMinor: 90000}, 5, 19000, 0},
+		{"lower of both caps", models.MatchingPool{CapTrees: capTrees(2), CapAmountMinor: capAmount(1000000)}, 5, 19000, 2},
+		{"over the cap", models.MatchingPool{CapTrees: capTrees(10), MatchedTrees: 12}, 5, 19000, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, matchableTrees(&tt.pool, tt.trees, tt.priceMinor))
+		})
+	}
+}
+
+func TestMatchReversals(t *testing.T) {
+	// Latest match first: 3 trees from a second pool after 5 from the first one
+	matches := []models.Donation{{TreesCount: 3}, {TreesCount: 5}}
+
+	tests := []struct {
+		name           string
+		remainingTrees int
+		expected       []int
+	}{
+		{"nothing refunded", 8, []int{0, 0}},
+		{"more trees left than matched", 10, []int{0, 0}},
+		{"partial refund within the latest match", 6, []int{2, 0}},
+		{"partial refund across both matches", 2, []int{3, 3}},
+		{"full refund", 0, []int{3, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, matchReversals(matches, tt.remainingTrees))
+		})
+	}
+}
@@ -0,0 +1,965 @@
+package payments
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+)
+
+// DefaultPayPalAPIURL is the PayPal live REST API endpoint
+const DefaultPayPalAPIURL = "https://api-m.paypal.com"
+
+// PayPal webhook event types handled by the provider
+const (
+	PayPalEventOrderApproved             = "CHECKOUT.ORDER.APPROVED"
+	PayPalEventCaptureCompleted          = "PAYMENT.CAPTURE.COMPLETED"
+	PayPalEventCaptureDenied             = "PAYMENT.CAPTURE.DENIED"
+	PayPalEventCaptureDeclined           = "PAYMENT.CAPTURE.DECLINED"
+	PayPalEventCaptureRefunded           = "PAYMENT.CAPTURE.REFUNDED"
+	PayPalEventSaleCompleted             = "PAYMENT.SALE.COMPLETED"
+	PayPalEventSaleRefunded              = "PAYMENT.SALE.REFUNDED"
+	PayPalEventSubscriptionActivated     = "BILLING.SUBSCRIPTION.ACTIVATED"
+	PayPalEventSubscriptionReactivated   = "BILLING.SUBSCRIPTION.RE-ACTIVATED"
+	PayPalEventSubscriptionSuspended     = "BILLING.SUBSCRIPTION.SUSPENDED"
+	PayPalEventSubscriptionCancelled     = "BILLING.SUBSCRIPTION.CANCELLED"
+	PayPalEventSubscriptionExpired       = "BILLING.SUBSCRIPTION.EXPIRED"
+	PayPalEventSubscriptionUpdated       = "BILLING.SUBSCRIPTION.UPDATED"
+	PayPalEventSubscriptionPaymentFailed = "BILLING.SUBSCRIPTION.PAYMENT.FAILED"
+)
+
+// CertFetcher fetches the certificate a PayPal webhook was signed with
+type CertFetcher interface {
+	FetchCert(certURL string) (*x509.Certificate, error)
+}
+
+// PayPalPlanStore remembers the billing plan created for each amount, currency and interval
+type PayPalPlanStore interface {
+	// FindPlan returns the stored plan ID, or "" when no plan was stored yet
+	FindPlan(amountMinor int64, currency models.Currency, intervalMonths int) (string, error)
+	// SavePlan stores a plan ID and returns the plan to use; when another instance
+	// stored a plan first, that plan is returned
+	SavePlan(amountMinor int64, currency models.Currency, intervalMonths int, planID string) (string, error)
+}
+
+// PayPalConfig configures the PayPal provider
+type PayPalConfig struct {
+	ClientID string
+	Secret   string
+	// APIURL defaults to DefaultPayPalAPIURL; use https://api-m.sandbox.paypal.com for the sandbox
+	APIURL string
+	// WebhookID is the ID of the webhook registered in the PayPal app. Every webhook is
+	// rejected while it is empty.
+	WebhookID string
+	// ProductID is the catalog product billing plans are created for; a product is
+	// created on first use when it is empty
+	ProductID string
+	// BrandName is shown to the donor on the PayPal checkout pages
+	BrandName string
+	// CertFetcher defaults to an HTTPCertFetcher that only trusts paypal.com
+	CertFetcher CertFetcher
+	// PlanStore defaults to one that keeps plans in memory, so plans are created again
+	// after every restart
+	PlanStore PayPalPlanStore
+	// HTTPClient is used for API calls
+	HTTPClient *http.Client
+}
+
+// PayPalProvider handles PayPal integration. One-time donations use the Orders API
+// and are captured as soon as the donor approves them; subscriptions use billing plans.
+type PayPalProvider struct {
+	cfg        PayPalConfig
+	httpClient *http.Client
+
+	mu             sync.Mutex
+	accessToken    string
+	tokenExpiresAt time.Time
+
+	// planMu is held while plans are created, so that concurrent intents share a plan
+	planMu    sync.Mutex
+	productID string
+}
+
+// NewPayPalProvider creates a new PayPal provider
+func NewPayPalProvider(cfg PayPalConfig) *PayPalProvider {
+	if cfg.APIURL == "" {
+		cfg.APIURL = DefaultPayPalAPIURL
+	}
+	cfg.APIURL = strings.TrimSuffix(cfg.APIURL, "/")
+	if cfg.BrandName == "" {
+		cfg.BrandName = "4Planet"
+	}
+	if cfg.CertFetcher == nil {
+		cfg.CertFetcher = NewHTTPCertFetcher(nil)
+	}
+	if cfg.PlanStore == nil {
+		cfg.PlanStore = newMemoryPlanStore()
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &PayPalProvider{
+		cfg:        cfg,
+		httpClient: httpClient,
+		productID:  cfg.ProductID,
+	}
+}
+
+// Name returns the provider name
+func (p *PayPalProvider) Name() models.PaymentProvider {
+	return models.PaymentProviderPayPal
+}
+
+// payPalSupportsCurrency reports whether donations in currency can be charged through PayPal
+func payPalSupportsCurrency(currency models.Currency) bool {
+	return currency == models.CurrencyUSD || currency == models.CurrencyEUR
+}
+
+// payPalMoney is the Orders and Billing API representation of an amount
+type payPalMoney struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// payPalLink is a HATEOAS link of a PayPal resource
+type payPalLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+// payPalOrder is the Orders API representation of an order
+type payPalOrder struct {
+	ID            string `json:"id"`
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		CustomID string       `json:"custom_id"`
+		Amount   *payPalMoney `json:"amount"`
+		Payments struct {
+			Captures []payPalCapture `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+	Links []payPalLink `json:"links"`
+}
+
+// payPalCapture is the Payments API representation of a captured payment
+type payPalCapture struct {
+	ID            string      `json:"id"`
+	Status        string      `json:"status"`
+	Amount        payPalMoney `json:"amount"`
+	CustomID      string      `json:"custom_id"`
+	CreateTime    time.Time   `json:"create_time"`
+	StatusDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"status_details,omitempty"`
+	SupplementaryData *struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data,omitempty"`
+}
+
+// payPalRefund is the Payments API representation of a capture refund
+type payPalRefund struct {
+	ID         string       `json:"id"`
+	Status     string       `json:"status"`
+	Amount     payPalMoney  `json:"amount"`
+	CustomID   string       `json:"custom_id"`
+	CreateTime time.Time    `json:"create_time"`
+	Links      []payPalLink `json:"links"`
+}
+
+// payPalSale is the v1 Payments API representation of a subscription charge or its refund
+type payPalSale struct {
+	ID     string `json:"id"`
+	State  string `json:"state"`
+	Amount struct {
+		Total    string `json:"total"`
+		Currency string `json:"currency"`
+	} `json:"amount"`
+	BillingAgreementID string    `json:"billing_agreement_id"`
+	SaleID             string    `json:"sale_id"`
+	Custom             string    `json:"custom"`
+	CreateTime         time.Time `json:"create_time"`
+}
+
+// payPalSubscription is the Billing API representation of a subscription
+type payPalSubscription struct {
+	ID          string       `json:"id"`
+	Status      string       `json:"status"`
+	PlanID      string       `json:"plan_id"`
+	CustomID    string       `json:"custom_id"`
+	Links       []payPalLink `json:"links"`
+	BillingInfo *struct {
+		LastFailedPayment *struct {
+			Amount payPalMoney `json:"amount"`
+			Time   time.Time   `json:"time"`
+		} `json:"last_failed_payment,omitempty"`
+	} `json:"billing_info,omitempty"`
+}
+
+// approveURL returns the link the donor follows to approve an order or subscription
+func approveURL(links []payPalLink) string {
+	for _, link := range links {
+		if link.Rel == "approve" || link.Rel == "payer-action" {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// CreatePaymentIntent creates a PayPal order for a one-time payment
+func (p *PayPalProvider) CreatePaymentIntent(payment *models.Payment, req *PaymentIntentRequest) (*PaymentIntentResponse, error) {
+	if !payPalSupportsCurrency(payment.Currency) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, payment.Currency)
+	}
+
+	description := "Tree planting donation"
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	body := map[string]interface{}{
+		"intent": "CAPTURE",
+		"purchase_units": []map[string]interface{}{{
+			"reference_id": payment.ID.String(),
+			// custom_id and invoice_id are copied to captures and refunds
+			"custom_id":   payment.ID.String(),
+			"invoice_id":  payment.ID.String(),
+			"description": description,
+			"amount": payPalMoney{
+				CurrencyCode: string(payment.Currency),
+				Value:        FormatAmountMinor(payment.AmountMinor),
+			},
+		}},
+		"application_context": map[string]interface{}{
+			"brand_name":          p.cfg.BrandName,
+			"return_url":          req.SuccessReturnURL,
+			"cancel_url":          req.FailReturnURL,
+			"user_action":         "PAY_NOW",
+			"shipping_preference": "NO_SHIPPING",
+		},
+	}
+
+	var order payPalOrder
+	if err := p.call(http.MethodPost, "/v2/checkout/orders", body, &order); err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+
+	// The order ID is replaced by the capture ID once the order is captured
+	payment.ProviderPaymentID = &order.ID
+
+	return &PaymentIntentResponse{
+		Provider:    string(p.Name()),
+		RedirectURL: approveURL(order.Links),
+		ProviderPayload: map[string]interface{}{
+			"orderId": order.ID,
+			"status":  order.Status,
+		},
+	}, nil
+}
+
+// CaptureOrder captures an approved order. Capturing an already captured order succeeds.
+func (p *PayPalProvider) CaptureOrder(orderID string) error {
+	var order payPalOrder
+	err := p.call(http.MethodPost, "/v2/checkout/orders/"+url.PathEscape(orderID)+"/capture", struct{}{}, &order)
+
+	var apiErr *PayPalAPIError
+	if errors.As(err, &apiErr) && apiErr.Issue == "ORDER_ALREADY_CAPTURED" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to capture order %s: %w", orderID, err)
+	}
+	return nil
+}
+
+// CreateSubscriptionIntent creates a PayPal subscription on a billing plan matching the amount and interval
+func (p *PayPalProvider) CreateSubscriptionIntent(subscription *models.Subscription, req *SubscriptionIntentRequest) (*SubscriptionIntentResponse, error) {
+	if !payPalSupportsCurrency(subscription.Currency) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, subscription.Currency)
+	}
+
+	planID, err := p.plan(subscription.AmountMinor, subscription.Currency, subscription.IntervalMonths)
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]interface{}{
+		"plan_id":   planID,
+		"custom_id": subscription.ID.String(),
+		"application_context": map[string]interface{}{
+			"brand_name":          p.cfg.BrandName,
+			"return_url":          req.SuccessReturnURL,
+			"cancel_url":          req.FailReturnURL,
+			"user_action":         "SUBSCRIBE_NOW",
+			"shipping_preference": "NO_SHIPPING",
+		},
+	}
+
+	var payPalSub payPalSubscription
+	if err := p.call(http.MethodPost, "/v1/billing/subscriptions", body, &payPalSub); err != nil {
+		return nil, fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	subscription.ProviderSubscriptionID = &payPalSub.ID
+
+	return &SubscriptionIntentResponse{
+		Provider:    string(p.Name()),
+		RedirectURL: approveURL(payPalSub.Links),
+		ProviderPayload: map[string]interface{}{
+			"subscriptionId": payPalSub.ID,
+			"planId":         planID,
+			"status":         payPalSub.Status,
+		},
+	}, nil
+}
+
+// plan returns a billing plan charging amountMinor every intervalMonths. A plan is only
+// created when the plan store has none yet.
+func (p *PayPalProvider) plan(amountMinor int64, currency models.Currency, intervalMonths int) (string, error) {
+	p.planMu.Lock()
+	defer p.planMu.Unlock()
+
+	planID, err := p.cfg.PlanStore.FindPlan(amountMinor, currency, intervalMonths)
+	if err != nil {
+		return "", fmt.Errorf("failed to find billing plan: %w", err)
+	}
+	if planID != "" {
+		return planID, nil
+	}
+
+	if p.productID == "" {
+		var product struct {
+			ID string `json:"id"`
+		}
+		body := map[string]interface{}{
+			"name":        "Tree planting",
+			"description": "Recurring tree planting donation",
+			"type":        "SERVICE",
+		}
+		if err := p.call(http.MethodPost, "/v1/catalogs/products", body, &product); err != nil {
+			return "", fmt.Errorf("failed to create product: %w", err)
+		}
+		p.productID = product.ID
+	}
+
+	body := map[string]interface{}{
+		"product_id": p.productID,
+		"name":       fmt.Sprintf("Tree planting, %s %s every %d month(s)", FormatAmountMinor(amountMinor), currency, intervalMonths),
+		"status":     "ACTIVE",
+		"billing_cycles": []map[string]interface{}{{
+			"frequency": map[string]interface{}{
+				"interval_unit":  "MONTH",
+				"interval_count": intervalMonths,
+			},
+			"tenure_type":  "REGULAR",
+			"sequence":     1,
+			"total_cycles": 0,
+			"pricing_scheme": map[string]interface{}{
+				"fixed_price": payPalMoney{
+					CurrencyCode: string(currency),
+					Value:        FormatAmountMinor(amountMinor),
+				},
+			},
+		}},
+		"payment_preferences": map[string]interface{}{
+			"auto_bill_outstanding":     true,
+			"payment_failure_threshold": 3,
+		},
+	}
+
+	var plan struct {
+		ID string `json:"id"`
+	}
+	if err := p.call(http.MethodPost, "/v1/billing/plans", body, &plan); err != nil {
+		return "", fmt.Errorf("failed to create billing plan: %w", err)
+	}
+
+	planID, err = p.cfg.PlanStore.SavePlan(amountMinor, currency, intervalMonths, plan.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to store billing plan: %w", err)
+	}
+	return planID, nil
+}
+
+// payPalWebhook is the envelope of every PayPal webhook
+type payPalWebhook struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	CreateTime   time.Time       `json:"create_time"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+// VerifyWebhook verifies the signature PayPal puts in the transmission headers
+func (p *PayPalProvider) VerifyWebhook(req *WebhookRequest) error {
+	if req.Kind != "" {
+		return fmt.Errorf("%w: %q", ErrUnsupportedWebhook, req.Kind)
+	}
+	if p.cfg.WebhookID == "" {
+		return fmt.Errorf("%w: PayPal webhook ID is not configured", ErrInvalidSignature)
+	}
+
+	transmissionID := req.Header.Get("PAYPAL-TRANSMISSION-ID")
+	transmissionTime := req.Header.Get("PAYPAL-TRANSMISSION-TIME")
+	certURL := req.Header.Get("PAYPAL-CERT-URL")
+	authAlgo := req.Header.Get("PAYPAL-AUTH-ALGO")
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get("PAYPAL-TRANSMISSION-SIG"))
+	if err != nil || len(signature) == 0 || transmissionID == "" || transmissionTime == "" || certURL == "" {
+		return fmt.Errorf("%w: missing transmission headers", ErrInvalidSignature)
+	}
+	if authAlgo != "" && authAlgo != "SHA256withRSA" {
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidSignature, authAlgo)
+	}
+
+	cert, err := p.cfg.CertFetcher.FetchCert(certURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch PayPal certificate: %w", err)
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: certificate expired", ErrInvalidSignature)
+	}
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: unexpected certificate key", ErrInvalidSignature)
+	}
+
+	message := PayPalSignedMessage(transmissionID, transmissionTime, p.cfg.WebhookID, req.Body)
+	digest := sha256.Sum256([]byte(message))
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature); err != nil {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// PayPalSignedMessage returns the string PayPal signs for a webhook transmission
+func PayPalSignedMessage(transmissionID, transmissionTime, webhookID string, body []byte) string {
+	return fmt.Sprintf("%s|%s|%s|%d", transmissionID, transmissionTime, webhookID, crc32.ChecksumIEEE(body))
+}
+
+// ParseWebhook translates a PayPal webhook into a WebhookEvent. An approved order is
+// captured right away; the capture is then reported by its own webhook.
+func (p *PayPalProvider) ParseWebhook(req *WebhookRequest) (*WebhookEvent, error) {
+	var webhook payPalWebhook
+	if err := json.Unmarshal(req.Body, &webhook); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	if webhook.ID == "" || webhook.EventType == "" {
+		return nil, fmt.Errorf("%w: id and event_type are required", ErrInvalidPayload)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(req.Body, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	event := &WebhookEvent{
+		Type:           EventIgnored,
+		Kind:           webhook.EventType,
+		IdempotencyKey: &webhook.ID,
+		OccurredAt:     webhook.CreateTime,
+		Raw:            raw,
+	}
+
+	var err error
+	switch webhook.EventType {
+	case PayPalEventOrderApproved:
+		err = p.parseOrderApproved(event, webhook.Resource)
+	case PayPalEventCaptureCompleted, PayPalEventCaptureDenied, PayPalEventCaptureDeclined:
+		err = parseCapture(event, webhook.Resource)
+	case PayPalEventCaptureRefunded:
+		err = parseCaptureRefund(event, webhook.Resource)
+	case PayPalEventSaleCompleted, PayPalEventSaleRefunded:
+		err = parseSale(event, webhook.Resource)
+	case PayPalEventSubscriptionActivated, PayPalEventSubscriptionReactivated, PayPalEventSubscriptionSuspended,
+		PayPalEventSubscriptionCancelled, PayPalEventSubscriptionExpired, PayPalEventSubscriptionPaymentFailed,
+		PayPalEventSubscriptionUpdated:
+		err = parseSubscription(event, webhook.Resource)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return event, nil
+}
+
+func (p *PayPalProvider) parseOrderApproved(event *WebhookEvent, resource json.RawMessage) error {
+	var order payPalOrder
+	if err := json.Unmarshal(resource, &order); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	event.Type = EventPaymentAuthorized
+	event.ProviderPaymentID = order.ID
+	if len(order.PurchaseUnits) > 0 {
+		event.InvoiceID = order.PurchaseUnits[0].CustomID
+	}
+
+	if order.Status == "COMPLETED" {
+		return nil
+	}
+	// A failed capture is answered with a server error so that PayPal redelivers the approval
+	return p.CaptureOrder(order.ID)
+}
+
+func parseCapture(event *WebhookEvent, resource json.RawMessage) error {
+	var capture payPalCapture
+	if err := json.Unmarshal(resource, &capture); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	amountMinor, err := ParseAmountMinor(capture.Amount.Value)
+	if err != nil {
+		return err
+	}
+
+	event.Type = EventPaymentSucceeded
+	if event.Kind != PayPalEventCaptureCompleted {
+		event.Type = EventPaymentFailed
+	}
+	event.InvoiceID = capture.CustomID
+	event.ProviderPaymentID = capture.ID
+	event.AmountMinor = amountMinor
+	event.Currency = models.Currency(capture.Amount.CurrencyCode)
+	if !capture.CreateTime.IsZero() {
+		event.OccurredAt = capture.CreateTime
+	}
+
+	event.Meta = map[string]interface{}{}
+	if capture.SupplementaryData != nil && capture.SupplementaryData.RelatedIDs.OrderID != "" {
+		event.Meta["paypal_order_id"] = capture.SupplementaryData.RelatedIDs.OrderID
+	}
+	if capture.StatusDetails != nil {
+		event.Meta["failure_reason"] = capture.StatusDetails.Reason
+	}
+	return nil
+}
+
+func parseCaptureRefund(event *WebhookEvent, resource json.RawMessage) error {
+	var refund payPalRefund
+	if err := json.Unmarshal(resource, &refund); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	amountMinor, err := ParseAmountMinor(refund.Amount.Value)
+	if err != nil {
+		return err
+	}
+
+	// The refunded capture is only referenced by the "up" link
+	for _, link := range refund.Links {
+		if link.Rel == "up" {
+			event.ProviderPaymentID = path.Base(link.Href)
+		}
+	}
+
+	event.Type = EventPaymentRefunded
+	event.InvoiceID = refund.CustomID
+	event.RefundID = refund.ID
+	event.AmountMinor = amountMinor
+	event.Currency = models.Currency(refund.Amount.CurrencyCode)
+	if !refund.CreateTime.IsZero() {
+		event.OccurredAt = refund.CreateTime
+	}
+	return nil
+}
+
+func parseSale(event *WebhookEvent, resource json.RawMessage) error {
+	var sale payPalSale
+	if err := json.Unmarshal(resource, &sale); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	amountMinor, err := ParseAmountMinor(sale.Amount.Total)
+	if err != nil {
+		return err
+	}
+
+	event.AmountMinor = amountMinor
+	event.Currency = models.Currency(sale.Amount.Currency)
+	if !sale.CreateTime.IsZero() {
+		event.OccurredAt = sale.CreateTime
+	}
+
+	if event.Kind == PayPalEventSaleRefunded {
+		event.Type = EventPaymentRefunded
+		event.ProviderPaymentID = sale.SaleID
+		event.RefundID = sale.ID
+		return nil
+	}
+
+	event.Type = EventPaymentSucceeded
+	event.InvoiceID = sale.Custom
+	event.ProviderPaymentID = sale.ID
+	event.ProviderSubscriptionID = sale.BillingAgreementID
+	return nil
+}
+
+func parseSubscription(event *WebhookEvent, resource json.RawMessage) error {
+	var subscription payPalSubscription
+	if err := json.Unmarshal(resource, &subscription); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	event.InvoiceID = subscription.CustomID
+	event.ProviderSubscriptionID = subscription.ID
+
+	switch event.Kind {
+	case PayPalEventSubscriptionPaymentFailed:
+		event.Type = EventPaymentFailed
+		if subscription.BillingInfo != nil && subscription.BillingInfo.LastFailedPayment != nil {
+			failed := subscription.BillingInfo.LastFailedPayment
+			event.AmountMinor, _ = ParseAmountMinor(failed.Amount.Value)
+			event.Currency = models.Currency(failed.Amount.CurrencyCode)
+			event.OccurredAt = failed.Time
+		}
+	case PayPalEventSubscriptionActivated, PayPalEventSubscriptionReactivated:
+		event.Type = EventSubscriptionUpdated
+		event.SubscriptionStatus = models.SubscriptionStatusActive
+	case PayPalEventSubscriptionSuspended:
+		event.Type = EventSubscriptionUpdated
+		event.SubscriptionStatus = models.SubscriptionStatusPastDue
+	case PayPalEventSubscriptionCancelled, PayPalEventSubscriptionExpired:
+		event.Type = EventSubscriptionUpdated
+		event.SubscriptionStatus = models.SubscriptionStatusCanceled
+	case PayPalEventSubscriptionUpdated:
+		// Sent once the donor approved a revision; the subscription is on the new plan
+		event.Type = EventSubscriptionRevised
+		event.ProviderPlanID = subscription.PlanID
+	}
+	return nil
+}
+
+// WebhookResponse acknowledges a webhook. PayPal redelivers any webhook that is not
+// answered with a 2xx status, so only errors that redelivery may fix are reported.
+func (p *PayPalProvider) WebhookResponse(event *WebhookEvent, err error) (int, interface{}) {
+	switch {
+	case err == nil:
+		return http.StatusOK, nil
+	case errors.Is(err, ErrInvalidSignature):
+		return http.StatusUnauthorized, map[string]interface{}{"error": "Invalid signature"}
+	case errors.Is(err, ErrInvalidPayload):
+		return http.StatusBadRequest, map[string]interface{}{"error": "Invalid payload"}
+	case errors.Is(err, ErrUnknownInvoice), errors.Is(err, ErrAccountMismatch),
+		errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrPaymentRejected):
+		return http.StatusOK, nil
+	default:
+		return http.StatusInternalServerError, map[string]interface{}{"error": "Failed to process webhook"}
+	}
+}
+
+// Refund refunds amountMinor of a captured order or of a subscription charge
+func (p *PayPalProvider) Refund(payment *models.Payment, amountMinor int64) (*RefundResult, error) {
+	if payment.ProviderPaymentID == nil || payment.Status != models.PaymentStatusSucceeded {
+		return nil, fmt.Errorf("payment %s has no PayPal capture", payment.ID)
+	}
+	id := url.PathEscape(*payment.ProviderPaymentID)
+
+	var refund struct {
+		ID string `json:"id"`
+	}
+
+	// Subscription charges are v1 sales, one-time payments are v2 captures
+	if payment.SubscriptionID != nil {
+		body := map[string]interface{}{
+			"amount": map[string]interface{}{
+				"total":    FormatAmountMinor(amountMinor),
+				"currency": string(payment.Currency),
+			},
+		}
+		if err := p.call(http.MethodPost, "/v1/payments/sale/"+id+"/refund", body, &refund); err != nil {
+			return nil, fmt.Errorf("failed to refund payment: %w", err)
+		}
+	} else {
+		body := map[string]interface{}{
+			"amount": payPalMoney{
+				CurrencyCode: string(payment.Currency),
+				Value:        FormatAmountMinor(amountMinor),
+			},
+		}
+		if err := p.call(http.MethodPost, "/v2/payments/captures/"+id+"/refund", body, &refund); err != nil {
+			return nil, fmt.Errorf("failed to refund payment: %w", err)
+		}
+	}
+
+	return &RefundResult{ProviderRefundID: refund.ID}, nil
+}
+
+// UpdateSubscription cancels, suspends or reactivates a PayPal subscription, or revises
+// it onto a billing plan with the new terms. A revision has to be approved by the donor.
+func (p *PayPalProvider) UpdateSubscription(subscription *models.Subscription, update *SubscriptionUpdate) (*SubscriptionUpdateResult, error) {
+	if subscription.ProviderSubscriptionID == nil {
+		return nil, fmt.Errorf("subscription %s has no PayPal subscription", subscription.ID)
+	}
+	path := "/v1/billing/subscriptions/" + url.PathEscape(*subscription.ProviderSubscriptionID)
+
+	reason := "Requested by the donor"
+	if update.Reason != nil && *update.Reason != "" {
+		reason = *update.Reason
+	}
+
+	switch update.Action {
+	case SubscriptionActionCancel, SubscriptionActionPause, SubscriptionActionResume:
+		operation := map[SubscriptionAction]string{
+			SubscriptionActionCancel: "/cancel",
+			SubscriptionActionPause:  "/suspend",
+			SubscriptionActionResume: "/activate",
+		}[update.Action]
+		if err := p.call(http.MethodPost, path+operation, map[string]string{"reason": reason}, nil); err != nil {
+			return nil, fmt.Errorf("failed to %s subscription: %w", update.Action, err)
+		}
+		return &SubscriptionUpdateResult{}, nil
+	case SubscriptionActionChange:
+		planID, err := p.plan(update.AmountMinor, subscription.Currency, update.IntervalMonths)
+		if err != nil {
+			return nil, err
+		}
+		var revision struct {
+			Links []payPalLink `json:"links"`
+		}
+		if err := p.call(http.MethodPost, path+"/revise", map[string]string{"plan_id": planID}, &revision); err != nil {
+			return nil, fmt.Errorf("failed to revise subscription: %w", err)
+		}
+		// The revision takes effect once the donor approves it, which PayPal reports with
+		// BILLING.SUBSCRIPTION.UPDATED
+		return &SubscriptionUpdateResult{RedirectURL: approveURL(revision.Links), ProviderPlanID: planID}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrSubscriptionChangeNotSupported, update.Action)
+	}
+}
+
+// RetrySubscriptionCharge captures the outstanding balance of a PayPal subscription
+func (p *PayPalProvider) RetrySubscriptionCharge(subscription *models.Subscription) error {
+	if subscription.ProviderSubscriptionID == nil {
+		return fmt.Errorf("subscription %s has no PayPal subscription", subscription.ID)
+	}
+
+	body := map[string]interface{}{
+		"note":         "Retry of a failed charge",
+		"capture_type": "OUTSTANDING_BALANCE",
+		"amount": payPalMoney{
+			CurrencyCode: string(subscription.Currency),
+			Value:        FormatAmountMinor(subscription.AmountMinor),
+		},
+	}
+	path := "/v1/billing/subscriptions/" + url.PathEscape(*subscription.ProviderSubscriptionID) + "/capture"
+	if err := p.call(http.MethodPost, path, body, nil); err != nil {
+		return fmt.Errorf("failed to capture outstanding balance: %w", err)
+	}
+	return nil
+}
+
+// PayPalAPIError is an error response of the PayPal REST API
+type PayPalAPIError struct {
+	StatusCode int
+	Name       string
+	Message    string
+	// Issue is the first detail issue, e.g. ORDER_ALREADY_CAPTURED
+	Issue string
+}
+
+func (e *PayPalAPIError) Error() string {
+	msg := fmt.Sprintf("PayPal API returned status %d", e.StatusCode)
+	if e.Name != "" {
+		msg += ": " + e.Name
+	}
+	if e.Issue != "" {
+		msg += " (" + e.Issue + ")"
+	}
+	return msg
+}
+
+// token returns a cached OAuth access token, requesting a new one when it expires
+func (p *PayPalProvider) token() (string, error) {
+	p.mu.Lock()
+	if p.accessToken != "" && time.Now().Before(p.tokenExpiresAt) {
+		token := p.accessToken
+		p.mu.Unlock()
+		return token, nil
+	}
+	p.mu.Unlock()
+
+	req, err := http.NewRequest(http.MethodPost, p.cfg.APIURL+"/v1/oauth2/token",
+		strings.NewReader(url.Values{"grant_type": {"client_credentials"}}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(p.cfg.ClientID, p.cfg.Secret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("PayPal authentication returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode PayPal token: %w", err)
+	}
+
+	p.mu.Lock()
+	p.accessToken = result.AccessToken
+	// Renew a minute early so that a token never expires mid-request
+	p.tokenExpiresAt = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	p.mu.Unlock()
+
+	return result.AccessToken, nil
+}
+
+// call performs an authenticated PayPal API request and decodes the response
+func (p *PayPalProvider) call(method, path string, body interface{}, result interface{}) error {
+	token, err := p.token()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, p.cfg.APIURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Prefer", "return=representation")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &PayPalAPIError{StatusCode: resp.StatusCode}
+		var errBody struct {
+			Name    string `json:"name"`
+			Message string `json:"message"`
+			Details []struct {
+				Issue string `json:"issue"`
+			} `json:"details"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&errBody) == nil {
+			apiErr.Name = errBody.Name
+			apiErr.Message = errBody.Message
+			if len(errBody.Details) > 0 {
+				apiErr.Issue = errBody.Details[0].Issue
+			}
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to decode PayPal response: %w", err)
+	}
+	return nil
+}
+
+// HTTPCertFetcher downloads and caches PayPal signing certificates. Only https URLs on
+// the allowed hosts are fetched, so a forged webhook cannot point at its own certificate.
+type HTTPCertFetcher struct {
+	client       *http.Client
+	allowedHosts []string
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewHTTPCertFetcher creates a certificate fetcher trusting allowedHosts and their
+// subdomains; paypal.com is trusted when none are given
+func NewHTTPCertFetcher(client *http.Client, allowedHosts ...string) *HTTPCertFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(allowedHosts) == 0 {
+		allowedHosts = []string{"paypal.com"}
+	}
+	return &HTTPCertFetcher{
+		client:       client,
+		allowedHosts: allowedHosts,
+		certs:        make(map[string]*x509.Certificate),
+	}
+}
+
+// FetchCert returns the certificate at certURL
+func (f *HTTPCertFetcher) FetchCert(certURL string) (*x509.Certificate, error) {
+	f.mu.Lock()
+	cert, ok := f.certs[certURL]
+	f.mu.Unlock()
+	if ok {
+		return cert, nil
+	}
+
+	u, err := url.Parse(certURL)
+	if err != nil || u.Scheme != "https" || !f.allowedHost(u.Hostname()) {
+		return nil, fmt.Errorf("%w: untrusted certificate URL %q", ErrInvalidSignature, certURL)
+	}
+
+	resp, err := f.client.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("certificate download returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	f.mu.Lock()
+	f.certs[certURL] = cert
+	f.mu.Unlock()
+
+	return cert, nil
+}
+
+func (f *HTTPCertFetcher) allowedHost(host string) bool {
+	for _, allowed := range f.allowedHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,442 @@
+package payments
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/payments/paypaltest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPayPalProvider(server *paypaltest.Server) *PayPalProvider {
+	return NewPayPalProvider(PayPalConfig{
+		ClientID:    "client-id",
+		Secret:      "secret",
+		APIURL:      server.URL,
+		WebhookID:   server.WebhookID,
+		CertFetcher: server,
+	})
+}
+
+// payPalMerchant records the webhooks PayPal delivers, verifying and parsing them the way Service does
+type payPalMerchant struct {
+	t        *testing.T
+	provider *PayPalProvider
+
+	mu     sync.Mutex
+	events []*WebhookEvent
+}
+
+func (m *payPalMerchant) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	require.NoError(m.t, err)
+
+	req := &WebhookRequest{Method: r.Method, Header: r.Header, Body: body}
+	require.NoError(m.t, m.provider.VerifyWebhook(req))
+
+	event, err := m.provider.ParseWebhook(req)
+	require.NoError(m.t, err)
+
+	m.mu.Lock()
+	m.events = append(m.events, event)
+	m.mu.Unlock()
+
+	status, _ := m.provider.WebhookResponse(event, nil)
+	w.WriteHeader(status)
+}
+
+// event returns the last recorded event of the given PayPal event type
+func (m *payPalMerchant) event(kind string) *WebhookEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.events) - 1; i >= 0; i-- {
+		if m.events[i].Kind == kind {
+			return m.events[i]
+		}
+	}
+	return nil
+}
+
+func startPayPal(t *testing.T) (*paypaltest.Server, *PayPalProvider, *payPalMerchant) {
+	paypal := paypaltest.NewServer("client-id", "secret")
+	t.Cleanup(paypal.Close)
+
+	provider := newPayPalProvider(paypal)
+	merchant := &payPalMerchant{t: t, provider: provider}
+	merchantServer := httptest.NewServer(merchant)
+	t.Cleanup(merchantServer.Close)
+	paypal.WebhookURL = merchantServer.URL
+
+	return paypal, provider, merchant
+}
+
+func TestPayPalPaymentFlow(t *testing.T) {
+	paypal, provider, merchant := startPayPal(t)
+
+	payment := &models.Payment{
+		ID:          uuid.New(),
+		Provider:    models.PaymentProviderPayPal,
+		AmountMinor: 2500,
+		Currency:    models.CurrencyUSD,
+		Status:      models.PaymentStatusPending,
+	}
+	response, err := provider.CreatePaymentIntent(payment, &PaymentIntentRequest{
+		Provider:         "paypal",
+		SuccessReturnURL: "https://app.local/return/success",
+		FailReturnURL:    "https://app.local/return/fail",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, payment.ProviderPaymentID)
+
+	orderID := *payment.ProviderPaymentID
+	assert.Equal(t, "paypal", response.Provider)
+	assert.Equal(t, paypal.URL+"/checkoutnow?token="+orderID, response.RedirectURL)
+	assert.Equal(t, orderID, response.ProviderPayload["orderId"])
+
+	order, ok := paypal.Order(orderID)
+	require.True(t, ok)
+	assert.Equal(t, payment.ID.String(), order.CustomID)
+	assert.Equal(t, "25.00", order.Value)
+	assert.Equal(t, "USD", order.CurrencyCode)
+
+	// Approving the order makes the merchant capture it, which PayPal reports separately
+	require.NoError(t, paypal.Approve(orderID))
+
+	approved := merchant.event(PayPalEventOrderApproved)
+	require.NotNil(t, approved)
+	assert.Equal(t, EventPaymentAuthorized, approved.Type)
+	assert.Equal(t, payment.ID.String(), approved.InvoiceID)
+
+	order, _ = paypal.Order(orderID)
+	assert.Equal(t, "COMPLETED", order.Status)
+
+	captured := merchant.event(PayPalEventCaptureCompleted)
+	require.NotNil(t, captured)
+	assert.Equal(t, EventPaymentSucceeded, captured.Type)
+	assert.Equal(t, payment.ID.String(), captured.InvoiceID)
+	assert.Equal(t, order.CaptureID, captured.ProviderPaymentID)
+	assert.Equal(t, int64(2500), captured.AmountMinor)
+	assert.Equal(t, models.CurrencyUSD, captured.Currency)
+	assert.Equal(t, orderID, captured.Meta["paypal_order_id"])
+
+	// A redelivered approval does not capture twice
+	require.NoError(t, provider.CaptureOrder(orderID))
+
+	payment.Status = models.PaymentStatusSucceeded
+	payment.ProviderPaymentID = &captured.ProviderPaymentID
+
+	result, err := provider.Refund(payment, 1000)
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.ProviderRefundID)
+	assert.Equal(t, []string{"10.00"}, paypal.Refunds(order.CaptureID))
+
+	refunded := merchant.event(PayPalEventCaptureRefunded)
+	require.NotNil(t, refunded)
+	assert.Equal(t, EventPaymentRefunded, refunded.Type)
+	assert.Equal(t, order.CaptureID, refunded.ProviderPaymentID)
+	assert.Equal(t, result.ProviderRefundID, refunded.RefundID)
+	assert.Equal(t, int64(1000), refunded.AmountMinor)
+}
+
+func TestPayPalSubscriptionFlow(t *testing.T) {
+	paypal, provider, merchant := startPayPal(t)
+
+	subscription := &models.Subscription{
+		ID:             uuid.New(),
+		Provider:       models.PaymentProviderPayPal,
+		AmountMinor:    1000,
+		Currency:       models.CurrencyEUR,
+		IntervalMonths: 1,
+		Status:         models.SubscriptionStatusIncomplete,
+	}
+	response, err := provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	require.NotNil(t, subscription.ProviderSubscriptionID)
+
+	subscriptionID := *subscription.ProviderSubscriptionID
+	assert.Equal(t, subscriptionID, response.ProviderPayload["subscriptionId"])
+	assert.NotEmpty(t, response.RedirectURL)
+
+	plan, ok := paypal.Plan(response.ProviderPayload["planId"].(string))
+	require.True(t, ok)
+	assert.Equal(t, "10.00", plan.Value)
+	assert.Equal(t, "EUR", plan.CurrencyCode)
+	assert.Equal(t, 1, plan.IntervalCount)
+
+	// Subscriptions with the same terms share a billing plan
+	other := &models.Subscription{ID: uuid.New(), AmountMinor: 1000, Currency: models.CurrencyEUR, IntervalMonths: 1}
+	otherResponse, err := provider.CreateSubscriptionIntent(other, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, response.ProviderPayload["planId"], otherResponse.ProviderPayload["planId"])
+
+	// Another instance with the same plan store reuses the plan instead of creating one
+	restarted := NewPayPalProvider(PayPalConfig{
+		ClientID:  "client-id",
+		Secret:    "secret",
+		APIURL:    paypal.URL,
+		PlanStore: provider.cfg.PlanStore,
+	})
+	restartedResponse, err := restarted.CreateSubscriptionIntent(&models.Subscription{ID: uuid.New(), AmountMinor: 1000, Currency: models.CurrencyEUR, IntervalMonths: 1}, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, response.ProviderPayload["planId"], restartedResponse.ProviderPayload["planId"])
+
+	require.NoError(t, paypal.ApproveSubscription(subscriptionID))
+
+	activated := merchant.event(PayPalEventSubscriptionActivated)
+	require.NotNil(t, activated)
+	assert.Equal(t, EventSubscriptionUpdated, activated.Type)
+	assert.Equal(t, models.SubscriptionStatusActive, activated.SubscriptionStatus)
+	assert.Equal(t, subscriptionID, activated.ProviderSubscriptionID)
+	assert.Equal(t, subscription.ID.String(), activated.InvoiceID)
+
+	charged := merchant.event(PayPalEventSaleCompleted)
+	require.NotNil(t, charged)
+	assert.Equal(t, EventPaymentSucceeded, charged.Type)
+	assert.Equal(t, subscriptionID, charged.ProviderSubscriptionID)
+	assert.Equal(t, int64(1000), charged.AmountMinor)
+	assert.Equal(t, models.CurrencyEUR, charged.Currency)
+
+	require.NoError(t, paypal.FailSubscriptionPayment(subscriptionID))
+
+	failed := merchant.event(PayPalEventSubscriptionPaymentFailed)
+	require.NotNil(t, failed)
+	assert.Equal(t, EventPaymentFailed, failed.Type)
+	assert.Equal(t, int64(1000), failed.AmountMinor)
+
+	subscriptionID2 := subscription.ID
+	payment := &models.Payment{
+		ID:                uuid.New(),
+		SubscriptionID:    &subscriptionID2,
+		AmountMinor:       1000,
+		Currency:          models.CurrencyEUR,
+		Status:            models.PaymentStatusSucceeded,
+		ProviderPaymentID: &charged.ProviderPaymentID,
+	}
+	_, err = provider.Refund(payment, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.00"}, paypal.Refunds(charged.ProviderPaymentID))
+
+	refunded := merchant.event(PayPalEventSaleRefunded)
+	require.NotNil(t, refunded)
+	assert.Equal(t, EventPaymentRefunded, refunded.Type)
+	assert.Equal(t, charged.ProviderPaymentID, refunded.ProviderPaymentID)
+}
+
+func TestPayPalVerifyWebhook(t *testing.T) {
+	paypal := paypaltest.NewServer("client-id", "secret")
+	defer paypal.Close()
+
+	var received *WebhookRequest
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = &WebhookRequest{Method: r.Method, Header: r.Header, Body: body}
+	}))
+	defer merchant.Close()
+	paypal.WebhookURL = merchant.URL
+
+	_, err := paypal.SendWebhook(PayPalEventCaptureCompleted, "capture", map[string]string{"id": "CAPTURE-1"})
+	require.NoError(t, err)
+	require.NotNil(t, received)
+
+	provider := newPayPalProvider(paypal)
+	assert.NoError(t, provider.VerifyWebhook(received))
+
+	tampered := *received
+	tampered.Body = append([]byte(nil), received.Body...)
+	tampered.Body[len(tampered.Body)-2] = ' '
+	assert.ErrorIs(t, provider.VerifyWebhook(&tampered), ErrInvalidSignature)
+
+	// A webhook signed for another webhook ID is rejected
+	other := NewPayPalProvider(PayPalConfig{WebhookID: "WH-OTHER", CertFetcher: paypal})
+	assert.ErrorIs(t, other.VerifyWebhook(received), ErrInvalidSignature)
+
+	missing := &WebhookRequest{Header: http.Header{}, Body: received.Body}
+	assert.ErrorIs(t, provider.VerifyWebhook(missing), ErrInvalidSignature)
+
+	assert.ErrorIs(t, provider.VerifyWebhook(&WebhookRequest{Kind: "pay"}), ErrUnsupportedWebhook)
+
+	// Without a webhook ID even correctly signed webhooks are rejected
+	unconfigured := NewPayPalProvider(PayPalConfig{CertFetcher: paypal})
+	assert.ErrorIs(t, unconfigured.VerifyWebhook(received), ErrInvalidSignature)
+	assert.ErrorIs(t, unconfigured.VerifyWebhook(missing), ErrInvalidSignature)
+}
+
+func TestHTTPCertFetcher_UntrustedURL(t *testing.T) {
+	fetcher := NewHTTPCertFetcher(nil)
+
+	tests := []string{
+		"http://api.paypal.com/cert.pem",
+		"https://paypal.com.evil.example/cert.pem",
+		"https://evilpaypal.com/cert.pem",
+		"https://127.0.0.1/cert.pem",
+	}
+	for _, certURL := range tests {
+		t.Run(certURL, func(t *testing.T) {
+			_, err := fetcher.FetchCert(certURL)
+			assert.ErrorIs(t, err, ErrInvalidSignature)
+		})
+	}
+}
+
+func TestPayPalCreatePaymentIntent_UnsupportedCurrency(t *testing.T) {
+	provider := NewPayPalProvider(PayPalConfig{APIURL: "http://127.0.0.1:0"})
+
+	payment := &models.Payment{ID: uuid.New(), AmountMinor: 19000, Currency: models.CurrencyRUB}
+	_, err := provider.CreatePaymentIntent(payment, &PaymentIntentRequest{Provider: "paypal"})
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+
+	subscription := &models.Subscription{ID: uuid.New(), AmountMinor: 19000, Currency: models.CurrencyKZT, IntervalMonths: 1}
+	_, err = provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	assert.ErrorIs(t, err, ErrUnsupportedCurrency)
+}
+
+func TestPayPalParseWebhook_Ignored(t *testing.T) {
+	provider := NewPayPalProvider(PayPalConfig{})
+
+	event, err := provider.ParseWebhook(&WebhookRequest{
+		Body: []byte(`{"id":"WH-1","event_type":"CUSTOMER.DISPUTE.CREATED","create_time":"2025-08-01T10:00:00Z","resource":{}}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, EventIgnored, event.Type)
+	assert.Equal(t, "CUSTOMER.DISPUTE.CREATED", event.Kind)
+	require.NotNil(t, event.IdempotencyKey)
+	assert.Equal(t, "WH-1", *event.IdempotencyKey)
+
+	_, err = provider.ParseWebhook(&WebhookRequest{Body: []byte(`{"event_type":"PAYMENT.CAPTURE.COMPLETED"}`)})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestPayPalWebhookResponse(t *testing.T) {
+	provider := NewPayPalProvider(PayPalConfig{})
+
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, http.StatusOK},
+		{"invalid signature", ErrInvalidSignature, http.StatusUnauthorized},
+		{"invalid payload", ErrInvalidPayload, http.StatusBadRequest},
+		{"unknown invoice", ErrUnknownInvoice, http.StatusOK},
+		{"invalid amount", ErrInvalidAmount, http.StatusOK},
+		{"database error", io.ErrUnexpectedEOF, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := provider.WebhookResponse(nil, tt.err)
+			assert.Equal(t, tt.expected, status)
+		})
+	}
+}
+
+func TestPayPalUpdateSubscription(t *testing.T) {
+	paypal, provider, merchant := startPayPal(t)
+
+	subscription := &models.Subscription{
+		ID:             uuid.New(),
+		Provider:       models.PaymentProviderPayPal,
+		AmountMinor:    1000,
+		Currency:       models.CurrencyEUR,
+		IntervalMonths: 1,
+		Status:         models.SubscriptionStatusIncomplete,
+	}
+	_, err := provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	subscriptionID := *subscription.ProviderSubscriptionID
+	require.NoError(t, paypal.ApproveSubscription(subscriptionID))
+
+	status := func() string {
+		payPalSub, ok := paypal.Subscription(subscriptionID)
+		require.True(t, ok)
+		return payPalSub.Status
+	}
+
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionPause})
+	require.NoError(t, err)
+	assert.Equal(t, "SUSPENDED", status())
+	suspended := merchant.event(PayPalEventSubscriptionSuspended)
+	require.NotNil(t, suspended)
+	// PayPal reports deliberate suspensions like the ones after failed payments
+	assert.Equal(t, models.SubscriptionStatusPastDue, suspended.SubscriptionStatus)
+
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionResume})
+	require.NoError(t, err)
+	assert.Equal(t, "ACTIVE", status())
+
+	// A revision moves the subscription onto a plan with the new terms and needs the donor's approval
+	result, err := provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionChange, AmountMinor: 2500, IntervalMonths: 3})
+	require.NoError(t, err)
+	assert.NotEmpty(t, result.RedirectURL)
+	require.NotEmpty(t, result.ProviderPlanID)
+	payPalSub, _ := paypal.Subscription(subscriptionID)
+	assert.NotEqual(t, result.ProviderPlanID, payPalSub.PlanID)
+	plan, ok := paypal.Plan(result.ProviderPlanID)
+	require.True(t, ok)
+	assert.Equal(t, "25.00", plan.Value)
+	assert.Equal(t, 3, plan.IntervalCount)
+
+	// The subscription moves onto the new plan once the donor approves the revision
+	require.NoError(t, paypal.ApproveRevision(subscriptionID))
+	payPalSub, _ = paypal.Subscription(subscriptionID)
+	assert.Equal(t, result.ProviderPlanID, payPalSub.PlanID)
+	revised := merchant.event(PayPalEventSubscriptionUpdated)
+	require.NotNil(t, revised)
+	assert.Equal(t, EventSubscriptionRevised, revised.Type)
+	assert.Equal(t, subscriptionID, revised.ProviderSubscriptionID)
+	assert.Equal(t, result.ProviderPlanID, revised.ProviderPlanID)
+
+	reason := "Moving abroad"
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionCancel, Reason: &reason})
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELLED", status())
+	require.NotNil(t, merchant.event(PayPalEventSubscriptionCancelled))
+
+	// A canceled subscription cannot be reactivated
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionResume})
+	var apiErr *PayPalAPIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "SUBSCRIPTION_STATUS_INVALID", apiErr.Issue)
+}
+
+func TestPayPalRetrySubscriptionCharge(t *testing.T) {
+	paypal, provider, merchant := startPayPal(t)
+
+	subscription := &models.Subscription{
+		ID:             uuid.New(),
+		Provider:       models.PaymentProviderPayPal,
+		AmountMinor:    1000,
+		Currency:       models.CurrencyEUR,
+		IntervalMonths: 1,
+		Status:         models.SubscriptionStatusIncomplete,
+	}
+	_, err := provider.CreateSubscriptionIntent(subscription, &SubscriptionIntentRequest{})
+	require.NoError(t, err)
+	subscriptionID := *subscription.ProviderSubscriptionID
+	require.NoError(t, paypal.ApproveSubscription(subscriptionID))
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionPause})
+	require.NoError(t, err)
+
+	// Capturing the outstanding balance reactivates the subscription and charges it
+	previousSale := merchant.event(PayPalEventSaleCompleted)
+	require.NoError(t, provider.RetrySubscriptionCharge(subscription))
+	payPalSub, ok := paypal.Subscription(subscriptionID)
+	require.True(t, ok)
+	assert.Equal(t, "ACTIVE", payPalSub.Status)
+	sale := merchant.event(PayPalEventSaleCompleted)
+	require.NotNil(t, sale)
+	assert.NotSame(t, previousSale, sale)
+
+	_, err = provider.UpdateSubscription(subscription, &SubscriptionUpdate{Action: SubscriptionActionCancel})
+	require.NoError(t, err)
+	var apiErr *PayPalAPIError
+	require.ErrorAs(t, provider.RetrySubscriptionCharge(subscription), &apiErr)
+	assert.Equal(t, "SUBSCRIPTION_STATUS_INVALID", apiErr.Issue)
+}
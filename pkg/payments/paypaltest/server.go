@@ -0,0 +1,854 @@
+// Package paypaltest provides an in-process fake of the PayPal REST API so that the
+// PayPal payment flow can be exercised without network access. The fake implements the
+// OAuth, Orders, Payments, Catalog and Billing endpoints the payments package uses, plays
+// the donor's side (approving orders and subscriptions) and delivers signed webhooks.
+package paypaltest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Order is an order created on the fake server
+type Order struct {
+	ID           string
+	Status       string
+	CustomID     string
+	CurrencyCode string
+	Value        string
+	CaptureID    string
+}
+
+// Subscription is a subscription created on the fake server
+type Subscription struct {
+	ID       string
+	Status   string
+	PlanID   string
+	CustomID string
+	// PendingPlanID is the plan of a revision the donor has not approved yet
+	PendingPlanID string
+}
+
+// Plan is a billing plan created on the fake server
+type Plan struct {
+	ID            string
+	ProductID     string
+	CurrencyCode  string
+	Value         string
+	IntervalCount int
+}
+
+// Delivery is a webhook delivered by the fake server
+type Delivery struct {
+	EventType  string
+	StatusCode int
+}
+
+// Server is a fake PayPal REST API
+type Server struct {
+	*httptest.Server
+
+	ClientID string
+	Secret   string
+	// WebhookID is the webhook ID the deliveries are signed for
+	WebhookID string
+	// WebhookURL receives webhooks; nothing is delivered when it is empty
+	WebhookURL string
+
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+	certPEM     []byte
+
+	mu            sync.Mutex
+	seq           int
+	tokens        map[string]bool
+	orders        map[string]*Order
+	captures      map[string]*Order // capture ID -> order
+	plans         map[string]*Plan
+	subscriptions map[string]*Subscription
+	sales         map[string]*Subscription // sale ID -> subscription
+	refunds       map[string][]string      // capture or sale ID -> refunded values
+	deliveries    []Delivery
+}
+
+// NewServer starts a fake PayPal API accepting the given client credentials
+func NewServer(clientID, secret string) *Server {
+	s := &Server{
+		ClientID:      clientID,
+		Secret:        secret,
+		WebhookID:     "WH-FAKE",
+		tokens:        make(map[string]bool),
+		orders:        make(map[string]*Order),
+		captures:      make(map[string]*Order),
+		plans:         make(map[string]*Plan),
+		subscriptions: make(map[string]*Subscription),
+		sales:         make(map[string]*Subscription),
+		refunds:       make(map[string][]string),
+	}
+	if err := s.generateCertificate(); err != nil {
+		panic(fmt.Sprintf("paypaltest: %v", err))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", s.handleToken)
+	mux.HandleFunc("GET /certs/cert.pem", s.handleCert)
+	mux.HandleFunc("POST /v2/checkout/orders", s.authorized(s.handleCreateOrder))
+	mux.HandleFunc("GET /v2/checkout/orders/{id}", s.authorized(s.handleGetOrder))
+	mux.HandleFunc("POST /v2/checkout/orders/{id}/capture", s.authorized(s.handleCaptureOrder))
+	mux.HandleFunc("POST /v2/payments/captures/{id}/refund", s.authorized(s.handleRefundCapture))
+	mux.HandleFunc("POST /v1/payments/sale/{id}/refund", s.authorized(s.handleRefundSale))
+	mux.HandleFunc("POST /v1/catalogs/products", s.authorized(s.handleCreateProduct))
+	mux.HandleFunc("POST /v1/billing/plans", s.authorized(s.handleCreatePlan))
+	mux.HandleFunc("POST /v1/billing/subscriptions", s.authorized(s.handleCreateSubscription))
+	mux.HandleFunc("GET /v1/billing/subscriptions/{id}", s.authorized(s.handleGetSubscription))
+	mux.HandleFunc("POST /v1/billing/subscriptions/{id}/cancel", s.authorized(s.subscriptionTransition("CANCELLED", "BILLING.SUBSCRIPTION.CANCELLED", "ACTIVE", "SUSPENDED")))
+	mux.HandleFunc("POST /v1/billing/subscriptions/{id}/suspend", s.authorized(s.subscriptionTransition("SUSPENDED", "BILLING.SUBSCRIPTION.SUSPENDED", "ACTIVE")))
+	mux.HandleFunc("POST /v1/billing/subscriptions/{id}/activate", s.authorized(s.subscriptionTransition("ACTIVE", "BILLING.SUBSCRIPTION.RE-ACTIVATED", "SUSPENDED")))
+	mux.HandleFunc("POST /v1/billing/subscriptions/{id}/revise", s.authorized(s.handleReviseSubscription))
+	mux.HandleFunc("POST /v1/billing/subscriptions/{id}/capture", s.authorized(s.handleCaptureSubscription))
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// CertURL is the URL sent in the PAYPAL-CERT-URL header of webhooks
+func (s *Server) CertURL() string {
+	return s.URL + "/certs/cert.pem"
+}
+
+// FetchCert returns the webhook signing certificate. It lets the server act as the
+// certificate fetcher of the provider under test, which only downloads from paypal.com.
+func (s *Server) FetchCert(certURL string) (*x509.Certificate, error) {
+	if certURL != s.CertURL() {
+		return nil, fmt.Errorf("unknown certificate %s", certURL)
+	}
+	return s.certificate, nil
+}
+
+// Order returns a copy of the order with the given ID
+func (s *Server) Order(id string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	if !ok {
+		return Order{}, false
+	}
+	return *order, true
+}
+
+// Subscription returns a copy of the subscription with the given ID
+func (s *Server) Subscription(id string) (Subscription, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *subscription, true
+}
+
+// Plan returns a copy of the billing plan with the given ID
+func (s *Server) Plan(id string) (Plan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plan, ok := s.plans[id]
+	if !ok {
+		return Plan{}, false
+	}
+	return *plan, true
+}
+
+// Refunds returns the values refunded from a capture or sale
+func (s *Server) Refunds(id string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.refunds[id]...)
+}
+
+// Deliveries returns the webhooks delivered so far
+func (s *Server) Deliveries() []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Delivery(nil), s.deliveries...)
+}
+
+// Approve approves an order as the donor would on the PayPal checkout page
+func (s *Server) Approve(orderID string) error {
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if !ok || order.Status != "CREATED" {
+		s.mu.Unlock()
+		return fmt.Errorf("order %s cannot be approved", orderID)
+	}
+	order.Status = "APPROVED"
+	resource := orderResource(order)
+	s.mu.Unlock()
+
+	_, err := s.SendWebhook("CHECKOUT.ORDER.APPROVED", "checkout-order", resource)
+	return err
+}
+
+// ApproveSubscription approves a subscription as the donor would; PayPal activates
+// it and charges the first billing cycle right away
+func (s *Server) ApproveSubscription(subscriptionID string) error {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok || subscription.Status != "APPROVAL_PENDING" {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription %s cannot be approved", subscriptionID)
+	}
+	subscription.Status = "ACTIVE"
+	resource := subscriptionResource(subscription)
+	s.mu.Unlock()
+
+	if _, err := s.SendWebhook("BILLING.SUBSCRIPTION.ACTIVATED", "subscription", resource); err != nil {
+		return err
+	}
+	_, err := s.ChargeSubscription(subscriptionID)
+	return err
+}
+
+// ApproveRevision approves a subscription revision as the donor would; PayPal moves the
+// subscription onto the new plan and reports it by webhook
+func (s *Server) ApproveRevision(subscriptionID string) error {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok || subscription.PendingPlanID == "" {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription %s has no revision to approve", subscriptionID)
+	}
+	subscription.PlanID = subscription.PendingPlanID
+	subscription.PendingPlanID = ""
+	resource := subscriptionResource(subscription)
+	s.mu.Unlock()
+
+	_, err := s.SendWebhook("BILLING.SUBSCRIPTION.UPDATED", "subscription", resource)
+	return err
+}
+
+// ChargeSubscription bills the next cycle of an active subscription and returns the sale ID
+func (s *Server) ChargeSubscription(subscriptionID string) (string, error) {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok || subscription.Status != "ACTIVE" {
+		s.mu.Unlock()
+		return "", fmt.Errorf("subscription %s is not active", subscriptionID)
+	}
+	return s.charge(subscription)
+}
+
+// charge bills a subscription and reports the sale by webhook; s.mu must be held and is released
+func (s *Server) charge(subscription *Subscription) (string, error) {
+	plan := s.plans[subscription.PlanID]
+	saleID := s.nextID("SALE")
+	s.sales[saleID] = subscription
+	resource := map[string]interface{}{
+		"id":    saleID,
+		"state": "completed",
+		"amount": map[string]interface{}{
+			"total":    plan.Value,
+			"currency": plan.CurrencyCode,
+		},
+		"billing_agreement_id": subscription.ID,
+		"custom":               subscription.CustomID,
+		"create_time":          time.Now().UTC().Format(time.RFC3339),
+	}
+	s.mu.Unlock()
+
+	_, err := s.SendWebhook("PAYMENT.SALE.COMPLETED", "sale", resource)
+	return saleID, err
+}
+
+// FailSubscriptionPayment reports a declined charge of a subscription
+func (s *Server) FailSubscriptionPayment(subscriptionID string) error {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown subscription %s", subscriptionID)
+	}
+	plan := s.plans[subscription.PlanID]
+	resource := subscriptionResource(subscription)
+	resource["billing_info"] = map[string]interface{}{
+		"last_failed_payment": map[string]interface{}{
+			"amount": map[string]interface{}{"currency_code": plan.CurrencyCode, "value": plan.Value},
+			"time":   time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	s.mu.Unlock()
+
+	_, err := s.SendWebhook("BILLING.SUBSCRIPTION.PAYMENT.FAILED", "subscription", resource)
+	return err
+}
+
+// SendWebhook delivers a signed webhook to WebhookURL and returns the response status
+func (s *Server) SendWebhook(eventType, resourceType string, resource interface{}) (int, error) {
+	if s.WebhookURL == "" {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	eventID := s.nextID("WH")
+	transmissionID := s.nextID("TX")
+	s.mu.Unlock()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":               eventID,
+		"event_version":    "1.0",
+		"create_time":      time.Now().UTC().Format(time.RFC3339),
+		"resource_type":    resourceType,
+		"event_type":       eventType,
+		"summary":          eventType,
+		"resource":         resource,
+		"resource_version": "2.0",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	transmissionTime := time.Now().UTC().Format(time.RFC3339)
+	message := fmt.Sprintf("%s|%s|%s|%d", transmissionID, transmissionTime, s.WebhookID, crc32.ChecksumIEEE(body))
+	digest := sha256.Sum256([]byte(message))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("PAYPAL-TRANSMISSION-ID", transmissionID)
+	req.Header.Set("PAYPAL-TRANSMISSION-TIME", transmissionTime)
+	req.Header.Set("PAYPAL-TRANSMISSION-SIG", base64.StdEncoding.EncodeToString(signature))
+	req.Header.Set("PAYPAL-CERT-URL", s.CertURL())
+	req.Header.Set("PAYPAL-AUTH-ALGO", "SHA256withRSA")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, Delivery{EventType: eventType, StatusCode: resp.StatusCode})
+	s.mu.Unlock()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook %s returned status %d", eventType, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != s.ClientID || secret != s.Secret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	token := s.nextID("A21AA")
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   32400,
+	})
+}
+
+func (s *Server) handleCert(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(s.certPEM)
+}
+
+func (s *Server) handleCreateOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Intent        string `json:"intent"`
+		PurchaseUnits []struct {
+			CustomID string `json:"custom_id"`
+			Amount   struct {
+				CurrencyCode string `json:"currency_code"`
+				Value        string `json:"value"`
+			} `json:"amount"`
+		} `json:"purchase_units"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Intent != "CAPTURE" || len(req.PurchaseUnits) != 1 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	s.mu.Lock()
+	order := &Order{
+		ID:           s.nextID("ORDER"),
+		Status:       "CREATED",
+		CustomID:     req.PurchaseUnits[0].CustomID,
+		CurrencyCode: req.PurchaseUnits[0].Amount.CurrencyCode,
+		Value:        req.PurchaseUnits[0].Amount.Value,
+	}
+	s.orders[order.ID] = order
+	resource := orderResource(order)
+	s.mu.Unlock()
+
+	resource["links"] = []map[string]string{
+		{"href": s.URL + "/v2/checkout/orders/" + order.ID, "rel": "self", "method": "GET"},
+		{"href": s.URL + "/checkoutnow?token=" + order.ID, "rel": "approve", "method": "GET"},
+	}
+	writeJSON(w, http.StatusCreated, resource)
+}
+
+func (s *Server) handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	order, ok := s.orders[r.PathValue("id")]
+	var resource map[string]interface{}
+	if ok {
+		resource = orderResource(order)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
+
+func (s *Server) handleCaptureOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	order, ok := s.orders[r.PathValue("id")]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	case order.Status == "COMPLETED":
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_CAPTURED")
+		return
+	case order.Status != "APPROVED":
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED")
+		return
+	}
+	order.Status = "COMPLETED"
+	order.CaptureID = s.nextID("CAPTURE")
+	s.captures[order.CaptureID] = order
+	resource := orderResource(order)
+	capture := captureResource(order)
+	s.mu.Unlock()
+
+	// PayPal reports the capture with its own webhook. It is delivered before the
+	// capture call returns so that tests observe a settled payment afterwards.
+	s.SendWebhook("PAYMENT.CAPTURE.COMPLETED", "capture", capture)
+
+	writeJSON(w, http.StatusCreated, resource)
+}
+
+func (s *Server) handleRefundCapture(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount struct {
+			CurrencyCode string `json:"currency_code"`
+			Value        string `json:"value"`
+		} `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	captureID := r.PathValue("id")
+	s.mu.Lock()
+	order, ok := s.captures[captureID]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	value := req.Amount.Value
+	if value == "" {
+		value = order.Value
+	}
+	refundID := s.nextID("REFUND")
+	s.refunds[captureID] = append(s.refunds[captureID], value)
+	resource := map[string]interface{}{
+		"id":          refundID,
+		"status":      "COMPLETED",
+		"amount":      map[string]string{"currency_code": order.CurrencyCode, "value": value},
+		"custom_id":   order.CustomID,
+		"create_time": time.Now().UTC().Format(time.RFC3339),
+		"links": []map[string]string{
+			{"href": s.URL + "/v2/payments/refunds/" + refundID, "rel": "self", "method": "GET"},
+			{"href": s.URL + "/v2/payments/captures/" + captureID, "rel": "up", "method": "GET"},
+		},
+	}
+	s.mu.Unlock()
+
+	s.SendWebhook("PAYMENT.CAPTURE.REFUNDED", "refund", resource)
+	writeJSON(w, http.StatusCreated, resource)
+}
+
+func (s *Server) handleRefundSale(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount struct {
+			Total    string `json:"total"`
+			Currency string `json:"currency"`
+		} `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	saleID := r.PathValue("id")
+	s.mu.Lock()
+	if _, ok := s.sales[saleID]; !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	refundID := s.nextID("SALEREFUND")
+	s.refunds[saleID] = append(s.refunds[saleID], req.Amount.Total)
+	resource := map[string]interface{}{
+		"id":          refundID,
+		"state":       "completed",
+		"sale_id":     saleID,
+		"amount":      map[string]string{"total": req.Amount.Total, "currency": req.Amount.Currency},
+		"create_time": time.Now().UTC().Format(time.RFC3339),
+	}
+	s.mu.Unlock()
+
+	s.SendWebhook("PAYMENT.SALE.REFUNDED", "refund", resource)
+	writeJSON(w, http.StatusCreated, resource)
+}
+
+func (s *Server) handleCreateProduct(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	s.mu.Lock()
+	id := s.nextID("PROD")
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": id, "name": req.Name, "type": req.Type})
+}
+
+func (s *Server) handleCreatePlan(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ProductID     string `json:"product_id"`
+		BillingCycles []struct {
+			Frequency struct {
+				IntervalUnit  string `json:"interval_unit"`
+				IntervalCount int    `json:"interval_count"`
+			} `json:"frequency"`
+			PricingScheme struct {
+				FixedPrice struct {
+					CurrencyCode string `json:"currency_code"`
+					Value        string `json:"value"`
+				} `json:"fixed_price"`
+			} `json:"pricing_scheme"`
+		} `json:"billing_cycles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ProductID == "" || len(req.BillingCycles) == 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+	cycle := req.BillingCycles[0]
+
+	s.mu.Lock()
+	plan := &Plan{
+		ID:            s.nextID("P"),
+		ProductID:     req.ProductID,
+		CurrencyCode:  cycle.PricingScheme.FixedPrice.CurrencyCode,
+		Value:         cycle.PricingScheme.FixedPrice.Value,
+		IntervalCount: cycle.Frequency.IntervalCount,
+	}
+	s.plans[plan.ID] = plan
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, map[string]string{"id": plan.ID, "product_id": plan.ProductID, "status": "ACTIVE"})
+}
+
+func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PlanID   string `json:"plan_id"`
+		CustomID string `json:"custom_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	s.mu.Lock()
+	if _, ok := s.plans[req.PlanID]; !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INVALID_PLAN_ID")
+		return
+	}
+	subscription := &Subscription{
+		ID:       s.nextID("I"),
+		Status:   "APPROVAL_PENDING",
+		PlanID:   req.PlanID,
+		CustomID: req.CustomID,
+	}
+	s.subscriptions[subscription.ID] = subscription
+	resource := subscriptionResource(subscription)
+	s.mu.Unlock()
+
+	resource["links"] = []map[string]string{
+		{"href": s.URL + "/webapps/billing/subscriptions?ba_token=" + subscription.ID, "rel": "approve", "method": "GET"},
+		{"href": s.URL + "/v1/billing/subscriptions/" + subscription.ID, "rel": "self", "method": "GET"},
+	}
+	writeJSON(w, http.StatusCreated, resource)
+}
+
+func (s *Server) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[r.PathValue("id")]
+	var resource map[string]interface{}
+	if ok {
+		resource = subscriptionResource(subscription)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, resource)
+}
+
+// subscriptionTransition handles a subscription status change that is only allowed from
+// the given statuses. Like PayPal, the change is also reported by webhook.
+func (s *Server) subscriptionTransition(status, eventType string, from ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Reason == "" {
+			writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MISSING_REQUIRED_PARAMETER")
+			return
+		}
+
+		s.mu.Lock()
+		subscription, ok := s.subscriptions[r.PathValue("id")]
+		if !ok {
+			s.mu.Unlock()
+			writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+			return
+		}
+		allowed := false
+		for _, current := range from {
+			allowed = allowed || subscription.Status == current
+		}
+		if !allowed {
+			s.mu.Unlock()
+			writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "SUBSCRIPTION_STATUS_INVALID")
+			return
+		}
+		subscription.Status = status
+		resource := subscriptionResource(subscription)
+		s.mu.Unlock()
+
+		s.SendWebhook(eventType, "subscription", resource)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleCaptureSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CaptureType string `json:"capture_type"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CaptureType != "OUTSTANDING_BALANCE" {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "INVALID_PARAMETER_VALUE")
+		return
+	}
+
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[r.PathValue("id")]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	case subscription.Status != "ACTIVE" && subscription.Status != "SUSPENDED":
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "SUBSCRIPTION_STATUS_INVALID")
+		return
+	}
+	// Paying the outstanding balance reactivates a subscription suspended for failed payments
+	subscription.Status = "ACTIVE"
+	s.charge(subscription)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleReviseSubscription(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PlanID string `json:"plan_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "")
+		return
+	}
+
+	s.mu.Lock()
+	subscription, ok := s.subscriptions[r.PathValue("id")]
+	_, planOK := s.plans[req.PlanID]
+	switch {
+	case !ok:
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	case !planOK:
+		s.mu.Unlock()
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INVALID_PLAN_ID")
+		return
+	}
+	// The revision waits for the donor's approval, see ApproveRevision
+	subscription.PendingPlanID = req.PlanID
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"plan_id": req.PlanID,
+		"links": []map[string]string{
+			{"href": s.URL + "/webapps/billing/subscriptions/update?ba_token=" + subscription.ID, "rel": "approve", "method": "GET"},
+		},
+	})
+}
+
+// authorized rejects requests without a token issued by the server
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") {
+			token = auth[len("Bearer "):]
+		}
+		s.mu.Lock()
+		ok := s.tokens[token]
+		s.mu.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "AUTHENTICATION_FAILURE", "")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) generateCertificate() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "messageverificationcerts.paypal.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	s.key = key
+	s.certificate = certificate
+	s.certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return nil
+}
+
+// nextID returns a new ID with the given prefix; callers must hold s.mu
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s-%06d", prefix, s.seq)
+}
+
+func orderResource(order *Order) map[string]interface{} {
+	unit := map[string]interface{}{
+		"custom_id": order.CustomID,
+		"amount":    map[string]string{"currency_code": order.CurrencyCode, "value": order.Value},
+	}
+	if order.CaptureID != "" {
+		unit["payments"] = map[string]interface{}{
+			"captures": []interface{}{captureResource(order)},
+		}
+	}
+	return map[string]interface{}{
+		"id":             order.ID,
+		"intent":         "CAPTURE",
+		"status":         order.Status,
+		"purchase_units": []interface{}{unit},
+	}
+}
+
+func captureResource(order *Order) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          order.CaptureID,
+		"status":      "COMPLETED",
+		"amount":      map[string]string{"currency_code": order.CurrencyCode, "value": order.Value},
+		"custom_id":   order.CustomID,
+		"create_time": time.Now().UTC().Format(time.RFC3339),
+		"supplementary_data": map[string]interface{}{
+			"related_ids": map[string]string{"order_id": order.ID},
+		},
+	}
+}
+
+func subscriptionResource(subscription *Subscription) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        subscription.ID,
+		"status":    subscription.Status,
+		"plan_id":   subscription.PlanID,
+		"custom_id": subscription.CustomID,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError writes a PayPal error response; issue is reported as the first detail
+func writeError(w http.ResponseWriter, status int, name, issue string) {
+	body := map[string]interface{}{"name": name, "message": name}
+	if issue != "" {
+		body["details"] = []map[string]string{{"issue": issue}}
+	}
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,88 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PlanStore keeps the billing plans of a provider in the provider_plans table, so that
+// plans are shared by every instance and survive restarts
+type PlanStore struct {
+	db       *gorm.DB
+	provider models.PaymentProvider
+}
+
+// NewPlanStore creates a plan store for the plans of a provider
+func NewPlanStore(provider models.PaymentProvider) *PlanStore {
+	return &PlanStore{
+		db:       database.GetDB(),
+		provider: provider,
+	}
+}
+
+// FindPlan returns the stored plan ID, or "" when no plan was stored yet
+func (s *PlanStore) FindPlan(amountMinor int64, currency models.Currency, intervalMonths int) (string, error) {
+	var plan models.ProviderPlan
+	err := s.db.Where("provider = ? AND amount_minor = ? AND currency = ? AND interval_months = ?",
+		s.provider, amountMinor, currency, intervalMonths).First(&plan).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return plan.ProviderPlanID, nil
+}
+
+// SavePlan stores a plan ID and returns the plan to use. When another instance created a
+// plan for the same terms first, its plan wins and planID stays unused.
+func (s *PlanStore) SavePlan(amountMinor int64, currency models.Currency, intervalMonths int, planID string) (string, error) {
+	plan := &models.ProviderPlan{
+		Provider:       s.provider,
+		AmountMinor:    amountMinor,
+		Currency:       currency,
+		IntervalMonths: intervalMonths,
+		ProviderPlanID: planID,
+	}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(plan).Error; err != nil {
+		return "", fmt.Errorf("failed to save plan: %w", err)
+	}
+	return s.FindPlan(amountMinor, currency, intervalMonths)
+}
+
+// memoryPlanStore keeps plans for the lifetime of the process
+type memoryPlanStore struct {
+	mu    sync.Mutex
+	plans map[string]string // amount:currency:months -> plan ID
+}
+
+func newMemoryPlanStore() *memoryPlanStore {
+	return &memoryPlanStore{plans: make(map[string]string)}
+}
+
+func planKey(amountMinor int64, currency models.Currency, intervalMonths int) string {
+	return fmt.Sprintf("%d:%s:%d", amountMinor, currency, intervalMonths)
+}
+
+func (s *memoryPlanStore) FindPlan(amountMinor int64, currency models.Currency, intervalMonths int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.plans[planKey(amountMinor, currency, intervalMonths)], nil
+}
+
+func (s *memoryPlanStore) SavePlan(amountMinor int64, currency models.Currency, intervalMonths int, planID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := planKey(amountMinor, currency, intervalMonths)
+	if existing, ok := s.plans[key]; ok {
+		return existing, nil
+	}
+	s.plans[key] = planID
+	return planID, nil
+}
@@ -0,0 +1,215 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUnknownProvider is returned when no provider is registered under the requested name
+	ErrUnknownProvider = errors.New("unsupported payment provider")
+	// ErrUnsupportedWebhook is returned when a provider does not handle the requested webhook kind
+	ErrUnsupportedWebhook = errors.New("unsupported webhook")
+	// ErrRefundNotSupported is returned when a provider cannot refund payments
+	ErrRefundNotSupported = errors.New("refunds are not supported by the provider")
+	// ErrSubscriptionsNotSupported is returned when a provider cannot charge recurring payments
+	ErrSubscriptionsNotSupported = errors.New("subscriptions are not supported by the provider")
+	// ErrUnsupportedCurrency is returned when a provider cannot charge the requested currency
+	ErrUnsupportedCurrency = errors.New("currency is not supported by the provider")
+	// ErrSubscriptionChangeNotSupported is returned for subscription changes a provider cannot make
+	ErrSubscriptionChangeNotSupported = errors.New("subscription change is not supported by the provider")
+)
+
+// Provider is a payment gateway. Implementations only talk to the gateway and translate
+// its notifications; all payment, subscription and donation bookkeeping is done by Service.
+type Provider interface {
+	// Name returns the provider stored on payments and subscriptions
+	Name() models.PaymentProvider
+
+	// CreatePaymentIntent prepares a one-time payment for an already stored payment.
+	// A provider that creates a payment object upfront sets payment.ProviderPaymentID.
+	CreatePaymentIntent(payment *models.Payment, req *PaymentIntentRequest) (*PaymentIntentResponse, error)
+
+	// CreateSubscriptionIntent prepares a recurring payment for an already stored subscription.
+	// A provider that creates a subscription object upfront sets subscription.ProviderSubscriptionID.
+	CreateSubscriptionIntent(subscription *models.Subscription, req *SubscriptionIntentRequest) (*SubscriptionIntentResponse, error)
+
+	// VerifyWebhook authenticates a webhook request. It returns ErrUnsupportedWebhook for
+	// requests the provider does not handle and ErrInvalidSignature for forged ones.
+	VerifyWebhook(req *WebhookRequest) error
+
+	// ParseWebhook translates a verified webhook request into a WebhookEvent
+	ParseWebhook(req *WebhookRequest) (*WebhookEvent, error)
+
+	// WebhookResponse builds the HTTP status and body the provider expects as an answer
+	// to a webhook. The event is nil when the request could not be parsed.
+	WebhookResponse(event *WebhookEvent, err error) (int, interface{})
+
+	// Refund returns amountMinor of a succeeded payment to the payer
+	Refund(payment *models.Payment, amountMinor int64) (*RefundResult, error)
+
+	// UpdateSubscription applies a donor's change to a subscription that exists at the
+	// provider. The subscription still holds the terms before the change.
+	UpdateSubscription(subscription *models.Subscription, update *SubscriptionUpdate) (*SubscriptionUpdateResult, error)
+
+	// RetrySubscriptionCharge asks the provider to charge a past due subscription again; the
+	// outcome is reported by webhook. Providers that retry on their own return
+	// ErrSubscriptionChangeNotSupported.
+	RetrySubscriptionCharge(subscription *models.Subscription) error
+}
+
+// WebhookRequest is a raw webhook request as received from a provider
+type WebhookRequest struct {
+	// Kind is the optional path segment after the provider name, e.g. "pay" in /webhooks/cloudpayments/pay
+	Kind   string
+	Method string
+	Header http.Header
+	Query  url.Values
+	Body   []byte
+	// RemoteIP is the client address, for providers that authenticate callbacks by IP
+	RemoteIP string
+}
+
+// EventType is a provider independent webhook event type
+type EventType string
+
+const (
+	// EventPaymentCheck asks whether a payment may be authorized; it changes nothing
+	EventPaymentCheck EventType = "payment.check"
+	// EventPaymentAuthorized reports a payment that still has to be captured
+	EventPaymentAuthorized EventType = "payment.authorized"
+	// EventPaymentSucceeded reports a captured one-time payment or subscription charge
+	EventPaymentSucceeded EventType = "payment.succeeded"
+	// EventPaymentFailed reports a declined one-time payment or subscription charge
+	EventPaymentFailed EventType = "payment.failed"
+	// EventPaymentCanceled reports a voided authorization
+	EventPaymentCanceled EventType = "payment.canceled"
+	// EventPaymentRefunded reports a full or partial refund
+	EventPaymentRefunded EventType = "payment.refunded"
+	// EventSubscriptionUpdated reports a subscription status change
+	EventSubscriptionUpdated EventType = "subscription.updated"
+	// EventSubscriptionRevised reports that a change of terms the donor approved took effect
+	EventSubscriptionRevised EventType = "subscription.revised"
+	// EventIgnored is a notification that requires no action; it is only recorded
+	EventIgnored EventType = "ignored"
+)
+
+// WebhookEvent is a provider notification translated by Provider.ParseWebhook
+type WebhookEvent struct {
+	Type EventType
+	// Kind is the provider's own name of the notification, stored with the raw payload
+	Kind string
+	// IdempotencyKey deduplicates redelivered notifications; nil disables deduplication
+	IdempotencyKey *string
+
+	// InvoiceID is our payment or subscription ID echoed back by the provider
+	InvoiceID              string
+	AccountID              string
+	ProviderPaymentID      string
+	ProviderSubscriptionID string
+	// AmountMinor is the payment amount, or the refunded amount for EventPaymentRefunded
+	AmountMinor int64
+	// Currency is empty when the provider does not report it
+	Currency   models.Currency
+	OccurredAt time.Time
+
+	// SubscriptionStatus is set for EventSubscriptionUpdated
+	SubscriptionStatus models.SubscriptionStatus
+	// ProviderPlanID is the plan the subscription is on for EventSubscriptionRevised
+	ProviderPlanID string
+	// RefundID is the provider ID of the refund transaction for EventPaymentRefunded
+	RefundID string
+
+	// Meta is merged into the meta of the affected payment or subscription
+	Meta map[string]interface{}
+	// Raw is the decoded notification stored in webhook_events
+	Raw interface{}
+}
+
+// RefundResult is the outcome of Provider.Refund
+type RefundResult struct {
+	ProviderRefundID string
+}
+
+// SubscriptionAction is a lifecycle change of a subscription
+type SubscriptionAction string
+
+const (
+	SubscriptionActionCancel SubscriptionAction = "cancel"
+	SubscriptionActionPause  SubscriptionAction = "pause"
+	SubscriptionActionResume SubscriptionAction = "resume"
+	// SubscriptionActionChange changes the amount or the interval of a subscription
+	SubscriptionActionChange SubscriptionAction = "change"
+	// SubscriptionActionProviderUpdate is a status change reported by the provider
+	SubscriptionActionProviderUpdate SubscriptionAction = "provider_update"
+)
+
+// SubscriptionUpdate is a change requested by the donor of a subscription
+type SubscriptionUpdate struct {
+	Action SubscriptionAction
+	// AmountMinor and IntervalMonths are the new terms for SubscriptionActionChange;
+	// zero keeps the current value
+	AmountMinor    int64
+	IntervalMonths int
+	// ProjectID is the new project for SubscriptionActionChange when ChangeProject is set;
+	// nil makes the subscription a general donation. Providers don't know about projects.
+	ProjectID     *uuid.UUID
+	ChangeProject bool
+	Reason        *string
+}
+
+// SubscriptionUpdateResult is the outcome of Provider.UpdateSubscription
+type SubscriptionUpdateResult struct {
+	// RedirectURL is set when the donor has to approve the change with the provider. The
+	// change then only takes effect when the provider reports EventSubscriptionRevised.
+	RedirectURL string
+	// ProviderPlanID is the plan an approved change moves the subscription onto
+	ProviderPlanID string
+}
+
+// Registry holds the configured payment providers keyed by their name
+type Registry struct {
+	providers map[models.PaymentProvider]Provider
+}
+
+// NewRegistry creates a registry with the given providers
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{
+		providers: make(map[models.PaymentProvider]Provider),
+	}
+	for _, p := range providers {
+		r.Register(p)
+	}
+	return r
+}
+
+// Register adds a provider, replacing any provider registered under the same name
+func (r *Registry) Register(p Provider) {
+	r.providers[p.Name()] = p
+}
+
+// Get returns the provider registered under name
+func (r *Registry) Get(name models.PaymentProvider) (Provider, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
+
+// Providers returns the names of all registered providers in alphabetical order
+func (r *Registry) Providers() []models.PaymentProvider {
+	names := make([]models.PaymentProvider, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
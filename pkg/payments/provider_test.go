@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	cloudPayments := NewCloudPaymentsProvider("public-id", "secret", "", "")
+	registry := NewRegistry(cloudPayments)
+
+	provider, err := registry.Get(models.PaymentProviderCloudPayments)
+	require.NoError(t, err)
+	assert.Equal(t, cloudPayments, provider)
+
+	_, err = registry.Get(models.PaymentProviderKaspi)
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	assert.Equal(t, []models.PaymentProvider{models.PaymentProviderCloudPayments}, registry.Providers())
+}
+
+func TestRegistry_RegisterReplaces(t *testing.T) {
+	registry := NewRegistry(NewCloudPaymentsProvider("old", "secret", "", ""))
+	replacement := NewCloudPaymentsProvider("new", "secret", "", "")
+	registry.Register(replacement)
+
+	provider, err := registry.Get(models.PaymentProviderCloudPayments)
+	require.NoError(t, err)
+	assert.Equal(t, replacement, provider)
+	assert.Len(t, registry.Providers(), 1)
+}
+
+func TestService_UnknownProvider(t *testing.T) {
+	service := NewService(NewRegistry(), AmountLimits{})
+
+	_, err := service.CreatePaymentIntent(&PaymentIntentRequest{Provider: "tribute"}, "user-1")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	_, err = service.CreateSubscriptionIntent(&SubscriptionIntentRequest{Provider: "tribute"}, "user-1")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
+
+func TestService_ApplyEventDefaultsOccurredAt(t *testing.T) {
+	service := NewService(NewRegistry(), AmountLimits{})
+
+	event := &WebhookEvent{Type: EventIgnored}
+	before := time.Now()
+	require.NoError(t, service.applyEvent(models.PaymentProviderCloudPayments, event))
+	assert.False(t, event.OccurredAt.Before(before))
+
+	occurredAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	event = &WebhookEvent{Type: EventIgnored, OccurredAt: occurredAt}
+	require.NoError(t, service.applyEvent(models.PaymentProviderCloudPayments, event))
+	assert.Equal(t, occurredAt, event.OccurredAt)
+}
+
+func TestPaymentMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    WebhookEvent
+		mismatch bool
+	}{
+		{"match", WebhookEvent{AmountMinor: 19000, Currency: models.CurrencyRUB}, false},
+		{"currency not reported", WebhookEvent{AmountMinor: 19000}, false},
+		{"different amount", WebhookEvent{AmountMinor: 100, Currency: models.CurrencyRUB}, true},
+		{"different currency", WebhookEvent{AmountMinor: 19000, Currency: models.CurrencyUSD}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatch := paymentMismatch(&tt.event, 19000, models.CurrencyRUB)
+			if !tt.mismatch {
+				assert.Nil(t, mismatch)
+				return
+			}
+			require.NotNil(t, mismatch)
+			assert.Equal(t, int64(19000), mismatch["expected_amount_minor"])
+			assert.Equal(t, tt.event.AmountMinor, mismatch["received_amount_minor"])
+		})
+	}
+}
+
+func TestNextSubscriptionStatus(t *testing.T) {
+	tests := []struct {
+		status   models.SubscriptionStatus
+		action   SubscriptionAction
+		expected models.SubscriptionStatus
+	}{
+		{models.SubscriptionStatusActive, SubscriptionActionCancel, models.SubscriptionStatusCanceled},
+		{models.SubscriptionStatusIncomplete, SubscriptionActionCancel, models.SubscriptionStatusCanceled},
+		{models.SubscriptionStatusPastDue, SubscriptionActionPause, models.SubscriptionStatusPaused},
+		{models.SubscriptionStatusPaused, SubscriptionActionResume, models.SubscriptionStatusActive},
+		{models.SubscriptionStatusPaused, SubscriptionActionChange, models.SubscriptionStatusPaused},
+	}
+	for _, tt := range tests {
+		status, err := nextSubscriptionStatus(tt.status, tt.action)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, status, "%s a subscription that is %s", tt.action, tt.status)
+	}
+
+	invalid := []struct {
+		status models.SubscriptionStatus
+		action SubscriptionAction
+	}{
+		{models.SubscriptionStatusCanceled, SubscriptionActionCancel},
+		{models.SubscriptionStatusPaused, SubscriptionActionPause},
+		{models.SubscriptionStatusActive, SubscriptionActionResume},
+		{models.SubscriptionStatusIncomplete, SubscriptionActionChange},
+	}
+	for _, tt := range invalid {
+		_, err := nextSubscriptionStatus(tt.status, tt.action)
+		assert.ErrorIs(t, err, ErrSubscriptionTransition, "%s a subscription that is %s", tt.action, tt.status)
+	}
+
+	_, err := nextSubscriptionStatus(models.SubscriptionStatusActive, SubscriptionActionProviderUpdate)
+	assert.ErrorIs(t, err, ErrInvalidSubscriptionUpdate)
+}
+
+func TestSameProject(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	aCopy := a
+
+	assert.True(t, sameProject(nil, nil))
+	assert.True(t, sameProject(&a, &aCopy))
+	assert.False(t, sameProject(&a, &b))
+	assert.False(t, sameProject(&a, nil))
+	assert.False(t, sameProject(nil, &b))
+}
@@ -0,0 +1,350 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/achievements"
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrRefundNotAllowed is returned when a payment is not in a refundable state
+	ErrRefundNotAllowed = errors.New("payment cannot be refunded")
+	// ErrInvalidRefundAmount is returned for refunds above the amount left on the payment
+	ErrInvalidRefundAmount = errors.New("invalid refund amount")
+)
+
+// RefundRequest is an admin request to refund a payment
+type RefundRequest struct {
+	// AmountMinor is the amount to refund; zero refunds everything that is left
+	AmountMinor int64
+	Reason      *string
+	// InitiatedBy is the admin requesting the refund
+	InitiatedBy string
+}
+
+// RefundPayment refunds a succeeded payment through its provider and reverses the trees
+// of its donation. The amount is reserved on the payment before the provider is asked,
+// so concurrent refunds cannot return more than is left. The provider may report the
+// same refund by webhook; it is recorded once.
+func (s *Service) RefundPayment(paymentID string, req *RefundRequest) (*models.Refund, error) {
+	payment, amountMinor, err := s.reserveRefund(paymentID, req.AmountMinor)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := s.registry.Get(payment.Provider)
+	if err != nil {
+		s.releaseRefundReservation(payment.ID, amountMinor)
+		return nil, err
+	}
+	result, err := provider.Refund(payment, amountMinor)
+	if err != nil {
+		s.releaseRefundReservation(payment.ID, amountMinor)
+		return nil, err
+	}
+
+	refund := &models.Refund{
+		ID:               uuid.New(),
+		PaymentID:        payment.ID,
+		ProviderRefundID: optionalString(result.ProviderRefundID),
+		AmountMinor:      amountMinor,
+		Reason:           req.Reason,
+		InitiatedBy:      optionalString(req.InitiatedBy),
+	}
+	recorded, err := s.applyRefund(refund, time.Now(), nil, amountMinor)
+	if err != nil {
+		// The money is already back with the payer; the provider's webhook retries the bookkeeping
+		logrus.WithFields(logrus.Fields{
+			"payment_id":         payment.ID,
+			"provider_refund_id": result.ProviderRefundID,
+		}).Errorf("Failed to record refund: %v", err)
+		s.releaseRefundReservation(payment.ID, amountMinor)
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// reserveRefund holds amountMinor of a succeeded payment for a refund; zero reserves
+// everything that is left. Amounts already refunded or reserved by other refunds are not
+// available.
+func (s *Service) reserveRefund(paymentID string, amountMinor int64) (*models.Payment, int64, error) {
+	var payment models.Payment
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", paymentID).First(&payment).Error; err != nil {
+			return err
+		}
+		if payment.Status != models.PaymentStatusSucceeded {
+			return ErrRefundNotAllowed
+		}
+
+		remaining := payment.AmountMinor - payment.RefundedAmountMinor - payment.RefundReservedMinor
+		if amountMinor == 0 {
+			amountMinor = remaining
+		}
+		if amountMinor <= 0 || amountMinor > remaining {
+			return fmt.Errorf("%w: %d of %d left", ErrInvalidRefundAmount, amountMinor, remaining)
+		}
+
+		payment.RefundReservedMinor += amountMinor
+		if err := tx.Model(&payment).Update("refund_reserved_minor", payment.RefundReservedMinor).Error; err != nil {
+			return fmt.Errorf("failed to reserve refund: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &payment, amountMinor, nil
+}
+
+// releaseRefundReservation gives back the reservation of a refund that the provider
+// declined or that could not be recorded
+func (s *Service) releaseRefundReservation(paymentID uuid.UUID, amountMinor int64) {
+	if err := releaseRefundReservation(s.db, paymentID, amountMinor); err != nil {
+		logrus.WithField("payment_id", paymentID).Errorf("Failed to release refund reservation: %v", err)
+	}
+}
+
+// releaseRefundReservation takes amountMinor off the refund reservation of a payment
+func releaseRefundReservation(tx *gorm.DB, paymentID uuid.UUID, amountMinor int64) error {
+	if amountMinor <= 0 {
+		return nil
+	}
+	err := tx.Model(&models.Payment{}).Where("id = ?", paymentID).
+		Update("refund_reserved_minor", gorm.Expr("GREATEST(refund_reserved_minor - ?, 0)", amountMinor)).Error
+	if err != nil {
+		return fmt.Errorf("failed to release refund reservation: %w", err)
+	}
+	return nil
+}
+
+// GetPaymentRefunds returns the refunds of a payment, oldest first
+func (s *Service) GetPaymentRefunds(paymentID string) ([]models.Refund, error) {
+	var refunds []models.Refund
+	if err := s.db.Where("payment_id = ?", paymentID).Order("created_at ASC").Find(&refunds).Error; err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// processRefundEvent processes a full or partial refund reported by the provider
+func (s *Service) processRefundEvent(e *WebhookEvent) error {
+	// Find payment by the original transaction ID
+	payment, err := s.findPayment(e.InvoiceID, e.ProviderPaymentID)
+	if err != nil {
+		return err
+	}
+
+	meta := mergeMeta(nil, e.Meta)
+	meta["webhook_processed"] = true
+	refund := &models.Refund{
+		ID:               uuid.New(),
+		PaymentID:        payment.ID,
+		ProviderRefundID: optionalString(e.RefundID),
+		AmountMinor:      e.AmountMinor,
+	}
+	_, err = s.applyRefund(refund, e.OccurredAt, meta, 0)
+	return err
+}
+
+// applyRefund records a refund and reverses its share of the donation in one transaction:
+// the payment's refunded amount and status, the donation's trees and the sponsor matches
+// it no longer earns, the user's counters, the credit of a fully refunded donation and the
+// tree-based achievements the user no longer qualifies for. A refund whose provider ID
+// is already recorded is returned as is. A refund without an amount takes whatever is
+// left on the payment; one above it is rejected. The refund is dated occurredAt; the
+// payment keeps the time it was made, which reports group it by. reservedMinor is the
+// reservation the refund was made under, which is released either way.
+func (s *Service) applyRefund(refund *models.Refund, occurredAt time.Time, meta map[string]interface{}, reservedMinor int64) (*models.Refund, error) {
+	var recorded *models.Refund
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Lock the payment so that concurrent refunds of it are applied one after another
+		var payment models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", refund.PaymentID).First(&payment).Error; err != nil {
+			return fmt.Errorf("failed to lock payment: %w", err)
+		}
+		if err := releaseRefundReservation(tx, payment.ID, reservedMinor); err != nil {
+			return err
+		}
+
+		if refund.ProviderRefundID != nil {
+			var existing models.Refund
+			err := tx.Where("provider_refund_id = ?", *refund.ProviderRefundID).First(&existing).Error
+			if err == nil {
+				recorded = &existing
+				return s.claimRefund(tx, &existing, refund)
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("failed to find refund: %w", err)
+			}
+		}
+
+		if payment.Status != models.PaymentStatusSucceeded {
+			return ErrRefundNotAllowed
+		}
+		// A reserved refund may be reported by webhook before it is recorded here, so
+		// reservations do not count against it
+		remaining := payment.AmountMinor - payment.RefundedAmountMinor
+		if refund.AmountMinor <= 0 {
+			refund.AmountMinor = remaining
+		}
+		if refund.AmountMinor <= 0 || refund.AmountMinor > remaining {
+			return fmt.Errorf("%w: %d of %d left", ErrInvalidRefundAmount, refund.AmountMinor, remaining)
+		}
+		refundedMinor := payment.RefundedAmountMinor + refund.AmountMinor
+
+		var donation models.Donation
+		err := tx.Where("payment_id = ?", payment.ID).First(&donation).Error
+		hasDonation := err == nil
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to find donation: %w", err)
+		}
+
+		// Refunds take back the donation's remainder credit before any of its trees
+		var remainderMinor int64
+		if hasDonation {
+			var reversedBefore int
+			if err := tx.Model(&models.Refund{}).Where("payment_id = ?", payment.ID).
+				Select("COALESCE(SUM(trees_reversed), 0)").Scan(&reversedBefore).Error; err != nil {
+				return fmt.Errorf("failed to sum reversed trees: %w", err)
+			}
+			if remainderMinor, err = donationRemainder(tx, donation.ID); err != nil {
+				return err
+			}
+			refund.TreesReversed = treesToReverse(donation.TreesCount+reversedBefore, donation.TreesCount,
+				payment.AmountMinor, refundedMinor, remainderMinor, donation.PriceMinor)
+		}
+
+		if !occurredAt.IsZero() {
+			refund.CreatedAt = occurredAt
+		}
+		if err := tx.Create(refund).Error; err != nil {
+			return fmt.Errorf("failed to create refund: %w", err)
+		}
+		recorded = refund
+
+		fullyRefunded := refundedMinor >= payment.AmountMinor
+		updates := map[string]interface{}{
+			"refunded_amount_minor": refundedMinor,
+		}
+		if fullyRefunded {
+			updates["status"] = models.PaymentStatusRefunded
+		}
+		if meta != nil {
+			updates["meta"] = mergeMeta(payment.Meta, meta)
+		}
+		if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+
+		if !hasDonation {
+			return nil
+		}
+		if fullyRefunded {
+			if err := reverseDonationCredit(tx, &donation, payment.Currency); err != nil {
+				return err
+			}
+		} else if creditMinor := min(refundedMinor, remainderMinor) - min(payment.RefundedAmountMinor, remainderMinor); creditMinor > 0 {
+			if err := reverseRefundedRemainder(tx, &donation, payment.Currency, creditMinor); err != nil {
+				return err
+			}
+		}
+		remainingTrees := donation.TreesCount - refund.TreesReversed
+		if err := reverseDonation(tx, &donation, refund.TreesReversed, fullyRefunded); err != nil {
+			return err
+		}
+		return reverseMatches(tx, &donation, remainingTrees)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.statusUpdates.publish(refund.PaymentID)
+	return recorded, nil
+}
+
+// claimRefund attributes a refund that the provider reported by webhook to the admin who
+// requested it
+func (s *Service) claimRefund(tx *gorm.DB, existing, requested *models.Refund) error {
+	if existing.InitiatedBy != nil || requested.InitiatedBy == nil {
+		return nil
+	}
+	existing.InitiatedBy = requested.InitiatedBy
+	existing.Reason = requested.Reason
+	updates := map[string]interface{}{
+		"initiated_by": requested.InitiatedBy,
+		"reason":       requested.Reason,
+	}
+	if err := tx.Model(existing).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update refund: %w", err)
+	}
+	return nil
+}
+
+// reverseDonation takes trees back from a donation, from the projects they were allocated
+// to and from the profile that counts them, the recipient's for a claimed gift. A fully
+// refunded donation no longer counts as a donation of its donor.
+func reverseDonation(tx *gorm.DB, donation *models.Donation, trees int, fullyRefunded bool) error {
+	if trees > 0 {
+		if err := tx.Model(donation).Update("trees_count", gorm.Expr("trees_count - ?", trees)).Error; err != nil {
+			return fmt.Errorf("failed to update donation: %w", err)
+		}
+		if err := allocations.Release(tx, donation.ID); err != nil {
+			return err
+		}
+	}
+
+	owner, err := treeOwner(tx, donation)
+	if err != nil {
+		return err
+	}
+	if fullyRefunded {
+		if err := tx.Model(&models.User{}).Where("auth_user_id = ?", donation.AuthUserID).
+			Update("donations_count", gorm.Expr("GREATEST(donations_count - 1, 0)")).Error; err != nil {
+			return fmt.Errorf("failed to update user counters: %w", err)
+		}
+	}
+	if err := tx.Model(&models.User{}).Where("auth_user_id = ?", owner).
+		Update("total_trees", gorm.Expr("GREATEST(total_trees - ?, 0)", trees)).Error; err != nil {
+		return fmt.Errorf("failed to update user counters: %w", err)
+	}
+
+	var user models.User
+	if err := tx.Where("auth_user_id = ?", owner).First(&user).Error; err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if err := achievements.RevokeTreeBasedAchievements(tx, owner, user.TotalTrees); err != nil {
+		return fmt.Errorf("failed to revoke achievements: %w", err)
+	}
+	return nil
+}
+
+// treesToReverse returns how many of a donation's remaining trees a refund takes back. The
+// donor keeps the trees still paid for after refundedMinor of amountMinor was returned;
+// the first remainderMinor refunded is the credit the donation added, not its trees. A
+// full refund reverses all trees. Donations without a recorded tree price lose trees in
+// proportion to the refund.
+func treesToReverse(originalTrees, currentTrees int, amountMinor, refundedMinor, remainderMinor, priceMinor int64) int {
+	if amountMinor <= 0 || refundedMinor >= amountMinor {
+		return currentTrees
+	}
+	var kept int
+	if priceMinor > 0 {
+		refundedTreesMinor := max(refundedMinor-remainderMinor, 0)
+		kept = int(max(int64(originalTrees)*priceMinor-refundedTreesMinor, 0) / priceMinor)
+	} else {
+		kept = int(int64(originalTrees) * (amountMinor - refundedMinor) / amountMinor)
+	}
+	if kept >= currentTrees {
+		return 0
+	}
+	return currentTrees - kept
+}
@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreesToReverse(t *testing.T) {
+	tests := []struct {
+		name           string
+		originalTrees  int
+		currentTrees   int
+		amountMinor    int64
+		refundedMinor  int64
+		remainderMinor int64
+		priceMinor     int64
+		expected       int
+	}{
+		{"full refund", 3, 3, 57000, 57000, 0, 19000, 3},
+		{"full refund after a partial one", 3, 2, 57000, 57000, 0, 19000, 2},
+		{"partial refund below one tree", 3, 3, 57000, 100, 0, 19000, 1},
+		{"half refund", 4, 4, 76000, 38000, 0, 19000, 2},
+		{"second partial refund", 4, 2, 76000, 57000, 0, 19000, 1},
+		{"refund of the remainder keeps the tree", 1, 1, 1500, 500, 500, 1000, 0},
+		{"refund beyond the remainder takes a tree", 1, 1, 1500, 600, 500, 1000, 1},
+		{"remainder is refunded once", 2, 2, 2500, 1000, 500, 1000, 1},
+		{"trees paid with credit", 2, 2, 1500, 100, 0, 1000, 1},
+		{"donation without trees", 0, 0, 600, 300, 600, 1000, 0},
+		{"trees kept are rounded down without a price", 3, 3, 60000, 3000, 0, 0, 1},
+		{"proportional without a price", 4, 4, 76000, 38000, 0, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, treesToReverse(tt.originalTrees, tt.currentTrees, tt.amountMinor, tt.refundedMinor, tt.remainderMinor, tt.priceMinor))
+		})
+	}
+}
@@ -0,0 +1,946 @@
+package payments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/allocations"
+	"github.com/4planet/backend/pkg/prices"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidSignature is returned when a webhook signature does not match the payload
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrInvalidPayload is returned when a webhook payload cannot be parsed
+	ErrInvalidPayload = errors.New("invalid webhook payload")
+	// ErrUnknownInvoice is returned when a notification references an unknown invoice
+	ErrUnknownInvoice = errors.New("unknown invoice")
+	// ErrAccountMismatch is returned when a notification's account does not own the invoice
+	ErrAccountMismatch = errors.New("account does not match invoice")
+	// ErrInvalidAmount is returned when a notification's amount or currency does not match the invoice
+	ErrInvalidAmount = errors.New("invalid amount")
+	// ErrPaymentRejected is returned when a payment can no longer be accepted
+	ErrPaymentRejected = errors.New("payment cannot be accepted")
+	// ErrUnknownProject is returned when a donation targets a project that does not exist
+	ErrUnknownProject = errors.New("unknown project")
+)
+
+// Service handles payments independently of the payment provider
+type Service struct {
+	db            *gorm.DB
+	registry      *Registry
+	limits        AmountLimits
+	statusUpdates *statusBroker
+}
+
+// NewService creates a new payments service
+func NewService(registry *Registry, limits AmountLimits) *Service {
+	return &Service{
+		db:            database.GetDB(),
+		registry:      registry,
+		limits:        limits,
+		statusUpdates: newStatusBroker(),
+	}
+}
+
+// PaymentIntentRequest represents a payment intent request
+type PaymentIntentRequest struct {
+	Provider         string     `json:"provider"`
+	AmountMinor      int64      `json:"amount_minor"`
+	Currency         string     `json:"currency"`
+	SuccessReturnURL string     `json:"success_return_url"`
+	FailReturnURL    string     `json:"fail_return_url"`
+	Description      *string    `json:"description,omitempty"`
+	ProjectID        *uuid.UUID `json:"project_id,omitempty"`
+	ReferralUserID   *string    `json:"referral_user_id,omitempty"`
+	// CampaignID attributes the donation to a running campaign
+	CampaignID *uuid.UUID `json:"campaign_id,omitempty"`
+	// Dedication makes the donation a gift to someone else
+	Dedication *Dedication `json:"dedication,omitempty"`
+}
+
+// PaymentIntentResponse represents a payment intent response
+type PaymentIntentResponse struct {
+	Provider        string                 `json:"provider"`
+	RedirectURL     string                 `json:"redirect_url"`
+	ProviderPayload map[string]interface{} `json:"provider_payload"`
+}
+
+// SubscriptionIntentRequest represents a subscription intent request
+type SubscriptionIntentRequest struct {
+	Provider         string     `json:"provider"`
+	AmountMinor      int64      `json:"amount_minor"`
+	Currency         string     `json:"currency"`
+	SuccessReturnURL string     `json:"success_return_url"`
+	FailReturnURL    string     `json:"fail_return_url"`
+	ProjectID        *uuid.UUID `json:"project_id,omitempty"`
+	IntervalMonths   int        `json:"interval_months"`
+	Description      *string    `json:"description,omitempty"`
+}
+
+// SubscriptionIntentResponse represents a subscription intent response
+type SubscriptionIntentResponse struct {
+	Provider        string                 `json:"provider"`
+	RedirectURL     string                 `json:"redirect_url"`
+	ProviderPayload map[string]interface{} `json:"provider_payload"`
+}
+
+// Provider returns the registered provider with the given name
+func (s *Service) Provider(name models.PaymentProvider) (Provider, error) {
+	return s.registry.Get(name)
+}
+
+// Providers returns the names of all registered providers
+func (s *Service) Providers() []models.PaymentProvider {
+	return s.registry.Providers()
+}
+
+// GetPaymentByID retrieves a payment by its ID
+func (s *Service) GetPaymentByID(id string) (*models.Payment, error) {
+	var payment models.Payment
+	if err := s.db.Where("id = ?", id).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetUserPayment retrieves a payment owned by the user, together with its donation
+func (s *Service) GetUserPayment(authUserID, id string) (*models.Payment, error) {
+	var payment models.Payment
+	if err := s.db.Preload("Donation").Preload("Donation.Matches.MatchingPool").Where("id = ? AND auth_user_id = ?", id, authUserID).First(&payment).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetGuestPayment retrieves a payment made by a guest account, together with its donation.
+// Payments of claimed accounts are only shown to their owner.
+func (s *Service) GetGuestPayment(id string) (*models.Payment, error) {
+	var payment models.Payment
+	err := s.db.Preload("Donation").Preload("Donation.Matches.MatchingPool").
+		Joins("JOIN user_auth ON user_auth.auth_user_id = payments.auth_user_id").
+		Where("payments.id = ? AND user_auth.guest", id).
+		First(&payment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetSubscriptionByID retrieves a subscription by its ID
+func (s *Service) GetSubscriptionByID(id string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	if err := s.db.Where("id = ?", id).First(&subscription).Error; err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ValidatePaymentIntent checks a payment intent the way CreatePaymentIntent does without
+// creating anything, so that callers can reject it before doing work of their own
+func (s *Service) ValidatePaymentIntent(req *PaymentIntentRequest) error {
+	_, err := s.checkPaymentIntent(req)
+	return err
+}
+
+// checkPaymentIntent validates a payment intent and returns the provider it is made with
+func (s *Service) checkPaymentIntent(req *PaymentIntentRequest) (Provider, error) {
+	provider, err := s.registry.Get(models.PaymentProvider(req.Provider))
+	if err != nil {
+		return nil, err
+	}
+	err = s.validateIntent(intentFields{
+		amountMinor:      req.AmountMinor,
+		currency:         req.Currency,
+		successReturnURL: req.SuccessReturnURL,
+		failReturnURL:    req.FailReturnURL,
+		projectID:        req.ProjectID,
+		dedication:       req.Dedication,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkProject(req.ProjectID); err != nil {
+		return nil, err
+	}
+	if err := s.checkCampaign(req.CampaignID, req.ProjectID, time.Now()); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// CreatePaymentIntent creates a payment intent for one-time payment with the requested provider
+func (s *Service) CreatePaymentIntent(req *PaymentIntentRequest, authUserID string) (*PaymentIntentResponse, error) {
+	provider, err := s.checkPaymentIntent(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create payment record
+	payment := &models.Payment{
+		ID:          uuid.New(),
+		Provider:    provider.Name(),
+		AuthUserID:  &authUserID,
+		AmountMinor: req.AmountMinor,
+		Currency:    models.Currency(req.Currency),
+		Status:      models.PaymentStatusPending,
+		Meta: map[string]interface{}{
+			"success_return_url": req.SuccessReturnURL,
+			"fail_return_url":    req.FailReturnURL,
+			"description":        req.Description,
+			"project_id":         req.ProjectID,
+			"referral_user_id":   req.ReferralUserID,
+			"campaign_id":        req.CampaignID,
+			"dedication":         req.Dedication,
+		},
+	}
+
+	if err := s.db.Create(payment).Error; err != nil {
+		return nil, fmt.Errorf("failed to create payment: %w", err)
+	}
+
+	response, err := provider.CreatePaymentIntent(payment, req)
+	if err != nil {
+		// The payment can never be completed, don't leave it pending
+		s.db.Model(payment).Update("status", models.PaymentStatusFailed)
+		return nil, fmt.Errorf("failed to create %s payment intent: %w", provider.Name(), err)
+	}
+
+	if payment.ProviderPaymentID != nil {
+		if err := s.db.Model(payment).Update("provider_payment_id", *payment.ProviderPaymentID).Error; err != nil {
+			return nil, fmt.Errorf("failed to update payment: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+// CreateSubscriptionIntent creates a subscription intent for recurring payments with the requested provider
+func (s *Service) CreateSubscriptionIntent(req *SubscriptionIntentRequest, authUserID string) (*SubscriptionIntentResponse, error) {
+	provider, err := s.registry.Get(models.PaymentProvider(req.Provider))
+	if err != nil {
+		return nil, err
+	}
+	err = s.validateIntent(intentFields{
+		amountMinor:      req.AmountMinor,
+		currency:         req.Currency,
+		successReturnURL: req.SuccessReturnURL,
+		failReturnURL:    req.FailReturnURL,
+		projectID:        req.ProjectID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkProject(req.ProjectID); err != nil {
+		return nil, err
+	}
+
+	// Create subscription record
+	subscription := &models.Subscription{
+		ID:             uuid.New(),
+		AuthUserID:     authUserID,
+		Provider:       provider.Name(),
+		AmountMinor:    req.AmountMinor,
+		Currency:       models.Currency(req.Currency),
+		IntervalMonths: req.IntervalMonths,
+		ProjectID:      req.ProjectID,
+		Status:         models.SubscriptionStatusIncomplete,
+		Meta: map[string]interface{}{
+			"success_return_url": req.SuccessReturnURL,
+			"fail_return_url":    req.FailReturnURL,
+			"description":        req.Description,
+		},
+	}
+
+	if err := s.db.Create(subscription).Error; err != nil {
+		return nil, fmt.Errorf("failed to create subscription: %w", err)
+	}
+
+	response, err := provider.CreateSubscriptionIntent(subscription, req)
+	if err != nil {
+		// The provider never saw the subscription, don't list it to the user
+		s.db.Delete(subscription)
+		return nil, fmt.Errorf("failed to create %s subscription intent: %w", provider.Name(), err)
+	}
+
+	if subscription.ProviderSubscriptionID != nil {
+		if err := s.db.Model(subscription).Update("provider_subscription_id", *subscription.ProviderSubscriptionID).Error; err != nil {
+			return nil, fmt.Errorf("failed to update subscription: %w", err)
+		}
+	}
+
+	return response, nil
+}
+
+// ProcessWebhook verifies, deduplicates and applies a provider webhook. The parsed event
+// is returned even when processing fails so that the provider can build its answer.
+func (s *Service) ProcessWebhook(provider Provider, req *WebhookRequest) (*WebhookEvent, error) {
+	if err := provider.VerifyWebhook(req); err != nil {
+		return nil, err
+	}
+
+	event, err := provider.ParseWebhook(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for duplicate events. Providers redeliver a notification until it gets a
+	// successful answer, so an event that already failed is processed again.
+	// Keys are stored per provider since event_idempotency is unique across all of them.
+	webhookEvent := &models.WebhookEvent{}
+	var idempotencyKey *string
+	err = gorm.ErrRecordNotFound
+	if event.IdempotencyKey != nil {
+		key := string(provider.Name()) + ":" + *event.IdempotencyKey
+		idempotencyKey = &key
+		err = s.db.Where("event_idempotency = ?", key).First(webhookEvent).Error
+	}
+	switch {
+	case err == nil:
+		if webhookEvent.ProcessedOK {
+			// Event already processed
+			return event, nil
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		webhookEvent = &models.WebhookEvent{
+			ID:               uuid.New(),
+			Provider:         provider.Name(),
+			EventType:        event.Kind,
+			EventIdempotency: idempotencyKey,
+		}
+	default:
+		return event, fmt.Errorf("failed to check webhook event: %w", err)
+	}
+
+	webhookEvent.RawPayload = event.Raw
+	webhookEvent.SignatureOK = true
+	webhookEvent.ReceivedAt = time.Now()
+
+	// Process the event based on its type
+	if err := s.applyEvent(provider.Name(), event); err != nil {
+		errStr := err.Error()
+		webhookEvent.ProcessedOK = false
+		webhookEvent.ProcessingError = &errStr
+		if err := s.db.Save(webhookEvent).Error; err != nil {
+			return event, fmt.Errorf("failed to save webhook event: %w", err)
+		}
+		return event, fmt.Errorf("failed to process webhook: %w", err)
+	}
+
+	webhookEvent.ProcessedOK = true
+	webhookEvent.ProcessingError = nil
+	if err := s.db.Save(webhookEvent).Error; err != nil {
+		return event, fmt.Errorf("failed to save webhook event: %w", err)
+	}
+
+	return event, nil
+}
+
+// applyEvent maps an event onto payment and subscription state transitions
+func (s *Service) applyEvent(provider models.PaymentProvider, event *WebhookEvent) error {
+	// Providers that leave the time out are taken to report the event as it happens
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	switch event.Type {
+	case EventPaymentCheck:
+		return s.processCheckEvent(provider, event)
+	case EventPaymentAuthorized:
+		// Authorized payments are credited once they get captured
+		return nil
+	case EventIgnored:
+		return nil
+	case EventPaymentSucceeded:
+		return s.processPaymentEvent(provider, event)
+	case EventPaymentFailed:
+		return s.processFailEvent(provider, event)
+	case EventPaymentCanceled:
+		return s.processCancelEvent(event)
+	case EventPaymentRefunded:
+		return s.processRefundEvent(event)
+	case EventSubscriptionUpdated:
+		return s.processSubscriptionEvent(provider, event)
+	case EventSubscriptionRevised:
+		return s.processSubscriptionRevisedEvent(provider, event)
+	default:
+		return fmt.Errorf("%w: unsupported event %q", ErrInvalidPayload, event.Type)
+	}
+}
+
+// processCheckEvent validates a payment before the provider authorizes it
+func (s *Service) processCheckEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	// Recurring charges reference the provider subscription only
+	if e.InvoiceID == "" && e.ProviderSubscriptionID != "" {
+		subscription, err := s.findProviderSubscription(provider, e.ProviderSubscriptionID)
+		if err != nil {
+			return err
+		}
+		if subscription.Status == models.SubscriptionStatusCanceled {
+			return ErrPaymentRejected
+		}
+		return checkAmount(e.AmountMinor, e.Currency, subscription.AmountMinor, subscription.Currency)
+	}
+
+	invoiceID, err := uuid.Parse(e.InvoiceID)
+	if err != nil {
+		return ErrUnknownInvoice
+	}
+
+	var payment models.Payment
+	err = s.db.Where("id = ?", invoiceID).First(&payment).Error
+	if err == nil {
+		if payment.AuthUserID != nil && e.AccountID != "" && *payment.AuthUserID != e.AccountID {
+			return ErrAccountMismatch
+		}
+		if payment.Status != models.PaymentStatusPending {
+			return ErrPaymentRejected
+		}
+		if err := checkAmount(e.AmountMinor, e.Currency, payment.AmountMinor, payment.Currency); err != nil {
+			return err
+		}
+		// The check is the first callback that carries the provider's transaction ID
+		return s.linkProviderPayment(&payment, e.ProviderPaymentID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to find payment: %w", err)
+	}
+
+	// The first charge of a subscription uses the subscription ID as invoice
+	var subscription models.Subscription
+	if err := s.db.Where("id = ?", invoiceID).First(&subscription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnknownInvoice
+		}
+		return fmt.Errorf("failed to find subscription: %w", err)
+	}
+	if e.AccountID != "" && subscription.AuthUserID != e.AccountID {
+		return ErrAccountMismatch
+	}
+	if subscription.Status == models.SubscriptionStatusCanceled {
+		return ErrPaymentRejected
+	}
+	return checkAmount(e.AmountMinor, e.Currency, subscription.AmountMinor, subscription.Currency)
+}
+
+// processPaymentEvent processes a completed payment
+func (s *Service) processPaymentEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	if e.ProviderSubscriptionID != "" {
+		return s.processSubscriptionChargeEvent(provider, e)
+	}
+
+	payment, err := s.findPayment(e.InvoiceID, e.ProviderPaymentID)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status == models.PaymentStatusSucceeded {
+		return nil // Already credited
+	}
+	if payment.AuthUserID != nil && e.AccountID != "" && *payment.AuthUserID != e.AccountID {
+		return ErrAccountMismatch
+	}
+
+	// A payment that does not match its intent is kept pending for review instead of being credited
+	if mismatch := paymentMismatch(e, payment.AmountMinor, payment.Currency); mismatch != nil {
+		return s.flagPayment(payment, e, mismatch)
+	}
+
+	// The payment succeeds together with its donation, so a failed donation leaves the
+	// payment pending and the provider's redelivery credits it
+	credited := false
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent deliveries of the same payment wait here and see it succeeded
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.ID).First(payment).Error; err != nil {
+			return fmt.Errorf("failed to lock payment: %w", err)
+		}
+		if payment.Status == models.PaymentStatusSucceeded {
+			return nil
+		}
+
+		meta := mergeMeta(payment.Meta, e.Meta)
+		meta["webhook_processed"] = true
+		updates := map[string]interface{}{
+			"status":      models.PaymentStatusSucceeded,
+			"occurred_at": e.OccurredAt,
+			"meta":        meta,
+		}
+		if e.ProviderPaymentID != "" {
+			updates["provider_payment_id"] = e.ProviderPaymentID
+		}
+		if err := tx.Model(payment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update payment: %w", err)
+		}
+		payment.Meta = meta
+		payment.OccurredAt = &e.OccurredAt
+
+		if err := s.createDonation(tx, payment); err != nil {
+			return err
+		}
+		credited = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if credited {
+		s.statusUpdates.publish(payment.ID)
+	}
+	return nil
+}
+
+// processSubscriptionChargeEvent processes a completed subscription charge
+func (s *Service) processSubscriptionChargeEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	// Find subscription; the first charge links our subscription to the provider's one
+	subscription, err := s.findProviderSubscription(provider, e.ProviderSubscriptionID)
+	if errors.Is(err, ErrUnknownInvoice) {
+		invoiceID, parseErr := uuid.Parse(e.InvoiceID)
+		if parseErr != nil {
+			return fmt.Errorf("subscription not found: %w", err)
+		}
+		subscription = &models.Subscription{}
+		err = s.db.Where("id = ?", invoiceID).First(subscription).Error
+	}
+	if err != nil {
+		return fmt.Errorf("subscription not found: %w", err)
+	}
+
+	if subscription.ProviderSubscriptionID == nil || subscription.Status == models.SubscriptionStatusIncomplete {
+		updates := map[string]interface{}{
+			"provider_subscription_id": e.ProviderSubscriptionID,
+			"status":                   models.SubscriptionStatusActive,
+		}
+		if err := s.db.Model(subscription).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to activate subscription: %w", err)
+		}
+	} else if subscription.Status == models.SubscriptionStatusPastDue || subscription.FailedCharges > 0 {
+		// A successful charge settles the debt and ends dunning
+		if err := s.settleSubscription(subscription); err != nil {
+			return err
+		}
+	}
+
+	// A charge whose payment exists was credited by an earlier delivery
+	if e.ProviderPaymentID != "" {
+		var charged int64
+		err := s.db.Model(&models.Payment{}).
+			Where("provider = ? AND provider_payment_id = ?", provider, e.ProviderPaymentID).
+			Count(&charged).Error
+		if err != nil {
+			return fmt.Errorf("failed to find payment: %w", err)
+		}
+		if charged > 0 {
+			return nil
+		}
+	}
+
+	// Create payment record for this charge; its donation goes to the subscription's project
+	occurredAt := e.OccurredAt
+	meta := mergeMeta(nil, e.Meta)
+	meta["subscription_charge"] = true
+	meta["webhook_processed"] = true
+	if subscription.ProjectID != nil {
+		meta["project_id"] = subscription.ProjectID.String()
+	}
+	payment := &models.Payment{
+		ID:                uuid.New(),
+		Provider:          provider,
+		ProviderPaymentID: optionalString(e.ProviderPaymentID),
+		AuthUserID:        &subscription.AuthUserID,
+		SubscriptionID:    &subscription.ID,
+		AmountMinor:       e.AmountMinor,
+		Currency:          subscription.Currency,
+		Status:            models.PaymentStatusSucceeded,
+		OccurredAt:        &occurredAt,
+		Meta:              meta,
+	}
+
+	// The payment is only stored with its donation, so a failed donation can be redelivered
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment: %w", err)
+		}
+		return s.createDonation(tx, payment)
+	})
+}
+
+// processFailEvent processes a declined payment
+func (s *Service) processFailEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	if e.ProviderSubscriptionID != "" {
+		subscription, err := s.findProviderSubscription(provider, e.ProviderSubscriptionID)
+		if err != nil {
+			if errors.Is(err, ErrUnknownInvoice) {
+				return nil // Nothing to record for an unknown subscription
+			}
+			return err
+		}
+
+		// Keep a record of the declined charge
+		occurredAt := e.OccurredAt
+		meta := mergeMeta(nil, e.Meta)
+		meta["subscription_charge"] = true
+		meta["webhook_processed"] = true
+		payment := &models.Payment{
+			ID:                uuid.New(),
+			Provider:          provider,
+			ProviderPaymentID: optionalString(e.ProviderPaymentID),
+			AuthUserID:        &subscription.AuthUserID,
+			SubscriptionID:    &subscription.ID,
+			AmountMinor:       e.AmountMinor,
+			Currency:          subscription.Currency,
+			Status:            models.PaymentStatusFailed,
+			OccurredAt:        &occurredAt,
+			Meta:              meta,
+		}
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(payment).Error; err != nil {
+				return fmt.Errorf("failed to create payment: %w", err)
+			}
+			if subscription.Status == models.SubscriptionStatusCanceled {
+				return nil
+			}
+			return recordFailedCharge(tx, subscription, time.Now())
+		})
+	}
+
+	payment, err := s.findPayment(e.InvoiceID, e.ProviderPaymentID)
+	if err != nil {
+		if errors.Is(err, ErrUnknownInvoice) {
+			return nil // Declined payments for unknown invoices need no action
+		}
+		return err
+	}
+	if payment.Status != models.PaymentStatusPending {
+		return nil
+	}
+
+	meta := mergeMeta(payment.Meta, e.Meta)
+	meta["webhook_processed"] = true
+	updates := map[string]interface{}{
+		"status":      models.PaymentStatusFailed,
+		"occurred_at": e.OccurredAt,
+		"meta":        meta,
+	}
+	if e.ProviderPaymentID != "" && payment.ProviderPaymentID == nil {
+		updates["provider_payment_id"] = e.ProviderPaymentID
+	}
+
+	if err := s.db.Model(payment).Updates(updates).Error; err != nil {
+		return err
+	}
+	s.statusUpdates.publish(payment.ID)
+	return nil
+}
+
+// processCancelEvent processes a voided authorization
+func (s *Service) processCancelEvent(e *WebhookEvent) error {
+	payment, err := s.findPayment(e.InvoiceID, e.ProviderPaymentID)
+	if err != nil {
+		return err
+	}
+	if payment.Status != models.PaymentStatusPending {
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"status":      models.PaymentStatusCanceled,
+		"occurred_at": e.OccurredAt,
+	}
+
+	if err := s.db.Model(payment).Updates(updates).Error; err != nil {
+		return err
+	}
+	s.statusUpdates.publish(payment.ID)
+	return nil
+}
+
+// processSubscriptionEvent synchronizes a subscription status change
+func (s *Service) processSubscriptionEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	subscription, err := s.findProviderSubscription(provider, e.ProviderSubscriptionID)
+	if err != nil {
+		return err
+	}
+	// Providers report a subscription the donor paused as suspended for missed payments
+	if subscription.Status == models.SubscriptionStatusPaused && e.SubscriptionStatus == models.SubscriptionStatusPastDue {
+		e.SubscriptionStatus = models.SubscriptionStatusPaused
+	}
+
+	updates := dunningUpdates(subscription, e.SubscriptionStatus, time.Now())
+	updates["status"] = e.SubscriptionStatus
+	updates["meta"] = mergeMeta(subscription.Meta, e.Meta)
+	if e.SubscriptionStatus == models.SubscriptionStatusCanceled && subscription.CanceledAt == nil {
+		updates["canceled_at"] = time.Now()
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if e.SubscriptionStatus != subscription.Status {
+			if err := recordProviderStatusChange(tx, subscription, e.SubscriptionStatus); err != nil {
+				return err
+			}
+		}
+		return tx.Model(subscription).Updates(updates).Error
+	})
+}
+
+// processSubscriptionRevisedEvent applies a subscription change the donor approved at the
+// provider
+func (s *Service) processSubscriptionRevisedEvent(provider models.PaymentProvider, e *WebhookEvent) error {
+	subscription, err := s.findProviderSubscription(provider, e.ProviderSubscriptionID)
+	if err != nil {
+		return err
+	}
+	return s.applyRevision(subscription, e.ProviderPlanID)
+}
+
+// linkProviderPayment stores the provider's transaction ID on a payment, so that later
+// notifications that only carry the transaction ID can be matched
+func (s *Service) linkProviderPayment(payment *models.Payment, providerPaymentID string) error {
+	if providerPaymentID == "" || (payment.ProviderPaymentID != nil && *payment.ProviderPaymentID == providerPaymentID) {
+		return nil
+	}
+	if err := s.db.Model(payment).Update("provider_payment_id", providerPaymentID).Error; err != nil {
+		return fmt.Errorf("failed to link provider payment: %w", err)
+	}
+	payment.ProviderPaymentID = &providerPaymentID
+	return nil
+}
+
+// flagPayment records a notification that does not match the payment intent. The payment
+// stays pending with the mismatch in its meta until someone reviews it.
+func (s *Service) flagPayment(payment *models.Payment, e *WebhookEvent, mismatch map[string]interface{}) error {
+	meta := mergeMeta(payment.Meta, e.Meta)
+	meta["mismatch"] = mismatch
+	meta["needs_review"] = true
+	updates := map[string]interface{}{
+		"meta": meta,
+	}
+	if e.ProviderPaymentID != "" {
+		updates["provider_payment_id"] = e.ProviderPaymentID
+	}
+	if err := s.db.Model(payment).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to flag payment: %w", err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"payment_id": payment.ID,
+		"mismatch":   mismatch,
+	}).Warn("Payment notification does not match the intent")
+
+	return fmt.Errorf("%w: payment %s flagged for review", ErrInvalidAmount, payment.ID)
+}
+
+// paymentMismatch compares a payment notification with the intent. It returns nil when
+// they match, otherwise the expected and received values. An empty currency is not checked.
+func paymentMismatch(e *WebhookEvent, expectedMinor int64, expectedCurrency models.Currency) map[string]interface{} {
+	if e.AmountMinor == expectedMinor && (e.Currency == "" || e.Currency == expectedCurrency) {
+		return nil
+	}
+	return map[string]interface{}{
+		"expected_amount_minor": expectedMinor,
+		"received_amount_minor": e.AmountMinor,
+		"expected_currency":     expectedCurrency,
+		"received_currency":     e.Currency,
+		"provider_payment_id":   e.ProviderPaymentID,
+		"detected_at":           time.Now(),
+	}
+}
+
+// findPayment finds a payment by our invoice ID, falling back to the provider transaction ID
+func (s *Service) findPayment(invoiceID, providerPaymentID string) (*models.Payment, error) {
+	var payment models.Payment
+
+	if id, err := uuid.Parse(invoiceID); err == nil {
+		err := s.db.Where("id = ?", id).First(&payment).Error
+		if err == nil {
+			return &payment, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to find payment: %w", err)
+		}
+	}
+
+	if providerPaymentID != "" {
+		err := s.db.Where("provider_payment_id = ?", providerPaymentID).First(&payment).Error
+		if err == nil {
+			return &payment, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to find payment: %w", err)
+		}
+	}
+
+	return nil, ErrUnknownInvoice
+}
+
+// findProviderSubscription finds a subscription by the provider's subscription ID
+func (s *Service) findProviderSubscription(provider models.PaymentProvider, providerSubscriptionID string) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := s.db.Where("provider = ? AND provider_subscription_id = ?", provider, providerSubscriptionID).
+		First(&subscription).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownInvoice
+		}
+		return nil, fmt.Errorf("failed to find subscription: %w", err)
+	}
+	return &subscription, nil
+}
+
+// checkAmount compares a notification amount with the expected one. An empty currency is not checked.
+func checkAmount(amountMinor int64, currency models.Currency, expectedMinor int64, expectedCurrency models.Currency) error {
+	if amountMinor <= 0 || amountMinor != expectedMinor {
+		return fmt.Errorf("%w: got %d, expected %d", ErrInvalidAmount, amountMinor, expectedMinor)
+	}
+	if currency != "" && currency != expectedCurrency {
+		return fmt.Errorf("%w: got currency %s, expected %s", ErrInvalidAmount, currency, expectedCurrency)
+	}
+	return nil
+}
+
+// optionalString returns nil for an empty string
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// mergeMeta returns a copy of a jsonb meta column with the given values added
+func mergeMeta(meta interface{}, values map[string]interface{}) map[string]interface{} {
+	result := metaMap(meta)
+	for k, v := range values {
+		result[k] = v
+	}
+	return result
+}
+
+// MetaString returns a string value of a jsonb meta column, or "" when it is not set
+func MetaString(meta interface{}, key string) string {
+	value, _ := metaMap(meta)[key].(string)
+	return value
+}
+
+// metaMap returns a copy of a jsonb meta column as a map
+func metaMap(meta interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+
+	var raw []byte
+	switch m := meta.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			result[k] = v
+		}
+		return result
+	case []byte:
+		raw = m
+	case string:
+		raw = []byte(m)
+	default:
+		if meta == nil {
+			return result
+		}
+		var err error
+		if raw, err = json.Marshal(meta); err != nil {
+			return result
+		}
+	}
+
+	_ = json.Unmarshal(raw, &result)
+	return result
+}
+
+// createDonation creates a donation record and updates user counters within the
+// transaction that marks the payment succeeded. Trees are bought at the price in force
+// when the payment was made, the project's own price if it has one. The donor's credit
+// in the payment currency is applied to the donation, and whatever does not buy a whole
+// tree is kept as credit for the next one. A payment made as a gift stores its
+// dedication with the donation, and open matching pools match the trees with donations
+// of their sponsors.
+func (s *Service) createDonation(tx *gorm.DB, payment *models.Payment) error {
+	// Get project ID from payment meta if available
+	var projectID *uuid.UUID
+	var referralUserID *string
+	meta := metaMap(payment.Meta)
+	if projectIDStr, exists := meta["project_id"]; exists && projectIDStr != nil {
+		if id, ok := projectIDStr.(string); ok {
+			if parsedID, err := uuid.Parse(id); err == nil {
+				projectID = &parsedID
+			}
+		}
+	}
+	// Get referral user ID from payment meta if available
+	if refUserID, exists := meta["referral_user_id"]; exists && refUserID != nil {
+		if id, ok := refUserID.(string); ok {
+			referralUserID = &id
+		}
+	}
+	campaignID := metaUUID(meta, "campaign_id")
+	dedication := dedicationFromMeta(meta)
+
+	paidAt := time.Now()
+	if payment.OccurredAt != nil && !payment.OccurredAt.IsZero() {
+		paidAt = *payment.OccurredAt
+	}
+	treePrice, err := prices.PriceAt(tx, payment.Currency, projectID, paidAt)
+	if err != nil {
+		return fmt.Errorf("tree price not found for currency %s: %w", payment.Currency, err)
+	}
+
+	credit, err := lockCreditBalance(tx, *payment.AuthUserID, payment.Currency)
+	if err != nil {
+		return err
+	}
+	treesCount, remainderMinor := splitDonation(payment.AmountMinor, credit.BalanceMinor, treePrice.PriceMinor)
+
+	// Create donation
+	donation := &models.Donation{
+		ID:             uuid.New(),
+		AuthUserID:     *payment.AuthUserID,
+		PaymentID:      &payment.ID,
+		ProjectID:      projectID,
+		ReferralUserID: referralUserID,
+		CampaignID:     campaignID,
+		TreesCount:     treesCount,
+		TreePriceID:    &treePrice.ID,
+		PriceMinor:     treePrice.PriceMinor,
+	}
+
+	if err := tx.Create(donation).Error; err != nil {
+		return fmt.Errorf("failed to create donation: %w", err)
+	}
+	if err := allocations.Allocate(tx, donation); err != nil {
+		return err
+	}
+	if err := setCreditBalance(tx, credit, &donation.ID, credit.BalanceMinor, remainderMinor, CreditEntryRemainder); err != nil {
+		return err
+	}
+	if dedication != nil {
+		if err := createDedication(tx, donation, dedication); err != nil {
+			return err
+		}
+	}
+	if err := matchDonation(tx, donation, paidAt); err != nil {
+		return err
+	}
+
+	// Update user counters
+	updates := map[string]interface{}{
+		"total_trees":      gorm.Expr("total_trees + ?", treesCount),
+		"donations_count":  gorm.Expr("donations_count + 1"),
+		"last_donation_at": time.Now(),
+	}
+
+	if err := tx.Model(&models.User{}).Where("auth_user_id = ?", *payment.AuthUserID).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update user counters: %w", err)
+	}
+
+	return nil
+}
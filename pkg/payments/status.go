@@ -0,0 +1,76 @@
+package payments
+
+import (
+	"sync"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// statusBroker notifies watchers when the status of a payment changes. Notifications
+// only reach watchers in the same process; watchers are expected to re-read the payment
+// and to poll as a fallback.
+type statusBroker struct {
+	mu       sync.Mutex
+	watchers map[uuid.UUID]map[chan struct{}]struct{}
+}
+
+func newStatusBroker() *statusBroker {
+	return &statusBroker{
+		watchers: make(map[uuid.UUID]map[chan struct{}]struct{}),
+	}
+}
+
+// watch registers a watcher for a payment. The returned function must be called to
+// stop watching.
+func (b *statusBroker) watch(paymentID uuid.UUID) (<-chan struct{}, func()) {
+	// A buffer of one coalesces notifications the watcher has not picked up yet
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	if b.watchers[paymentID] == nil {
+		b.watchers[paymentID] = make(map[chan struct{}]struct{})
+	}
+	b.watchers[paymentID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.watchers[paymentID], ch)
+		if len(b.watchers[paymentID]) == 0 {
+			delete(b.watchers, paymentID)
+		}
+	}
+}
+
+// publish notifies all watchers of a payment without blocking
+func (b *statusBroker) publish(paymentID uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.watchers[paymentID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// WatchPayment returns a channel that receives a notification whenever a webhook changes
+// the payment. Call the returned function to stop watching.
+func (s *Service) WatchPayment(paymentID uuid.UUID) (<-chan struct{}, func()) {
+	return s.statusUpdates.watch(paymentID)
+}
+
+// IsSettled reports whether a payment left the pending state. A succeeded payment is only
+// settled once its donation is there, so the payment must be loaded with its donation.
+func IsSettled(payment *models.Payment) bool {
+	switch payment.Status {
+	case models.PaymentStatusPending:
+		return false
+	case models.PaymentStatusSucceeded:
+		return payment.Donation != nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,50 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusBroker(t *testing.T) {
+	broker := newStatusBroker()
+	paymentID := uuid.New()
+
+	updates, stop := broker.watch(paymentID)
+	other, stopOther := broker.watch(uuid.New())
+	defer stopOther()
+
+	// Notifications that were not picked up yet are coalesced
+	broker.publish(paymentID)
+	broker.publish(paymentID)
+	assert.Len(t, updates, 1)
+	assert.Empty(t, other)
+	<-updates
+
+	stop()
+	broker.publish(paymentID)
+	assert.Empty(t, updates)
+	assert.NotContains(t, broker.watchers, paymentID)
+}
+
+func TestIsSettled(t *testing.T) {
+	tests := []struct {
+		name     string
+		payment  models.Payment
+		expected bool
+	}{
+		{"pending", models.Payment{Status: models.PaymentStatusPending}, false},
+		{"succeeded without donation", models.Payment{Status: models.PaymentStatusSucceeded}, false},
+		{"succeeded with donation", models.Payment{Status: models.PaymentStatusSucceeded, Donation: &models.Donation{TreesCount: 3}}, true},
+		{"failed", models.Payment{Status: models.PaymentStatusFailed}, true},
+		{"canceled", models.Payment{Status: models.PaymentStatusCanceled}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsSettled(&tt.payment))
+		})
+	}
+}
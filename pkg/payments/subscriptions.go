@@ -0,0 +1,322 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrSubscriptionTransition is returned for changes a subscription cannot make in its status
+	ErrSubscriptionTransition = errors.New("subscription cannot make this change in its current status")
+	// ErrInvalidSubscriptionUpdate is returned for changes with invalid or unchanged terms
+	ErrInvalidSubscriptionUpdate = errors.New("invalid subscription change")
+)
+
+// subscriptionTransitions lists the statuses each donor action is allowed from
+var subscriptionTransitions = map[SubscriptionAction][]models.SubscriptionStatus{
+	SubscriptionActionCancel: {models.SubscriptionStatusActive, models.SubscriptionStatusPastDue, models.SubscriptionStatusPaused, models.SubscriptionStatusIncomplete},
+	SubscriptionActionPause:  {models.SubscriptionStatusActive, models.SubscriptionStatusPastDue},
+	SubscriptionActionResume: {models.SubscriptionStatusPaused},
+	SubscriptionActionChange: {models.SubscriptionStatusActive, models.SubscriptionStatusPastDue, models.SubscriptionStatusPaused},
+}
+
+// nextSubscriptionStatus returns the status a subscription has after a donor action
+func nextSubscriptionStatus(status models.SubscriptionStatus, action SubscriptionAction) (models.SubscriptionStatus, error) {
+	allowed, ok := subscriptionTransitions[action]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown action %q", ErrInvalidSubscriptionUpdate, action)
+	}
+	for _, from := range allowed {
+		if status != from {
+			continue
+		}
+		switch action {
+		case SubscriptionActionCancel:
+			return models.SubscriptionStatusCanceled, nil
+		case SubscriptionActionPause:
+			return models.SubscriptionStatusPaused, nil
+		case SubscriptionActionResume:
+			return models.SubscriptionStatusActive, nil
+		default:
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("%w: cannot %s a subscription that is %s", ErrSubscriptionTransition, action, status)
+}
+
+// UpdateSubscription cancels, pauses, resumes or changes the terms of one of the user's
+// subscriptions. The change is made at the provider first, then stored and audited.
+func (s *Service) UpdateSubscription(authUserID, id string, update *SubscriptionUpdate) (*models.Subscription, *SubscriptionUpdateResult, error) {
+	var subscription models.Subscription
+	if err := s.db.Where("id = ? AND auth_user_id = ?", id, authUserID).First(&subscription).Error; err != nil {
+		return nil, nil, err
+	}
+
+	result, err := s.applySubscriptionUpdate(&subscription, update, &authUserID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &subscription, result, nil
+}
+
+// applySubscriptionUpdate makes a change at the provider, then stores and audits it.
+// The subscription is locked and reloaded first, so that webhooks and dunning wait until
+// the provider and the database agree. actorID is nil for changes made by the system; a
+// requiredStatus other than "" rejects the change once the subscription left that status.
+func (s *Service) applySubscriptionUpdate(subscription *models.Subscription, update *SubscriptionUpdate, actorID *string, requiredStatus models.SubscriptionStatus) (*SubscriptionUpdateResult, error) {
+	var result *SubscriptionUpdateResult
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", subscription.ID).First(subscription).Error; err != nil {
+			return fmt.Errorf("failed to lock subscription: %w", err)
+		}
+		if requiredStatus != "" && subscription.Status != requiredStatus {
+			return fmt.Errorf("%w: subscription is %s", ErrSubscriptionTransition, subscription.Status)
+		}
+		var err error
+		result, err = s.updateLockedSubscription(tx, subscription, update, actorID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// updateLockedSubscription checks and applies a change to a subscription locked by tx
+func (s *Service) updateLockedSubscription(tx *gorm.DB, subscription *models.Subscription, update *SubscriptionUpdate, actorID *string) (*SubscriptionUpdateResult, error) {
+	status, err := nextSubscriptionStatus(subscription.Status, update.Action)
+	if err != nil {
+		return nil, err
+	}
+
+	// The provider receives the complete new terms
+	termsChanged := update.Action != SubscriptionActionChange
+	projectChanged := false
+	if update.Action == SubscriptionActionChange {
+		if update.AmountMinor < 0 || update.IntervalMonths < 0 {
+			return nil, fmt.Errorf("%w: amount and interval must be positive", ErrInvalidSubscriptionUpdate)
+		}
+		if update.AmountMinor == 0 {
+			update.AmountMinor = subscription.AmountMinor
+		}
+		if update.IntervalMonths == 0 {
+			update.IntervalMonths = subscription.IntervalMonths
+		}
+		termsChanged = update.AmountMinor != subscription.AmountMinor || update.IntervalMonths != subscription.IntervalMonths
+		projectChanged = update.ChangeProject && !sameProject(update.ProjectID, subscription.ProjectID)
+		if !termsChanged && !projectChanged {
+			return nil, fmt.Errorf("%w: nothing to change", ErrInvalidSubscriptionUpdate)
+		}
+		projectID := subscription.ProjectID
+		if projectChanged {
+			if err := s.checkProject(update.ProjectID); err != nil {
+				return nil, err
+			}
+			projectID = update.ProjectID
+		}
+		// New amounts follow the rules of new intents; the tree price may differ per project
+		if update.AmountMinor != subscription.AmountMinor || projectChanged {
+			errs := &ValidationError{}
+			if err := s.validatePricedAmount(errs, update.AmountMinor, subscription.Currency, projectID); err != nil {
+				return nil, err
+			}
+			if err := errs.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// A subscription that was never set up at the provider only exists here
+	result := &SubscriptionUpdateResult{}
+	pending := false
+	if termsChanged && subscription.ProviderSubscriptionID != nil {
+		provider, err := s.registry.Get(subscription.Provider)
+		if err != nil {
+			return nil, err
+		}
+		if result, err = provider.UpdateSubscription(subscription, update); err != nil {
+			return nil, err
+		}
+	}
+
+	change := &models.SubscriptionChange{
+		ID:                 uuid.New(),
+		SubscriptionID:     subscription.ID,
+		ActorID:            actorID,
+		Action:             string(update.Action),
+		FromStatus:         subscription.Status,
+		ToStatus:           status,
+		FromAmountMinor:    subscription.AmountMinor,
+		ToAmountMinor:      subscription.AmountMinor,
+		FromIntervalMonths: subscription.IntervalMonths,
+		ToIntervalMonths:   subscription.IntervalMonths,
+		FromProjectID:      subscription.ProjectID,
+		ToProjectID:        subscription.ProjectID,
+		Reason:             update.Reason,
+	}
+	now := time.Now()
+	updates := dunningUpdates(subscription, status, now)
+	updates["status"] = status
+	switch update.Action {
+	case SubscriptionActionCancel:
+		subscription.CanceledAt = &now
+		updates["canceled_at"] = now
+	case SubscriptionActionChange:
+		if termsChanged && result.RedirectURL != "" {
+			// The new terms wait for the donor's approval at the provider; only the project
+			// changes right away
+			pending = true
+			updates["pending_amount_minor"] = update.AmountMinor
+			updates["pending_interval_months"] = update.IntervalMonths
+			updates["pending_plan_id"] = result.ProviderPlanID
+		} else {
+			change.ToAmountMinor = update.AmountMinor
+			change.ToIntervalMonths = update.IntervalMonths
+			updates["amount_minor"] = update.AmountMinor
+			updates["interval_months"] = update.IntervalMonths
+			updates["pending_amount_minor"] = nil
+			updates["pending_interval_months"] = nil
+			updates["pending_plan_id"] = nil
+		}
+		if projectChanged {
+			change.ToProjectID = update.ProjectID
+			updates["project_id"] = update.ProjectID
+		}
+	}
+
+	if err := tx.Model(subscription).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %w", err)
+	}
+	// A pending change is audited once the provider confirms it
+	if !pending || projectChanged {
+		if err := tx.Create(change).Error; err != nil {
+			return nil, fmt.Errorf("failed to record subscription change: %w", err)
+		}
+	}
+
+	subscription.Status = status
+	subscription.AmountMinor = change.ToAmountMinor
+	subscription.IntervalMonths = change.ToIntervalMonths
+	subscription.ProjectID = change.ToProjectID
+	if pending {
+		subscription.PendingAmountMinor = &update.AmountMinor
+		subscription.PendingIntervalMonths = &update.IntervalMonths
+		subscription.PendingPlanID = &result.ProviderPlanID
+	} else if update.Action == SubscriptionActionChange {
+		subscription.PendingAmountMinor = nil
+		subscription.PendingIntervalMonths = nil
+		subscription.PendingPlanID = nil
+	}
+	return result, nil
+}
+
+// applyRevision puts a subscription on the terms of its pending change once the provider
+// confirms that the donor approved it. Revisions of other plans are ignored.
+func (s *Service) applyRevision(subscription *models.Subscription, providerPlanID string) error {
+	if subscription.PendingPlanID == nil || *subscription.PendingPlanID != providerPlanID ||
+		subscription.PendingAmountMinor == nil || subscription.PendingIntervalMonths == nil {
+		return nil
+	}
+
+	change := &models.SubscriptionChange{
+		ID:                 uuid.New(),
+		SubscriptionID:     subscription.ID,
+		ActorID:            &subscription.AuthUserID,
+		Action:             string(SubscriptionActionChange),
+		FromStatus:         subscription.Status,
+		ToStatus:           subscription.Status,
+		FromAmountMinor:    subscription.AmountMinor,
+		ToAmountMinor:      *subscription.PendingAmountMinor,
+		FromIntervalMonths: subscription.IntervalMonths,
+		ToIntervalMonths:   *subscription.PendingIntervalMonths,
+		FromProjectID:      subscription.ProjectID,
+		ToProjectID:        subscription.ProjectID,
+	}
+	updates := map[string]interface{}{
+		"amount_minor":            change.ToAmountMinor,
+		"interval_months":         change.ToIntervalMonths,
+		"pending_amount_minor":    nil,
+		"pending_interval_months": nil,
+		"pending_plan_id":         nil,
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// Only the first delivery of the confirmation applies the change
+		result := tx.Model(subscription).Where("pending_plan_id = ?", providerPlanID).Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update subscription: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		if err := tx.Create(change).Error; err != nil {
+			return fmt.Errorf("failed to record subscription change: %w", err)
+		}
+		return nil
+	})
+}
+
+// checkProject makes sure that a donation targets an existing project. No project means a
+// general donation.
+func (s *Service) checkProject(projectID *uuid.UUID) error {
+	if projectID == nil {
+		return nil
+	}
+	var count int64
+	if err := s.db.Model(&models.Project{}).Where("id = ?", *projectID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to find project: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownProject, projectID)
+	}
+	return nil
+}
+
+// sameProject reports whether two optional project IDs are equal
+func sameProject(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// GetSubscriptionChanges returns the audit of one of the user's subscriptions, newest first
+func (s *Service) GetSubscriptionChanges(authUserID, id string) ([]models.SubscriptionChange, error) {
+	var subscription models.Subscription
+	if err := s.db.Where("id = ? AND auth_user_id = ?", id, authUserID).First(&subscription).Error; err != nil {
+		return nil, err
+	}
+
+	var changes []models.SubscriptionChange
+	if err := s.db.Where("subscription_id = ?", subscription.ID).Order("created_at DESC").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// recordProviderStatusChange audits a subscription status change reported by the provider
+func recordProviderStatusChange(tx *gorm.DB, subscription *models.Subscription, status models.SubscriptionStatus) error {
+	change := &models.SubscriptionChange{
+		ID:                 uuid.New(),
+		SubscriptionID:     subscription.ID,
+		Action:             string(SubscriptionActionProviderUpdate),
+		FromStatus:         subscription.Status,
+		ToStatus:           status,
+		FromAmountMinor:    subscription.AmountMinor,
+		ToAmountMinor:      subscription.AmountMinor,
+		FromIntervalMonths: subscription.IntervalMonths,
+		ToIntervalMonths:   subscription.IntervalMonths,
+		FromProjectID:      subscription.ProjectID,
+		ToProjectID:        subscription.ProjectID,
+	}
+	if err := tx.Create(change).Error; err != nil {
+		return fmt.Errorf("failed to record subscription change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/4planet/backend/pkg/prices"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Codes of field errors
+const (
+	FieldErrorRequired    = "required"
+	FieldErrorInvalid     = "invalid"
+	FieldErrorUnsupported = "unsupported"
+	FieldErrorTooSmall    = "too_small"
+	FieldErrorTooLarge    = "too_large"
+	FieldErrorPrecision   = "invalid_precision"
+)
+
+// minorUnitSteps is the smallest step, in minor units, amounts of each currency can be
+// charged in. Tenge are charged in whole tenge; tiyn are not accepted by Kaspi.
+var minorUnitSteps = map[models.Currency]int64{
+	models.CurrencyRUB: 1,
+	models.CurrencyKZT: 100,
+	models.CurrencyUSD: 1,
+	models.CurrencyEUR: 1,
+}
+
+// FieldError describes why one field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Limit is the bound an amount broke, in minor units
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// ValidationError is returned for requests with invalid fields
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// Add records an invalid field
+func (e *ValidationError) Add(field, code, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message})
+}
+
+// addLimit records an amount that broke a bound
+func (e *ValidationError) addLimit(field, code, message string, limit int64) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: message, Limit: &limit})
+}
+
+// Err returns the error if any field was invalid and nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// AmountLimits caps the amount of a single payment or recurring charge
+type AmountLimits struct {
+	// MaxAmountMinor is the cap per currency; currencies without a cap accept any amount
+	MaxAmountMinor map[models.Currency]int64
+}
+
+// intentFields are the fields payment and subscription intents have in common
+type intentFields struct {
+	amountMinor      int64
+	currency         string
+	successReturnURL string
+	failReturnURL    string
+	projectID        *uuid.UUID
+	dedication       *Dedication
+}
+
+// validateIntent checks the currency, amount, return URLs and dedication of an intent.
+// Amounts must buy at least one tree at the current price of the currency, for the project
+// when there is one.
+func (s *Service) validateIntent(fields intentFields) error {
+	errs := &ValidationError{}
+	if fields.successReturnURL == "" {
+		errs.Add("success_return_url", FieldErrorRequired, "Success return URL is required")
+	}
+	if fields.failReturnURL == "" {
+		errs.Add("fail_return_url", FieldErrorRequired, "Fail return URL is required")
+	}
+	if fields.dedication != nil {
+		validateDedication(errs, fields.dedication)
+	}
+
+	currency := models.Currency(fields.currency)
+	switch {
+	case fields.currency == "":
+		errs.Add("currency", FieldErrorRequired, "Currency is required")
+		return errs.Err()
+	case !currency.IsValid():
+		errs.Add("currency", FieldErrorUnsupported, fmt.Sprintf("Currency %q is not supported", fields.currency))
+		return errs.Err()
+	}
+
+	if err := s.validatePricedAmount(errs, fields.amountMinor, currency, fields.projectID); err != nil {
+		return err
+	}
+	return errs.Err()
+}
+
+// validatePricedAmount records the ways an amount breaks the rules of its currency at the
+// current tree price, the project's price when there is one
+func (s *Service) validatePricedAmount(errs *ValidationError, amountMinor int64, currency models.Currency, projectID *uuid.UUID) error {
+	price, err := prices.PriceAt(s.db, currency, projectID, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		errs.Add("currency", FieldErrorUnsupported, fmt.Sprintf("Donations in %s are not accepted yet", currency))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find tree price: %w", err)
+	}
+	validateAmount(errs, amountMinor, currency, price.PriceMinor, s.limits.MaxAmountMinor[currency])
+	return nil
+}
+
+// validateAmount records the ways an amount breaks the rules of its currency. A cap of zero
+// means no cap.
+func validateAmount(errs *ValidationError, amountMinor int64, currency models.Currency, minMinor, maxMinor int64) {
+	if step := minorUnitSteps[currency]; step > 1 && amountMinor%step != 0 {
+		errs.addLimit("amount_minor", FieldErrorPrecision, fmt.Sprintf("Amounts in %s must be a multiple of %d minor units", currency, step), step)
+	}
+	if amountMinor < minMinor {
+		errs.addLimit("amount_minor", FieldErrorTooSmall, "Amount must pay for at least one tree", minMinor)
+	}
+	if maxMinor > 0 && amountMinor > maxMinor {
+		errs.addLimit("amount_minor", FieldErrorTooLarge, fmt.Sprintf("Amount must not exceed %d minor units", maxMinor), maxMinor)
+	}
+}
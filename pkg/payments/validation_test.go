@@ -0,0 +1,80 @@
+package payments
+
+import (
+	"testing"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAmount(t *testing.T) {
+	tests := []struct {
+		name      string
+		amount    int64
+		currency  models.Currency
+		wantCodes []string
+	}{
+		{"one tree", 19000, models.CurrencyRUB, nil},
+		{"at the cap", 1000000, models.CurrencyRUB, nil},
+		{"below one tree", 18999, models.CurrencyRUB, []string{FieldErrorTooSmall}},
+		{"zero", 0, models.CurrencyRUB, []string{FieldErrorTooSmall}},
+		{"negative", -100, models.CurrencyRUB, []string{FieldErrorTooSmall}},
+		{"above the cap", 1000001, models.CurrencyRUB, []string{FieldErrorTooLarge}},
+		{"whole tenge", 19000, models.CurrencyKZT, nil},
+		{"tiyn", 19050, models.CurrencyKZT, []string{FieldErrorPrecision}},
+		{"tiyn below one tree", 50, models.CurrencyKZT, []string{FieldErrorPrecision, FieldErrorTooSmall}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := &ValidationError{}
+			validateAmount(errs, tt.amount, tt.currency, 19000, 1000000)
+
+			var codes []string
+			for _, field := range errs.Fields {
+				assert.Equal(t, "amount_minor", field.Field)
+				assert.NotNil(t, field.Limit)
+				codes = append(codes, field.Code)
+			}
+			assert.Equal(t, tt.wantCodes, codes)
+		})
+	}
+}
+
+func TestValidateAmount_NoCap(t *testing.T) {
+	errs := &ValidationError{}
+	validateAmount(errs, 1<<40, models.CurrencyUSD, 500, 0)
+	assert.NoError(t, errs.Err())
+}
+
+func TestValidateIntent_InvalidCurrency(t *testing.T) {
+	service := NewService(NewRegistry(), AmountLimits{})
+
+	tests := []struct {
+		name     string
+		currency string
+		wantCode string
+	}{
+		{"missing", "", FieldErrorRequired},
+		{"unknown", "GBP", FieldErrorUnsupported},
+		{"lower case", "rub", FieldErrorUnsupported},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := service.validateIntent(intentFields{
+				amountMinor:      19000,
+				currency:         tt.currency,
+				successReturnURL: "https://app.local/ok",
+			})
+
+			var validationErr *ValidationError
+			if assert.ErrorAs(t, err, &validationErr) {
+				assert.Equal(t, []FieldError{
+					{Field: "fail_return_url", Code: FieldErrorRequired, Message: "Fail return URL is required"},
+					{Field: "currency", Code: tt.wantCode, Message: validationErr.Fields[1].Message},
+				}, validationErr.Fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package planting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Polygon is a GeoJSON polygon: rings of [longitude, latitude] positions, the first ring
+// being the boundary and any others holes in it
+type Polygon struct {
+	Type        string        `json:"type"`
+	Coordinates [][][]float64 `json:"coordinates"`
+}
+
+// ParsePolygon reads and validates a GeoJSON polygon. Every ring needs at least four
+// positions and must end where it starts.
+func ParsePolygon(data []byte) (Polygon, error) {
+	var polygon Polygon
+	if len(data) == 0 {
+		return polygon, errors.New("a GeoJSON polygon is required")
+	}
+	if err := json.Unmarshal(data, &polygon); err != nil {
+		return polygon, fmt.Errorf("invalid GeoJSON: %v", err)
+	}
+	if polygon.Type != "Polygon" {
+		return polygon, fmt.Errorf("type must be Polygon, got %q", polygon.Type)
+	}
+	if len(polygon.Coordinates) == 0 {
+		return polygon, errors.New("coordinates are required")
+	}
+
+	for i, ring := range polygon.Coordinates {
+		if len(ring) < 4 {
+			return polygon, fmt.Errorf("ring %d must have at least 4 positions", i)
+		}
+		for j, position := range ring {
+			if len(position) < 2 || len(position) > 3 {
+				return polygon, fmt.Errorf("ring %d position %d must be [longitude, latitude]", i, j)
+			}
+			if position[0] < -180 || position[0] > 180 || position[1] < -90 || position[1] > 90 {
+				return polygon, fmt.Errorf("ring %d position %d is out of range", i, j)
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return polygon, fmt.Errorf("ring %d must end at its first position", i)
+		}
+	}
+	return polygon, nil
+}
+
+// geoJSON returns the polygon the way it is stored
+func (p Polygon) geoJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        p.Type,
+		"coordinates": p.Coordinates,
+	}
+}
+
+// polygonFromGeoJSON reads a stored polygon, which the driver returns as JSON text
+func polygonFromGeoJSON(value interface{}) Polygon {
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		var err error
+		if raw, err = json.Marshal(value); err != nil {
+			return Polygon{}
+		}
+	}
+	var polygon Polygon
+	_ = json.Unmarshal(raw, &polygon)
+	return polygon
+}
@@ -0,0 +1,414 @@
+package planting
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/4planet/backend/internal/database"
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidBatch is returned for batches with missing or invalid fields
+	ErrInvalidBatch = errors.New("invalid planting batch")
+	// ErrInvalidMedia is returned for evidence with missing or invalid fields
+	ErrInvalidMedia = errors.New("invalid media file")
+	// ErrProjectNotFound is returned for batches of unknown projects
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrBatchNotFound is returned for unknown batches
+	ErrBatchNotFound = errors.New("planting batch not found")
+	// ErrDonationNotFound is returned for donations that do not exist or are not the user's
+	ErrDonationNotFound = errors.New("donation not found")
+)
+
+// maxSpeciesLength limits species names, in characters
+const maxSpeciesLength = 200
+
+// dateLayout is the format of planting dates
+const dateLayout = "2006-01-02"
+
+// BatchInput is what admins record about a planting batch
+type BatchInput struct {
+	PlantedOn  time.Time
+	Species    string
+	TreesCount int
+	// Area is a GeoJSON polygon of the GPS coordinates the trees were planted in
+	Area  []byte
+	Notes *string
+}
+
+// MediaInput is a photo, video or document attached to a batch as evidence
+type MediaInput struct {
+	Kind     models.MediaKind
+	URL      string
+	MimeType *string
+	Title    *string
+	AltText  *string
+}
+
+// Evidence is a media file attached to a batch
+type Evidence struct {
+	ID       uuid.UUID        `json:"id"`
+	Kind     models.MediaKind `json:"kind"`
+	URL      string           `json:"url"`
+	MimeType *string          `json:"mime_type,omitempty"`
+	Title    *string          `json:"title,omitempty"`
+	AltText  *string          `json:"alt_text,omitempty"`
+}
+
+// Batch is the view of a planting batch
+type Batch struct {
+	ID         uuid.UUID  `json:"id"`
+	ProjectID  uuid.UUID  `json:"project_id"`
+	PlantedOn  string     `json:"planted_on"`
+	Species    string     `json:"species"`
+	TreesCount int        `json:"trees_count"`
+	Area       Polygon    `json:"area"`
+	Notes      *string    `json:"notes,omitempty"`
+	Evidence   []Evidence `json:"evidence"`
+	CreatedAt  time.Time  `json:"created_at"`
+	// LinkedTrees is how many of the trees are linked to donations
+	LinkedTrees int `json:"linked_trees"`
+}
+
+// PlantedTrees are the trees of a donation one batch planted
+type PlantedTrees struct {
+	Batch
+	ProjectTitle  string `json:"project_title"`
+	DonationTrees int    `json:"donation_trees"`
+}
+
+// DonationPlanting shows a donor where and when the trees of a donation were planted
+type DonationPlanting struct {
+	DonationID   uuid.UUID `json:"donation_id"`
+	TreesCount   int       `json:"trees_count"`
+	TreesPlanted int       `json:"trees_planted"`
+	// TreesWaiting are funded but not planted yet
+	TreesWaiting int            `json:"trees_waiting"`
+	Batches      []PlantedTrees `json:"batches"`
+}
+
+// pendingTrees are funded trees of a donation that were not planted yet
+type pendingTrees struct {
+	DonationID uuid.UUID
+	Trees      int
+}
+
+// Service records planting batches and links the funded trees of donations to them
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService creates a new planting service
+func NewService() *Service {
+	return &Service{
+		db: database.GetDB(),
+	}
+}
+
+// ListBatches returns the batches of a project with their evidence, latest planting first
+func (s *Service) ListBatches(projectID uuid.UUID) ([]Batch, error) {
+	var batches []models.PlantingBatch
+	err := s.db.Where("project_id = ?", projectID).
+		Preload("MediaFiles", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Order("planted_on DESC, created_at DESC").
+		Find(&batches).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch planting batches: %w", err)
+	}
+
+	ids := make([]uuid.UUID, 0, len(batches))
+	for _, batch := range batches {
+		ids = append(ids, batch.ID)
+	}
+	linked, err := s.linkedTrees(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]Batch, 0, len(batches))
+	for i := range batches {
+		view := newBatch(&batches[i])
+		view.LinkedTrees = linked[batches[i].ID]
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// CreateBatch records trees planted in a project. The project's funded trees that were not
+// planted yet are linked to the batch, oldest allocation first; trees beyond them were paid
+// for otherwise and are not linked to donations.
+func (s *Service) CreateBatch(projectID uuid.UUID, input *BatchInput, now time.Time) (*Batch, error) {
+	area, err := validateInput(input, now)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &models.PlantingBatch{
+		ID:          uuid.New(),
+		ProjectID:   projectID,
+		PlantedOn:   input.PlantedOn,
+		Species:     input.Species,
+		TreesCount:  input.TreesCount,
+		AreaGeoJSON: area.geoJSON(),
+		Notes:       input.Notes,
+	}
+	linked := 0
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the project links each funded tree to one batch only
+		var project models.Project
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", projectID).First(&project).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProjectNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find project: %w", err)
+		}
+
+		if err := tx.Create(batch).Error; err != nil {
+			return fmt.Errorf("failed to create planting batch: %w", err)
+		}
+
+		var pending []pendingTrees
+		err = tx.Raw(`SELECT a.donation_id, SUM(a.trees) - COALESCE(MAX(p.trees), 0) AS trees
+			FROM tree_allocations a
+			LEFT JOIN (
+				SELECT tp.donation_id, SUM(tp.trees) AS trees
+				FROM tree_plantings tp JOIN planting_batches b ON b.id = tp.planting_batch_id
+				WHERE b.project_id = ?
+				GROUP BY tp.donation_id
+			) p ON p.donation_id = a.donation_id
+			WHERE a.project_id = ?
+			GROUP BY a.donation_id
+			HAVING SUM(a.trees) - COALESCE(MAX(p.trees), 0) > 0
+			ORDER BY MIN(a.created_at) ASC`, projectID, projectID).Scan(&pending).Error
+		if err != nil {
+			return fmt.Errorf("failed to find unplanted trees: %w", err)
+		}
+
+		plantings := linkTrees(batch.ID, pending, batch.TreesCount)
+		if len(plantings) > 0 {
+			if err := tx.CreateInBatches(plantings, 500).Error; err != nil {
+				return fmt.Errorf("failed to link trees to planting batch: %w", err)
+			}
+		}
+		for _, planting := range plantings {
+			linked += planting.Trees
+		}
+
+		if err := tx.Model(&models.Project{}).Where("id = ?", projectID).
+			Update("trees_planted", gorm.Expr("COALESCE(trees_planted, 0) + ?", batch.TreesCount)).Error; err != nil {
+			return fmt.Errorf("failed to update project counters: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	view := newBatch(batch)
+	view.LinkedTrees = linked
+	return &view, nil
+}
+
+// AddEvidence attaches a media file to a batch. The file also belongs to the batch's project.
+func (s *Service) AddEvidence(batchID uuid.UUID, input *MediaInput) (*Evidence, error) {
+	if err := validateMedia(input); err != nil {
+		return nil, err
+	}
+
+	var batch models.PlantingBatch
+	err := s.db.Select("id", "project_id").Where("id = ?", batchID).First(&batch).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBatchNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find planting batch: %w", err)
+	}
+
+	media := &models.MediaFile{
+		ID:              uuid.New(),
+		ProjectID:       batch.ProjectID,
+		PlantingBatchID: &batch.ID,
+		Kind:            input.Kind,
+		URL:             input.URL,
+		MimeType:        input.MimeType,
+		Title:           input.Title,
+		AltText:         input.AltText,
+	}
+	if err := s.db.Create(media).Error; err != nil {
+		return nil, fmt.Errorf("failed to create media file: %w", err)
+	}
+	evidence := newEvidence(media)
+	return &evidence, nil
+}
+
+// GetDonationPlanting returns the batches that planted the trees of a donation. The donation
+// must be the user's or a gift the user claimed.
+func (s *Service) GetDonationPlanting(authUserID string, donationID uuid.UUID) (*DonationPlanting, error) {
+	var donation models.Donation
+	err := s.db.Where("id = ?", donationID).
+		Where("auth_user_id = ? OR id IN (SELECT donation_id FROM dedications WHERE claimed_by_auth_user_id = ?)", authUserID, authUserID).
+		First(&donation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDonationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find donation: %w", err)
+	}
+
+	var plantings []models.TreePlanting
+	err = s.db.Where("donation_id = ?", donationID).
+		Preload("PlantingBatch").
+		Preload("PlantingBatch.Project").
+		Preload("PlantingBatch.MediaFiles", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Order("created_at ASC").
+		Find(&plantings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch plantings: %w", err)
+	}
+	return newDonationPlanting(&donation, plantings), nil
+}
+
+// linkedTrees sums the donated trees linked to each of some batches
+func (s *Service) linkedTrees(batchIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	linked := make(map[uuid.UUID]int, len(batchIDs))
+	if len(batchIDs) == 0 {
+		return linked, nil
+	}
+	var sums []struct {
+		PlantingBatchID uuid.UUID
+		Trees           int
+	}
+	err := s.db.Model(&models.TreePlanting{}).
+		Select("planting_batch_id, SUM(trees) AS trees").
+		Where("planting_batch_id IN ?", batchIDs).
+		Group("planting_batch_id").
+		Scan(&sums).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum linked trees: %w", err)
+	}
+	for _, sum := range sums {
+		linked[sum.PlantingBatchID] = sum.Trees
+	}
+	return linked, nil
+}
+
+// linkTrees links up to a batch's trees to the pending trees of donations in order
+func linkTrees(batchID uuid.UUID, pending []pendingTrees, trees int) []models.TreePlanting {
+	var plantings []models.TreePlanting
+	for _, p := range pending {
+		if trees <= 0 {
+			break
+		}
+		n := min(p.Trees, trees)
+		if n <= 0 {
+			continue
+		}
+		plantings = append(plantings, models.TreePlanting{
+			ID:              uuid.New(),
+			PlantingBatchID: batchID,
+			DonationID:      p.DonationID,
+			Trees:           n,
+		})
+		trees -= n
+	}
+	return plantings
+}
+
+// newDonationPlanting builds the planting view of a donation from its plantings loaded with
+// their batches
+func newDonationPlanting(donation *models.Donation, plantings []models.TreePlanting) *DonationPlanting {
+	result := &DonationPlanting{
+		DonationID: donation.ID,
+		TreesCount: donation.TreesCount,
+		Batches:    make([]PlantedTrees, 0, len(plantings)),
+	}
+	for i := range plantings {
+		planting := &plantings[i]
+		result.TreesPlanted += planting.Trees
+		result.Batches = append(result.Batches, PlantedTrees{
+			Batch:         newBatch(&planting.PlantingBatch),
+			ProjectTitle:  planting.PlantingBatch.Project.Title,
+			DonationTrees: planting.Trees,
+		})
+	}
+	result.TreesWaiting = max(donation.TreesCount-result.TreesPlanted, 0)
+	return result
+}
+
+// newBatch builds the view of a batch loaded with its media files
+func newBatch(batch *models.PlantingBatch) Batch {
+	view := Batch{
+		ID:         batch.ID,
+		ProjectID:  batch.ProjectID,
+		PlantedOn:  batch.PlantedOn.Format(dateLayout),
+		Species:    batch.Species,
+		TreesCount: batch.TreesCount,
+		Area:       polygonFromGeoJSON(batch.AreaGeoJSON),
+		Notes:      batch.Notes,
+		Evidence:   make([]Evidence, 0, len(batch.MediaFiles)),
+		CreatedAt:  batch.CreatedAt,
+	}
+	for i := range batch.MediaFiles {
+		view.Evidence = append(view.Evidence, newEvidence(&batch.MediaFiles[i]))
+	}
+	return view
+}
+
+// newEvidence builds the view of a media file
+func newEvidence(media *models.MediaFile) Evidence {
+	return Evidence{
+		ID:       media.ID,
+		Kind:     media.Kind,
+		URL:      media.URL,
+		MimeType: media.MimeType,
+		Title:    media.Title,
+		AltText:  media.AltText,
+	}
+}
+
+// validateInput checks the fields of a batch and parses its area
+func validateInput(input *BatchInput, now time.Time) (Polygon, error) {
+	input.Species = strings.TrimSpace(input.Species)
+	switch {
+	case input.Species == "":
+		return Polygon{}, fmt.Errorf("%w: species is required", ErrInvalidBatch)
+	case utf8.RuneCountInString(input.Species) > maxSpeciesLength:
+		return Polygon{}, fmt.Errorf("%w: species must be at most %d characters", ErrInvalidBatch, maxSpeciesLength)
+	case input.TreesCount <= 0:
+		return Polygon{}, fmt.Errorf("%w: trees_count must be positive", ErrInvalidBatch)
+	case input.PlantedOn.IsZero():
+		return Polygon{}, fmt.Errorf("%w: planted_on is required", ErrInvalidBatch)
+	case input.PlantedOn.After(now):
+		return Polygon{}, fmt.Errorf("%w: planted_on must not be in the future", ErrInvalidBatch)
+	}
+
+	area, err := ParsePolygon(input.Area)
+	if err != nil {
+		return Polygon{}, fmt.Errorf("%w: area: %v", ErrInvalidBatch, err)
+	}
+	return area, nil
+}
+
+// validateMedia checks the fields of evidence; the kind defaults to image
+func validateMedia(input *MediaInput) error {
+	if input.Kind == "" {
+		input.Kind = models.MediaKindImage
+	}
+	if !input.Kind.IsValid() {
+		return fmt.Errorf("%w: unsupported kind %q", ErrInvalidMedia, input.Kind)
+	}
+	u, err := url.Parse(input.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidMedia)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package planting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const plot = `{"type":"Polygon","coordinates":[[[37.61,55.75],[37.62,55.75],[37.62,55.76],[37.61,55.75]]]}`
+
+func TestParsePolygon(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"polygon", plot, false},
+		{"polygon with a hole and altitude", `{"type":"Polygon","coordinates":[[[0,0,120],[10,0,120],[10,10,120],[0,10,120],[0,0,120]],[[2,2],[3,2],[3,3],[2,2]]]}`, false},
+		{"empty", ``, true},
+		{"not JSON", `{`, true},
+		{"point", `{"type":"Point","coordinates":[37.61,55.75]}`, true},
+		{"no rings", `{"type":"Polygon","coordinates":[]}`, true},
+		{"too few positions", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`, true},
+		{"open ring", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`, true},
+		{"latitude out of range", `{"type":"Polygon","coordinates":[[[0,0],[1,95],[1,1],[0,0]]]}`, true},
+		{"longitude out of range", `{"type":"Polygon","coordinates":[[[0,0],[181,0],[1,1],[0,0]]]}`, true},
+		{"position without latitude", `{"type":"Polygon","coordinates":[[[0,0],[1],[1,1],[0,0]]]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolygon([]byte(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPolygonFromGeoJSON(t *testing.T) {
+	polygon, err := ParsePolygon([]byte(plot))
+	assert.NoError(t, err)
+
+	assert.Equal(t, polygon, polygonFromGeoJSON(plot))
+	assert.Equal(t, polygon, polygonFromGeoJSON([]byte(plot)))
+	assert.Equal(t, polygon, polygonFromGeoJSON(polygon.geoJSON()))
+	assert.Equal(t, Polygon{}, polygonFromGeoJSON(nil))
+}
+
+func TestLinkTrees(t *testing.T) {
+	batchID := uuid.New()
+	first, second, third := uuid.New(), uuid.New(), uuid.New()
+	pending := []pendingTrees{{first, 3}, {second, 10}, {third, 5}}
+
+	tests := []struct {
+		name     string
+		trees    int
+		expected map[uuid.UUID]int
+	}{
+		{"oldest donation first", 2, map[uuid.UUID]int{first: 2}},
+		{"across donations", 8, map[uuid.UUID]int{first: 3, second: 5}},
+		{"exactly the funded trees", 18, map[uuid.UUID]int{first: 3, second: 10, third: 5}},
+		{"more trees than funded", 50, map[uuid.UUID]int{first: 3, second: 10, third: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			linked := make(map[uuid.UUID]int)
+			for _, planting := range linkTrees(batchID, pending, tt.trees) {
+				assert.Equal(t, batchID, planting.PlantingBatchID)
+				linked[planting.DonationID] += planting.Trees
+			}
+			assert.Equal(t, tt.expected, linked)
+		})
+	}
+
+	assert.Empty(t, linkTrees(batchID, nil, 10))
+	assert.Empty(t, linkTrees(batchID, pending, 0))
+}
+
+func TestNewDonationPlanting(t *testing.T) {
+	project := models.Project{Title: "Moscow region"}
+	october := models.PlantingBatch{ID: uuid.New(), Project: project, PlantedOn: time.Date(2027, 10, 5, 0, 0, 0, 0, time.UTC), TreesCount: 1200}
+	november := models.PlantingBatch{ID: uuid.New(), Project: project, PlantedOn: time.Date(2027, 11, 2, 0, 0, 0, 0, time.UTC), TreesCount: 800}
+
+	tests := []struct {
+		name          string
+		trees         int
+		plantings     []models.TreePlanting
+		expectPlanted int
+		expectWaiting int
+		expectBatches int
+	}{
+		{"not planted yet", 10, nil, 0, 10, 0},
+		{"planted in part", 10, []models.TreePlanting{{Trees: 4, PlantingBatch: october}}, 4, 6, 1},
+		{"planted across batches", 10, []models.TreePlanting{{Trees: 4, PlantingBatch: october}, {Trees: 6, PlantingBatch: november}}, 10, 0, 2},
+		{"more planted than left after a refund", 3, []models.TreePlanting{{Trees: 4, PlantingBatch: october}}, 4, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			donation := &models.Donation{ID: uuid.New(), TreesCount: tt.trees}
+			result := newDonationPlanting(donation, tt.plantings)
+			assert.Equal(t, donation.ID, result.DonationID)
+			assert.Equal(t, tt.expectPlanted, result.TreesPlanted)
+			assert.Equal(t, tt.expectWaiting, result.TreesWaiting)
+			assert.Len(t, result.Batches, tt.expectBatches)
+			for i, batch := range result.Batches {
+				assert.Equal(t, tt.plantings[i].Trees, batch.DonationTrees)
+				assert.Equal(t, project.Title, batch.ProjectTitle)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Date(2027, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		plantedOn  time.Time
+		species    string
+		treesCount int
+		area       string
+		wantErr    bool
+	}{
+		{"valid", time.Date(2027, 10, 5, 0, 0, 0, 0, time.UTC), "Scots pine", 1200, plot, false},
+		{"planted today", time.Date(2027, 10, 16, 0, 0, 0, 0, time.UTC), "Scots pine", 1200, plot, false},
+		{"blank species", time.Date(2027, 10, 5, 0, 0, 0, 0, time.UTC), "  ", 1200, plot, true},
+		{"no trees", time.Date(2027, 10, 5, 0, 0, 0, 0, time.UTC), "Scots pine", 0, plot, true},
+		{"no date", time.Time{}, "Scots pine", 1200, plot, true},
+		{"planted in the future", now.AddDate(0, 0, 1), "Scots pine", 1200, plot, true},
+		{"invalid area", time.Date(2027, 10, 5, 0, 0, 0, 0, time.UTC), "Scots pine", 1200, `{"type":"Point","coordinates":[0,0]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &BatchInput{
+				PlantedOn:  tt.plantedOn,
+				Species:    tt.species,
+				TreesCount: tt.treesCount,
+				Area:       []byte(tt.area),
+			}
+			_, err := validateInput(input, now)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidBatch)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateMedia(t *testing.T) {
+	input := &MediaInput{URL: "https://cdn.example.com/batches/moscow-2027-10.jpg"}
+	assert.NoError(t, validateMedia(input))
+	assert.Equal(t, models.MediaKindImage, input.Kind)
+
+	assert.NoError(t, validateMedia(&MediaInput{Kind: models.MediaKindDocument, URL: "https://cdn.example.com/act.pdf"}))
+	assert.True(t, errors.Is(validateMedia(&MediaInput{Kind: "audio", URL: "https://cdn.example.com/a.mp3"}), ErrInvalidMedia))
+	assert.True(t, errors.Is(validateMedia(&MediaInput{URL: "ftp://cdn.example.com/a.jpg"}), ErrInvalidMedia))
+	assert.True(t, errors.Is(validateMedia(&MediaInput{URL: "photo.jpg"}), ErrInvalidMedia))
+}
@@ -1,9 +1,23 @@
 package prices
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/4planet/backend/internal/database"
 	"github.com/4planet/backend/internal/models"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrPriceBackdated is returned for a price version that would take effect before the
+	// latest version of its currency
+	ErrPriceBackdated = errors.New("price cannot take effect before the latest price")
+	// ErrUnknownProject is returned for price overrides of a project that does not exist
+	ErrUnknownProject = errors.New("unknown project")
 )
 
 type Service struct {
@@ -16,10 +30,10 @@ func NewService() *Service {
 	}
 }
 
-// GetPrices retrieves all tree prices as a map of currency to price
+// GetPrices retrieves all current global tree prices as a map of currency to price
 func (s *Service) GetPrices() (map[string]int64, error) {
 	var prices []models.TreePrice
-	err := s.db.Order("currency").Find(&prices).Error
+	err := inForce(forProject(s.db, nil), time.Now()).Order("currency").Find(&prices).Error
 	if err != nil {
 		return nil, err
 	}
@@ -33,22 +47,157 @@ func (s *Service) GetPrices() (map[string]int64, error) {
 	return pricesMap, nil
 }
 
-// GetPriceByCurrency retrieves a tree price by currency
+// GetProjectPrices retrieves the current tree prices of a project as a map of currency to
+// price: the project's own prices where it has them and the global prices otherwise
+func (s *Service) GetProjectPrices(projectID uuid.UUID) (map[string]int64, error) {
+	pricesMap, err := s.GetPrices()
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []models.TreePrice
+	if err := inForce(forProject(s.db, &projectID), time.Now()).Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	for _, price := range overrides {
+		pricesMap[string(price.Currency)] = price.PriceMinor
+	}
+
+	return pricesMap, nil
+}
+
+// GetPriceByCurrency retrieves the current global tree price of a currency
 func (s *Service) GetPriceByCurrency(currency models.Currency) (*models.TreePrice, error) {
+	return PriceAt(s.db, currency, nil, time.Now())
+}
+
+// GetPriceHistory retrieves every global price version of a currency, newest first
+func (s *Service) GetPriceHistory(currency models.Currency) ([]models.TreePrice, error) {
+	var prices []models.TreePrice
+	err := forProject(s.db, nil).Where("currency = ?", currency).Order("effective_from DESC").Find(&prices).Error
+	return prices, err
+}
+
+// UpdatePrice changes the global tree price of a currency from now on
+func (s *Service) UpdatePrice(currency models.Currency, priceMinor int64) error {
+	_, err := s.SchedulePrice(currency, nil, priceMinor, time.Now())
+	return err
+}
+
+// SchedulePrice adds a price version that takes effect at effectiveFrom and ends the
+// latest version at that time. Without a project the global price changes; with one,
+// the project's price override.
+func (s *Service) SchedulePrice(currency models.Currency, projectID *uuid.UUID, priceMinor int64, effectiveFrom time.Time) (*models.TreePrice, error) {
+	price := &models.TreePrice{
+		ID:            uuid.New(),
+		Currency:      currency,
+		ProjectID:     projectID,
+		PriceMinor:    priceMinor,
+		EffectiveFrom: effectiveFrom,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if projectID != nil {
+			var count int64
+			if err := tx.Model(&models.Project{}).Where("id = ?", *projectID).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to find project: %w", err)
+			}
+			if count == 0 {
+				return ErrUnknownProject
+			}
+		}
+
+		latest, err := lockLatestPrice(tx, currency, projectID)
+		if err != nil {
+			return err
+		}
+		if latest != nil {
+			if !effectiveFrom.After(latest.EffectiveFrom) || (latest.EffectiveTo != nil && effectiveFrom.Before(*latest.EffectiveTo)) {
+				return ErrPriceBackdated
+			}
+			if latest.EffectiveTo == nil {
+				if err := tx.Model(latest).Update("effective_to", effectiveFrom).Error; err != nil {
+					return fmt.Errorf("failed to end latest price: %w", err)
+				}
+			}
+		}
+
+		return tx.Create(price).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return price, nil
+}
+
+// EndProjectPrice ends a project's price override of a currency now, so that the project
+// falls back to the global price. It returns gorm.ErrRecordNotFound when the project has
+// no current override.
+func (s *Service) EndProjectPrice(projectID uuid.UUID, currency models.Currency) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		latest, err := lockLatestPrice(tx, currency, &projectID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if latest == nil || (latest.EffectiveTo != nil && !latest.EffectiveTo.After(now)) {
+			return gorm.ErrRecordNotFound
+		}
+		// An override that has not taken effect yet is dropped
+		if latest.EffectiveFrom.After(now) {
+			return tx.Delete(latest).Error
+		}
+		return tx.Model(latest).Update("effective_to", now).Error
+	})
+}
+
+// lockLatestPrice returns the price version of a currency that took or takes effect
+// last, locked until the end of the transaction. It returns nil without versions.
+func lockLatestPrice(tx *gorm.DB, currency models.Currency, projectID *uuid.UUID) (*models.TreePrice, error) {
+	var latest models.TreePrice
+	err := forProject(tx, projectID).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("currency = ?", currency).
+		Order("effective_from DESC").
+		First(&latest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find latest price: %w", err)
+	}
+	return &latest, nil
+}
+
+// PriceAt returns the tree price of a currency that was in force at a point in time. A
+// project's own price takes precedence over the global price.
+func PriceAt(db *gorm.DB, currency models.Currency, projectID *uuid.UUID, at time.Time) (*models.TreePrice, error) {
 	var price models.TreePrice
-	err := s.db.Where("currency = ?", currency).First(&price).Error
+	if projectID != nil {
+		err := inForce(forProject(db, projectID), at).Where("currency = ?", currency).First(&price).Error
+		if err == nil {
+			return &price, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	err := inForce(forProject(db, nil), at).Where("currency = ?", currency).First(&price).Error
 	if err != nil {
 		return nil, err
 	}
 	return &price, nil
 }
 
-// UpdatePrice updates or creates a tree price for a specific currency
-func (s *Service) UpdatePrice(currency models.Currency, priceMinor int64) error {
-	price := models.TreePrice{
-		Currency:   currency,
-		PriceMinor: priceMinor,
+// forProject limits a query to the price versions of a project, or to the global ones
+func forProject(db *gorm.DB, projectID *uuid.UUID) *gorm.DB {
+	if projectID == nil {
+		return db.Where("project_id IS NULL")
 	}
+	return db.Where("project_id = ?", *projectID)
+}
 
-	return s.db.Save(&price).Error
+// inForce limits a query to the price versions in force at a point in time
+func inForce(db *gorm.DB, at time.Time) *gorm.DB {
+	return db.Where("effective_from <= ? AND (effective_to IS NULL OR effective_to > ?)", at, at)
 }
@@ -45,3 +45,13 @@ func TestService_UpdatePrice(t *testing.T) {
 	// Note: service.db might be nil if database connection is not available during testing
 	// This is expected behavior in test environments
 }
+
+func TestService_GetPriceHistory(t *testing.T) {
+	service := NewService()
+	assert.NotNil(t, service)
+}
+
+func TestService_GetProjectPrices(t *testing.T) {
+	service := NewService()
+	assert.NotNil(t, service)
+}
@@ -26,6 +26,16 @@ func (s *Service) GetUserByAuthID(authUserID string) (*models.User, error) {
 	return &user, nil
 }
 
+// GetCreditBalances returns the user's credit towards the next tree in each currency the
+// user has credit in
+func (s *Service) GetCreditBalances(authUserID string) ([]models.CreditBalance, error) {
+	var balances []models.CreditBalance
+	err := s.db.Where("auth_user_id = ? AND balance_minor > 0", authUserID).
+		Order("currency ASC").
+		Find(&balances).Error
+	return balances, err
+}
+
 // GetUserByID retrieves a user by their UUID
 func (s *Service) GetUserByID(id string) (*models.User, error) {
 	var user models.User
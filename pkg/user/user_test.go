@@ -32,3 +32,8 @@ func TestService_UpdateUser(t *testing.T) {
 	service := NewService()
 	assert.NotNil(t, service)
 }
+
+func TestService_GetCreditBalances(t *testing.T) {
+	service := NewService()
+	assert.NotNil(t, service)
+}